@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+
+	"github.com/lcrownover/hpcadmin-server/database/migration"
+	"github.com/lcrownover/hpcadmin-server/internal/auth"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/export"
+	"github.com/lcrownover/hpcadmin-server/internal/manifest"
+)
+
+// apiKeyRoles are the roles an api key can be created with
+var apiKeyRoles = []string{"admin", "user"}
+
+// runMigrate applies the migrations built into the binary
+func runMigrate(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	_, dbConn, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	m, err := migration.New(dbConn)
+	if err != nil {
+		return fmt.Errorf("loading migrations: %w", err)
+	}
+
+	switch {
+	case action == "up" && len(args) <= 1:
+		if err := migration.Up(m); err != nil {
+			return fmt.Errorf("migrating up: %w", err)
+		}
+	case action == "down" && len(args) == 2:
+		steps, err := strconv.Atoi(args[1])
+		if err != nil || steps < 1 {
+			return fmt.Errorf("down needs a number of steps to roll back, got %q", args[1])
+		}
+		if err := m.Steps(-steps); err != nil {
+			return fmt.Errorf("migrating down: %w", err)
+		}
+	case action == "version" && len(args) == 1:
+	default:
+		return fmt.Errorf("usage: hpcadmin-server migrate [up | down <steps> | version]")
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("No migrations applied")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if dirty {
+		fmt.Printf("Schema version %d (dirty, the last migration failed partway through)\n", version)
+		return nil
+	}
+	fmt.Printf("Schema version %d\n", version)
+	return nil
+}
+
+// runBootstrap creates the first admin user on a fresh install and prints
+// an admin api key for it
+func runBootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	username := fs.String("username", "", "Username of the admin user")
+	email := fs.String("email", "", "Email address of the admin user")
+	firstName := fs.String("firstname", "", "First name of the admin user")
+	lastName := fs.String("lastname", "", "Last name of the admin user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" || *email == "" {
+		return fmt.Errorf("usage: hpcadmin-server bootstrap -username <username> -email <email> [-firstname <name>] [-lastname <name>]")
+	}
+
+	store, dbConn, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	ctx := context.Background()
+
+	// the user and its key are created together, so a failed key insert
+	// doesn't leave a user behind that blocks the next bootstrap
+	var user *data.User
+	var key string
+	err = store.Transaction(ctx, func(tx data.Store) error {
+		users, err := tx.GetAllUsers(ctx)
+		if err != nil {
+			return fmt.Errorf("checking for existing users: %w", err)
+		}
+		if len(users) > 0 {
+			return fmt.Errorf("the database already has users, use apikey create to add an admin key")
+		}
+		user, err = tx.CreateUser(ctx, &data.UserRequest{
+			Username:  *username,
+			Email:     *email,
+			FirstName: *firstName,
+			LastName:  *lastName,
+		})
+		if err != nil {
+			return fmt.Errorf("creating admin user: %w", err)
+		}
+		key, err = createAPIKey(ctx, tx, user.Id, "admin")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created admin user %s with id %d\n", user.Username, user.Id)
+	fmt.Println(key)
+	return nil
+}
+
+// runAPIKey creates or revokes api keys
+func runAPIKey(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: hpcadmin-server apikey create|revoke")
+	}
+	switch args[0] {
+	case "create":
+		return apiKeyCreate(args[1:])
+	case "revoke":
+		return apiKeyRevoke(args[1:])
+	}
+	return fmt.Errorf("unknown apikey command %q, must be create or revoke", args[0])
+}
+
+func apiKeyCreate(args []string) error {
+	fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	username := fs.String("user", "", "Username the key belongs to")
+	role := fs.String("role", "user", "Role of the key, admin or user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return fmt.Errorf("usage: hpcadmin-server apikey create -user <username> [-role admin|user]")
+	}
+	if !slices.Contains(apiKeyRoles, *role) {
+		return fmt.Errorf("invalid role %q, must be one of %v", *role, apiKeyRoles)
+	}
+
+	store, dbConn, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	ctx := context.Background()
+
+	user, err := store.GetUserByUsername(ctx, *username)
+	if errors.Is(err, data.ErrNotFound) {
+		return fmt.Errorf("user %s not found", *username)
+	}
+	if err != nil {
+		return fmt.Errorf("looking up user: %w", err)
+	}
+	key, err := createAPIKey(ctx, store, user.Id, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// apiKeyRevoke deletes a key. Running servers stop accepting it once
+// their cached copy expires, within auth.APIKeyTTL.
+func apiKeyRevoke(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: hpcadmin-server apikey revoke <key>")
+	}
+	store, dbConn, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	err = store.DeleteAPIKey(context.Background(), args[0])
+	if errors.Is(err, data.ErrNotFound) {
+		return fmt.Errorf("api key not found")
+	}
+	if err != nil {
+		return fmt.Errorf("revoking api key: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "Revoked api key")
+	return nil
+}
+
+func createAPIKey(ctx context.Context, store data.APIKeyStore, userId int, role string) (string, error) {
+	key, err := auth.NewAPIKey()
+	if err != nil {
+		return "", err
+	}
+	if _, err := store.CreateAPIKey(ctx, &data.APIKeyRequest{Key: key, Role: role, UserId: userId}); err != nil {
+		return "", fmt.Errorf("creating api key: %w", err)
+	}
+	return key, nil
+}
+
+const exportUsage = `usage: hpcadmin-server export slurm -cluster <name> [-o file]
+       hpcadmin-server export group|passwd [-o file]
+       hpcadmin-server export ldif [-since time] [-o file]`
+
+// runExport writes the data out for other systems to load
+func runExport(args []string) error {
+	if len(args) == 0 {
+		return errors.New(exportUsage)
+	}
+	fs := flag.NewFlagSet("export "+args[0], flag.ContinueOnError)
+	var cluster, since *string
+	switch args[0] {
+	case "slurm":
+		cluster = fs.String("cluster", "", "Name of the cluster to export")
+	case "ldif":
+		since = fs.String("since", "", "Write the changes after the export with this snapshot time")
+	case "group", "passwd":
+	default:
+		return errors.New(exportUsage)
+	}
+	output := fs.String("o", "", "File to write to instead of stdout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if cluster != nil && *cluster == "" {
+		return errors.New(exportUsage)
+	}
+	var sinceTime time.Time
+	if since != nil && *since != "" {
+		t, err := time.Parse(time.RFC3339Nano, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since %q, must be an RFC 3339 time", *since)
+		}
+		sinceTime = t
+	}
+
+	cfg, err := setup(config.ValidateExport)
+	if err != nil {
+		return err
+	}
+	opts, err := export.NewUnixOptions(cfg.Unix)
+	if err != nil {
+		return err
+	}
+	dbConn, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	store := data.NewPostgresStore(dbConn)
+	var ldif *export.LDIFOptions
+	if args[0] == "ldif" {
+		if ldif, err = export.NewLDIFOptions(cfg.LDAP, opts); err != nil {
+			return err
+		}
+	}
+
+	// the file is only replaced once the export succeeds, so a node
+	// reading it never sees a partial or rejected file
+	var buf bytes.Buffer
+	ctx := context.Background()
+	switch args[0] {
+	case "slurm":
+		err = export.Slurm(ctx, store, *cluster, &buf)
+	case "group":
+		err = export.Group(ctx, store, opts, &buf)
+	case "passwd":
+		err = export.Passwd(ctx, store, opts, &buf)
+	case "ldif":
+		if sinceTime.IsZero() {
+			err = export.LDIF(ctx, store, ldif, &buf)
+		} else {
+			err = export.LDIFChanges(ctx, store, ldif, sinceTime, &buf)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return writeFileAtomic(*output, buf.Bytes(), 0644)
+}
+
+// writeFileAtomic writes the file next to path and renames it over path,
+// so readers see either the old file or the whole new one
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+const manifestUsage = `usage: hpcadmin-server manifest plan|apply [-prune] [-prune-pirgs] <file>`
+
+// runManifest prints the changes a manifest makes as json, and makes them
+// in one transaction for apply
+func runManifest(args []string) error {
+	if len(args) == 0 || (args[0] != "plan" && args[0] != "apply") {
+		return errors.New(manifestUsage)
+	}
+	fs := flag.NewFlagSet("manifest "+args[0], flag.ContinueOnError)
+	prune := fs.Bool("prune", false, "Delete the groups of listed pirgs and the institutional pirg members the manifest doesn't list")
+	prunePirgs := fs.Bool("prune-pirgs", false, "Delete the pirgs the manifest doesn't list")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(manifestUsage)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := manifest.Parse(f)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := setup(config.ValidateDatabase)
+	if err != nil {
+		return err
+	}
+	dbConn, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	store := data.NewPostgresStore(dbConn)
+
+	opts := manifest.PlanOptions{Prune: *prune, PrunePirgs: *prunePirgs}
+	var plan *manifest.Plan
+	if args[0] == "plan" {
+		plan, err = manifest.NewPlan(context.Background(), store, m, opts)
+	} else {
+		plan, err = manifest.Apply(context.Background(), store, m, opts)
+	}
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
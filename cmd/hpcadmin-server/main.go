@@ -1,126 +1,184 @@
 package main
 
 import (
-	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/docgen"
-	"github.com/go-chi/render"
-
-	"github.com/lcrownover/hpcadmin-server/internal/api"
-	"github.com/lcrownover/hpcadmin-server/internal/auth"
 	"github.com/lcrownover/hpcadmin-server/internal/config"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
-	"github.com/lcrownover/hpcadmin-server/internal/keys"
-	"github.com/lcrownover/hpcadmin-server/internal/util"
-
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
 )
 
-var docs = flag.String("docs", "", "Generate router documentation")
+var docs = flag.String("docs", "", "Generate router documentation (deprecated, use the docs command)")
 var configPath = flag.String("config", "", "Path to hpcadmin-server configuration file")
 var debug = flag.Bool("debug", false, "Enable debug mode")
 
-func main() {
-	var err error
+const usage = `usage: hpcadmin-server [-config path] [-debug] <command> [arguments]
+
+commands:
+  serve                                  run the server (the default)
+  migrate [up | down <steps> | version]  apply or roll back database migrations
+  bootstrap -username ... -email ...     create the first admin user and print an admin api key
+  apikey create -user <username> [-role admin|user]
+                                         create an api key and print it
+  apikey revoke <key>                    delete an api key
+  export slurm -cluster <name> [-o file] write a sacctmgr dump of the pirgs and users
+                                         enabled on the cluster
+  export group|passwd [-o file]          write the pirgs and pirg groups, or the users,
+                                         in /etc/group or /etc/passwd format
+  export ldif [-since time] [-o file]    write the users and groups as LDIF, or the
+                                         changes after the export with that snapshot time
+  manifest plan|apply [-prune] [-prune-pirgs] <file>
+                                         print the changes a YAML or JSON manifest of users
+                                         and pirgs makes as JSON, and make them for apply
+  docs [markdown]                        print the routes, or write them to routes.md
+  config check                           print the effective configuration and validate it
+`
+
+// command runs a subcommand with the arguments that follow its name
+type command func(args []string) error
+
+var commands = map[string]command{
+	"serve":     runServe,
+	"migrate":   runMigrate,
+	"bootstrap": runBootstrap,
+	"apikey":    runAPIKey,
+	"export":    runExport,
+	"manifest":  runManifest,
+	"docs":      runDocs,
+	"config":    runConfig,
+}
 
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nflags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	util.ConfigureLogging(*debug)
+	// log at debug while loading the configuration if asked to,
+	// the configured format and level take over once it's loaded
+	startupLevel := slog.LevelInfo
+	if *debug {
+		startupLevel = slog.LevelDebug
+	}
+	logging.Configure(os.Stdout, "text", startupLevel)
 
-	slog.Debug("loading configuration from file", "package", "main", "method", "main")
-	cfg, err := config.LoadFile(*configPath)
-	if err != nil {
-		fmt.Printf("Error loading configuration from file: %v\n", err)
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if *docs != "" {
+		name, args = "docs", []string{*docs}
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
 
-	slog.Debug("searching environment variables for overrides", "package", "main", "method", "main")
-	cfg = config.LoadEnvironment(cfg)
-
-	slog.Debug("validating Configuration", "package", "main", "method", "main")
-	err = config.Validate(cfg)
+// loadConfig reads the configuration file and applies environment overrides
+func loadConfig(path string) (*config.ServerConfig, error) {
+	slog.Debug("loading configuration from file", "package", "main", "method", "loadConfig")
+	cfg, err := config.LoadFile(path)
 	if err != nil {
-		fmt.Printf("Error validating configuration: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
+	slog.Debug("searching environment variables for overrides", "package", "main", "method", "loadConfig")
+	return config.LoadEnvironment(cfg)
+}
 
-	slog.Debug("starting hpcadmin-server", "package", "main", "method", "main")
-
-	dbRequest := data.DBRequest{
-		Host:       cfg.DB.Host,
-		Port:       cfg.DB.Port,
-		User:       cfg.DB.User,
-		Password:   cfg.DB.Password,
-		DBName:     cfg.DB.DBName,
-		DisableSSL: true,
-	}
-	dbConn, err := data.NewDBConn(dbRequest)
+// setup loads the configuration and checks it with validate, then
+// switches logging over to the configured format and level
+func setup(validate func(*config.ServerConfig) error) (*config.ServerConfig, error) {
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		fmt.Printf("Error connecting to database: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("loading configuration: %w", err)
+	}
+	slog.Debug("validating configuration", "package", "main", "method", "setup")
+	if err := validate(cfg); err != nil {
+		return nil, fmt.Errorf("validating configuration:\n%w", err)
 	}
 
-	listenAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-
-	authCache := auth.NewAuthCache()
-	mw := auth.NewMiddleware(dbConn)
-
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, keys.DBConnKey, dbConn)
-	ctx = context.WithValue(ctx, keys.ListenAddrKey, listenAddr)
-	ctx = context.WithValue(ctx, keys.AuthCacheKey, authCache)
-	ctx = context.WithValue(ctx, keys.ConfigKey, cfg)
-
-	r := chi.NewRouter()
-	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.URLFormat)
-	r.Use(render.SetContentType(render.ContentTypeJSON))
-
-	// public routes for logging in and simple homepage
-	r.Group(func(r chi.Router) {
-		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
-		// r.Mount("/login", api.LoginRouter(ctx)) // TODO(lcrown)
-		r.Mount("/oauth", auth.OauthRouter(ctx))
-	})
-
-	// private routes for authenticated users
-	r.Group(func(r chi.Router) {
-		r.Use(mw.APIKeyLoader)
-		r.Use(mw.OauthLoader)
-		r.Use(mw.RoleVerifier)
-		r.Route("/api/v1", func(r chi.Router) {
-			r.Mount("/users", api.UsersRouter(ctx))
-			r.Mount("/pirgs", api.PirgsRouter(ctx))
-		})
-	})
+	logLevel := slog.LevelInfo
+	if cfg.Logging.Level != "" {
+		logLevel, _ = logging.ParseLevel(cfg.Logging.Level)
+	}
+	if *debug {
+		logLevel = slog.LevelDebug
+	}
+	logging.Configure(os.Stdout, cfg.Logging.Format, logLevel)
+	return cfg, nil
+}
 
-	// admin routes for authenticated admins
-	r.Group(func(r chi.Router) {
-		r.Use(mw.APIKeyLoader)
-		r.Use(mw.OauthLoader)
-		r.Use(mw.RoleVerifier)
-		r.Use(mw.AdminOnly)
-		r.Mount("/admin", api.AdminRouter(ctx))
+// openDB connects to the configured database
+func openDB(cfg *config.ServerConfig) (*sql.DB, error) {
+	dbConn, err := data.NewDBConn(data.DBRequest{
+		Host:            cfg.DB.Host,
+		Port:            cfg.DB.Port,
+		User:            cfg.DB.User,
+		Password:        cfg.DB.Password,
+		DBName:          cfg.DB.DBName,
+		SSLMode:         cfg.DB.SSLMode,
+		SSLRootCert:     cfg.DB.SSLRootCert,
+		SSLCert:         cfg.DB.SSLCert,
+		SSLKey:          cfg.DB.SSLKey,
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+		ConnectTimeout:  cfg.DB.ConnectTimeout,
+		DSN:             cfg.DB.DSN,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	return dbConn, nil
+}
 
-	if *docs != "" {
-		api.GenerateDocs(r, *docs)
-		return
+// openStore loads the configuration and connects to the database for
+// the commands that work with the data directly, which only need the
+// database settings to be valid
+func openStore() (*data.PostgresStore, *sql.DB, error) {
+	cfg, err := setup(config.ValidateDatabase)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbConn, err := openDB(cfg)
+	if err != nil {
+		return nil, nil, err
 	}
+	return data.NewPostgresStore(dbConn), dbConn, nil
+}
 
-	docgen.PrintRoutes(r)
+func runConfig(args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return fmt.Errorf("usage: hpcadmin-server config check")
+	}
+	return configCheck()
+}
 
-	fmt.Println("Listening on " + listenAddr)
-	err = http.ListenAndServe(listenAddr, r)
+// configCheck prints the effective configuration with secrets redacted
+// and reports every problem with it
+func configCheck() error {
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		fmt.Printf("Error starting server: %v\n", err)
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+	if err := config.WriteRedacted(os.Stdout, cfg); err != nil {
+		return fmt.Errorf("printing configuration: %w", err)
+	}
+	if err := config.Validate(cfg); err != nil {
+		return fmt.Errorf("configuration is invalid:\n%w", err)
 	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return nil
 }
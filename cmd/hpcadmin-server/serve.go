@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/docgen"
+	"github.com/go-chi/render"
+
+	"github.com/lcrownover/hpcadmin-server/database/migration"
+	"github.com/lcrownover/hpcadmin-server/internal/api"
+	"github.com/lcrownover/hpcadmin-server/internal/auth"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/health"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
+	"github.com/lcrownover/hpcadmin-server/internal/metrics"
+	"github.com/lcrownover/hpcadmin-server/internal/notifications"
+	"github.com/lcrownover/hpcadmin-server/internal/ratelimit"
+	"github.com/lcrownover/hpcadmin-server/internal/server"
+	"github.com/lcrownover/hpcadmin-server/internal/sweep"
+	"github.com/lcrownover/hpcadmin-server/internal/tracing"
+)
+
+// routes is the router along with the state its middlewares keep
+type routes struct {
+	chi.Router
+	limiters ratelimit.Limiters
+	lockout  *ratelimit.Lockout
+	auth     *auth.Middleware
+}
+
+// newRouter builds every route the server serves. dbConn may be nil when
+// the routes are only being documented.
+func newRouter(cfg *config.ServerConfig, store data.Store, dbConn *sql.DB) (*routes, error) {
+	listenAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	authCache := auth.NewAuthCache()
+	mw := auth.NewMiddleware(store)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, keys.DBConnKey, dbConn)
+	ctx = context.WithValue(ctx, keys.StoreKey, store)
+	ctx = context.WithValue(ctx, keys.ListenAddrKey, listenAddr)
+	ctx = context.WithValue(ctx, keys.AuthCacheKey, authCache)
+	ctx = context.WithValue(ctx, keys.ConfigKey, cfg)
+	if cfg.Notifications.Enabled() {
+		ctx = context.WithValue(ctx, keys.NotifierKey, notifications.NewNotifier(store))
+	}
+
+	metricsRegistry := metrics.NewRegistry(dbConn)
+
+	schemaVersion, err := migration.LatestVersion()
+	if err != nil {
+		return nil, fmt.Errorf("reading embedded migrations: %w", err)
+	}
+	checker := health.NewChecker(health.DefaultCheckTimeout)
+	checker.Add("database", health.DatabaseCheck(dbConn, time.Second))
+	checker.Add("schema", health.SchemaCheck(dbConn, schemaVersion))
+	checker.Add("oidc", health.URLCheck(http.DefaultClient, auth.OIDCMetadataURL(cfg.Oauth.TenantID)))
+
+	limiters := ratelimit.NewLimiters(cfg.RateLimit.Groups)
+	lockout := ratelimit.NewLockout(cfg.RateLimit.Lockout)
+
+	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	if cfg.RateLimit.TrustProxyHeaders {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(middleware.RequestID)
+	r.Use(metrics.Middleware)
+	r.Use(logging.AccessLog)
+	r.Use(middleware.Recoverer)
+	r.Use(middleware.URLFormat)
+	r.Use(render.SetContentType(render.ContentTypeJSON))
+
+	// public routes for logging in and simple homepage
+	r.Group(func(r chi.Router) {
+		r.Use(tracing.Stage("rate_limit", limiters.Middleware("public", ratelimit.CallerKey)))
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		// r.Mount("/login", api.LoginRouter(ctx)) // TODO(lcrown)
+		r.Mount("/oauth", auth.OauthRouter(ctx))
+		r.Get("/healthz", checker.Healthz)
+		r.Get("/readyz", checker.Readyz)
+	})
+
+	// private routes for authenticated users
+	r.Group(func(r chi.Router) {
+		r.Use(tracing.Stage("lockout", lockout.Middleware))
+		// limited by ip before the loaders so unknown keys can't flood the database
+		r.Use(tracing.Stage("auth_rate_limit", limiters.Middleware("auth", ratelimit.IPKey)))
+		r.Use(tracing.Stage("api_key_loader", mw.APIKeyLoader))
+		r.Use(tracing.Stage("oauth_loader", mw.OauthLoader))
+		// limited after the loaders so known callers get their own bucket
+		r.Use(tracing.Stage("rate_limit", limiters.Middleware("api", ratelimit.CallerKey)))
+		r.Use(tracing.Stage("role_verifier", mw.RoleVerifier))
+		r.Route("/api/v1", func(r chi.Router) {
+			r.Mount("/users", api.UsersRouter(ctx))
+			r.Mount("/pirgs", api.PirgsRouter(ctx))
+			r.Mount("/locations", api.LocationsRouter(ctx))
+			r.Mount("/clusters", api.ClustersRouter(ctx))
+			r.Mount("/partitions", api.PartitionsRouter(ctx))
+			r.Mount("/qos", api.QOSRouter(ctx))
+			r.Mount("/compute", api.ComputeReportsRouter(ctx))
+			r.Mount("/reports", api.ReportsRouter(ctx))
+			r.Mount("/reviews", api.ReviewsRouter(ctx))
+			r.Mount("/export", api.ExportRouter(ctx))
+			r.Mount("/manifest", api.ManifestRouter(ctx))
+		})
+	})
+
+	// admin routes for authenticated admins
+	r.Group(func(r chi.Router) {
+		r.Use(tracing.Stage("lockout", lockout.Middleware))
+		r.Use(tracing.Stage("auth_rate_limit", limiters.Middleware("auth", ratelimit.IPKey)))
+		r.Use(tracing.Stage("api_key_loader", mw.APIKeyLoader))
+		r.Use(tracing.Stage("oauth_loader", mw.OauthLoader))
+		r.Use(tracing.Stage("rate_limit", limiters.Middleware("admin", ratelimit.CallerKey)))
+		r.Use(tracing.Stage("role_verifier", mw.RoleVerifier))
+		r.Use(tracing.Stage("admin_only", mw.AdminOnly))
+		r.Mount("/admin", api.AdminRouter(ctx))
+		// counts of users and pirgs and the auth and rate limit counters
+		// aren't public, scrapers need an admin api key
+		r.Method("GET", "/metrics", metrics.Handler(metricsRegistry))
+	})
+
+	return &routes{Router: r, limiters: limiters, lockout: lockout, auth: mw}, nil
+}
+
+func runServe(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: hpcadmin-server serve")
+	}
+	cfg, err := setup(config.Validate)
+	if err != nil {
+		return err
+	}
+	slog.Debug("starting hpcadmin-server", "package", "main", "method", "runServe")
+
+	dbConn, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	store := data.NewPostgresStore(dbConn)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+
+	r, err := newRouter(cfg, store, dbConn)
+	if err != nil {
+		return err
+	}
+	docgen.PrintRoutes(r)
+
+	listenAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	srv := server.New(listenAddr, r, cfg.HTTP)
+	srv.OnShutdown(dbConn.Close)
+	srv.OnShutdown(shutdownTracing)
+	srv.Go("metrics-refresher", func(ctx context.Context) {
+		metrics.RefreshDomainGauges(ctx, store, metrics.DefaultRefreshInterval)
+	})
+	srv.Go("sweeper", func(ctx context.Context) {
+		sweep.Run(ctx, store, sweep.DefaultInterval)
+	})
+	if cfg.Notifications.Enabled() {
+		templates, err := notifications.LoadTemplates(cfg.Notifications.TemplateDir)
+		if err != nil {
+			return fmt.Errorf("loading notification templates: %w", err)
+		}
+		sender, err := notifications.NewSMTPSender(cfg.Notifications.From, cfg.Notifications.SMTP)
+		if err != nil {
+			return fmt.Errorf("configuring notifications: %w", err)
+		}
+		notifier := notifications.NewService(store, sender, templates, cfg.Notifications, cfg.BaseURL())
+		srv.Go("notifier", notifier.Run)
+	}
+	srv.Go("rate-limit-pruner", func(ctx context.Context) {
+		ratelimit.RunPruner(ctx, ratelimit.DefaultPruneInterval, r.limiters, r.lockout, r.auth)
+	})
+	if cfg.TLS.Enabled() {
+		certs, err := server.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		tlsConfig, err := server.NewTLSConfig(cfg.TLS, certs)
+		if err != nil {
+			return fmt.Errorf("configuring TLS: %w", err)
+		}
+		srv.UseTLS(tlsConfig)
+		srv.Go("tls-cert-reloader", func(ctx context.Context) {
+			certs.Watch(ctx, cfg.TLS.ReloadInterval)
+		})
+	}
+
+	// in-flight requests are drained on SIGINT/SIGTERM
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Listening on " + listenAddr)
+	if err := srv.ListenAndServe(sigCtx); err != nil {
+		return fmt.Errorf("running server: %w", err)
+	}
+	slog.Info("server stopped", "package", "main", "method", "runServe")
+	return nil
+}
+
+// runDocs prints the routes, or writes them to routes.md with "markdown"
+func runDocs(args []string) error {
+	format := ""
+	if len(args) > 1 {
+		return fmt.Errorf("usage: hpcadmin-server docs [markdown]")
+	}
+	if len(args) == 1 {
+		format = args[0]
+	}
+	// the routes don't depend on the configuration, so it doesn't need to be valid
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+	r, err := newRouter(cfg, data.NewMemoryStore(), nil)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		docgen.PrintRoutes(r)
+		return nil
+	}
+	api.GenerateDocs(r, format)
+	return nil
+}
@@ -0,0 +1,40 @@
+DROP INDEX IF EXISTS api_keys_user_id_idx;
+DROP INDEX IF EXISTS groups_users_user_id_idx;
+DROP INDEX IF EXISTS pirgs_admins_user_id_idx;
+DROP INDEX IF EXISTS pirgs_users_user_id_idx;
+DROP INDEX IF EXISTS pirgs_owner_id_idx;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT api_keys_user_id_fkey,
+    ADD CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE groups_users
+    DROP CONSTRAINT groups_users_group_id_fkey,
+    DROP CONSTRAINT groups_users_user_id_fkey,
+    ADD CONSTRAINT groups_users_group_id_fkey FOREIGN KEY (group_id) REFERENCES pirgs_groups(id),
+    ADD CONSTRAINT groups_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE pirgs_groups
+    DROP CONSTRAINT pirgs_groups_pirg_id_fkey,
+    ADD CONSTRAINT pirgs_groups_pirg_id_fkey FOREIGN KEY (pirg_id) REFERENCES pirgs(id);
+
+ALTER TABLE pirgs_admins
+    DROP CONSTRAINT pirgs_admins_pirg_id_fkey,
+    DROP CONSTRAINT pirgs_admins_user_id_fkey,
+    ADD CONSTRAINT pirgs_admins_pirg_id_fkey FOREIGN KEY (pirg_id) REFERENCES pirgs(id),
+    ADD CONSTRAINT pirgs_admins_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE pirgs_users
+    DROP CONSTRAINT pirgs_users_pirg_id_fkey,
+    DROP CONSTRAINT pirgs_users_user_id_fkey,
+    ADD CONSTRAINT pirgs_users_pirg_id_fkey FOREIGN KEY (pirg_id) REFERENCES pirgs(id),
+    ADD CONSTRAINT pirgs_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE pirgs
+    DROP CONSTRAINT pirgs_owner_id_fkey,
+    ADD CONSTRAINT pirgs_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES users(id);
+
+ALTER TABLE pirgs_groups DROP CONSTRAINT pirgs_groups_pirg_id_name_key;
+ALTER TABLE groups_users DROP CONSTRAINT groups_users_group_id_user_id_key;
+ALTER TABLE pirgs_admins DROP CONSTRAINT pirgs_admins_pirg_id_user_id_key;
+ALTER TABLE pirgs_users DROP CONSTRAINT pirgs_users_pirg_id_user_id_key;
@@ -0,0 +1,55 @@
+-- Remove duplicate memberships so the unique constraints below can be added.
+-- The oldest row for each pair is kept.
+DELETE FROM pirgs_users a USING pirgs_users b
+    WHERE a.id > b.id AND a.pirg_id = b.pirg_id AND a.user_id = b.user_id;
+DELETE FROM pirgs_admins a USING pirgs_admins b
+    WHERE a.id > b.id AND a.pirg_id = b.pirg_id AND a.user_id = b.user_id;
+DELETE FROM groups_users a USING groups_users b
+    WHERE a.id > b.id AND a.group_id = b.group_id AND a.user_id = b.user_id;
+DELETE FROM pirgs_groups a USING pirgs_groups b
+    WHERE a.id > b.id AND a.pirg_id = b.pirg_id AND a.name = b.name;
+
+ALTER TABLE pirgs_users ADD CONSTRAINT pirgs_users_pirg_id_user_id_key UNIQUE (pirg_id, user_id);
+ALTER TABLE pirgs_admins ADD CONSTRAINT pirgs_admins_pirg_id_user_id_key UNIQUE (pirg_id, user_id);
+ALTER TABLE groups_users ADD CONSTRAINT groups_users_group_id_user_id_key UNIQUE (group_id, user_id);
+ALTER TABLE pirgs_groups ADD CONSTRAINT pirgs_groups_pirg_id_name_key UNIQUE (pirg_id, name);
+
+-- A user can't be deleted while they own a pirg; ownership has to be
+-- transferred first. Everything hanging off a pirg or a user goes with it.
+ALTER TABLE pirgs
+    DROP CONSTRAINT pirgs_owner_id_fkey,
+    ADD CONSTRAINT pirgs_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE RESTRICT;
+
+ALTER TABLE pirgs_users
+    DROP CONSTRAINT pirgs_users_pirg_id_fkey,
+    DROP CONSTRAINT pirgs_users_user_id_fkey,
+    ADD CONSTRAINT pirgs_users_pirg_id_fkey FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    ADD CONSTRAINT pirgs_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE pirgs_admins
+    DROP CONSTRAINT pirgs_admins_pirg_id_fkey,
+    DROP CONSTRAINT pirgs_admins_user_id_fkey,
+    ADD CONSTRAINT pirgs_admins_pirg_id_fkey FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    ADD CONSTRAINT pirgs_admins_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE pirgs_groups
+    DROP CONSTRAINT pirgs_groups_pirg_id_fkey,
+    ADD CONSTRAINT pirgs_groups_pirg_id_fkey FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE;
+
+ALTER TABLE groups_users
+    DROP CONSTRAINT groups_users_group_id_fkey,
+    DROP CONSTRAINT groups_users_user_id_fkey,
+    ADD CONSTRAINT groups_users_group_id_fkey FOREIGN KEY (group_id) REFERENCES pirgs_groups(id) ON DELETE CASCADE,
+    ADD CONSTRAINT groups_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE api_keys
+    DROP CONSTRAINT api_keys_user_id_fkey,
+    ADD CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+-- The unique constraints above index the (pirg_id, ...) side of each
+-- membership table, these cover lookups from the user side.
+CREATE INDEX pirgs_owner_id_idx ON pirgs (owner_id);
+CREATE INDEX pirgs_users_user_id_idx ON pirgs_users (user_id);
+CREATE INDEX pirgs_admins_user_id_idx ON pirgs_admins (user_id);
+CREATE INDEX groups_users_user_id_idx ON groups_users (user_id);
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
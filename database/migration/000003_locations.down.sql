@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS storage_allocations;
+DROP TABLE IF EXISTS locations;
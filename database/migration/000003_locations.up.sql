@@ -0,0 +1,33 @@
+-- Locations describe where resources live. They form a tree so that a
+-- filesystem can sit under a cluster or datacenter, under a site.
+CREATE TABLE locations (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('site', 'datacenter', 'cluster', 'filesystem')),
+    parent_id INT,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- NO ACTION rather than RESTRICT so a whole subtree can be removed in one statement
+    FOREIGN KEY (parent_id) REFERENCES locations(id) ON DELETE NO ACTION
+);
+CREATE TRIGGER update_locations_modtime BEFORE UPDATE ON locations FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+-- names are unique among siblings, and among top level locations
+CREATE UNIQUE INDEX locations_parent_id_name_key ON locations (COALESCE(parent_id, 0), name);
+CREATE INDEX locations_parent_id_idx ON locations (parent_id);
+
+CREATE TABLE storage_allocations (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    location_id INT NOT NULL,
+    path TEXT NOT NULL,
+    soft_limit_bytes BIGINT NOT NULL DEFAULT 0,
+    hard_limit_bytes BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    FOREIGN KEY (location_id) REFERENCES locations(id) ON DELETE RESTRICT,
+    UNIQUE (location_id, path)
+);
+CREATE TRIGGER update_storage_allocations_modtime BEFORE UPDATE ON storage_allocations FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX storage_allocations_pirg_id_idx ON storage_allocations (pirg_id);
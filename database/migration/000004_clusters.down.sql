@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS clusters_pirgs_users;
+DROP TABLE IF EXISTS clusters_pirgs;
+DROP TABLE IF EXISTS clusters;
@@ -0,0 +1,39 @@
+-- Clusters share one directory of users and pirgs, but a pirg only has
+-- access to the clusters it has been enabled on.
+CREATE TABLE clusters (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TRIGGER update_clusters_modtime BEFORE UPDATE ON clusters FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+CREATE TABLE clusters_pirgs (
+    id SERIAL PRIMARY KEY,
+    cluster_id INT NOT NULL,
+    pirg_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (cluster_id) REFERENCES clusters(id) ON DELETE CASCADE,
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    UNIQUE (cluster_id, pirg_id)
+);
+CREATE TRIGGER update_clusters_pirgs_modtime BEFORE UPDATE ON clusters_pirgs FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX clusters_pirgs_pirg_id_idx ON clusters_pirgs (pirg_id);
+
+-- The members of a pirg that can use the cluster. Removing a user from
+-- the pirg or disabling the pirg on the cluster removes their access too.
+CREATE TABLE clusters_pirgs_users (
+    id SERIAL PRIMARY KEY,
+    cluster_id INT NOT NULL,
+    pirg_id INT NOT NULL,
+    user_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (cluster_id, pirg_id) REFERENCES clusters_pirgs(cluster_id, pirg_id) ON DELETE CASCADE,
+    FOREIGN KEY (pirg_id, user_id) REFERENCES pirgs_users(pirg_id, user_id) ON DELETE CASCADE,
+    UNIQUE (cluster_id, pirg_id, user_id)
+);
+CREATE TRIGGER update_clusters_pirgs_users_modtime BEFORE UPDATE ON clusters_pirgs_users FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX clusters_pirgs_users_pirg_id_user_id_idx ON clusters_pirgs_users (pirg_id, user_id);
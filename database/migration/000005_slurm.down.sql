@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS pirgs_users_partitions;
+DROP TABLE IF EXISTS pirgs_users_qos;
+DROP TABLE IF EXISTS pirgs_partitions;
+DROP TABLE IF EXISTS pirgs_qos;
+DROP TABLE IF EXISTS partitions;
+DROP TABLE IF EXISTS qos;
@@ -0,0 +1,80 @@
+-- QOS are shared by every cluster, like they are in slurmdbd
+CREATE TABLE qos (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TRIGGER update_qos_modtime BEFORE UPDATE ON qos FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+-- A cluster can't be deleted while it still has partitions
+CREATE TABLE partitions (
+    id SERIAL PRIMARY KEY,
+    cluster_id INT NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (cluster_id) REFERENCES clusters(id) ON DELETE RESTRICT,
+    UNIQUE (cluster_id, name)
+);
+CREATE TRIGGER update_partitions_modtime BEFORE UPDATE ON partitions FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+-- Grants of QOS and partitions to pirgs. QOS and partitions can't be
+-- deleted while they are granted to anyone.
+CREATE TABLE pirgs_qos (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    qos_id INT NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    FOREIGN KEY (qos_id) REFERENCES qos(id) ON DELETE RESTRICT,
+    UNIQUE (pirg_id, qos_id)
+);
+CREATE TRIGGER update_pirgs_qos_modtime BEFORE UPDATE ON pirgs_qos FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE UNIQUE INDEX pirgs_qos_default_key ON pirgs_qos (pirg_id) WHERE is_default;
+
+CREATE TABLE pirgs_partitions (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    partition_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    FOREIGN KEY (partition_id) REFERENCES partitions(id) ON DELETE RESTRICT,
+    UNIQUE (pirg_id, partition_id)
+);
+CREATE TRIGGER update_pirgs_partitions_modtime BEFORE UPDATE ON pirgs_partitions FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+-- Grants to a single member of a pirg replace the pirg's grants for that
+-- member. They go away when the user leaves the pirg.
+CREATE TABLE pirgs_users_qos (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    user_id INT NOT NULL,
+    qos_id INT NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id, user_id) REFERENCES pirgs_users(pirg_id, user_id) ON DELETE CASCADE,
+    FOREIGN KEY (qos_id) REFERENCES qos(id) ON DELETE RESTRICT,
+    UNIQUE (pirg_id, user_id, qos_id)
+);
+CREATE TRIGGER update_pirgs_users_qos_modtime BEFORE UPDATE ON pirgs_users_qos FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE UNIQUE INDEX pirgs_users_qos_default_key ON pirgs_users_qos (pirg_id, user_id) WHERE is_default;
+
+CREATE TABLE pirgs_users_partitions (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    user_id INT NOT NULL,
+    partition_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id, user_id) REFERENCES pirgs_users(pirg_id, user_id) ON DELETE CASCADE,
+    FOREIGN KEY (partition_id) REFERENCES partitions(id) ON DELETE RESTRICT,
+    UNIQUE (pirg_id, user_id, partition_id)
+);
+CREATE TRIGGER update_pirgs_users_partitions_modtime BEFORE UPDATE ON pirgs_users_partitions FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
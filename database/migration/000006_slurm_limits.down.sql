@@ -0,0 +1,9 @@
+ALTER TABLE pirgs_users DROP COLUMN IF EXISTS max_wall;
+ALTER TABLE pirgs_users DROP COLUMN IF EXISTS max_jobs;
+ALTER TABLE pirgs_users DROP COLUMN IF EXISTS grp_tres;
+ALTER TABLE pirgs_users DROP COLUMN IF EXISTS fairshare;
+
+ALTER TABLE pirgs DROP COLUMN IF EXISTS max_wall;
+ALTER TABLE pirgs DROP COLUMN IF EXISTS max_jobs;
+ALTER TABLE pirgs DROP COLUMN IF EXISTS grp_tres;
+ALTER TABLE pirgs DROP COLUMN IF EXISTS fairshare;
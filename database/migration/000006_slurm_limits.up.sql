@@ -0,0 +1,11 @@
+-- Slurm association limits for each pirg's account and for each member's
+-- association inside it. NULL and '' leave the limit unset.
+ALTER TABLE pirgs ADD COLUMN fairshare INT;
+ALTER TABLE pirgs ADD COLUMN grp_tres TEXT NOT NULL DEFAULT '';
+ALTER TABLE pirgs ADD COLUMN max_jobs INT;
+ALTER TABLE pirgs ADD COLUMN max_wall TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE pirgs_users ADD COLUMN fairshare INT;
+ALTER TABLE pirgs_users ADD COLUMN grp_tres TEXT NOT NULL DEFAULT '';
+ALTER TABLE pirgs_users ADD COLUMN max_jobs INT;
+ALTER TABLE pirgs_users ADD COLUMN max_wall TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS compute_usage;
+DROP TABLE IF EXISTS compute_allocations;
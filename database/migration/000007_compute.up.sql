@@ -0,0 +1,40 @@
+-- Hours of compute given to a pirg to use between two dates
+CREATE TABLE compute_allocations (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    resource TEXT NOT NULL CHECK (resource IN ('cpu', 'gpu')),
+    hours DOUBLE PRECISION NOT NULL CHECK (hours > 0),
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    CHECK (end_date >= start_date)
+);
+CREATE TRIGGER update_compute_allocations_modtime BEFORE UPDATE ON compute_allocations FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX compute_allocations_pirg_id_idx ON compute_allocations (pirg_id);
+
+-- Finished jobs ingested from sacct, kept for historical reports. The
+-- username is kept when the user is deleted, or if they were never known,
+-- and the account is kept when the pirg is deleted. Clusters with usage
+-- can't be deleted.
+CREATE TABLE compute_usage (
+    id SERIAL PRIMARY KEY,
+    cluster_id INT NOT NULL,
+    job_id TEXT NOT NULL,
+    pirg_id INT,
+    account TEXT NOT NULL,
+    user_id INT,
+    username TEXT NOT NULL,
+    cpu_hours DOUBLE PRECISION NOT NULL DEFAULT 0,
+    gpu_hours DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ended_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (cluster_id) REFERENCES clusters(id) ON DELETE RESTRICT,
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE SET NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    UNIQUE (cluster_id, job_id)
+);
+CREATE TRIGGER update_compute_usage_modtime BEFORE UPDATE ON compute_usage FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX compute_usage_pirg_id_ended_at_idx ON compute_usage (pirg_id, ended_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS storage_usage;
+
+ALTER TABLE pirgs DROP COLUMN IF EXISTS gid;
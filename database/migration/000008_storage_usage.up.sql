@@ -0,0 +1,18 @@
+-- The unix group of a pirg, used to match quota reports to it
+ALTER TABLE pirgs ADD COLUMN gid INT UNIQUE CHECK (gid > 0);
+
+-- Space used by a pirg on a filesystem location over time, ingested from
+-- quota reports. Samples go away with the pirg or the location.
+CREATE TABLE storage_usage (
+    id SERIAL PRIMARY KEY,
+    pirg_id INT NOT NULL,
+    location_id INT NOT NULL,
+    used_bytes BIGINT NOT NULL CHECK (used_bytes >= 0),
+    sampled_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    FOREIGN KEY (location_id) REFERENCES locations(id) ON DELETE CASCADE
+);
+CREATE TRIGGER update_storage_usage_modtime BEFORE UPDATE ON storage_usage FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX storage_usage_pirg_id_location_id_sampled_at_idx ON storage_usage (pirg_id, location_id, sampled_at);
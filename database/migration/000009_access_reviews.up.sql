@@ -0,0 +1,31 @@
+-- Campaigns asking pirg owners to re-certify their members before a deadline
+CREATE TABLE access_reviews (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    deadline TIMESTAMP NOT NULL,
+    remove_unconfirmed BOOLEAN NOT NULL DEFAULT FALSE,
+    closed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TRIGGER update_access_reviews_modtime BEFORE UPDATE ON access_reviews FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+-- The members of each pirg when the review was opened and what the owner
+-- decided about them
+CREATE TABLE access_review_items (
+    id SERIAL PRIMARY KEY,
+    review_id INT NOT NULL,
+    pirg_id INT NOT NULL,
+    user_id INT NOT NULL,
+    decision TEXT NOT NULL DEFAULT 'pending' CHECK (decision IN ('pending', 'confirmed', 'removed')),
+    decided_by INT,
+    decided_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (review_id) REFERENCES access_reviews(id) ON DELETE CASCADE,
+    FOREIGN KEY (pirg_id) REFERENCES pirgs(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (decided_by) REFERENCES users(id) ON DELETE SET NULL,
+    UNIQUE (review_id, pirg_id, user_id)
+);
+CREATE TRIGGER update_access_review_items_modtime BEFORE UPDATE ON access_review_items FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
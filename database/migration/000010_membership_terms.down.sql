@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS audit_log;
+
+DROP INDEX IF EXISTS pirgs_users_expires_at_idx;
+ALTER TABLE pirgs_users
+    DROP COLUMN IF EXISTS starts_at,
+    DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,20 @@
+-- Memberships can be bound to a term. A null starts_at or expires_at leaves
+-- that end open.
+ALTER TABLE pirgs_users
+    ADD COLUMN starts_at TIMESTAMP,
+    ADD COLUMN expires_at TIMESTAMP,
+    ADD CONSTRAINT pirgs_users_term_check CHECK (starts_at IS NULL OR expires_at IS NULL OR expires_at > starts_at);
+CREATE INDEX pirgs_users_expires_at_idx ON pirgs_users (expires_at) WHERE expires_at IS NOT NULL;
+
+-- Changes made by the server itself, such as removing expired memberships.
+-- There are no foreign keys so entries outlive the pirgs and users they name.
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    pirg_id INT,
+    user_id INT,
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,20 @@
+-- Outbox of email waiting to be sent. Messages are rendered from their
+-- event's template with params when they're sent. dedupe_key keeps events
+-- that are checked for periodically, like expiring memberships, from being
+-- queued more than once.
+CREATE TABLE notifications (
+    id SERIAL PRIMARY KEY,
+    event TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    dedupe_key TEXT UNIQUE,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP,
+    failed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    modified_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TRIGGER update_notifications_modtime BEFORE UPDATE ON notifications FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+CREATE INDEX notifications_pending_idx ON notifications (next_attempt_at) WHERE sent_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS unix_id_marks;
+DROP INDEX IF EXISTS groups_users_pirg_id_user_id_idx;
+ALTER TABLE groups_users
+    DROP CONSTRAINT IF EXISTS groups_users_pirg_id_user_id_fkey,
+    DROP CONSTRAINT IF EXISTS groups_users_group_id_pirg_id_fkey,
+    DROP COLUMN IF EXISTS pirg_id;
+INSERT INTO groups_users (group_id, user_id)
+    SELECT group_id, user_id FROM groups_users_outside_pirg
+    ON CONFLICT DO NOTHING;
+DROP TABLE IF EXISTS groups_users_outside_pirg;
+ALTER TABLE pirgs_groups
+    DROP CONSTRAINT IF EXISTS pirgs_groups_id_pirg_id_key,
+    DROP COLUMN IF EXISTS gid;
+ALTER TABLE users DROP COLUMN IF EXISTS uid;
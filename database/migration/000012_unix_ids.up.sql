@@ -0,0 +1,36 @@
+-- Unix ids for the passwd and group exports. Users and pirg groups left
+-- without one are given the next free id in the configured range when
+-- they're exported. Pirgs already have a gid.
+ALTER TABLE users ADD COLUMN uid INT UNIQUE CHECK (uid > 0);
+ALTER TABLE pirgs_groups ADD COLUMN gid INT UNIQUE CHECK (gid > 0);
+
+-- Group members have to be members of the group's pirg. Removing a user
+-- from the pirg removes them from its groups too, like cluster access.
+ALTER TABLE groups_users ADD COLUMN pirg_id INT;
+UPDATE groups_users gu SET pirg_id = pg.pirg_id FROM pirgs_groups pg WHERE pg.id = gu.group_id;
+-- Members that aren't in the pirg are moved aside rather than dropped, so
+-- they can be checked and added back by hand. Migrating down restores them.
+CREATE TABLE groups_users_outside_pirg (
+    group_id INT NOT NULL REFERENCES pirgs_groups(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+WITH outside AS (
+    DELETE FROM groups_users gu WHERE NOT EXISTS (
+        SELECT 1 FROM pirgs_users pu WHERE pu.pirg_id = gu.pirg_id AND pu.user_id = gu.user_id
+    ) RETURNING group_id, user_id
+)
+INSERT INTO groups_users_outside_pirg (group_id, user_id) SELECT DISTINCT group_id, user_id FROM outside;
+ALTER TABLE groups_users ALTER COLUMN pirg_id SET NOT NULL;
+ALTER TABLE pirgs_groups ADD CONSTRAINT pirgs_groups_id_pirg_id_key UNIQUE (id, pirg_id);
+ALTER TABLE groups_users
+    ADD CONSTRAINT groups_users_group_id_pirg_id_fkey FOREIGN KEY (group_id, pirg_id) REFERENCES pirgs_groups(id, pirg_id) ON DELETE CASCADE,
+    ADD CONSTRAINT groups_users_pirg_id_user_id_fkey FOREIGN KEY (pirg_id, user_id) REFERENCES pirgs_users(pirg_id, user_id) ON DELETE CASCADE;
+CREATE INDEX groups_users_pirg_id_user_id_idx ON groups_users (pirg_id, user_id);
+
+-- The highest uid and gid handed out by the allocator, so that the id of
+-- a user or group that was deleted isn't given to someone else.
+CREATE TABLE unix_id_marks (
+    kind TEXT PRIMARY KEY,
+    last_id INT NOT NULL
+);
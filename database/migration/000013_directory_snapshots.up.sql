@@ -0,0 +1,10 @@
+-- The contents of the LDIF export, kept so that a later export can list
+-- the changes since an earlier one. A new snapshot is only saved when the
+-- digest of the entries changes.
+CREATE TABLE directory_snapshots (
+    id SERIAL PRIMARY KEY,
+    digest TEXT NOT NULL,
+    entries JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX directory_snapshots_created_at_idx ON directory_snapshots (created_at);
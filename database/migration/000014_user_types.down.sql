@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS users_sponsor_id_idx;
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_guest_check,
+    DROP CONSTRAINT IF EXISTS users_sponsor_id_fkey,
+    DROP COLUMN IF EXISTS deactivated_at,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS sponsor_id,
+    DROP COLUMN IF EXISTS type;
@@ -0,0 +1,12 @@
+-- Users are institutional accounts, guests or service accounts. Guests are
+-- sponsored by a pirg owner and expire, and are deactivated rather than
+-- deleted when they do or when their sponsor leaves. Deleting the sponsor
+-- leaves the guest without one until the sweep deactivates them.
+ALTER TABLE users
+    ADD COLUMN type TEXT NOT NULL DEFAULT 'institutional' CHECK (type IN ('institutional', 'guest', 'service')),
+    ADD COLUMN sponsor_id INT,
+    ADD COLUMN expires_at TIMESTAMP,
+    ADD COLUMN deactivated_at TIMESTAMP,
+    ADD CONSTRAINT users_sponsor_id_fkey FOREIGN KEY (sponsor_id) REFERENCES users(id) ON DELETE SET NULL,
+    ADD CONSTRAINT users_guest_check CHECK (type = 'guest' OR (sponsor_id IS NULL AND expires_at IS NULL));
+CREATE INDEX users_sponsor_id_idx ON users (sponsor_id) WHERE sponsor_id IS NOT NULL;
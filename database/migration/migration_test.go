@@ -0,0 +1,31 @@
+package migration
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestLatestVersion(t *testing.T) {
+	version, err := LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every version needs both an up and a down migration
+	entries, err := fs.ReadDir(FS, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var up, down int
+	for _, entry := range entries {
+		switch {
+		case strings.HasSuffix(entry.Name(), ".up.sql"):
+			up++
+		case strings.HasSuffix(entry.Name(), ".down.sql"):
+			down++
+		}
+	}
+	if up != int(version) || down != int(version) {
+		t.Fatalf("expected %d up and down migrations, got %d up and %d down", version, up, down)
+	}
+}
@@ -3,9 +3,13 @@ package api
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
 )
 
 // A completely separate router for administrator routes
@@ -20,5 +24,52 @@ func AdminRouter(ctx context.Context) chi.Router {
 	r.Get("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "admin: view user id %v", chi.URLParam(r, "userId"))
 	})
+	r.Get("/loglevel", GetLogLevel)
+	r.Put("/loglevel", SetLogLevel)
 	return r
 }
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+func (l *LogLevelResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newLogLevelResponse(level slog.Level) *LogLevelResponse {
+	return &LogLevelResponse{Level: strings.ToLower(level.String())}
+}
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+
+	level slog.Level
+}
+
+func (l *LogLevelRequest) Bind(r *http.Request) error {
+	level, err := logging.ParseLevel(l.Level)
+	if err != nil {
+		return err
+	}
+	l.level = level
+	return nil
+}
+
+// GetLogLevel returns the current log level
+func GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, newLogLevelResponse(logging.Level()))
+}
+
+// SetLogLevel changes the log level without restarting the server
+func SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	levelReq := &LogLevelRequest{}
+	if err := render.Bind(r, levelReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	previous := logging.Level()
+	logging.SetLevel(levelReq.level)
+	slog.InfoContext(r.Context(), "log level changed", "from", previous, "to", levelReq.level, "package", "api", "method", "SetLogLevel")
+	render.Render(w, r, newLogLevelResponse(levelReq.level))
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	previous := logging.Level()
+	t.Cleanup(func() { logging.SetLevel(previous) })
+
+	r := chi.NewRouter()
+	r.Use(render.SetContentType(render.ContentTypeJSON))
+	r.Mount("/admin", AdminRouter(context.Background()))
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	ts := &testServer{Server: srv}
+
+	resp := ts.do(t, "PUT", "/admin/loglevel", LogLevelRequest{Level: "debug"})
+	expectStatus(t, resp, http.StatusOK)
+	if logging.Level() != slog.LevelDebug {
+		t.Fatalf("expected debug level, got %v", logging.Level())
+	}
+
+	resp = ts.do(t, "GET", "/admin/loglevel", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var levelResponse LogLevelResponse
+	decodeResponse(t, resp, &levelResponse)
+	if levelResponse.Level != "debug" {
+		t.Fatalf("expected level debug, got %s", levelResponse.Level)
+	}
+
+	resp = ts.do(t, "PUT", "/admin/loglevel", LogLevelRequest{Level: "loud"})
+	expectStatus(t, resp, http.StatusBadRequest)
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type ClusterResponse struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+func (c *ClusterResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newClusterResponse(c *data.Cluster) *ClusterResponse {
+	return &ClusterResponse{
+		Id:          c.Id,
+		Name:        c.Name,
+		Description: c.Description,
+		CreatedAt:   c.CreatedAt,
+		ModifiedAt:  c.ModifiedAt,
+	}
+}
+
+// newClusterResponseList converts a list of Cluster objects into a list of render.Renderer objects
+func newClusterResponseList(clusters []*data.Cluster) []render.Renderer {
+	list := []render.Renderer{}
+	for _, cluster := range clusters {
+		list = append(list, newClusterResponse(cluster))
+	}
+	return list
+}
+
+type ClusterRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (c *ClusterRequest) Bind(r *http.Request) error {
+	if c.Name == "" {
+		return fmt.Errorf("missing required cluster fields: %+v", c)
+	}
+	return nil
+}
+
+func newClusterRequest(c *data.Cluster) *ClusterRequest {
+	return &ClusterRequest{
+		Name:        c.Name,
+		Description: c.Description,
+	}
+}
+
+type ClusterPirgResponse struct {
+	ClusterId  int       `json:"cluster_id"`
+	PirgId     int       `json:"pirg_id"`
+	UserIds    []int     `json:"user_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+func (c *ClusterPirgResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newClusterPirgResponse(cp *data.ClusterPirg) *ClusterPirgResponse {
+	userIds := cp.UserIds
+	if userIds == nil {
+		userIds = []int{}
+	}
+	return &ClusterPirgResponse{
+		ClusterId:  cp.ClusterId,
+		PirgId:     cp.PirgId,
+		UserIds:    userIds,
+		CreatedAt:  cp.CreatedAt,
+		ModifiedAt: cp.ModifiedAt,
+	}
+}
+
+// newClusterPirgResponseList converts a list of ClusterPirg objects into a list of render.Renderer objects
+func newClusterPirgResponseList(cps []*data.ClusterPirg) []render.Renderer {
+	list := []render.Renderer{}
+	for _, cp := range cps {
+		list = append(list, newClusterPirgResponse(cp))
+	}
+	return list
+}
+
+// ClusterPirgRequest lists the users of the pirg that can use the cluster
+type ClusterPirgRequest struct {
+	UserIds []int `json:"user_ids"`
+}
+
+func (c *ClusterPirgRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+type ClusterHandler struct {
+	store data.Store
+}
+
+func ClustersRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newClusterHandler(ctx)
+	r.Get("/", h.GetAllClusters)
+	r.Post("/", h.CreateCluster)
+	r.Route("/{clusterID}", func(r chi.Router) {
+		r.Use(h.ClusterCtx)
+		r.Get("/", h.GetCluster)
+		r.Put("/", h.UpdateCluster)
+		r.Delete("/", h.DeleteCluster)
+		r.Get("/pirgs", h.GetClusterPirgs)
+		r.With(requireAdmin).Post("/usage", newComputeHandler(ctx).UploadClusterUsage)
+		r.Route("/pirgs/{pirgID}", func(r chi.Router) {
+			r.Get("/", h.GetClusterPirg)
+			r.Put("/", h.SetClusterPirg)
+			r.Delete("/", h.DeleteClusterPirg)
+		})
+	})
+	return r
+}
+
+func newClusterHandler(ctx context.Context) *ClusterHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &ClusterHandler{store: store}
+}
+
+// GetAllClusters returns all existing clusters
+func (h *ClusterHandler) GetAllClusters(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting all clusters", "package", "api", "method", "GetAllClusters")
+	clusters, err := h.store.GetAllClusters(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newClusterResponseList(clusters)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreateCluster creates a new cluster
+func (h *ClusterHandler) CreateCluster(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating new cluster", "package", "api", "method", "CreateCluster")
+	clusterReq := &ClusterRequest{}
+	if err := render.Bind(r, clusterReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataCluster := data.ClusterRequest(*clusterReq)
+	newCluster, err := h.store.CreateCluster(r.Context(), &dataCluster)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newClusterResponse(newCluster))
+}
+
+// ClusterCtx middleware is used to load a Cluster object from /clusters/{clusterID} requests
+// and then attach it to the request context. In case of failure the request is aborted
+// and a 404 error response is sent to the client.
+func (h *ClusterHandler) ClusterCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clusterIDParam := chi.URLParam(r, "clusterID")
+		slog.DebugContext(r.Context(), "loading specific cluster ctx", "id", clusterIDParam, "package", "api", "method", "ClusterCtx")
+		clusterId, err := strconv.Atoi(clusterIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		cluster, err := h.store.GetClusterById(r.Context(), clusterId)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.ClusterKey, cluster)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetCluster returns the cluster in the request context
+func (h *ClusterHandler) GetCluster(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting cluster", "package", "api", "method", "GetCluster")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	if err := render.Render(w, r, newClusterResponse(cluster)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdateCluster updates a cluster
+func (h *ClusterHandler) UpdateCluster(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating cluster", "package", "api", "method", "UpdateCluster")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	clusterReq := newClusterRequest(cluster)
+	if err := render.Bind(r, clusterReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataClusterRequest := data.ClusterRequest(*clusterReq)
+	updatedCluster, err := h.store.UpdateCluster(r.Context(), cluster.Id, &dataClusterRequest)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newClusterResponse(updatedCluster))
+}
+
+// DeleteCluster deletes a cluster along with every pirg's access to it
+func (h *ClusterHandler) DeleteCluster(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting cluster", "package", "api", "method", "DeleteCluster")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	err := h.store.DeleteCluster(r.Context(), cluster.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
+
+// GetClusterPirgs returns the pirgs enabled on the cluster and their enabled users
+func (h *ClusterHandler) GetClusterPirgs(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirgs for cluster", "package", "api", "method", "GetClusterPirgs")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	cps, err := h.store.GetClusterPirgs(r.Context(), cluster.Id)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newClusterPirgResponseList(cps)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// clusterPirgId reads the pirg id from /clusters/{clusterID}/pirgs/{pirgID} requests
+func clusterPirgId(r *http.Request) (int, error) {
+	return strconv.Atoi(chi.URLParam(r, "pirgID"))
+}
+
+// GetClusterPirg returns the users of the pirg enabled on the cluster
+func (h *ClusterHandler) GetClusterPirg(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirg on cluster", "package", "api", "method", "GetClusterPirg")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	pirgId, err := clusterPirgId(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	cp, err := h.store.GetClusterPirg(r.Context(), cluster.Id, pirgId)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if err := render.Render(w, r, newClusterPirgResponse(cp)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// SetClusterPirg enables the pirg on the cluster for exactly the listed users
+func (h *ClusterHandler) SetClusterPirg(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "enabling pirg on cluster", "package", "api", "method", "SetClusterPirg")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	pirgId, err := clusterPirgId(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	cpReq := &ClusterPirgRequest{}
+	if err := render.Bind(r, cpReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	cp, err := h.store.SetClusterPirg(r.Context(), cluster.Id, pirgId, cpReq.UserIds)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newClusterPirgResponse(cp))
+}
+
+// DeleteClusterPirg takes the pirg's access to the cluster away
+func (h *ClusterHandler) DeleteClusterPirg(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "disabling pirg on cluster", "package", "api", "method", "DeleteClusterPirg")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	pirgId, err := clusterPirgId(r)
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := h.store.DeleteClusterPirg(r.Context(), cluster.Id, pirgId); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
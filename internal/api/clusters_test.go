@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func createTestCluster(t *testing.T, ts *testServer, cr ClusterRequest) ClusterResponse {
+	t.Helper()
+	resp := ts.do(t, "POST", "/api/v1/clusters", cr)
+	expectStatus(t, resp, http.StatusCreated)
+	var clusterResponse ClusterResponse
+	decodeResponse(t, resp, &clusterResponse)
+	return clusterResponse
+}
+
+func TestAPICreateCluster(t *testing.T) {
+	ts := newTestServer(t)
+	cr := ClusterRequest{Name: "testapicreatecluster", Description: "test cluster"}
+	clusterResponse := createTestCluster(t, ts, cr)
+	if clusterResponse.Name != cr.Name || clusterResponse.Description != cr.Description {
+		t.Errorf("expected cluster to match request %+v, got %+v", cr, clusterResponse)
+	}
+
+	resp := ts.do(t, "POST", "/api/v1/clusters", cr)
+	expectStatus(t, resp, http.StatusConflict)
+	resp = ts.do(t, "POST", "/api/v1/clusters", ClusterRequest{})
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestAPIClusterPirg(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapiclusterpirgowner")
+	member := createTestPirgOwner(t, ts, "testapiclusterpirgmember")
+	outsider := createTestPirgOwner(t, ts, "testapiclusterpirgoutsider")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapiclusterpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	cluster := createTestCluster(t, ts, ClusterRequest{Name: "testapiclusterpirgcluster"})
+	path := fmt.Sprintf("/api/v1/clusters/%d/pirgs/%d", cluster.Id, pirg.Id)
+
+	resp := ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusNotFound)
+	// only users of the pirg can be enabled
+	resp = ts.do(t, "PUT", path, ClusterPirgRequest{UserIds: []int{outsider.Id}})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "PUT", fmt.Sprintf("/api/v1/clusters/%d/pirgs/%d", cluster.Id, -1), ClusterPirgRequest{})
+	expectStatus(t, resp, http.StatusNotFound)
+
+	resp = ts.do(t, "PUT", path, ClusterPirgRequest{UserIds: []int{member.Id}})
+	expectStatus(t, resp, http.StatusOK)
+	var cpResponse ClusterPirgResponse
+	decodeResponse(t, resp, &cpResponse)
+	if !reflect.DeepEqual(cpResponse.UserIds, []int{member.Id}) {
+		t.Errorf("expected user_ids %v got %v", []int{member.Id}, cpResponse.UserIds)
+	}
+
+	resp = ts.do(t, "GET", fmt.Sprintf("/api/v1/clusters/%d/pirgs", cluster.Id), nil)
+	expectStatus(t, resp, http.StatusOK)
+	var cpResponses []ClusterPirgResponse
+	decodeResponse(t, resp, &cpResponses)
+	if len(cpResponses) != 1 || cpResponses[0].PirgId != pirg.Id {
+		t.Errorf("expected pirg %d on cluster, got %+v", pirg.Id, cpResponses)
+	}
+
+	resp = ts.do(t, "DELETE", path, nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp = ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusNotFound)
+}
@@ -0,0 +1,471 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/sacct"
+)
+
+// DateLayout is how allocation dates and report ranges are written
+const DateLayout = "2006-01-02"
+
+// maxUsageUploadBytes bounds the sacct output accepted in one upload
+const maxUsageUploadBytes = 64 << 20
+
+type ComputeAllocationResponse struct {
+	Id         int       `json:"id"`
+	PirgId     int       `json:"pirg_id"`
+	Resource   string    `json:"resource"`
+	Hours      float64   `json:"hours"`
+	StartDate  string    `json:"start_date"`
+	EndDate    string    `json:"end_date"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+func (c *ComputeAllocationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newComputeAllocationResponse(a *data.ComputeAllocation) *ComputeAllocationResponse {
+	return &ComputeAllocationResponse{
+		Id:         a.Id,
+		PirgId:     a.PirgId,
+		Resource:   a.Resource,
+		Hours:      a.Hours,
+		StartDate:  a.StartDate.Format(DateLayout),
+		EndDate:    a.EndDate.Format(DateLayout),
+		CreatedAt:  a.CreatedAt,
+		ModifiedAt: a.ModifiedAt,
+	}
+}
+
+// newComputeAllocationResponseList converts a list of ComputeAllocation objects into a list of render.Renderer objects
+func newComputeAllocationResponseList(allocations []*data.ComputeAllocation) []render.Renderer {
+	list := []render.Renderer{}
+	for _, allocation := range allocations {
+		list = append(list, newComputeAllocationResponse(allocation))
+	}
+	return list
+}
+
+type ComputeAllocationRequest struct {
+	Resource  string  `json:"resource"`
+	Hours     float64 `json:"hours"`
+	StartDate string  `json:"start_date"`
+	EndDate   string  `json:"end_date"`
+
+	start time.Time
+	end   time.Time
+}
+
+func (c *ComputeAllocationRequest) Bind(r *http.Request) error {
+	if c.Resource == "" || c.StartDate == "" || c.EndDate == "" {
+		return fmt.Errorf("missing required compute allocation fields: %+v", c)
+	}
+	var err error
+	if c.start, err = time.Parse(DateLayout, c.StartDate); err != nil {
+		return fmt.Errorf("invalid start_date %q, expected YYYY-MM-DD", c.StartDate)
+	}
+	if c.end, err = time.Parse(DateLayout, c.EndDate); err != nil {
+		return fmt.Errorf("invalid end_date %q, expected YYYY-MM-DD", c.EndDate)
+	}
+	return nil
+}
+
+func (c *ComputeAllocationRequest) data() *data.ComputeAllocationRequest {
+	return &data.ComputeAllocationRequest{Resource: c.Resource, Hours: c.Hours, StartDate: c.start, EndDate: c.end}
+}
+
+func newComputeAllocationRequest(a *data.ComputeAllocation) *ComputeAllocationRequest {
+	return &ComputeAllocationRequest{
+		Resource:  a.Resource,
+		Hours:     a.Hours,
+		StartDate: a.StartDate.Format(DateLayout),
+		EndDate:   a.EndDate.Format(DateLayout),
+	}
+}
+
+type ComputeBalanceResponse struct {
+	Allocation          *ComputeAllocationResponse `json:"allocation"`
+	UsedHours           float64                    `json:"used_hours"`
+	RemainingHours      float64                    `json:"remaining_hours"`
+	UsedFraction        float64                    `json:"used_fraction"`
+	BurnRate            float64                    `json:"burn_rate"`
+	ProjectedExhaustion *time.Time                 `json:"projected_exhaustion"`
+	Flagged             bool                       `json:"flagged"`
+}
+
+func (c *ComputeBalanceResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newComputeBalanceResponse(b *data.ComputeBalance) *ComputeBalanceResponse {
+	return &ComputeBalanceResponse{
+		Allocation:          newComputeAllocationResponse(b.Allocation),
+		UsedHours:           b.UsedHours,
+		RemainingHours:      b.RemainingHours,
+		UsedFraction:        b.UsedFraction,
+		BurnRate:            b.BurnRate,
+		ProjectedExhaustion: b.ProjectedExhaustion,
+		Flagged:             b.Flagged,
+	}
+}
+
+type UserUsageResponse struct {
+	UserId   *int    `json:"user_id"`
+	Username string  `json:"username"`
+	Jobs     int     `json:"jobs"`
+	CPUHours float64 `json:"cpu_hours"`
+	GPUHours float64 `json:"gpu_hours"`
+}
+
+// PirgUsageResponse totals the jobs of a pirg that ended from From through To
+type PirgUsageResponse struct {
+	PirgId   int                 `json:"pirg_id"`
+	From     string              `json:"from"`
+	To       string              `json:"to"`
+	Jobs     int                 `json:"jobs"`
+	CPUHours float64             `json:"cpu_hours"`
+	GPUHours float64             `json:"gpu_hours"`
+	Users    []UserUsageResponse `json:"users"`
+}
+
+func (p *PirgUsageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// UsageUploadResponse reports what was done with an sacct upload. Jobs
+// charged to accounts that aren't pirgs are skipped.
+type UsageUploadResponse struct {
+	Recorded        int      `json:"recorded"`
+	Skipped         int      `json:"skipped"`
+	UnknownAccounts []string `json:"unknown_accounts"`
+}
+
+func (u *UsageUploadResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type ComputeHandler struct {
+	store data.Store
+}
+
+// ComputeRouter is mounted below /pirgs/{pirgID}, so the pirg
+// is already loaded into the request context. Only admins can change
+// allocations.
+func ComputeRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newComputeHandler(ctx)
+	r.Get("/", h.GetPirgComputeAllocations)
+	r.With(requireAdmin).Post("/", h.CreateComputeAllocation)
+	r.Get("/usage", h.GetPirgUsage)
+	r.Route("/{allocationID}", func(r chi.Router) {
+		r.Use(h.ComputeAllocationCtx)
+		r.Get("/", h.GetComputeAllocation)
+		r.With(requireAdmin).Put("/", h.UpdateComputeAllocation)
+		r.With(requireAdmin).Delete("/", h.DeleteComputeAllocation)
+		r.Get("/balance", h.GetComputeBalance)
+	})
+	return r
+}
+
+// ComputeReportsRouter reports on the allocations of every pirg
+func ComputeReportsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newComputeHandler(ctx)
+	r.Get("/balances", h.GetComputeBalances)
+	return r
+}
+
+func newComputeHandler(ctx context.Context) *ComputeHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &ComputeHandler{store: store}
+}
+
+// GetPirgComputeAllocations returns the compute allocations for the pirg
+func (h *ComputeHandler) GetPirgComputeAllocations(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting compute allocations", "package", "api", "method", "GetPirgComputeAllocations")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	allocations, err := h.store.GetPirgComputeAllocations(r.Context(), pirg.Id)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newComputeAllocationResponseList(allocations)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreateComputeAllocation gives the pirg cpu or gpu hours
+func (h *ComputeHandler) CreateComputeAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating compute allocation", "package", "api", "method", "CreateComputeAllocation")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	allocationReq := &ComputeAllocationRequest{}
+	if err := render.Bind(r, allocationReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	allocation, err := h.store.CreateComputeAllocation(r.Context(), pirg.Id, allocationReq.data())
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newComputeAllocationResponse(allocation))
+}
+
+// ComputeAllocationCtx middleware loads the compute allocation from the URL,
+// making sure it belongs to the pirg in the request context
+func (h *ComputeHandler) ComputeAllocationCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+		allocationIDParam := chi.URLParam(r, "allocationID")
+		slog.DebugContext(r.Context(), "loading specific compute allocation ctx", "id", allocationIDParam, "package", "api", "method", "ComputeAllocationCtx")
+		allocationId, err := strconv.Atoi(allocationIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		allocation, err := h.store.GetComputeAllocationById(r.Context(), allocationId)
+		if err != nil || allocation.PirgId != pirg.Id {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.ComputeAllocationKey, allocation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetComputeAllocation returns the compute allocation in the request context
+func (h *ComputeHandler) GetComputeAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting compute allocation", "package", "api", "method", "GetComputeAllocation")
+	allocation := r.Context().Value(keys.ComputeAllocationKey).(*data.ComputeAllocation)
+	if err := render.Render(w, r, newComputeAllocationResponse(allocation)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdateComputeAllocation updates a compute allocation
+func (h *ComputeHandler) UpdateComputeAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating compute allocation", "package", "api", "method", "UpdateComputeAllocation")
+	allocation := r.Context().Value(keys.ComputeAllocationKey).(*data.ComputeAllocation)
+	allocationReq := newComputeAllocationRequest(allocation)
+	if err := render.Bind(r, allocationReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	updated, err := h.store.UpdateComputeAllocation(r.Context(), allocation.Id, allocationReq.data())
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newComputeAllocationResponse(updated))
+}
+
+// DeleteComputeAllocation deletes a compute allocation. Usage is kept.
+func (h *ComputeHandler) DeleteComputeAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting compute allocation", "package", "api", "method", "DeleteComputeAllocation")
+	allocation := r.Context().Value(keys.ComputeAllocationKey).(*data.ComputeAllocation)
+	if err := h.store.DeleteComputeAllocation(r.Context(), allocation.Id); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
+
+// balance works out the balance of the allocation as of now
+func (h *ComputeHandler) balance(ctx context.Context, allocation *data.ComputeAllocation, now time.Time) (*data.ComputeBalance, error) {
+	from, to := allocation.Window()
+	usage, err := h.store.GetPirgUsage(ctx, allocation.PirgId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return data.NewComputeBalance(allocation, usage, now), nil
+}
+
+// GetComputeBalance returns how much of the allocation has been used and how fast
+func (h *ComputeHandler) GetComputeBalance(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting compute balance", "package", "api", "method", "GetComputeBalance")
+	allocation := r.Context().Value(keys.ComputeAllocationKey).(*data.ComputeAllocation)
+	balance, err := h.balance(r.Context(), allocation, time.Now())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.Render(w, r, newComputeBalanceResponse(balance)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetComputeBalances returns the balances of the allocations that are
+// currently active, or only the ones nearing exhaustion with ?flagged=true
+func (h *ComputeHandler) GetComputeBalances(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting compute balances", "package", "api", "method", "GetComputeBalances")
+	flaggedOnly := r.URL.Query().Get("flagged") == "true"
+	allocations, err := h.store.GetAllComputeAllocations(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	now := time.Now()
+	list := []render.Renderer{}
+	for _, allocation := range allocations {
+		from, to := allocation.Window()
+		if now.Before(from) || !now.Before(to) {
+			continue
+		}
+		balance, err := h.balance(r.Context(), allocation, now)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		if flaggedOnly && !balance.Flagged {
+			continue
+		}
+		list = append(list, newComputeBalanceResponse(balance))
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// usageRange reads the from and to dates of a usage report. Both are
+// included, from defaults to the first of the month and to to today.
+func usageRange(r *http.Request, now time.Time) (from time.Time, to time.Time, err error) {
+	to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if param := r.URL.Query().Get("from"); param != "" {
+		if from, err = time.Parse(DateLayout, param); err != nil {
+			return from, to, fmt.Errorf("invalid from %q, expected YYYY-MM-DD", param)
+		}
+	}
+	if param := r.URL.Query().Get("to"); param != "" {
+		if to, err = time.Parse(DateLayout, param); err != nil {
+			return from, to, fmt.Errorf("invalid to %q, expected YYYY-MM-DD", param)
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	return from, to, nil
+}
+
+// GetPirgUsage returns the usage of the pirg and each of its users between
+// the from and to dates
+func (h *ComputeHandler) GetPirgUsage(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirg compute usage", "package", "api", "method", "GetPirgUsage")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	from, to, err := usageRange(r, time.Now().UTC())
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	usage, err := h.store.GetPirgUsage(r.Context(), pirg.Id, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	resp := &PirgUsageResponse{PirgId: pirg.Id, From: from.Format(DateLayout), To: to.Format(DateLayout), Users: []UserUsageResponse{}}
+	for _, u := range usage {
+		resp.Jobs += u.Jobs
+		resp.CPUHours += u.CPUHours
+		resp.GPUHours += u.GPUHours
+		resp.Users = append(resp.Users, UserUsageResponse(*u))
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UploadClusterUsage records the jobs in `sacct --parsable2` output sent
+// as the request body. It's mounted below /clusters/{clusterID}. The job
+// account is the pirg name. Times are read in the timezone given by ?tz=,
+// UTC if there isn't one.
+func (h *ComputeHandler) UploadClusterUsage(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "uploading cluster usage", "package", "api", "method", "UploadClusterUsage")
+	cluster := r.Context().Value(keys.ClusterKey).(*data.Cluster)
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid tz %q", tz)))
+			return
+		}
+	}
+	jobs, err := sacct.Parse(http.MaxBytesReader(w, r.Body, maxUsageUploadBytes), loc)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	resp := &UsageUploadResponse{UnknownAccounts: []string{}}
+	pirgIds := make(map[string]int)
+	userIds := make(map[string]*int)
+	var usage []*data.JobUsage
+	for _, job := range jobs {
+		pirgId, ok := pirgIds[job.Account]
+		if !ok {
+			pirg, err := h.store.GetPirgByName(r.Context(), job.Account)
+			switch {
+			case errors.Is(err, data.ErrNotFound):
+				resp.UnknownAccounts = append(resp.UnknownAccounts, job.Account)
+			case err != nil:
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			default:
+				pirgId = pirg.Id
+			}
+			pirgIds[job.Account] = pirgId
+		}
+		if pirgId == 0 {
+			resp.Skipped++
+			continue
+		}
+		userId, ok := userIds[job.User]
+		if !ok {
+			user, err := h.store.GetUserByUsername(r.Context(), job.User)
+			switch {
+			case errors.Is(err, data.ErrNotFound):
+				// the usage is still charged to the pirg
+			case err != nil:
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			default:
+				userId = &user.Id
+			}
+			userIds[job.User] = userId
+		}
+		usage = append(usage, &data.JobUsage{
+			ClusterId: cluster.Id,
+			JobId:     job.JobId,
+			PirgId:    pirgId,
+			Account:   job.Account,
+			UserId:    userId,
+			Username:  job.User,
+			CPUHours:  job.CPUHours,
+			GPUHours:  job.GPUHours,
+			EndedAt:   job.End,
+		})
+	}
+	if len(usage) > 0 {
+		if err := h.store.RecordJobUsage(r.Context(), usage); err != nil {
+			render.Render(w, r, ErrStore(err))
+			return
+		}
+	}
+	resp.Recorded = len(usage)
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
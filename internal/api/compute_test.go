@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIComputeAllocation(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapicomputeowner")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapicomputepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	cluster := createTestCluster(t, ts, ClusterRequest{Name: "testapicomputecluster"})
+	path := fmt.Sprintf("/api/v1/pirgs/%d/compute", pirg.Id)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	ar := ComputeAllocationRequest{
+		Resource:  "cpu",
+		Hours:     1000,
+		StartDate: today.AddDate(0, 0, -10).Format(DateLayout),
+		EndDate:   today.AddDate(0, 0, 89).Format(DateLayout),
+	}
+	resp := ts.do(t, "POST", path, ar)
+	expectStatus(t, resp, http.StatusCreated)
+	var allocation ComputeAllocationResponse
+	decodeResponse(t, resp, &allocation)
+	if allocation.StartDate != ar.StartDate || allocation.EndDate != ar.EndDate || allocation.Hours != ar.Hours {
+		t.Fatalf("expected allocation to match request %+v, got %+v", ar, allocation)
+	}
+
+	resp = ts.do(t, "POST", path, ComputeAllocationRequest{Resource: "cpu", Hours: 1, StartDate: "tomorrow", EndDate: ar.EndDate})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "POST", path, ComputeAllocationRequest{Resource: "tpu", Hours: 1, StartDate: ar.StartDate, EndDate: ar.EndDate})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	// only admins can allocate
+	ts.Role = "user"
+	resp = ts.do(t, "POST", path, ar)
+	expectStatus(t, resp, http.StatusForbidden)
+	resp = ts.do(t, "POST", fmt.Sprintf("/api/v1/clusters/%d/usage", cluster.Id), nil)
+	expectStatus(t, resp, http.StatusForbidden)
+	ts.Role = "admin"
+
+	yesterday := today.AddDate(0, 0, -1)
+	sacctOutput := "JobID|Account|User|End|ElapsedRaw|AllocTRES\n" +
+		fmt.Sprintf("1|testapicomputepirg|testapicomputeowner|%s|36000|cpu=40\n", yesterday.Format("2006-01-02T15:04:05")) +
+		fmt.Sprintf("1.batch|testapicomputepirg||%s|36000|cpu=40\n", yesterday.Format("2006-01-02T15:04:05")) +
+		fmt.Sprintf("2|testapicomputepirg|someoneelse|%s|3600|cpu=100\n", yesterday.Format("2006-01-02T15:04:05")) +
+		fmt.Sprintf("3|notapirg|testapicomputeowner|%s|3600|cpu=1\n", yesterday.Format("2006-01-02T15:04:05"))
+	resp = ts.doRaw(t, "POST", fmt.Sprintf("/api/v1/clusters/%d/usage", cluster.Id), sacctOutput)
+	expectStatus(t, resp, http.StatusOK)
+	var upload UsageUploadResponse
+	decodeResponse(t, resp, &upload)
+	if upload.Recorded != 2 || upload.Skipped != 1 || len(upload.UnknownAccounts) != 1 || upload.UnknownAccounts[0] != "notapirg" {
+		t.Fatalf("unexpected upload result %+v", upload)
+	}
+
+	resp = ts.do(t, "GET", fmt.Sprintf("%s/usage?from=%s&to=%s", path, ar.StartDate, today.Format(DateLayout)), nil)
+	expectStatus(t, resp, http.StatusOK)
+	var usage PirgUsageResponse
+	decodeResponse(t, resp, &usage)
+	if usage.Jobs != 2 || usage.CPUHours != 500 || len(usage.Users) != 2 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	if usage.Users[1].Username != "testapicomputeowner" || usage.Users[1].UserId == nil || *usage.Users[1].UserId != owner.Id {
+		t.Fatalf("expected usage for the owner, got %+v", usage.Users[1])
+	}
+	resp = ts.do(t, "GET", path+"/usage?from=2024-02-01&to=2024-01-01", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	// 500 hours in 10 days won't last the 100 days
+	resp = ts.do(t, "GET", fmt.Sprintf("%s/%d/balance", path, allocation.Id), nil)
+	expectStatus(t, resp, http.StatusOK)
+	var balance ComputeBalanceResponse
+	decodeResponse(t, resp, &balance)
+	if balance.UsedHours != 500 || balance.RemainingHours != 500 || !balance.Flagged || balance.ProjectedExhaustion == nil {
+		t.Fatalf("expected a flagged balance, got %+v", balance)
+	}
+	resp = ts.do(t, "GET", "/api/v1/compute/balances?flagged=true", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var balances []ComputeBalanceResponse
+	decodeResponse(t, resp, &balances)
+	if len(balances) != 1 || balances[0].Allocation.Id != allocation.Id {
+		t.Fatalf("expected only allocation %d to be flagged, got %+v", allocation.Id, balances)
+	}
+
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, allocation.Id), map[string]any{"hours": 100000})
+	expectStatus(t, resp, http.StatusOK)
+	resp = ts.do(t, "GET", "/api/v1/compute/balances?flagged=true", nil)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &balances)
+	if len(balances) != 0 {
+		t.Fatalf("expected no flagged allocations, got %+v", balances)
+	}
+
+	// the cluster has usage now
+	resp = ts.do(t, "DELETE", fmt.Sprintf("/api/v1/clusters/%d", cluster.Id), nil)
+	expectStatus(t, resp, http.StatusConflict)
+
+	resp = ts.do(t, "DELETE", fmt.Sprintf("%s/%d", path, allocation.Id), nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp = ts.do(t, "GET", fmt.Sprintf("%s/%d", path, allocation.Id), nil)
+	expectStatus(t, resp, http.StatusNotFound)
+}
@@ -1,56 +1,118 @@
 package api
 
 import (
-	"database/sql"
-	"log"
-	"os"
-	"strconv"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notifications"
 )
 
-type testDataHandler struct {
-	DB *sql.DB
+// testServer serves the api routes from an in-memory store, to callers
+// with Role and, if it's set, the user id CallerId
+type testServer struct {
+	*httptest.Server
+	Store    data.Store
+	Role     string
+	CallerId int
 }
 
-func NewTestDataHandler() *testDataHandler {
-	host, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_HOST")
-	if !found {
-		panic("HPCADMIN_TEST_DATABASE_HOST not set")
-	}
-	portStr, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_PORT")
-	if !found {
-		panic("HPCADMIN_TEST_DATABASE_PORT not set")
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	store := data.NewMemoryStore()
+	ctx := context.WithValue(context.Background(), keys.StoreKey, data.Store(store))
+	ctx = context.WithValue(ctx, keys.NotifierKey, notifications.NewNotifier(store))
+	ts := &testServer{Store: store, Role: "admin"}
+
+	r := chi.NewRouter()
+	r.Use(render.SetContentType(render.ContentTypeJSON))
+	// stands in for the auth middlewares
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), keys.RoleKey, ts.Role)
+			if ts.CallerId != 0 {
+				ctx = context.WithValue(ctx, keys.CallerIdKey, ts.CallerId)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Mount("/users", UsersRouter(ctx))
+		r.Mount("/pirgs", PirgsRouter(ctx))
+		r.Mount("/locations", LocationsRouter(ctx))
+		r.Mount("/clusters", ClustersRouter(ctx))
+		r.Mount("/partitions", PartitionsRouter(ctx))
+		r.Mount("/qos", QOSRouter(ctx))
+		r.Mount("/compute", ComputeReportsRouter(ctx))
+		r.Mount("/reports", ReportsRouter(ctx))
+		r.Mount("/reviews", ReviewsRouter(ctx))
+		r.Mount("/export", ExportRouter(ctx))
+		r.Mount("/manifest", ManifestRouter(ctx))
+	})
+
+	ts.Server = httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request with body encoded as json, if present
+func (ts *testServer) do(t *testing.T, method string, path string, body any) *http.Response {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewBuffer(b)
 	}
-	port, err := strconv.Atoi(portStr)
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
 	if err != nil {
-		panic("HPCADMIN_TEST_DATABASE_PORT not an integer")
-	}
-	user, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_USERNAME")
-	if !found {
-		panic("HPCADMIN_TEST_DATABASE_USERNAME not set")
+		t.Fatal(err)
 	}
-	password, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_PASSWORD")
-	if !found {
-		panic("HPCADMIN_TEST_DATABASE_PASSWORD not set")
-	}
-	dbname, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_NAME")
-	if !found {
-		panic("HPCADMIN_TEST_DATABASE_NAME not set")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
 	}
-	dbr := data.DBRequest{
-		Host:       host,
-		Port:       port,
-		User:       user,
-		Password:   password,
-		DBName:     dbname,
-		DisableSSL: true,
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// doRaw sends a request with a body that isn't json, like an uploaded report
+func (ts *testServer) doRaw(t *testing.T, method string, path string, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
 	}
-	db, err := data.NewDBConn(dbr)
+	resp, err := ts.Client().Do(req)
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func expectStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, want)
 	}
-	return &testDataHandler{
-		DB: db,
+}
+
+func decodeResponse(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
 	}
 }
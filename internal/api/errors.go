@@ -1,8 +1,11 @@
 package api
 
 import (
-	"github.com/go-chi/render"
+	"errors"
 	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
 )
 
 //--
@@ -55,4 +58,28 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		StatusText:     "Conflict.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrStore picks the response for an error returned by a data store.
+// Anything that isn't a missing record or a conflict is treated as
+// a request that failed validation.
+func ErrStore(err error) render.Renderer {
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, data.ErrConflict):
+		return ErrConflict(err)
+	}
+	return ErrInvalidRequest(err)
+}
+
 var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
+
+var ErrForbidden = &ErrResponse{HTTPStatusCode: 403, StatusText: "Forbidden."}
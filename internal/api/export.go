@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/export"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// UnixProblemsResponse is sent with a 409 instead of a passwd or group
+// file that would have collisions or invalid names in it
+type UnixProblemsResponse struct {
+	*ErrResponse
+	Collisions []export.Collision `json:"collisions"`
+	Invalid    []string           `json:"invalid"`
+}
+
+func newUnixProblemsResponse(problems *export.UnixProblems) *UnixProblemsResponse {
+	return &UnixProblemsResponse{
+		ErrResponse: &ErrResponse{
+			Err:            problems,
+			HTTPStatusCode: http.StatusConflict,
+			StatusText:     "Conflict.",
+			ErrorText:      "the export has collisions or invalid names",
+		},
+		Collisions: problems.Collisions,
+		Invalid:    problems.Invalid,
+	}
+}
+
+type ExportHandler struct {
+	store data.Store
+	opts  *export.UnixOptions
+	// optsErr is returned by every request when the unix config is invalid
+	optsErr error
+	// ldif is nil when the ldap config has no base dn
+	ldif *export.LDIFOptions
+}
+
+// ExportRouter serves files for the nodes that poll the server. Each
+// response has an ETag, so a node can send If-None-Match and get a 304 when
+// nothing has changed.
+func ExportRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newExportHandler(ctx)
+	r.Get("/group", h.GetGroup)
+	r.Get("/passwd", h.GetPasswd)
+	r.Get("/ldif", h.GetLDIF)
+	return r
+}
+
+func newExportHandler(ctx context.Context) *ExportHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	var unix config.UnixConfig
+	var ldap config.LDAPConfig
+	if cfg, ok := ctx.Value(keys.ConfigKey).(*config.ServerConfig); ok {
+		unix, ldap = cfg.Unix, cfg.LDAP
+	}
+	h := &ExportHandler{store: store}
+	h.opts, h.optsErr = export.NewUnixOptions(unix)
+	if h.optsErr == nil && ldap.Enabled() {
+		h.ldif, _ = export.NewLDIFOptions(ldap, h.opts)
+	}
+	return h
+}
+
+// GetGroup returns every pirg and pirg group in /etc/group format
+func (h *ExportHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "exporting group file", "package", "api", "method", "GetGroup")
+	h.serveFile(w, r, func(buf *bytes.Buffer) error {
+		return export.Group(r.Context(), h.store, h.opts, buf)
+	})
+}
+
+// GetPasswd returns every user in /etc/passwd format
+func (h *ExportHandler) GetPasswd(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "exporting passwd file", "package", "api", "method", "GetPasswd")
+	h.serveFile(w, r, func(buf *bytes.Buffer) error {
+		return export.Passwd(r.Context(), h.store, h.opts, buf)
+	})
+}
+
+// GetLDIF returns every user, pirg and pirg group as a full LDIF dump, or
+// with ?since= the change records after the export with that snapshot time
+func (h *ExportHandler) GetLDIF(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "exporting ldif", "package", "api", "method", "GetLDIF")
+	if h.ldif == nil {
+		render.Render(w, r, &ErrResponse{HTTPStatusCode: http.StatusNotFound, StatusText: "Resource not found.", ErrorText: export.ErrLDIFDisabled.Error()})
+		return
+	}
+	param := r.URL.Query().Get("since")
+	if param == "" {
+		h.serveFile(w, r, func(buf *bytes.Buffer) error {
+			return export.LDIF(r.Context(), h.store, h.ldif, buf)
+		})
+		return
+	}
+	since, err := time.Parse(time.RFC3339Nano, param)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid since %q, must be an RFC 3339 time", param)))
+		return
+	}
+	h.serveFile(w, r, func(buf *bytes.Buffer) error {
+		return export.LDIFChanges(r.Context(), h.store, h.ldif, since, buf)
+	})
+}
+
+// serveFile writes the file as plain text with an ETag of its contents
+func (h *ExportHandler) serveFile(w http.ResponseWriter, r *http.Request, write func(*bytes.Buffer) error) {
+	if h.optsErr != nil {
+		render.Render(w, r, ErrInternalServer(h.optsErr))
+		return
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		var problems *export.UnixProblems
+		if errors.As(err, &problems) {
+			slog.WarnContext(r.Context(), "refusing to export a file with problems", "path", r.URL.Path, "error", problems, "package", "api", "method", "serveFile")
+			render.Render(w, r, newUnixProblemsResponse(problems))
+			return
+		}
+		if errors.Is(err, export.ErrSnapshotExpired) {
+			render.Render(w, r, &ErrResponse{Err: err, HTTPStatusCode: http.StatusGone, StatusText: "Gone.", ErrorText: err.Error()})
+			return
+		}
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes()))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// etagMatches reports whether an If-None-Match header names the etag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
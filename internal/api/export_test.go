@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+func TestAPIExport(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapiexportowner")
+	createTestPirg(t, ts, PirgRequest{
+		Name:     "testapiexportpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+
+	get := func(path string, etag string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get("/api/v1/export/group", "")
+	expectStatus(t, resp, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "testapiexportpirg:x:200000:testapiexportowner\n"; string(body) != want {
+		t.Fatalf("unexpected group file %q, want %q", body, want)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an etag")
+	}
+
+	resp = get("/api/v1/export/group", etag)
+	expectStatus(t, resp, http.StatusNotModified)
+	resp = get("/api/v1/export/group", `"other", W/`+etag)
+	expectStatus(t, resp, http.StatusNotModified)
+
+	resp = get("/api/v1/export/passwd", "")
+	expectStatus(t, resp, http.StatusOK)
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "testapiexportowner:x:100000:100:TestAPI PirgOwner:/home/testapiexportowner:/bin/bash\n"; string(body) != want {
+		t.Fatalf("unexpected passwd file %q, want %q", body, want)
+	}
+
+	// a new member changes the file
+	member := createTestPirgOwner(t, ts, "testapiexportmember")
+	pirgs, err := ts.Store.GetAllPirgs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := &data.PirgRequest{Name: pirgs[0].Name, OwnerId: owner.Id, Gid: pirgs[0].Gid, AdminIds: pirgs[0].AdminIds, UserIds: []int{owner.Id, member.Id}}
+	if _, err := ts.Store.UpdatePirg(context.Background(), pirgs[0].Id, pr); err != nil {
+		t.Fatal(err)
+	}
+	resp = get("/api/v1/export/group", etag)
+	expectStatus(t, resp, http.StatusOK)
+
+	// a pirg group named after the pirg collides with it
+	if _, err := ts.Store.CreatePirgGroup(context.Background(), pirgs[0].Id, &data.PirgGroupRequest{Name: pirgs[0].Name}); err != nil {
+		t.Fatal(err)
+	}
+	resp = get("/api/v1/export/group", "")
+	expectStatus(t, resp, http.StatusConflict)
+	var problems struct {
+		Collisions []struct {
+			Kind    string   `json:"kind"`
+			Value   string   `json:"value"`
+			Entries []string `json:"entries"`
+		} `json:"collisions"`
+	}
+	decodeResponse(t, resp, &problems)
+	if len(problems.Collisions) != 1 || problems.Collisions[0].Kind != "name" || problems.Collisions[0].Value != "testapiexportpirg" {
+		t.Fatalf("expected the name collision to be reported, got %+v", problems)
+	}
+}
+
+func TestAPIExportLDIF(t *testing.T) {
+	ts := newTestServer(t)
+	resp := ts.do(t, "GET", "/api/v1/export/ldif", nil)
+	expectStatus(t, resp, http.StatusNotFound)
+
+	// the test server has no configuration, so the ldif export gets its own
+	ctx := context.WithValue(context.Background(), keys.StoreKey, ts.Store)
+	ctx = context.WithValue(ctx, keys.ConfigKey, &config.ServerConfig{LDAP: config.LDAPConfig{BaseDN: "dc=example,dc=edu"}})
+	srv := httptest.NewServer(ExportRouter(ctx))
+	t.Cleanup(srv.Close)
+	owner := createTestPirgOwner(t, ts, "testapildifowner")
+	createTestPirg(t, ts, PirgRequest{
+		Name:     "testapildifpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+
+	get := func(query string) (*http.Response, string) {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + "/ldif" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+	resp, body := get("")
+	expectStatus(t, resp, http.StatusOK)
+	if !strings.Contains(body, "dn: uid=testapildifowner,ou=people,dc=example,dc=edu\n") || !strings.Contains(body, "memberUid: testapildifowner\n") {
+		t.Fatalf("unexpected ldif:\n%s", body)
+	}
+	since := strings.TrimPrefix(strings.Split(body, "\n")[1], "# hpcadmin-server snapshot ")
+
+	createTestPirgOwner(t, ts, "testapildifnew")
+	resp, body = get("?since=" + url.QueryEscape(since))
+	expectStatus(t, resp, http.StatusOK)
+	if !strings.Contains(body, "dn: uid=testapildifnew,ou=people,dc=example,dc=edu\nchangetype: add\n") || strings.Contains(body, "testapildifowner") {
+		t.Fatalf("expected only the new user to be added, got:\n%s", body)
+	}
+
+	resp, _ = get("?since=yesterday")
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp, _ = get("?since=2000-01-01T00:00:00Z")
+	expectStatus(t, resp, http.StatusGone)
+}
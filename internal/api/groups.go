@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type PirgGroupResponse struct {
+	Id         int       `json:"id"`
+	PirgId     int       `json:"pirg_id"`
+	Name       string    `json:"name"`
+	Gid        *int      `json:"gid"`
+	UserIds    []int     `json:"user_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+func (g *PirgGroupResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgGroupResponse(g *data.PirgGroup) *PirgGroupResponse {
+	return &PirgGroupResponse{
+		Id:         g.Id,
+		PirgId:     g.PirgId,
+		Name:       g.Name,
+		Gid:        g.Gid,
+		UserIds:    g.UserIds,
+		CreatedAt:  g.CreatedAt,
+		ModifiedAt: g.ModifiedAt,
+	}
+}
+
+// newPirgGroupResponseList converts a list of PirgGroup objects into a list of render.Renderer objects
+func newPirgGroupResponseList(groups []*data.PirgGroup) []render.Renderer {
+	list := []render.Renderer{}
+	for _, group := range groups {
+		list = append(list, newPirgGroupResponse(group))
+	}
+	return list
+}
+
+type PirgGroupRequest struct {
+	Name    string `json:"name"`
+	Gid     *int   `json:"gid"`
+	UserIds []int  `json:"user_ids"`
+}
+
+func (g *PirgGroupRequest) Bind(r *http.Request) error {
+	if g.Name == "" {
+		return fmt.Errorf("missing required pirg group fields: %+v", g)
+	}
+	return nil
+}
+
+func newPirgGroupRequest(g *data.PirgGroup) *PirgGroupRequest {
+	return &PirgGroupRequest{
+		Name:    g.Name,
+		Gid:     g.Gid,
+		UserIds: g.UserIds,
+	}
+}
+
+type PirgGroupHandler struct {
+	store data.Store
+}
+
+// GroupsRouter is mounted below /pirgs/{pirgID}, so the pirg
+// is already loaded into the request context
+func GroupsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newPirgGroupHandler(ctx)
+	r.Get("/", h.GetPirgGroups)
+	r.Post("/", h.CreatePirgGroup)
+	r.Route("/{groupID}", func(r chi.Router) {
+		r.Use(h.PirgGroupCtx)
+		r.Get("/", h.GetPirgGroup)
+		r.Put("/", h.UpdatePirgGroup)
+		r.Delete("/", h.DeletePirgGroup)
+	})
+	return r
+}
+
+func newPirgGroupHandler(ctx context.Context) *PirgGroupHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &PirgGroupHandler{store: store}
+}
+
+// GetPirgGroups returns the groups of the pirg
+func (h *PirgGroupHandler) GetPirgGroups(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirg groups", "package", "api", "method", "GetPirgGroups")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	groups, err := h.store.GetPirgGroups(r.Context(), pirg.Id)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newPirgGroupResponseList(groups)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreatePirgGroup adds a group to the pirg
+func (h *PirgGroupHandler) CreatePirgGroup(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating pirg group", "package", "api", "method", "CreatePirgGroup")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	groupReq := &PirgGroupRequest{}
+	if err := render.Bind(r, groupReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataGroup := data.PirgGroupRequest(*groupReq)
+	group, err := h.store.CreatePirgGroup(r.Context(), pirg.Id, &dataGroup)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newPirgGroupResponse(group))
+}
+
+// PirgGroupCtx middleware loads the pirg group from the URL,
+// making sure it belongs to the pirg in the request context
+func (h *PirgGroupHandler) PirgGroupCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+		groupIDParam := chi.URLParam(r, "groupID")
+		slog.DebugContext(r.Context(), "loading specific pirg group ctx", "id", groupIDParam, "package", "api", "method", "PirgGroupCtx")
+		groupId, err := strconv.Atoi(groupIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		group, err := h.store.GetPirgGroupById(r.Context(), groupId)
+		if err != nil || group.PirgId != pirg.Id {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.PirgGroupKey, group)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetPirgGroup returns the pirg group in the request context
+func (h *PirgGroupHandler) GetPirgGroup(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirg group", "package", "api", "method", "GetPirgGroup")
+	group := r.Context().Value(keys.PirgGroupKey).(*data.PirgGroup)
+	if err := render.Render(w, r, newPirgGroupResponse(group)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdatePirgGroup renames a pirg group, changes its gid or replaces its members
+func (h *PirgGroupHandler) UpdatePirgGroup(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating pirg group", "package", "api", "method", "UpdatePirgGroup")
+	group := r.Context().Value(keys.PirgGroupKey).(*data.PirgGroup)
+	groupReq := newPirgGroupRequest(group)
+	if err := render.Bind(r, groupReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataGroup := data.PirgGroupRequest(*groupReq)
+	updated, err := h.store.UpdatePirgGroup(r.Context(), group.Id, &dataGroup)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newPirgGroupResponse(updated))
+}
+
+// DeletePirgGroup deletes a pirg group
+func (h *PirgGroupHandler) DeletePirgGroup(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting pirg group", "package", "api", "method", "DeletePirgGroup")
+	group := r.Context().Value(keys.PirgGroupKey).(*data.PirgGroup)
+	if err := h.store.DeletePirgGroup(r.Context(), group.Id); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAPIPirgGroups(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapigroupowner")
+	student := createTestPirgOwner(t, ts, "testapigroupstudent")
+	outsider := createTestPirgOwner(t, ts, "testapigroupoutsider")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapigrouppirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, student.Id},
+	})
+	other := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapigroupother",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	path := fmt.Sprintf("/api/v1/pirgs/%d/groups", pirg.Id)
+
+	gid := 4242
+	resp := ts.do(t, "POST", path, PirgGroupRequest{Name: "students", Gid: &gid, UserIds: []int{student.Id}})
+	expectStatus(t, resp, http.StatusCreated)
+	var group PirgGroupResponse
+	decodeResponse(t, resp, &group)
+	if group.PirgId != pirg.Id || group.Name != "students" || group.Gid == nil || *group.Gid != gid || len(group.UserIds) != 1 {
+		t.Fatalf("unexpected group %+v", group)
+	}
+
+	resp = ts.do(t, "POST", path, PirgGroupRequest{Name: "students"})
+	expectStatus(t, resp, http.StatusConflict)
+	resp = ts.do(t, "POST", path, PirgGroupRequest{Name: "outsiders", UserIds: []int{outsider.Id}})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "POST", path, PirgGroupRequest{})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, group.Id), PirgGroupRequest{Name: "lab", UserIds: []int{owner.Id, student.Id}})
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &group)
+	if group.Name != "lab" || group.Gid != nil || len(group.UserIds) != 2 {
+		t.Fatalf("unexpected updated group %+v", group)
+	}
+
+	resp = ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var groups []PirgGroupResponse
+	decodeResponse(t, resp, &groups)
+	if len(groups) != 1 || groups[0].Id != group.Id {
+		t.Fatalf("expected only the lab group, got %+v", groups)
+	}
+
+	// groups are only found below their own pirg
+	resp = ts.do(t, "GET", fmt.Sprintf("/api/v1/pirgs/%d/groups/%d", other.Id, group.Id), nil)
+	expectStatus(t, resp, http.StatusNotFound)
+
+	resp = ts.do(t, "DELETE", fmt.Sprintf("%s/%d", path, group.Id), nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp = ts.do(t, "GET", fmt.Sprintf("%s/%d", path, group.Id), nil)
+	expectStatus(t, resp, http.StatusNotFound)
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type LocationResponse struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	ParentId    *int      `json:"parent_id"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+func (l *LocationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newLocationResponse(l *data.Location) *LocationResponse {
+	return &LocationResponse{
+		Id:          l.Id,
+		Name:        l.Name,
+		Kind:        l.Kind,
+		ParentId:    l.ParentId,
+		Description: l.Description,
+		CreatedAt:   l.CreatedAt,
+		ModifiedAt:  l.ModifiedAt,
+	}
+}
+
+// newLocationResponseList converts a list of Location objects into a list of render.Renderer objects
+func newLocationResponseList(locations []*data.Location) []render.Renderer {
+	list := []render.Renderer{}
+	for _, location := range locations {
+		list = append(list, newLocationResponse(location))
+	}
+	return list
+}
+
+type LocationRequest struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	ParentId    *int   `json:"parent_id"`
+	Description string `json:"description"`
+}
+
+func (l *LocationRequest) Bind(r *http.Request) error {
+	if l.Name == "" || l.Kind == "" {
+		return fmt.Errorf("missing required location fields: %+v", l)
+	}
+	if !slices.Contains(data.LocationKinds, l.Kind) {
+		return fmt.Errorf("invalid location kind %q, must be one of %v", l.Kind, data.LocationKinds)
+	}
+	return nil
+}
+
+func newLocationRequest(l *data.Location) *LocationRequest {
+	return &LocationRequest{
+		Name:        l.Name,
+		Kind:        l.Kind,
+		ParentId:    l.ParentId,
+		Description: l.Description,
+	}
+}
+
+type LocationHandler struct {
+	store data.Store
+}
+
+func LocationsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newLocationHandler(ctx)
+	r.Get("/", h.GetAllLocations)
+	r.Post("/", h.CreateLocation)
+	r.Route("/{locationID}", func(r chi.Router) {
+		r.Use(h.LocationCtx)
+		r.Get("/", h.GetLocation)
+		r.Put("/", h.UpdateLocation)
+		r.Delete("/", h.DeleteLocation)
+		r.Get("/pirgs", h.GetLocationPirgs)
+		r.With(requireAdmin).Post("/usage", newStorageHandler(ctx).UploadLocationUsage)
+	})
+	return r
+}
+
+func newLocationHandler(ctx context.Context) *LocationHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &LocationHandler{store: store}
+}
+
+// GetAllLocations returns all existing locations
+func (h *LocationHandler) GetAllLocations(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting all locations", "package", "api", "method", "GetAllLocations")
+	locations, err := h.store.GetAllLocations(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	resp := newLocationResponseList(locations)
+	if err := render.RenderList(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreateLocation creates a new location
+func (h *LocationHandler) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating new location", "package", "api", "method", "CreateLocation")
+	locationReq := &LocationRequest{}
+	if err := render.Bind(r, locationReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataLocation := data.LocationRequest(*locationReq)
+	newLocation, err := h.store.CreateLocation(r.Context(), &dataLocation)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	resp := newLocationResponse(newLocation)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
+
+// LocationCtx middleware is used to load a Location object from /locations/{locationID} requests
+// and then attach it to the request context. In case of failure the request is aborted
+// and a 404 error response is sent to the client.
+func (h *LocationHandler) LocationCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locationIDParam := chi.URLParam(r, "locationID")
+		slog.DebugContext(r.Context(), "loading specific location ctx", "id", locationIDParam, "package", "api", "method", "LocationCtx")
+		locationId, err := strconv.Atoi(locationIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		location, err := h.store.GetLocationById(r.Context(), locationId)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.LocationKey, location)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetLocation returns the location in the request context
+func (h *LocationHandler) GetLocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting location", "package", "api", "method", "GetLocation")
+	location := r.Context().Value(keys.LocationKey).(*data.Location)
+	if err := render.Render(w, r, newLocationResponse(location)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdateLocation updates a location
+func (h *LocationHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating location", "package", "api", "method", "UpdateLocation")
+	location := r.Context().Value(keys.LocationKey).(*data.Location)
+	locationReq := newLocationRequest(location)
+	if err := render.Bind(r, locationReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataLocationRequest := data.LocationRequest(*locationReq)
+	updatedLocation, err := h.store.UpdateLocation(r.Context(), location.Id, &dataLocationRequest)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newLocationResponse(updatedLocation))
+}
+
+// DeleteLocation deletes a location that no longer has children or storage allocations
+func (h *LocationHandler) DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting location", "package", "api", "method", "DeleteLocation")
+	location := r.Context().Value(keys.LocationKey).(*data.Location)
+	err := h.store.DeleteLocation(r.Context(), location.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
+
+// GetLocationPirgs returns the pirgs with storage at the location or below it
+func (h *LocationHandler) GetLocationPirgs(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirgs for location", "package", "api", "method", "GetLocationPirgs")
+	location := r.Context().Value(keys.LocationKey).(*data.Location)
+	pirgs, err := h.store.GetLocationPirgs(r.Context(), location.Id)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newPirgResponseList(pirgs)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
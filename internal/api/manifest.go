@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/manifest"
+)
+
+// maxManifestBytes bounds the manifest accepted in one request
+const maxManifestBytes = 16 << 20
+
+// ManifestPlanResponse lists the changes a manifest makes, and whether
+// they were applied
+type ManifestPlanResponse struct {
+	*manifest.Plan
+}
+
+func (mp *ManifestPlanResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type ManifestHandler struct {
+	store data.Store
+}
+
+// ManifestRouter compares a YAML or JSON manifest sent as the request body
+// with the database. Both routes take ?prune=true to also delete the groups
+// and users the manifest manages but doesn't list, and ?prune_pirgs=true to
+// delete the pirgs it doesn't list. They're only open to admins.
+func ManifestRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newManifestHandler(ctx)
+	r.Use(requireAdmin)
+	r.Post("/plan", h.PlanManifest)
+	r.Post("/apply", h.ApplyManifest)
+	return r
+}
+
+func newManifestHandler(ctx context.Context) *ManifestHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &ManifestHandler{store: store}
+}
+
+// planOptions reads ?prune=true, ?prune_pirgs=true and the caller, who is
+// never pruned
+func planOptions(r *http.Request) manifest.PlanOptions {
+	callerId, _ := r.Context().Value(keys.CallerIdKey).(int)
+	return manifest.PlanOptions{
+		Prune:      r.URL.Query().Get("prune") == "true",
+		PrunePirgs: r.URL.Query().Get("prune_pirgs") == "true",
+		CallerId:   callerId,
+	}
+}
+
+// PlanManifest returns the changes the manifest would make without making them
+func (h *ManifestHandler) PlanManifest(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "planning manifest", "package", "api", "method", "PlanManifest")
+	m, err := manifest.Parse(http.MaxBytesReader(w, r.Body, maxManifestBytes))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	plan, err := manifest.NewPlan(r.Context(), h.store, m, planOptions(r))
+	if errors.Is(err, manifest.ErrInvalid) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &ManifestPlanResponse{Plan: plan})
+}
+
+// ApplyManifest makes the changes of the manifest in one transaction and
+// returns them. Nothing is changed if any of them fails.
+func (h *ManifestHandler) ApplyManifest(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "applying manifest", "package", "api", "method", "ApplyManifest")
+	m, err := manifest.Parse(http.MaxBytesReader(w, r.Body, maxManifestBytes))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	plan, err := manifest.Apply(r.Context(), h.store, m, planOptions(r))
+	if errors.Is(err, manifest.ErrInvalid) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &ManifestPlanResponse{Plan: plan})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/manifest"
+)
+
+func TestAPIManifest(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapimanifestowner")
+	body := `
+users:
+  - username: testapimanifestmember
+    email: testapimanifestmember@localhost
+pirgs:
+  - name: testapimanifestpirg
+    owner: testapimanifestowner
+    members: [testapimanifestmember]
+`
+
+	resp := ts.doRaw(t, "POST", "/api/v1/manifest/plan", body)
+	expectStatus(t, resp, http.StatusOK)
+	var plan manifest.Plan
+	decodeResponse(t, resp, &plan)
+	if plan.Applied || plan.Summary != (manifest.Summary{Create: 2}) || len(plan.Changes) != 2 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if _, err := ts.Store.GetPirgByName(context.Background(), "testapimanifestpirg"); err == nil {
+		t.Fatal("expected the plan not to create the pirg")
+	}
+
+	resp = ts.doRaw(t, "POST", "/api/v1/manifest/apply", body)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &plan)
+	if !plan.Applied || plan.Summary.Create != 2 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	pirg, err := ts.Store.GetPirgByName(context.Background(), "testapimanifestpirg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pirg.OwnerId != owner.Id || len(pirg.UserIds) != 2 {
+		t.Errorf("unexpected pirg %+v", pirg)
+	}
+
+	t.Run("Prune", func(t *testing.T) {
+		// the owner isn't listed, so pruning would delete them
+		resp := ts.doRaw(t, "POST", "/api/v1/manifest/plan?prune=true", body)
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("PrunePirgs", func(t *testing.T) {
+		if _, err := ts.Store.CreatePirg(context.Background(), &data.PirgRequest{Name: "testapimanifestother", OwnerId: owner.Id}); err != nil {
+			t.Fatal(err)
+		}
+		// unlisted pirgs are only deleted when asked for separately
+		resp := ts.doRaw(t, "POST", "/api/v1/manifest/plan", body)
+		expectStatus(t, resp, http.StatusOK)
+		var plan manifest.Plan
+		decodeResponse(t, resp, &plan)
+		if plan.Summary.Delete != 0 {
+			t.Fatalf("expected nothing to be deleted, got %+v", plan)
+		}
+		resp = ts.doRaw(t, "POST", "/api/v1/manifest/plan?prune_pirgs=true", body)
+		expectStatus(t, resp, http.StatusOK)
+		decodeResponse(t, resp, &plan)
+		if !plan.PrunePirgs || plan.Summary.Delete != 1 || plan.Changes[0].Name != "testapimanifestother" {
+			t.Fatalf("expected the other pirg to be deleted, got %+v", plan)
+		}
+	})
+
+	t.Run("PruneCaller", func(t *testing.T) {
+		caller := createTestPirgOwner(t, ts, "testapimanifestcaller")
+		pr := &data.PirgRequest{Name: pirg.Name, OwnerId: pirg.OwnerId, AdminIds: pirg.AdminIds, UserIds: append(pirg.UserIds, caller.Id)}
+		if _, err := ts.Store.UpdatePirg(context.Background(), pirg.Id, pr); err != nil {
+			t.Fatal(err)
+		}
+		listed := `
+users:
+  - username: testapimanifestowner
+    email: testapimanifestowner@localhost
+    first_name: TestAPI
+    last_name: PirgOwner
+  - username: testapimanifestmember
+    email: testapimanifestmember@localhost
+pirgs:
+  - name: testapimanifestpirg
+    owner: testapimanifestowner
+    members: [testapimanifestmember]
+`
+		// the caller is in the pirg but not listed, so pruning would delete them
+		ts.CallerId = caller.Id
+		defer func() { ts.CallerId = 0 }()
+		resp := ts.doRaw(t, "POST", "/api/v1/manifest/plan?prune=true", listed)
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		resp := ts.doRaw(t, "POST", "/api/v1/manifest/plan", "users: [{username: a, nickname: b}]")
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		ts.Role = "user"
+		defer func() { ts.Role = "admin" }()
+		resp := ts.doRaw(t, "POST", "/api/v1/manifest/apply", body)
+		expectStatus(t, resp, http.StatusForbidden)
+	})
+}
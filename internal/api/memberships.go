@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// DefaultExpiringDays is how far ahead the expiring memberships report looks
+const DefaultExpiringDays = 30
+
+// defaultAuditLimit is how many audit entries are returned without ?limit=
+const defaultAuditLimit = 100
+
+type MembershipResponse struct {
+	PirgId    int        `json:"pirg_id"`
+	UserId    int        `json:"user_id"`
+	StartsAt  *time.Time `json:"starts_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Active    bool       `json:"active"`
+}
+
+func (m *MembershipResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newMembershipResponse(m *data.Membership, now time.Time) *MembershipResponse {
+	return &MembershipResponse{
+		PirgId:    m.PirgId,
+		UserId:    m.UserId,
+		StartsAt:  m.StartsAt,
+		ExpiresAt: m.ExpiresAt,
+		Active:    m.Active(now),
+	}
+}
+
+// MembershipTermRequest sets the term of a membership. Leaving out a time,
+// or setting it to null, leaves that end of the term open.
+type MembershipTermRequest struct {
+	StartsAt  *time.Time `json:"starts_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (m *MembershipTermRequest) Bind(r *http.Request) error {
+	if m.StartsAt != nil && m.ExpiresAt != nil && !m.ExpiresAt.After(*m.StartsAt) {
+		return fmt.Errorf("expires_at must be after starts_at")
+	}
+	return nil
+}
+
+// ExpiringMembershipResponse is a membership that expires soon, named so
+// it can be passed on to the pirg's owner
+type ExpiringMembershipResponse struct {
+	PirgId    int        `json:"pirg_id"`
+	PirgName  string     `json:"pirg_name"`
+	UserId    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	StartsAt  *time.Time `json:"starts_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (e *ExpiringMembershipResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type AuditEntryResponse struct {
+	Id        int       `json:"id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	PirgId    *int      `json:"pirg_id"`
+	UserId    *int      `json:"user_id"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *AuditEntryResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type MembershipHandler struct {
+	store data.Store
+}
+
+// MembershipsRouter is mounted below /pirgs/{pirgID}, so the pirg is
+// already loaded into the request context
+func MembershipsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newMembershipHandler(ctx)
+	r.Get("/", h.GetPirgMemberships)
+	r.Put("/{userID}", h.SetMembershipTerm)
+	return r
+}
+
+func newMembershipHandler(ctx context.Context) *MembershipHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &MembershipHandler{store: store}
+}
+
+// GetPirgMemberships returns the members of the pirg with the terms of their membership
+func (h *MembershipHandler) GetPirgMemberships(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting pirg memberships", "package", "api", "method", "GetPirgMemberships")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	memberships, err := h.store.GetPirgMemberships(r.Context(), pirg.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	now := time.Now()
+	list := []render.Renderer{}
+	for _, m := range memberships {
+		list = append(list, newMembershipResponse(m, now))
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// SetMembershipTerm sets when a member's membership of the pirg starts and
+// expires. Admins can set any term, the owner and admins of the pirg can
+// set the terms of its other members.
+func (h *MembershipHandler) SetMembershipTerm(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "setting membership term", "package", "api", "method", "SetMembershipTerm")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	role, _ := r.Context().Value(keys.RoleKey).(string)
+	callerId, known := r.Context().Value(keys.CallerIdKey).(int)
+	pirgAdmin := known && (callerId == pirg.OwnerId || slices.Contains(pirg.AdminIds, callerId))
+	if role != "admin" && (!pirgAdmin || callerId == userId) {
+		render.Render(w, r, ErrForbidden)
+		return
+	}
+	termReq := &MembershipTermRequest{}
+	if err := render.Bind(r, termReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	membership, err := h.store.SetMembershipTerm(r.Context(), pirg.Id, userId, &data.MembershipTerm{StartsAt: termReq.StartsAt, ExpiresAt: termReq.ExpiresAt})
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Render(w, r, newMembershipResponse(membership, time.Now()))
+}
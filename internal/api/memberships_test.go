@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIMembershipTerm(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapitermowner")
+	student := createTestPirgOwner(t, ts, "testapitermstudent")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapitermpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, student.Id},
+	})
+	path := fmt.Sprintf("/api/v1/pirgs/%d/members", pirg.Id)
+
+	startsAt := time.Now().UTC().Truncate(time.Second).AddDate(0, 0, 1)
+	expiresAt := startsAt.AddDate(0, 0, 10)
+	resp := ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, student.Id), MembershipTermRequest{StartsAt: &startsAt, ExpiresAt: &expiresAt})
+	expectStatus(t, resp, http.StatusOK)
+	var membership MembershipResponse
+	decodeResponse(t, resp, &membership)
+	if membership.StartsAt == nil || !membership.StartsAt.Equal(startsAt) || membership.Active {
+		t.Fatalf("expected a membership that hasn't started, got %+v", membership)
+	}
+
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, student.Id), MembershipTermRequest{StartsAt: &expiresAt, ExpiresAt: &startsAt})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, owner.Id), MembershipTermRequest{ExpiresAt: &expiresAt})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, 999999), MembershipTermRequest{ExpiresAt: &expiresAt})
+	expectStatus(t, resp, http.StatusNotFound)
+
+	resp = ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var memberships []MembershipResponse
+	decodeResponse(t, resp, &memberships)
+	if len(memberships) != 2 || !memberships[0].Active || memberships[1].ExpiresAt == nil {
+		t.Fatalf("expected the owner's open and the student's bounded membership, got %+v", memberships)
+	}
+
+	// the student expires in 11 days
+	resp = ts.do(t, "GET", "/api/v1/reports/expiring?days=7", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var expiring []ExpiringMembershipResponse
+	decodeResponse(t, resp, &expiring)
+	if len(expiring) != 0 {
+		t.Fatalf("expected nothing to expire within 7 days, got %+v", expiring)
+	}
+	resp = ts.do(t, "GET", "/api/v1/reports/expiring", nil)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &expiring)
+	if len(expiring) != 1 || expiring[0].Username != "testapitermstudent" || expiring[0].PirgName != "testapitermpirg" {
+		t.Fatalf("expected the student to be expiring, got %+v", expiring)
+	}
+	resp = ts.do(t, "GET", "/api/v1/reports/expiring?days=soon", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	resp = ts.do(t, "GET", "/api/v1/reports/audit", nil)
+	expectStatus(t, resp, http.StatusOK)
+	ts.Role = "user"
+	resp = ts.do(t, "GET", "/api/v1/reports/audit", nil)
+	expectStatus(t, resp, http.StatusForbidden)
+
+	// members can't clear their own term, the owner of the pirg can
+	ts.CallerId = student.Id
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, student.Id), MembershipTermRequest{})
+	expectStatus(t, resp, http.StatusForbidden)
+	ts.CallerId = owner.Id
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/%d", path, student.Id), MembershipTermRequest{ExpiresAt: &expiresAt})
+	expectStatus(t, resp, http.StatusOK)
+}
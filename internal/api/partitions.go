@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type PartitionResponse struct {
+	Id          int       `json:"id"`
+	ClusterId   int       `json:"cluster_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+func (p *PartitionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPartitionResponse(p *data.Partition) *PartitionResponse {
+	return &PartitionResponse{
+		Id:          p.Id,
+		ClusterId:   p.ClusterId,
+		Name:        p.Name,
+		Description: p.Description,
+		CreatedAt:   p.CreatedAt,
+		ModifiedAt:  p.ModifiedAt,
+	}
+}
+
+// newPartitionResponseList converts a list of Partition objects into a list of render.Renderer objects
+func newPartitionResponseList(partitions []*data.Partition) []render.Renderer {
+	list := []render.Renderer{}
+	for _, partition := range partitions {
+		list = append(list, newPartitionResponse(partition))
+	}
+	return list
+}
+
+type PartitionRequest struct {
+	ClusterId   int    `json:"cluster_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (p *PartitionRequest) Bind(r *http.Request) error {
+	if p.ClusterId == 0 || p.Name == "" {
+		return fmt.Errorf("missing required partition fields: %+v", p)
+	}
+	return nil
+}
+
+func newPartitionRequest(p *data.Partition) *PartitionRequest {
+	return &PartitionRequest{
+		ClusterId:   p.ClusterId,
+		Name:        p.Name,
+		Description: p.Description,
+	}
+}
+
+type PartitionHandler struct {
+	store data.Store
+}
+
+func PartitionsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newPartitionHandler(ctx)
+	r.Get("/", h.GetAllPartitions)
+	r.Post("/", h.CreatePartition)
+	r.Route("/{partitionID}", func(r chi.Router) {
+		r.Use(h.PartitionCtx)
+		r.Get("/", h.GetPartition)
+		r.Put("/", h.UpdatePartition)
+		r.Delete("/", h.DeletePartition)
+	})
+	return r
+}
+
+func newPartitionHandler(ctx context.Context) *PartitionHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &PartitionHandler{store: store}
+}
+
+// GetAllPartitions returns all existing partitions
+func (h *PartitionHandler) GetAllPartitions(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting all partitions", "package", "api", "method", "GetAllPartitions")
+	partitions, err := h.store.GetAllPartitions(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newPartitionResponseList(partitions)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreatePartition creates a new partition on a cluster
+func (h *PartitionHandler) CreatePartition(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating new partition", "package", "api", "method", "CreatePartition")
+	partitionReq := &PartitionRequest{}
+	if err := render.Bind(r, partitionReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataPartition := data.PartitionRequest(*partitionReq)
+	newPartition, err := h.store.CreatePartition(r.Context(), &dataPartition)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newPartitionResponse(newPartition))
+}
+
+// PartitionCtx middleware is used to load a Partition object from /partitions/{partitionID} requests
+// and then attach it to the request context. In case of failure the request is aborted
+// and a 404 error response is sent to the client.
+func (h *PartitionHandler) PartitionCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partitionIDParam := chi.URLParam(r, "partitionID")
+		slog.DebugContext(r.Context(), "loading specific partition ctx", "id", partitionIDParam, "package", "api", "method", "PartitionCtx")
+		partitionId, err := strconv.Atoi(partitionIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		partition, err := h.store.GetPartitionById(r.Context(), partitionId)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.PartitionKey, partition)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetPartition returns the partition in the request context
+func (h *PartitionHandler) GetPartition(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting partition", "package", "api", "method", "GetPartition")
+	partition := r.Context().Value(keys.PartitionKey).(*data.Partition)
+	if err := render.Render(w, r, newPartitionResponse(partition)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdatePartition updates a partition
+func (h *PartitionHandler) UpdatePartition(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating partition", "package", "api", "method", "UpdatePartition")
+	partition := r.Context().Value(keys.PartitionKey).(*data.Partition)
+	partitionReq := newPartitionRequest(partition)
+	if err := render.Bind(r, partitionReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataPartitionRequest := data.PartitionRequest(*partitionReq)
+	updatedPartition, err := h.store.UpdatePartition(r.Context(), partition.Id, &dataPartitionRequest)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newPartitionResponse(updatedPartition))
+}
+
+// DeletePartition deletes a partition that is no longer granted to anyone
+func (h *PartitionHandler) DeletePartition(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting partition", "package", "api", "method", "DeletePartition")
+	partition := r.Context().Value(keys.PartitionKey).(*data.Partition)
+	if err := h.store.DeletePartition(r.Context(), partition.Id); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
@@ -2,7 +2,6 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -14,12 +13,14 @@ import (
 	"github.com/go-chi/render"
 	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notifications"
 )
 
 type PirgResponse struct {
 	Id         int       `json:"id"`
 	Name       string    `json:"name"`
 	OwnerId    int       `json:"owner_id"`
+	Gid        *int      `json:"gid"`
 	AdminIds   []int     `json:"admin_ids"`
 	UserIds    []int     `json:"user_ids"`
 	CreatedAt  time.Time `json:"created_at"`
@@ -39,6 +40,7 @@ func newPirgResponse(u *data.Pirg) *PirgResponse {
 		Id:         u.Id,
 		Name:       u.Name,
 		OwnerId:    u.OwnerId,
+		Gid:        u.Gid,
 		AdminIds:   u.AdminIds,
 		UserIds:    u.UserIds,
 		CreatedAt:  u.CreatedAt,
@@ -58,6 +60,7 @@ func newPirgResponseList(Pirgs []*data.Pirg) []render.Renderer {
 type PirgRequest struct {
 	Name     string `json:"name"`
 	OwnerId  int    `json:"owner_id"`
+	Gid      *int   `json:"gid"`
 	AdminIds []int  `json:"admin_ids"`
 	UserIds  []int  `json:"user_ids"`
 }
@@ -94,6 +97,7 @@ func newPirgRequest(u *data.Pirg) *PirgRequest {
 	return &PirgRequest{
 		Name:     u.Name,
 		OwnerId:  u.OwnerId,
+		Gid:      u.Gid,
 		AdminIds: u.AdminIds,
 		UserIds:  u.UserIds,
 	}
@@ -105,7 +109,8 @@ type PirgStub struct {
 }
 
 type PirgHandler struct {
-	dbConn *sql.DB
+	store    data.Store
+	notifier *notifications.Notifier
 }
 
 func PirgsRouter(ctx context.Context) http.Handler {
@@ -118,14 +123,20 @@ func PirgsRouter(ctx context.Context) http.Handler {
 		r.Get("/", h.GetPirg)
 		r.Put("/", h.UpdatePirg)
 		r.Delete("/", h.DeletePirg)
+		r.Mount("/storage", StorageRouter(ctx))
+		r.Mount("/slurm", SlurmRouter(ctx))
+		r.Mount("/compute", ComputeRouter(ctx))
+		r.Mount("/members", MembershipsRouter(ctx))
+		r.Mount("/groups", GroupsRouter(ctx))
 		// r.Mount("/admins", PirgAdminsRouter(ctx))
 	})
 	return r
 }
 
 func newPirgHandler(ctx context.Context) *PirgHandler {
-	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
-	return &PirgHandler{dbConn: dbConn}
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	notifier, _ := ctx.Value(keys.NotifierKey).(*notifications.Notifier)
+	return &PirgHandler{store: store, notifier: notifier}
 }
 
 // GetAllPirgs returns all existing Pirgs
@@ -133,8 +144,8 @@ func (h *PirgHandler) GetAllPirgs(w http.ResponseWriter, r *http.Request) {
 	searchName := r.URL.Query().Get("name")
 	// name passed as query param, get specific pirg
 	if searchName != "" {
-		slog.Debug("getting pirg by name", "package", "api", "method", "GetAllPirgs")
-		pirg, err := data.GetPirgByName(h.dbConn, searchName)
+		slog.DebugContext(r.Context(), "getting pirg by name", "package", "api", "method", "GetAllPirgs")
+		pirg, err := h.store.GetPirgByName(r.Context(), searchName)
 		if err != nil {
 			render.Render(w, r, ErrNotFound)
 			return
@@ -146,10 +157,10 @@ func (h *PirgHandler) GetAllPirgs(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// no name passed as query param, get all pirgs
-		slog.Debug("getting all pirgs", "package", "api", "method", "GetAllPirgs")
+		slog.DebugContext(r.Context(), "getting all pirgs", "package", "api", "method", "GetAllPirgs")
 		var pirgs []*data.Pirg
 
-		pirgs, err := data.GetAllPirgs(h.dbConn)
+		pirgs, err := h.store.GetAllPirgs(r.Context())
 		if err != nil {
 			render.Render(w, r, ErrInternalServer(err))
 			return
@@ -165,7 +176,7 @@ func (h *PirgHandler) GetAllPirgs(w http.ResponseWriter, r *http.Request) {
 
 // CreatePirg creates a new Pirg
 func (h *PirgHandler) CreatePirg(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("creating new pirg", "package", "api", "method", "CreatePirg")
+	slog.DebugContext(r.Context(), "creating new pirg", "package", "api", "method", "CreatePirg")
 	pirg := &PirgRequest{}
 	if err := render.Bind(r, pirg); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
@@ -174,12 +185,14 @@ func (h *PirgHandler) CreatePirg(w http.ResponseWriter, r *http.Request) {
 
 	dataPirg := data.PirgRequest(*pirg)
 
-	newPirg, err := data.CreatePirg(h.dbConn, &dataPirg)
+	newPirg, err := h.store.CreatePirg(r.Context(), &dataPirg)
 	if err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+		render.Render(w, r, ErrStore(err))
 		return
 	}
 
+	h.notifyAdded(r.Context(), newPirg, nil)
+
 	resp := newPirgResponse(newPirg)
 	render.Status(r, http.StatusCreated)
 	render.Render(w, r, resp)
@@ -194,13 +207,13 @@ func (h *PirgHandler) PirgCtx(next http.Handler) http.Handler {
 		var err error
 
 		pirgIDParam := chi.URLParam(r, "pirgID")
-		slog.Debug("loading specific pirg ctx", "id", pirgIDParam, "package", "api", "method", "UserCtx")
+		slog.DebugContext(r.Context(), "loading specific pirg ctx", "id", pirgIDParam, "package", "api", "method", "UserCtx")
 		pirgId, err := strconv.Atoi(pirgIDParam)
 		if err != nil {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
-		pirg, err = data.GetPirgById(h.dbConn, pirgId)
+		pirg, err = h.store.GetPirgById(r.Context(), pirgId)
 		if err != nil {
 			render.Render(w, r, ErrNotFound)
 			return
@@ -213,7 +226,7 @@ func (h *PirgHandler) PirgCtx(next http.Handler) http.Handler {
 
 // GetPirg returns the Pirg by the ID in the URL
 func (h *PirgHandler) GetPirg(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("getting pirg", "package", "api", "method", "GetPirg")
+	slog.DebugContext(r.Context(), "getting pirg", "package", "api", "method", "GetPirg")
 	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
 	resp := newPirgResponse(pirg)
 	if err := render.Render(w, r, resp); err != nil {
@@ -223,7 +236,7 @@ func (h *PirgHandler) GetPirg(w http.ResponseWriter, r *http.Request) {
 
 // UpdatePirg updates a Pirg
 func (h *PirgHandler) UpdatePirg(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("updating pirg", "package", "api", "method", "UpdatePirg")
+	slog.DebugContext(r.Context(), "updating pirg", "package", "api", "method", "UpdatePirg")
 	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
 	pirgReq := newPirgRequest(pirg)
 	if err := render.Bind(r, pirgReq); err != nil {
@@ -231,13 +244,15 @@ func (h *PirgHandler) UpdatePirg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dataPirgRequest := data.PirgRequest(*pirgReq)
-	fmt.Printf("dataPirgRequest: %+v\n", dataPirgRequest)
-	updatedPirg, err := data.UpdatePirg(h.dbConn, pirg.Id, &dataPirgRequest)
+	slog.DebugContext(r.Context(), "updating pirg from request", "request", dataPirgRequest, "package", "api", "method", "UpdatePirg")
+	updatedPirg, err := h.store.UpdatePirg(r.Context(), pirg.Id, &dataPirgRequest)
 	if err != nil {
-		render.Render(w, r, ErrInternalServer(err))
+		render.Render(w, r, ErrStore(err))
 		return
 	}
 
+	h.notifyAdded(r.Context(), updatedPirg, pirg.UserIds)
+
 	resp := newPirgResponse(updatedPirg)
 	render.Status(r, http.StatusOK)
 	render.Render(w, r, resp)
@@ -245,11 +260,11 @@ func (h *PirgHandler) UpdatePirg(w http.ResponseWriter, r *http.Request) {
 
 // DeletePirg deletes a Pirg
 func (h *PirgHandler) DeletePirg(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("deleting pirg", "package", "api", "method", "DeletePirg")
+	slog.DebugContext(r.Context(), "deleting pirg", "package", "api", "method", "DeletePirg")
 	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
-	err := data.DeletePirg(h.dbConn, pirg.Id)
+	err := h.store.DeletePirg(r.Context(), pirg.Id)
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrStore(err))
 		return
 	}
 	render.Status(r, http.StatusNoContent)
@@ -292,3 +307,32 @@ func ValidatePirgName(name string) error {
 	}
 	return nil
 }
+
+// notifyAdded tells the users that weren't already members, other than the
+// owner, that they were added to the pirg. The change is already saved, so
+// failures are only logged.
+func (h *PirgHandler) notifyAdded(ctx context.Context, pirg *data.Pirg, previousIds []int) {
+	if h.notifier == nil {
+		return
+	}
+	var owner *data.User
+	for _, id := range pirg.UserIds {
+		if id == pirg.OwnerId || slices.Contains(previousIds, id) {
+			continue
+		}
+		var err error
+		if owner == nil {
+			if owner, err = h.store.GetUserById(ctx, pirg.OwnerId); err != nil {
+				slog.Warn("failed to get pirg owner to notify new members", "pirg", pirg.Name, "error", err, "package", "api", "method", "notifyAdded")
+				return
+			}
+		}
+		user, err := h.store.GetUserById(ctx, id)
+		if err == nil {
+			err = h.notifier.PirgMemberAdded(ctx, pirg, owner, user)
+		}
+		if err != nil {
+			slog.Warn("failed to notify new pirg member", "pirg", pirg.Name, "user_id", id, "error", err, "package", "api", "method", "notifyAdded")
+		}
+	}
+}
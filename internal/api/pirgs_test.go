@@ -1,348 +1,199 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/notifications"
 )
 
-func TestAPICreatePirg(t *testing.T) {
-	th := NewTestDataHandler()
-	// TODO(lcrown): create a user in the beginning of all these, then fix all the tests
-
-	// first we need to create a pirg, then get it back
-	ur := PirgRequest{
-		Name:  "testapicreatepirg",
-		Email:     "testapicreatepirg@localhost",
+func createTestPirgOwner(t *testing.T, ts *testServer, username string) *data.User {
+	t.Helper()
+	user, err := ts.Store.CreateUser(context.Background(), &data.UserRequest{
+		Username:  username,
+		Email:     username + "@localhost",
 		FirstName: "TestAPI",
-		LastName:  "CreatePirg",
-	}
-	pirgReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/pirgs", bytes.NewBuffer([]byte(pirgReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		LastName:  "PirgOwner",
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
+	return user
+}
+
+func createTestPirg(t *testing.T, ts *testServer, pr PirgRequest) PirgResponse {
+	t.Helper()
+	resp := ts.do(t, "POST", "/api/v1/pirgs", pr)
+	expectStatus(t, resp, http.StatusCreated)
 	var pirgResponse PirgResponse
-	err = json.NewDecoder(resp.Body).Decode(&pirgResponse)
-	if err != nil {
-		t.Fatal(err)
+	decodeResponse(t, resp, &pirgResponse)
+	return pirgResponse
+}
+
+func TestAPICreatePirg(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapicreatepirgowner")
+	pr := PirgRequest{
+		Name:     "testapicreatepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
+	pirgResponse := createTestPirg(t, ts, pr)
 
-	u, err := data.GetPirgById(th.DB, pirgResponse.Id)
+	p, err := ts.Store.GetPirgById(context.Background(), pirgResponse.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Pirgname != ur.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur.Pirgname, u.Pirgname)
+	if p.Name != pr.Name {
+		t.Errorf("expected name %v got %v", pr.Name, p.Name)
 	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
+	if p.OwnerId != pr.OwnerId {
+		t.Errorf("expected owner_id %v got %v", pr.OwnerId, p.OwnerId)
 	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
+	if !reflect.DeepEqual(p.AdminIds, pr.AdminIds) {
+		t.Errorf("expected admin_ids %v got %v", pr.AdminIds, p.AdminIds)
 	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
+	if !reflect.DeepEqual(p.UserIds, pr.UserIds) {
+		t.Errorf("expected user_ids %v got %v", pr.UserIds, p.UserIds)
 	}
+
+	resp := ts.do(t, "POST", "/api/v1/pirgs", pr)
+	expectStatus(t, resp, http.StatusConflict)
+
+	// owner must be an admin and a user
+	resp = ts.do(t, "POST", "/api/v1/pirgs", PirgRequest{Name: "testapibadpirg", OwnerId: owner.Id})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	// owner must exist
+	resp = ts.do(t, "POST", "/api/v1/pirgs", PirgRequest{Name: "testapinoowner", OwnerId: 999, AdminIds: []int{999}, UserIds: []int{999}})
+	expectStatus(t, resp, http.StatusBadRequest)
 }
 
 // TestGetAllPirgs tests the GET /api/v1/pirgs endpoint
 // it creates a pirg, then gets all pirgs and checks that the created pirg is in the list
 func TestAPIGetAllPirgs(t *testing.T) {
-	// first we need to create a pirg, then get it back
-	ur := PirgRequest{
-		Pirgname:  "testapigetallpirgs",
-		Email:     "testapigetallpirgs@localhost",
-		FirstName: "TestAPI",
-		LastName:  "GetAllPirgs",
-	}
-	pirgReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/pirgs", bytes.NewBuffer([]byte(pirgReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-
-	// now get all pirgs
-	req, err = http.NewRequest("GET", "http://localhost:3333/api/v1/pirgs", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapigetallpirgsowner")
+	pr := PirgRequest{
+		Name:     "testapigetallpirgs",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	}
+	createTestPirg(t, ts, pr)
+
+	resp := ts.do(t, "GET", "/api/v1/pirgs", nil)
+	expectStatus(t, resp, http.StatusOK)
 	var pirgsResponse []PirgResponse
-	err = json.NewDecoder(resp.Body).Decode(&pirgsResponse)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// check if the pirgsResponse has more than 1 pirg
-	if len(pirgsResponse) < 1 {
-		t.Errorf("expected more than 1 pirg, got %v", len(pirgsResponse))
+	decodeResponse(t, resp, &pirgsResponse)
+	if len(pirgsResponse) != 1 || pirgsResponse[0].Name != pr.Name {
+		t.Errorf("expected to find only pirg %v in the list of pirgs, got %+v", pr.Name, pirgsResponse)
 	}
 
-	// check if the pirg we created is in the list
-	found := false
-	for _, pirg := range pirgsResponse {
-		if pirg.Pirgname == ur.Pirgname {
-			found = true
-		}
-	}
-	if !found {
-		t.Errorf("expected to find pirg %v in the list of pirgs", ur.Pirgname)
+	resp = ts.do(t, "GET", "/api/v1/pirgs?name="+pr.Name, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var pirgResponse PirgResponse
+	decodeResponse(t, resp, &pirgResponse)
+	if pirgResponse.Name != pr.Name {
+		t.Errorf("expected name %v got %v", pr.Name, pirgResponse.Name)
 	}
 }
 
 func TestAPIUpdatePirg(t *testing.T) {
-	th := NewTestDataHandler()
-
-	// first we need to create a pirg, then get it back
-	ur := PirgRequest{
-		Pirgname:  "testapiupdatepirg",
-		Email:     "testapiupdatepirg@localhost",
-		FirstName: "TestAPI",
-		LastName:  "UpdatePirg",
-	}
-	pirgReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/pirgs", bytes.NewBuffer([]byte(pirgReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-	var pirgResponse PirgResponse
-	err = json.NewDecoder(resp.Body).Decode(&pirgResponse)
-	if err != nil {
-		t.Fatal(err)
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapiupdatepirgowner")
+	member := createTestPirgOwner(t, ts, "testapiupdatepirgmember")
+	pr := PirgRequest{
+		Name:     "testapiupdatepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
+	pirgResponse := createTestPirg(t, ts, pr)
 
-	u, err := data.GetPirgById(th.DB, pirgResponse.Id)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if u.Pirgname != ur.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur.Pirgname, u.Pirgname)
-	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
-	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
-	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
+	pr2 := PirgRequest{
+		Name:     "testapiupdatepirgtwo",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
 	}
+	resp := ts.do(t, "PUT", fmt.Sprintf("/api/v1/pirgs/%d", pirgResponse.Id), pr2)
+	expectStatus(t, resp, http.StatusOK)
 
-	// now update the pirg
-	ur2 := PirgRequest{
-		Pirgname:  "testapiupdatepirg",
-		Email:     "testapiupdatepirg2@localhost",
-		FirstName: "TestAPI2",
-		LastName:  "UpdatePirg2",
-	}
-	pirgReq2, err := json.Marshal(ur2)
-	if err != nil {
-		t.Fatal(err)
-	}
-	updateURL := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d", pirgResponse.Id)
-	req, err = http.NewRequest("PUT", updateURL, bytes.NewBuffer([]byte(pirgReq2)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-	pirgResponse = PirgResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&pirgResponse)
+	p, err := ts.Store.GetPirgById(context.Background(), pirgResponse.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	u, err = data.GetPirgById(th.DB, pirgResponse.Id)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if u.Pirgname != ur2.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur2.Pirgname, u.Pirgname)
-	}
-	if u.Email != ur2.Email {
-		t.Errorf("expected email %v got %v", ur2.Email, u.Email)
+	if p.Name != pr2.Name {
+		t.Errorf("expected name %v got %v", pr2.Name, p.Name)
 	}
-	if u.FirstName != ur2.FirstName {
-		t.Errorf("expected firstname %v got %v", ur2.FirstName, u.FirstName)
-	}
-	if u.LastName != ur2.LastName {
-		t.Errorf("expected lastname %v got %v", ur2.LastName, u.LastName)
+	if !reflect.DeepEqual(p.UserIds, pr2.UserIds) {
+		t.Errorf("expected user_ids %v got %v", pr2.UserIds, p.UserIds)
 	}
 }
 
-func TestAPIDeletePirg(t *testing.T) {
-	th := NewTestDataHandler()
-
-	// first we need to create a pirg, then delete it
-	ur := PirgRequest{
-		Pirgname:  "testapideletepirg",
-		Email:     "testapideletepirg@localhost",
-		FirstName: "TestAPI",
-		LastName:  "deletePirg",
-	}
-	pirgReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/pirgs", bytes.NewBuffer([]byte(pirgReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-	var pirgResponse PirgResponse
-	err = json.NewDecoder(resp.Body).Decode(&pirgResponse)
-	if err != nil {
-		t.Fatal(err)
+func TestAPIPirgNotifiesNewMembers(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapinotifypirgowner")
+	first := createTestPirgOwner(t, ts, "testapinotifypirgfirst")
+	second := createTestPirgOwner(t, ts, "testapinotifypirgsecond")
+	pr := PirgRequest{
+		Name:     "testapinotifypirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, first.Id},
 	}
+	pirgResponse := createTestPirg(t, ts, pr)
+	pr.UserIds = []int{owner.Id, first.Id, second.Id}
+	resp := ts.do(t, "PUT", fmt.Sprintf("/api/v1/pirgs/%d", pirgResponse.Id), pr)
+	expectStatus(t, resp, http.StatusOK)
 
-	u, err := data.GetPirgById(th.DB, pirgResponse.Id)
+	// the owner isn't told and existing members aren't told again
+	queued, err := ts.Store.ClaimNotifications(context.Background(), time.Now().Add(time.Minute), time.Minute, 100)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Pirgname != ur.Pirgname {
-		t.Errorf("expected pirgname %v got %v", ur.Pirgname, u.Pirgname)
-	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
-	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
+	var recipients []string
+	for _, n := range queued {
+		if n.Event != notifications.EventPirgMemberAdded || n.Params["pirg"] != pr.Name {
+			t.Errorf("unexpected notification %+v", n)
+		}
+		recipients = append(recipients, n.Recipient)
 	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
+	if want := []string{first.Email, second.Email}; !reflect.DeepEqual(recipients, want) {
+		t.Errorf("expected notifications to %v, got %v", want, recipients)
 	}
+}
 
-	// now delete the pirg
-	deleteURL := fmt.Sprintf("http://localhost:3333/api/v1/pirgs/%d", pirgResponse.Id)
-	req, err = http.NewRequest("DELETE", deleteURL, bytes.NewBuffer([]byte(pirgReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusOK)
+func TestAPIDeletePirg(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapideletepirgowner")
+	pr := PirgRequest{
+		Name:     "testapideletepirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
 	}
+	pirgResponse := createTestPirg(t, ts, pr)
 
-	// now try to get the pirg again, should get an error
-	req, err = http.NewRequest("GET", "http://localhost:3333/api/v1/pirgs", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusOK)
-	}
-	var pirgsResponse []PirgResponse
-	err = json.NewDecoder(resp.Body).Decode(&pirgsResponse)
-	if err != nil {
-		t.Fatal(err)
-	}
+	// the owner can't be deleted while the pirg exists
+	resp := ts.do(t, "DELETE", fmt.Sprintf("/api/v1/users/%d", owner.Id), nil)
+	expectStatus(t, resp, http.StatusConflict)
 
-	// make sure the pirg is not in the list
-	found := false
-	for _, pirg := range pirgsResponse {
-		if pirg.Pirgname == ur.Pirgname {
-			found = true
-		}
-	}
-	if found {
-		t.Error("found pirg that should have been deleted")
+	resp = ts.do(t, "DELETE", fmt.Sprintf("/api/v1/pirgs/%d", pirgResponse.Id), nil)
+	expectStatus(t, resp, http.StatusOK)
+
+	_, err := ts.Store.GetPirgById(context.Background(), pirgResponse.Id)
+	if !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("expected deleted pirg to be gone, got %v", err)
 	}
+	resp = ts.do(t, "GET", fmt.Sprintf("/api/v1/pirgs/%d", pirgResponse.Id), nil)
+	expectStatus(t, resp, http.StatusNotFound)
 }
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+type QOSResponse struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+func (q *QOSResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newQOSResponse(q *data.QOS) *QOSResponse {
+	return &QOSResponse{
+		Id:          q.Id,
+		Name:        q.Name,
+		Description: q.Description,
+		CreatedAt:   q.CreatedAt,
+		ModifiedAt:  q.ModifiedAt,
+	}
+}
+
+// newQOSResponseList converts a list of QOS objects into a list of render.Renderer objects
+func newQOSResponseList(qos []*data.QOS) []render.Renderer {
+	list := []render.Renderer{}
+	for _, q := range qos {
+		list = append(list, newQOSResponse(q))
+	}
+	return list
+}
+
+type QOSRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (q *QOSRequest) Bind(r *http.Request) error {
+	if q.Name == "" {
+		return fmt.Errorf("missing required qos fields: %+v", q)
+	}
+	return nil
+}
+
+func newQOSRequest(q *data.QOS) *QOSRequest {
+	return &QOSRequest{
+		Name:        q.Name,
+		Description: q.Description,
+	}
+}
+
+type QOSHandler struct {
+	store data.Store
+}
+
+func QOSRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newQOSHandler(ctx)
+	r.Get("/", h.GetAllQOS)
+	r.Post("/", h.CreateQOS)
+	r.Route("/{qosID}", func(r chi.Router) {
+		r.Use(h.QOSCtx)
+		r.Get("/", h.GetQOS)
+		r.Put("/", h.UpdateQOS)
+		r.Delete("/", h.DeleteQOS)
+	})
+	return r
+}
+
+func newQOSHandler(ctx context.Context) *QOSHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &QOSHandler{store: store}
+}
+
+// GetAllQOS returns all existing QOS
+func (h *QOSHandler) GetAllQOS(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting all qos", "package", "api", "method", "GetAllQOS")
+	qos, err := h.store.GetAllQOS(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newQOSResponseList(qos)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreateQOS creates a new QOS
+func (h *QOSHandler) CreateQOS(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating new qos", "package", "api", "method", "CreateQOS")
+	qosReq := &QOSRequest{}
+	if err := render.Bind(r, qosReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataQOS := data.QOSRequest(*qosReq)
+	newQOS, err := h.store.CreateQOS(r.Context(), &dataQOS)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newQOSResponse(newQOS))
+}
+
+// QOSCtx middleware is used to load a QOS object from /qos/{qosID} requests
+// and then attach it to the request context. In case of failure the request is aborted
+// and a 404 error response is sent to the client.
+func (h *QOSHandler) QOSCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qosIDParam := chi.URLParam(r, "qosID")
+		slog.DebugContext(r.Context(), "loading specific qos ctx", "id", qosIDParam, "package", "api", "method", "QOSCtx")
+		qosId, err := strconv.Atoi(qosIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		qos, err := h.store.GetQOSById(r.Context(), qosId)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.QOSKey, qos)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetQOS returns the QOS in the request context
+func (h *QOSHandler) GetQOS(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting qos", "package", "api", "method", "GetQOS")
+	qos := r.Context().Value(keys.QOSKey).(*data.QOS)
+	if err := render.Render(w, r, newQOSResponse(qos)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdateQOS updates a QOS
+func (h *QOSHandler) UpdateQOS(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating qos", "package", "api", "method", "UpdateQOS")
+	qos := r.Context().Value(keys.QOSKey).(*data.QOS)
+	qosReq := newQOSRequest(qos)
+	if err := render.Bind(r, qosReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataQOSRequest := data.QOSRequest(*qosReq)
+	updatedQOS, err := h.store.UpdateQOS(r.Context(), qos.Id, &dataQOSRequest)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newQOSResponse(updatedQOS))
+}
+
+// DeleteQOS deletes a QOS that is no longer granted to anyone
+func (h *QOSHandler) DeleteQOS(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting qos", "package", "api", "method", "DeleteQOS")
+	qos := r.Context().Value(keys.QOSKey).(*data.QOS)
+	if err := h.store.DeleteQOS(r.Context(), qos.Id); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
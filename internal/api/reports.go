@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// StorageStatusResponse is the latest usage of a storage allocation and
+// how fast it's growing
+type StorageStatusResponse struct {
+	PirgId             int        `json:"pirg_id"`
+	PirgName           string     `json:"pirg_name"`
+	AllocationId       int        `json:"allocation_id"`
+	LocationId         int        `json:"location_id"`
+	Path               string     `json:"path"`
+	UsedBytes          int64      `json:"used_bytes"`
+	SoftLimitBytes     int64      `json:"soft_limit_bytes"`
+	HardLimitBytes     int64      `json:"hard_limit_bytes"`
+	SampledAt          *time.Time `json:"sampled_at"`
+	OverSoft           bool       `json:"over_soft"`
+	OverHard           bool       `json:"over_hard"`
+	GrowthBytesPerDay  float64    `json:"growth_bytes_per_day"`
+	ProjectedHardLimit *time.Time `json:"projected_hard_limit"`
+}
+
+func (s *StorageStatusResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newStorageStatusResponse(pirgName string, s *data.StorageStatus) *StorageStatusResponse {
+	return &StorageStatusResponse{
+		PirgId:             s.Allocation.PirgId,
+		PirgName:           pirgName,
+		AllocationId:       s.Allocation.Id,
+		LocationId:         s.Allocation.LocationId,
+		Path:               s.Allocation.Path,
+		UsedBytes:          s.UsedBytes,
+		SoftLimitBytes:     s.Allocation.SoftLimitBytes,
+		HardLimitBytes:     s.Allocation.HardLimitBytes,
+		SampledAt:          s.SampledAt,
+		OverSoft:           s.OverSoft,
+		OverHard:           s.OverHard,
+		GrowthBytesPerDay:  s.GrowthPerDay,
+		ProjectedHardLimit: s.ProjectedHardLimit,
+	}
+}
+
+type ReportHandler struct {
+	store data.Store
+}
+
+func ReportsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newReportHandler(ctx)
+	r.Get("/storage", h.GetStorageReport)
+	r.Get("/expiring", h.GetExpiringMemberships)
+	r.With(requireAdmin).Get("/audit", h.GetAuditLog)
+	return r
+}
+
+func newReportHandler(ctx context.Context) *ReportHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &ReportHandler{store: store}
+}
+
+// GetStorageReport lists the storage allocations that are over their soft
+// or hard limit, or all of them with ?all=true, with their growth over the
+// last data.StorageTrendDays days
+func (h *ReportHandler) GetStorageReport(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting storage report", "package", "api", "method", "GetStorageReport")
+	all := r.URL.Query().Get("all") == "true"
+	allocations, err := h.store.GetAllStorageAllocations(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	now := time.Now()
+	from := now.AddDate(0, 0, -data.StorageTrendDays)
+	pirgNames := make(map[int]string)
+	list := []render.Renderer{}
+	for _, allocation := range allocations {
+		samples, err := h.store.GetStorageUsage(r.Context(), allocation.PirgId, allocation.LocationId, from, now)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		status := data.NewStorageStatus(allocation, samples)
+		if !all && !status.OverSoft && !status.OverHard {
+			continue
+		}
+		if _, ok := pirgNames[allocation.PirgId]; !ok {
+			pirg, err := h.store.GetPirgById(r.Context(), allocation.PirgId)
+			if err != nil {
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			}
+			pirgNames[pirg.Id] = pirg.Name
+		}
+		list = append(list, newStorageStatusResponse(pirgNames[allocation.PirgId], status))
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetExpiringMemberships lists the memberships that expire in the next
+// ?days= days, DefaultExpiringDays if it isn't given
+func (h *ReportHandler) GetExpiringMemberships(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting expiring memberships", "package", "api", "method", "GetExpiringMemberships")
+	days := DefaultExpiringDays
+	if param := r.URL.Query().Get("days"); param != "" {
+		var err error
+		if days, err = strconv.Atoi(param); err != nil || days < 0 {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid days %q", param)))
+			return
+		}
+	}
+	memberships, err := h.store.GetExpiringMemberships(r.Context(), time.Now().AddDate(0, 0, days))
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	pirgs := make(map[int]*data.Pirg)
+	users := make(map[int]*data.User)
+	list := []render.Renderer{}
+	for _, m := range memberships {
+		if _, ok := pirgs[m.PirgId]; !ok {
+			if pirgs[m.PirgId], err = h.store.GetPirgById(r.Context(), m.PirgId); err != nil {
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			}
+		}
+		if _, ok := users[m.UserId]; !ok {
+			if users[m.UserId], err = h.store.GetUserById(r.Context(), m.UserId); err != nil {
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			}
+		}
+		list = append(list, &ExpiringMembershipResponse{
+			PirgId:    m.PirgId,
+			PirgName:  pirgs[m.PirgId].Name,
+			UserId:    m.UserId,
+			Username:  users[m.UserId].Username,
+			StartsAt:  m.StartsAt,
+			ExpiresAt: m.ExpiresAt,
+		})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// GetAuditLog returns the latest ?limit= audit entries, newest first
+func (h *ReportHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting audit log", "package", "api", "method", "GetAuditLog")
+	limit := defaultAuditLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit <= 0 {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid limit %q", param)))
+			return
+		}
+	}
+	entries, err := h.store.GetAuditLog(r.Context(), limit)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	list := []render.Renderer{}
+	for _, e := range entries {
+		list = append(list, &AuditEntryResponse{
+			Id:        e.Id,
+			Action:    e.Action,
+			Actor:     e.Actor,
+			PirgId:    e.PirgId,
+			UserId:    e.UserId,
+			Detail:    e.Detail,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestAPIStorageReport(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	owner := createTestPirgOwner(t, ts, "testapistoragereportowner")
+	gid := 5000
+	full := createTestPirg(t, ts, PirgRequest{Name: "testapistoragefull", OwnerId: owner.Id, Gid: &gid, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	if full.Gid == nil || *full.Gid != gid {
+		t.Fatalf("expected gid %d, got %v", gid, full.Gid)
+	}
+	fine := createTestPirg(t, ts, PirgRequest{Name: "testapistoragefine", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	fs, err := ts.Store.CreateLocation(ctx, &data.LocationRequest{Name: "/projects", Kind: "filesystem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := ts.Store.CreateLocation(ctx, &data.LocationRequest{Name: "testapistoragesite", Kind: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pirg := range []PirgResponse{full, fine} {
+		_, err := ts.Store.CreateStorageAllocation(ctx, pirg.Id, &data.StorageAllocationRequest{LocationId: fs.Id, Path: "/projects/" + pirg.Name, SoftLimitBytes: 1 << 30, HardLimitBytes: 2 << 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/locations/%d/usage", fs.Id)
+	lastWeek := time.Now().AddDate(0, 0, -7).UTC().Format(time.RFC3339)
+	resp := ts.doRaw(t, "POST", path+"?format=csv&sampled_at="+lastWeek, "gid,group,used_bytes\n5000,,512M\n,testapistoragefine,1M\n")
+	expectStatus(t, resp, http.StatusOK)
+
+	// matched by gid in the xfs report and by name in the lfs one
+	report := `Group quota on /projects (/dev/sdb1)
+Group ID         Used       Soft       Hard    Warn/Grace
+---------- --------------------------------------------------
+#5000          1.5G          0          0     00 [--------]
+nobody            4          0          0     00 [--------]
+`
+	resp = ts.doRaw(t, "POST", path+"?format=xfs", report)
+	expectStatus(t, resp, http.StatusOK)
+	var upload StorageUsageUploadResponse
+	decodeResponse(t, resp, &upload)
+	if upload.Recorded != 1 || len(upload.Unmatched) != 1 || upload.Unmatched[0] != "nobody" {
+		t.Fatalf("unexpected upload result %+v", upload)
+	}
+	resp = ts.doRaw(t, "POST", path+"?format=lfs", "Disk quotas for grp testapistoragefine (gid 9999):\n Filesystem kbytes quota limit grace\n /projects 2048 0 0 -\n")
+	expectStatus(t, resp, http.StatusOK)
+
+	resp = ts.doRaw(t, "POST", path+"?format=gpfs", "")
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.doRaw(t, "POST", fmt.Sprintf("/api/v1/locations/%d/usage?format=csv", site.Id), "group,used_bytes\n")
+	expectStatus(t, resp, http.StatusBadRequest)
+	ts.Role = "user"
+	resp = ts.doRaw(t, "POST", path+"?format=csv", "group,used_bytes\n")
+	expectStatus(t, resp, http.StatusForbidden)
+	ts.Role = "admin"
+
+	resp = ts.do(t, "GET", "/api/v1/reports/storage", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var statuses []StorageStatusResponse
+	decodeResponse(t, resp, &statuses)
+	if len(statuses) != 1 || statuses[0].PirgName != full.Name || !statuses[0].OverSoft || statuses[0].OverHard {
+		t.Fatalf("expected only %s to be over its soft limit, got %+v", full.Name, statuses)
+	}
+	// 1G in a week
+	if growth := statuses[0].GrowthBytesPerDay; growth < 150e6 || growth > 160e6 || statuses[0].ProjectedHardLimit == nil {
+		t.Fatalf("expected growth of about 1G a week, got %+v", statuses[0])
+	}
+
+	resp = ts.do(t, "GET", "/api/v1/reports/storage?all=true", nil)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &statuses)
+	if len(statuses) != 2 || statuses[1].PirgName != fine.Name || statuses[1].UsedBytes != 2<<20 {
+		t.Fatalf("expected both allocations, got %+v", statuses)
+	}
+}
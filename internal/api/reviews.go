@@ -0,0 +1,406 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/notifications"
+)
+
+type AccessReviewResponse struct {
+	Id                int        `json:"id"`
+	Name              string     `json:"name"`
+	Deadline          time.Time  `json:"deadline"`
+	RemoveUnconfirmed bool       `json:"remove_unconfirmed"`
+	Status            string     `json:"status"`
+	ClosedAt          *time.Time `json:"closed_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ModifiedAt        time.Time  `json:"modified_at"`
+}
+
+func (a *AccessReviewResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newAccessReviewResponse(review *data.AccessReview) *AccessReviewResponse {
+	status := "open"
+	if review.ClosedAt != nil {
+		status = "closed"
+	}
+	return &AccessReviewResponse{
+		Id:                review.Id,
+		Name:              review.Name,
+		Deadline:          review.Deadline,
+		RemoveUnconfirmed: review.RemoveUnconfirmed,
+		Status:            status,
+		ClosedAt:          review.ClosedAt,
+		CreatedAt:         review.CreatedAt,
+		ModifiedAt:        review.ModifiedAt,
+	}
+}
+
+// newAccessReviewResponseList converts a list of AccessReview objects into a list of render.Renderer objects
+func newAccessReviewResponseList(reviews []*data.AccessReview) []render.Renderer {
+	list := []render.Renderer{}
+	for _, review := range reviews {
+		list = append(list, newAccessReviewResponse(review))
+	}
+	return list
+}
+
+// AccessReviewProgressResponse counts the decisions made for one pirg, or
+// for the whole review
+type AccessReviewProgressResponse struct {
+	PirgId    int `json:"pirg_id,omitempty"`
+	Pending   int `json:"pending"`
+	Confirmed int `json:"confirmed"`
+	Removed   int `json:"removed"`
+}
+
+func (a *AccessReviewProgressResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// AccessReviewDetailResponse is a review with the totals of its decisions
+type AccessReviewDetailResponse struct {
+	*AccessReviewResponse
+	Progress AccessReviewProgressResponse `json:"progress"`
+}
+
+type AccessReviewItemResponse struct {
+	UserId    int        `json:"user_id"`
+	Decision  string     `json:"decision"`
+	DecidedBy *int       `json:"decided_by"`
+	DecidedAt *time.Time `json:"decided_at"`
+}
+
+// AccessReviewPirgResponse is the members of a pirg under review
+type AccessReviewPirgResponse struct {
+	ReviewId int                        `json:"review_id"`
+	PirgId   int                        `json:"pirg_id"`
+	Items    []AccessReviewItemResponse `json:"items"`
+}
+
+func (a *AccessReviewPirgResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newAccessReviewPirgResponse(reviewId int, pirgId int, items []*data.AccessReviewItem) *AccessReviewPirgResponse {
+	resp := &AccessReviewPirgResponse{ReviewId: reviewId, PirgId: pirgId, Items: []AccessReviewItemResponse{}}
+	for _, item := range items {
+		if item.PirgId != pirgId {
+			continue
+		}
+		resp.Items = append(resp.Items, AccessReviewItemResponse{
+			UserId:    item.UserId,
+			Decision:  item.Decision,
+			DecidedBy: item.DecidedBy,
+			DecidedAt: item.DecidedAt,
+		})
+	}
+	return resp
+}
+
+type AccessReviewRequest struct {
+	Name              string `json:"name"`
+	Deadline          string `json:"deadline"`
+	RemoveUnconfirmed bool   `json:"remove_unconfirmed"`
+	PirgIds           []int  `json:"pirg_ids"`
+
+	deadline time.Time
+}
+
+func (a *AccessReviewRequest) Bind(r *http.Request) error {
+	if a.Name == "" || a.Deadline == "" {
+		return fmt.Errorf("missing required access review fields: %+v", a)
+	}
+	var err error
+	if a.deadline, err = time.Parse(time.RFC3339, a.Deadline); err != nil {
+		return fmt.Errorf("invalid deadline %q, expected RFC3339", a.Deadline)
+	}
+	if !a.deadline.After(time.Now()) {
+		return fmt.Errorf("deadline must be in the future")
+	}
+	return nil
+}
+
+func (a *AccessReviewRequest) data() *data.AccessReviewRequest {
+	return &data.AccessReviewRequest{Name: a.Name, Deadline: a.deadline, RemoveUnconfirmed: a.RemoveUnconfirmed, PirgIds: a.PirgIds}
+}
+
+type AccessReviewDecisionRequest struct {
+	UserId   int    `json:"user_id"`
+	Decision string `json:"decision"`
+}
+
+// AccessReviewDecisionsRequest confirms or removes members of a pirg
+type AccessReviewDecisionsRequest struct {
+	Decisions []AccessReviewDecisionRequest `json:"decisions"`
+}
+
+func (a *AccessReviewDecisionsRequest) Bind(r *http.Request) error {
+	if len(a.Decisions) == 0 {
+		return fmt.Errorf("no decisions given")
+	}
+	return nil
+}
+
+func (a *AccessReviewDecisionsRequest) data() []data.AccessReviewDecision {
+	decisions := make([]data.AccessReviewDecision, len(a.Decisions))
+	for i, d := range a.Decisions {
+		decisions[i] = data.AccessReviewDecision(d)
+	}
+	return decisions
+}
+
+type ReviewHandler struct {
+	store    data.Store
+	notifier *notifications.Notifier
+}
+
+// ReviewsRouter serves access reviews. Only admins can open and close
+// them, decisions can also be made by the owner of the pirg.
+func ReviewsRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newReviewHandler(ctx)
+	r.Get("/", h.GetAllAccessReviews)
+	r.With(requireAdmin).Post("/", h.CreateAccessReview)
+	r.Route("/{reviewID}", func(r chi.Router) {
+		r.Use(h.AccessReviewCtx)
+		r.Get("/", h.GetAccessReview)
+		r.With(requireAdmin).Post("/close", h.CloseAccessReview)
+		r.Get("/pirgs", h.GetAccessReviewProgress)
+		r.Get("/pirgs/{pirgID}", h.GetAccessReviewPirg)
+		r.Put("/pirgs/{pirgID}", h.DecideAccessReview)
+	})
+	return r
+}
+
+func newReviewHandler(ctx context.Context) *ReviewHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	notifier, _ := ctx.Value(keys.NotifierKey).(*notifications.Notifier)
+	return &ReviewHandler{store: store, notifier: notifier}
+}
+
+// GetAllAccessReviews returns every access review
+func (h *ReviewHandler) GetAllAccessReviews(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting all access reviews", "package", "api", "method", "GetAllAccessReviews")
+	reviews, err := h.store.GetAllAccessReviews(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newAccessReviewResponseList(reviews)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreateAccessReview opens an access review, snapshotting the members of the pirgs
+func (h *ReviewHandler) CreateAccessReview(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating access review", "package", "api", "method", "CreateAccessReview")
+	reviewReq := &AccessReviewRequest{}
+	if err := render.Bind(r, reviewReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	review, err := h.store.CreateAccessReview(r.Context(), reviewReq.data())
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	h.notifyPending(r.Context(), review)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newAccessReviewResponse(review))
+}
+
+// AccessReviewCtx middleware is used to load an AccessReview object from
+// the URL parameters passed through as the request. In case
+// the AccessReview could not be found, we stop here and return a 404.
+func (h *ReviewHandler) AccessReviewCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reviewIDParam := chi.URLParam(r, "reviewID")
+		slog.DebugContext(r.Context(), "loading specific access review ctx", "id", reviewIDParam, "package", "api", "method", "AccessReviewCtx")
+		reviewId, err := strconv.Atoi(reviewIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		review, err := h.store.GetAccessReviewById(r.Context(), reviewId)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.AccessReviewKey, review)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetAccessReview returns the access review with the totals of its decisions
+func (h *ReviewHandler) GetAccessReview(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting access review", "package", "api", "method", "GetAccessReview")
+	review := r.Context().Value(keys.AccessReviewKey).(*data.AccessReview)
+	items, err := h.store.GetAccessReviewItems(r.Context(), review.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	resp := &AccessReviewDetailResponse{AccessReviewResponse: newAccessReviewResponse(review)}
+	for _, p := range data.ReviewProgress(items) {
+		resp.Progress.Pending += p.Pending
+		resp.Progress.Confirmed += p.Confirmed
+		resp.Progress.Removed += p.Removed
+	}
+	if err := render.Render(w, r, resp); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CloseAccessReview closes the access review, removing the unconfirmed
+// members if the review was opened with remove_unconfirmed
+func (h *ReviewHandler) CloseAccessReview(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "closing access review", "package", "api", "method", "CloseAccessReview")
+	review := r.Context().Value(keys.AccessReviewKey).(*data.AccessReview)
+	closed, err := h.store.CloseAccessReview(r.Context(), review.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Render(w, r, newAccessReviewResponse(closed))
+}
+
+// GetAccessReviewProgress returns the decisions made for each pirg under review
+func (h *ReviewHandler) GetAccessReviewProgress(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting access review progress", "package", "api", "method", "GetAccessReviewProgress")
+	review := r.Context().Value(keys.AccessReviewKey).(*data.AccessReview)
+	items, err := h.store.GetAccessReviewItems(r.Context(), review.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	list := []render.Renderer{}
+	for _, p := range data.ReviewProgress(items) {
+		list = append(list, &AccessReviewProgressResponse{PirgId: p.PirgId, Pending: p.Pending, Confirmed: p.Confirmed, Removed: p.Removed})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// reviewPirg loads the pirg named in the URL
+func (h *ReviewHandler) reviewPirg(r *http.Request) (*data.Pirg, error) {
+	pirgId, err := strconv.Atoi(chi.URLParam(r, "pirgID"))
+	if err != nil {
+		return nil, data.ErrNotFound
+	}
+	return h.store.GetPirgById(r.Context(), pirgId)
+}
+
+// GetAccessReviewPirg returns the members of the pirg under review and the
+// decisions made so far
+func (h *ReviewHandler) GetAccessReviewPirg(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting access review pirg", "package", "api", "method", "GetAccessReviewPirg")
+	review := r.Context().Value(keys.AccessReviewKey).(*data.AccessReview)
+	pirg, err := h.reviewPirg(r)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	items, err := h.store.GetAccessReviewItems(r.Context(), review.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if err := render.Render(w, r, newAccessReviewPirgResponse(review.Id, pirg.Id, items)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// DecideAccessReview confirms or removes members of the pirg. Admins and
+// the owner of the pirg can decide.
+func (h *ReviewHandler) DecideAccessReview(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deciding access review", "package", "api", "method", "DecideAccessReview")
+	review := r.Context().Value(keys.AccessReviewKey).(*data.AccessReview)
+	pirg, err := h.reviewPirg(r)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	role, _ := r.Context().Value(keys.RoleKey).(string)
+	callerId, known := r.Context().Value(keys.CallerIdKey).(int)
+	if role != "admin" && (!known || callerId != pirg.OwnerId) {
+		render.Render(w, r, ErrForbidden)
+		return
+	}
+	var decidedBy *int
+	if known && callerId != 0 {
+		decidedBy = &callerId
+	}
+	decisionsReq := &AccessReviewDecisionsRequest{}
+	if err := render.Bind(r, decisionsReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	items, err := h.store.DecideAccessReview(r.Context(), review.Id, pirg.Id, decisionsReq.data(), decidedBy)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	h.notifyDecided(r.Context(), review, pirg, decisionsReq.data())
+	if err := render.Render(w, r, newAccessReviewPirgResponse(review.Id, pirg.Id, items)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// notifyPending asks the owner of each pirg in the review to decide on its
+// members. The review is already saved, so failures are only logged.
+func (h *ReviewHandler) notifyPending(ctx context.Context, review *data.AccessReview) {
+	if h.notifier == nil {
+		return
+	}
+	items, err := h.store.GetAccessReviewItems(ctx, review.Id)
+	if err != nil {
+		slog.Warn("failed to get access review items to notify owners", "review", review.Name, "error", err, "package", "api", "method", "notifyPending")
+		return
+	}
+	for _, p := range data.ReviewProgress(items) {
+		if p.Pending == 0 {
+			continue
+		}
+		pirg, err := h.store.GetPirgById(ctx, p.PirgId)
+		var owner *data.User
+		if err == nil {
+			owner, err = h.store.GetUserById(ctx, pirg.OwnerId)
+		}
+		if err == nil {
+			err = h.notifier.AccessRequestPending(ctx, review, pirg, owner, p.Pending)
+		}
+		if err != nil {
+			slog.Warn("failed to notify pirg owner of access review", "review", review.Name, "pirg_id", p.PirgId, "error", err, "package", "api", "method", "notifyPending")
+		}
+	}
+}
+
+// notifyDecided tells the members whether their access was confirmed or
+// removed. The decisions are already saved, so failures are only logged.
+func (h *ReviewHandler) notifyDecided(ctx context.Context, review *data.AccessReview, pirg *data.Pirg, decisions []data.AccessReviewDecision) {
+	if h.notifier == nil {
+		return
+	}
+	for _, d := range decisions {
+		user, err := h.store.GetUserById(ctx, d.UserId)
+		if err == nil {
+			err = h.notifier.AccessRequestDecided(ctx, review, pirg, user, d.Decision)
+		}
+		if err != nil {
+			slog.Warn("failed to notify member of access review decision", "review", review.Name, "pirg", pirg.Name, "user_id", d.UserId, "error", err, "package", "api", "method", "notifyDecided")
+		}
+	}
+}
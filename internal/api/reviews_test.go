@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/notifications"
+)
+
+func TestAPIAccessReview(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapireviewowner")
+	member := createTestPirgOwner(t, ts, "testapireviewmember")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapireviewpirg",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+
+	rr := AccessReviewRequest{
+		Name:     "testapireview",
+		Deadline: time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+		PirgIds:  []int{pirg.Id},
+	}
+	resp := ts.do(t, "POST", "/api/v1/reviews", AccessReviewRequest{Name: "past", Deadline: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "POST", "/api/v1/reviews", rr)
+	expectStatus(t, resp, http.StatusCreated)
+	var review AccessReviewResponse
+	decodeResponse(t, resp, &review)
+	if review.Status != "open" {
+		t.Fatalf("expected an open review, got %+v", review)
+	}
+	path := fmt.Sprintf("/api/v1/reviews/%d", review.Id)
+
+	// only the owner of the pirg or an admin can decide
+	stranger := createTestPirgOwner(t, ts, "testapireviewstranger")
+	decisions := AccessReviewDecisionsRequest{Decisions: []AccessReviewDecisionRequest{{UserId: member.Id, Decision: "confirmed"}}}
+	ts.Role = "user"
+	ts.CallerId = stranger.Id
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/pirgs/%d", path, pirg.Id), decisions)
+	expectStatus(t, resp, http.StatusForbidden)
+	resp = ts.do(t, "POST", path+"/close", nil)
+	expectStatus(t, resp, http.StatusForbidden)
+
+	ts.CallerId = owner.Id
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/pirgs/%d", path, pirg.Id), decisions)
+	expectStatus(t, resp, http.StatusOK)
+	var decided AccessReviewPirgResponse
+	decodeResponse(t, resp, &decided)
+	if len(decided.Items) != 1 || decided.Items[0].Decision != "confirmed" || decided.Items[0].DecidedBy == nil || *decided.Items[0].DecidedBy != owner.Id {
+		t.Fatalf("expected the owner to have confirmed the member, got %+v", decided)
+	}
+	// the owner was asked to decide and the member was told the decision
+	queued, err := ts.Store.ClaimNotifications(context.Background(), time.Now().Add(time.Minute), time.Minute, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	for _, n := range queued {
+		if n.Event == notifications.EventPirgMemberAdded {
+			continue
+		}
+		if n.Params["pirg"] != pirg.Name || n.Params["review"] != rr.Name {
+			t.Errorf("unexpected notification %+v", n)
+		}
+		sent = append(sent, n.Event+" "+n.Recipient+" "+n.Params["pending"]+n.Params["decision"])
+	}
+	want := []string{
+		notifications.EventAccessRequestPending + " " + owner.Email + " 1",
+		notifications.EventAccessRequestDecided + " " + member.Email + " confirmed",
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("expected notifications %v, got %v", want, sent)
+	}
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/pirgs/%d", path, pirg.Id), AccessReviewDecisionsRequest{Decisions: []AccessReviewDecisionRequest{{UserId: member.Id, Decision: "maybe"}}})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	resp = ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var detail AccessReviewDetailResponse
+	decodeResponse(t, resp, &detail)
+	if detail.Progress != (AccessReviewProgressResponse{Confirmed: 1}) {
+		t.Fatalf("expected one confirmed member, got %+v", detail.Progress)
+	}
+
+	ts.Role = "admin"
+	ts.CallerId = 0
+	resp = ts.do(t, "POST", path+"/close", nil)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &review)
+	if review.Status != "closed" || review.ClosedAt == nil {
+		t.Fatalf("expected a closed review, got %+v", review)
+	}
+	resp = ts.do(t, "POST", path+"/close", nil)
+	expectStatus(t, resp, http.StatusConflict)
+	resp = ts.do(t, "PUT", fmt.Sprintf("%s/pirgs/%d", path, pirg.Id), decisions)
+	expectStatus(t, resp, http.StatusConflict)
+}
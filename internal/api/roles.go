@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// requireAdmin restricts single routes inside the user api to admins. The
+// role is loaded by the auth middlewares in front of the api routes.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value(keys.RoleKey).(string)
+		if role != "admin" {
+			render.Render(w, r, ErrForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+)
+
+// SlurmGrants are the QOS and partitions granted to a pirg or one of its members
+type SlurmGrants struct {
+	QOSIds       []int `json:"qos_ids"`
+	DefaultQOSId *int  `json:"default_qos_id"`
+	PartitionIds []int `json:"partition_ids"`
+}
+
+func newSlurmGrants(g data.SlurmGrants) SlurmGrants {
+	grants := SlurmGrants{QOSIds: g.QOSIds, DefaultQOSId: g.DefaultQOSId, PartitionIds: g.PartitionIds}
+	if grants.QOSIds == nil {
+		grants.QOSIds = []int{}
+	}
+	if grants.PartitionIds == nil {
+		grants.PartitionIds = []int{}
+	}
+	return grants
+}
+
+type MemberSlurm struct {
+	UserId int `json:"user_id"`
+	SlurmGrants
+}
+
+type PirgSlurmResponse struct {
+	PirgId int `json:"pirg_id"`
+	SlurmGrants
+	Members []MemberSlurm `json:"members"`
+}
+
+func (p *PirgSlurmResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgSlurmResponse(ps *data.PirgSlurm) *PirgSlurmResponse {
+	resp := &PirgSlurmResponse{
+		PirgId:      ps.PirgId,
+		SlurmGrants: newSlurmGrants(ps.SlurmGrants),
+		Members:     []MemberSlurm{},
+	}
+	for _, member := range ps.Members {
+		resp.Members = append(resp.Members, MemberSlurm{UserId: member.UserId, SlurmGrants: newSlurmGrants(member.SlurmGrants)})
+	}
+	return resp
+}
+
+// PirgSlurmRequest replaces everything granted to the pirg and its members
+type PirgSlurmRequest struct {
+	SlurmGrants
+	Members []MemberSlurm `json:"members"`
+}
+
+func (p *PirgSlurmRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+func (p *PirgSlurmRequest) toData() *data.PirgSlurm {
+	ps := &data.PirgSlurm{SlurmGrants: data.SlurmGrants(p.SlurmGrants)}
+	for _, member := range p.Members {
+		ps.Members = append(ps.Members, data.MemberSlurm{UserId: member.UserId, SlurmGrants: data.SlurmGrants(member.SlurmGrants)})
+	}
+	return ps
+}
+
+// SlurmLimits are the limits on the association of a pirg or one of its members
+type SlurmLimits struct {
+	Fairshare *int   `json:"fairshare"`
+	GrpTRES   string `json:"grp_tres"`
+	MaxJobs   *int   `json:"max_jobs"`
+	MaxWall   string `json:"max_wall"`
+}
+
+type MemberLimits struct {
+	UserId int `json:"user_id"`
+	SlurmLimits
+}
+
+type PirgLimitsResponse struct {
+	PirgId int `json:"pirg_id"`
+	SlurmLimits
+	Members []MemberLimits `json:"members"`
+}
+
+func (p *PirgLimitsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPirgLimitsResponse(pl *data.PirgLimits) *PirgLimitsResponse {
+	resp := &PirgLimitsResponse{
+		PirgId:      pl.PirgId,
+		SlurmLimits: SlurmLimits(pl.SlurmLimits),
+		Members:     []MemberLimits{},
+	}
+	for _, member := range pl.Members {
+		resp.Members = append(resp.Members, MemberLimits{UserId: member.UserId, SlurmLimits: SlurmLimits(member.SlurmLimits)})
+	}
+	return resp
+}
+
+// PirgLimitsRequest replaces the limits on the pirg and its members
+type PirgLimitsRequest struct {
+	SlurmLimits
+	Members []MemberLimits `json:"members"`
+}
+
+func (p *PirgLimitsRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+func (p *PirgLimitsRequest) toData() *data.PirgLimits {
+	pl := &data.PirgLimits{SlurmLimits: data.SlurmLimits(p.SlurmLimits)}
+	for _, member := range p.Members {
+		pl.Members = append(pl.Members, data.MemberLimits{UserId: member.UserId, SlurmLimits: data.SlurmLimits(member.SlurmLimits)})
+	}
+	return pl
+}
+
+type SlurmHandler struct {
+	store data.Store
+}
+
+// SlurmRouter is mounted below /pirgs/{pirgID}, so the pirg
+// is already loaded into the request context
+func SlurmRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newSlurmHandler(ctx)
+	r.Get("/", h.GetPirgSlurm)
+	r.Put("/", h.SetPirgSlurm)
+	r.Get("/limits", h.GetPirgLimits)
+	r.With(requireAdmin).Put("/limits", h.SetPirgLimits)
+	return r
+}
+
+func newSlurmHandler(ctx context.Context) *SlurmHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &SlurmHandler{store: store}
+}
+
+// GetPirgSlurm returns the QOS and partitions granted to the pirg and its members
+func (h *SlurmHandler) GetPirgSlurm(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting slurm grants", "package", "api", "method", "GetPirgSlurm")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	ps, err := h.store.GetPirgSlurm(r.Context(), pirg.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if err := render.Render(w, r, newPirgSlurmResponse(ps)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// SetPirgSlurm replaces the QOS and partitions granted to the pirg and its members
+func (h *SlurmHandler) SetPirgSlurm(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "setting slurm grants", "package", "api", "method", "SetPirgSlurm")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	slurmReq := &PirgSlurmRequest{}
+	if err := render.Bind(r, slurmReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ps, err := h.store.SetPirgSlurm(r.Context(), pirg.Id, slurmReq.toData())
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newPirgSlurmResponse(ps))
+}
+
+// GetPirgLimits returns the limits on the pirg's account and its members
+func (h *SlurmHandler) GetPirgLimits(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting slurm limits", "package", "api", "method", "GetPirgLimits")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	pl, err := h.store.GetPirgLimits(r.Context(), pirg.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if err := render.Render(w, r, newPirgLimitsResponse(pl)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// SetPirgLimits replaces the limits on the pirg's account and its members
+func (h *SlurmHandler) SetPirgLimits(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "setting slurm limits", "package", "api", "method", "SetPirgLimits")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	limitsReq := &PirgLimitsRequest{}
+	if err := render.Bind(r, limitsReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	pl, err := h.store.SetPirgLimits(r.Context(), pirg.Id, limitsReq.toData())
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newPirgLimitsResponse(pl))
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAPIPirgSlurm(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapipirgslurmowner")
+	member := createTestPirgOwner(t, ts, "testapipirgslurmmember")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapipirgslurm",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id, member.Id},
+	})
+	cluster := createTestCluster(t, ts, ClusterRequest{Name: "testapipirgslurmcluster"})
+
+	resp := ts.do(t, "POST", "/api/v1/qos", QOSRequest{Name: "testapipirgslurmqos"})
+	expectStatus(t, resp, http.StatusCreated)
+	var qos QOSResponse
+	decodeResponse(t, resp, &qos)
+	resp = ts.do(t, "POST", "/api/v1/partitions", PartitionRequest{ClusterId: cluster.Id, Name: "condo"})
+	expectStatus(t, resp, http.StatusCreated)
+	var partition PartitionResponse
+	decodeResponse(t, resp, &partition)
+
+	path := fmt.Sprintf("/api/v1/pirgs/%d/slurm", pirg.Id)
+	resp = ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var ps PirgSlurmResponse
+	decodeResponse(t, resp, &ps)
+	if len(ps.QOSIds) != 0 || len(ps.PartitionIds) != 0 || len(ps.Members) != 0 {
+		t.Errorf("expected no grants, got %+v", ps)
+	}
+
+	// the default has to be one of the granted qos
+	resp = ts.do(t, "PUT", path, PirgSlurmRequest{SlurmGrants: SlurmGrants{DefaultQOSId: &qos.Id}})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	req := PirgSlurmRequest{
+		SlurmGrants: SlurmGrants{QOSIds: []int{qos.Id}, DefaultQOSId: &qos.Id, PartitionIds: []int{}},
+		Members: []MemberSlurm{
+			{UserId: member.Id, SlurmGrants: SlurmGrants{QOSIds: []int{}, PartitionIds: []int{partition.Id}}},
+		},
+	}
+	resp = ts.do(t, "PUT", path, req)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &ps)
+	if !reflect.DeepEqual(ps.SlurmGrants, req.SlurmGrants) || !reflect.DeepEqual(ps.Members, req.Members) {
+		t.Errorf("expected grants %+v got %+v", req, ps)
+	}
+
+	// granted qos and partitions can't be deleted
+	resp = ts.do(t, "DELETE", fmt.Sprintf("/api/v1/qos/%d", qos.Id), nil)
+	expectStatus(t, resp, http.StatusConflict)
+	resp = ts.do(t, "DELETE", fmt.Sprintf("/api/v1/partitions/%d", partition.Id), nil)
+	expectStatus(t, resp, http.StatusConflict)
+}
+
+func TestAPIPirgLimits(t *testing.T) {
+	ts := newTestServer(t)
+	owner := createTestPirgOwner(t, ts, "testapipirglimitsowner")
+	pirg := createTestPirg(t, ts, PirgRequest{
+		Name:     "testapipirglimits",
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	})
+	path := fmt.Sprintf("/api/v1/pirgs/%d/slurm/limits", pirg.Id)
+
+	fairshare := 5
+	req := PirgLimitsRequest{
+		SlurmLimits: SlurmLimits{Fairshare: &fairshare, GrpTRES: "cpu=100,gres/gpu=2", MaxWall: "1-00:00:00"},
+		Members:     []MemberLimits{{UserId: owner.Id, SlurmLimits: SlurmLimits{GrpTRES: "cpu=10"}}},
+	}
+	resp := ts.do(t, "PUT", path, PirgLimitsRequest{SlurmLimits: SlurmLimits{GrpTRES: "cores=100"}})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = ts.do(t, "PUT", path, req)
+	expectStatus(t, resp, http.StatusOK)
+	var pl PirgLimitsResponse
+	decodeResponse(t, resp, &pl)
+	if !reflect.DeepEqual(pl.SlurmLimits, req.SlurmLimits) || !reflect.DeepEqual(pl.Members, req.Members) {
+		t.Errorf("expected limits %+v got %+v", req, pl)
+	}
+
+	// only admins can change limits, but anyone can read them
+	ts.Role = "user"
+	resp = ts.do(t, "PUT", path, PirgLimitsRequest{})
+	expectStatus(t, resp, http.StatusForbidden)
+	resp = ts.do(t, "GET", path, nil)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &pl)
+	if pl.GrpTRES != req.GrpTRES {
+		t.Errorf("expected grp_tres %s got %s", req.GrpTRES, pl.GrpTRES)
+	}
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/quota"
+)
+
+// maxQuotaUploadBytes bounds the quota report accepted in one upload
+const maxQuotaUploadBytes = 16 << 20
+
+type StorageAllocationResponse struct {
+	Id             int       `json:"id"`
+	PirgId         int       `json:"pirg_id"`
+	LocationId     int       `json:"location_id"`
+	Path           string    `json:"path"`
+	SoftLimitBytes int64     `json:"soft_limit_bytes"`
+	HardLimitBytes int64     `json:"hard_limit_bytes"`
+	CreatedAt      time.Time `json:"created_at"`
+	ModifiedAt     time.Time `json:"modified_at"`
+}
+
+func (s *StorageAllocationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newStorageAllocationResponse(s *data.StorageAllocation) *StorageAllocationResponse {
+	return &StorageAllocationResponse{
+		Id:             s.Id,
+		PirgId:         s.PirgId,
+		LocationId:     s.LocationId,
+		Path:           s.Path,
+		SoftLimitBytes: s.SoftLimitBytes,
+		HardLimitBytes: s.HardLimitBytes,
+		CreatedAt:      s.CreatedAt,
+		ModifiedAt:     s.ModifiedAt,
+	}
+}
+
+// newStorageAllocationResponseList converts a list of StorageAllocation objects into a list of render.Renderer objects
+func newStorageAllocationResponseList(allocations []*data.StorageAllocation) []render.Renderer {
+	list := []render.Renderer{}
+	for _, allocation := range allocations {
+		list = append(list, newStorageAllocationResponse(allocation))
+	}
+	return list
+}
+
+type StorageAllocationRequest struct {
+	LocationId     int    `json:"location_id"`
+	Path           string `json:"path"`
+	SoftLimitBytes int64  `json:"soft_limit_bytes"`
+	HardLimitBytes int64  `json:"hard_limit_bytes"`
+}
+
+func (s *StorageAllocationRequest) Bind(r *http.Request) error {
+	if s.LocationId == 0 || s.Path == "" {
+		return fmt.Errorf("missing required storage allocation fields: %+v", s)
+	}
+	if s.SoftLimitBytes < 0 || s.HardLimitBytes < 0 {
+		return fmt.Errorf("storage limits must not be negative")
+	}
+	if s.HardLimitBytes > 0 && s.SoftLimitBytes > s.HardLimitBytes {
+		return fmt.Errorf("soft_limit_bytes must not exceed hard_limit_bytes")
+	}
+	return nil
+}
+
+func newStorageAllocationRequest(s *data.StorageAllocation) *StorageAllocationRequest {
+	return &StorageAllocationRequest{
+		LocationId:     s.LocationId,
+		Path:           s.Path,
+		SoftLimitBytes: s.SoftLimitBytes,
+		HardLimitBytes: s.HardLimitBytes,
+	}
+}
+
+// StorageUsageUploadResponse reports what was done with a quota report.
+// Groups that don't match a pirg by gid or name are listed in Unmatched.
+type StorageUsageUploadResponse struct {
+	Recorded  int      `json:"recorded"`
+	Unmatched []string `json:"unmatched"`
+}
+
+func (s *StorageUsageUploadResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type StorageHandler struct {
+	store data.Store
+}
+
+// StorageRouter is mounted below /pirgs/{pirgID}, so the pirg
+// is already loaded into the request context
+func StorageRouter(ctx context.Context) http.Handler {
+	r := chi.NewRouter()
+	h := newStorageHandler(ctx)
+	r.Get("/", h.GetPirgStorageAllocations)
+	r.Post("/", h.CreateStorageAllocation)
+	r.Route("/{allocationID}", func(r chi.Router) {
+		r.Use(h.StorageAllocationCtx)
+		r.Get("/", h.GetStorageAllocation)
+		r.Put("/", h.UpdateStorageAllocation)
+		r.Delete("/", h.DeleteStorageAllocation)
+	})
+	return r
+}
+
+func newStorageHandler(ctx context.Context) *StorageHandler {
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &StorageHandler{store: store}
+}
+
+// GetPirgStorageAllocations returns the storage allocations for the pirg
+func (h *StorageHandler) GetPirgStorageAllocations(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting storage allocations", "package", "api", "method", "GetPirgStorageAllocations")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	allocations, err := h.store.GetPirgStorageAllocations(r.Context(), pirg.Id)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, newStorageAllocationResponseList(allocations)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// CreateStorageAllocation allocates storage to the pirg
+func (h *StorageHandler) CreateStorageAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "creating storage allocation", "package", "api", "method", "CreateStorageAllocation")
+	pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+	allocationReq := &StorageAllocationRequest{}
+	if err := render.Bind(r, allocationReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataAllocation := data.StorageAllocationRequest(*allocationReq)
+	allocation, err := h.store.CreateStorageAllocation(r.Context(), pirg.Id, &dataAllocation)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newStorageAllocationResponse(allocation))
+}
+
+// StorageAllocationCtx middleware loads the storage allocation from the URL,
+// making sure it belongs to the pirg in the request context
+func (h *StorageHandler) StorageAllocationCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pirg := r.Context().Value(keys.PirgKey).(*data.Pirg)
+		allocationIDParam := chi.URLParam(r, "allocationID")
+		slog.DebugContext(r.Context(), "loading specific storage allocation ctx", "id", allocationIDParam, "package", "api", "method", "StorageAllocationCtx")
+		allocationId, err := strconv.Atoi(allocationIDParam)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		allocation, err := h.store.GetStorageAllocationById(r.Context(), allocationId)
+		if err != nil || allocation.PirgId != pirg.Id {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), keys.StorageAllocationKey, allocation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetStorageAllocation returns the storage allocation in the request context
+func (h *StorageHandler) GetStorageAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "getting storage allocation", "package", "api", "method", "GetStorageAllocation")
+	allocation := r.Context().Value(keys.StorageAllocationKey).(*data.StorageAllocation)
+	if err := render.Render(w, r, newStorageAllocationResponse(allocation)); err != nil {
+		render.Render(w, r, ErrRender(err))
+	}
+}
+
+// UpdateStorageAllocation updates a storage allocation
+func (h *StorageHandler) UpdateStorageAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "updating storage allocation", "package", "api", "method", "UpdateStorageAllocation")
+	allocation := r.Context().Value(keys.StorageAllocationKey).(*data.StorageAllocation)
+	allocationReq := newStorageAllocationRequest(allocation)
+	if err := render.Bind(r, allocationReq); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	dataAllocation := data.StorageAllocationRequest(*allocationReq)
+	updated, err := h.store.UpdateStorageAllocation(r.Context(), allocation.Id, &dataAllocation)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, newStorageAllocationResponse(updated))
+}
+
+// DeleteStorageAllocation deletes a storage allocation
+func (h *StorageHandler) DeleteStorageAllocation(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "deleting storage allocation", "package", "api", "method", "DeleteStorageAllocation")
+	allocation := r.Context().Value(keys.StorageAllocationKey).(*data.StorageAllocation)
+	if err := h.store.DeleteStorageAllocation(r.Context(), allocation.Id); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusNoContent)
+}
+
+// UploadLocationUsage records the usage in a quota report sent as the
+// request body. It's mounted below /locations/{locationID}. The format is
+// picked with ?format= and the samples are taken now unless ?sampled_at=
+// gives an RFC 3339 time.
+func (h *StorageHandler) UploadLocationUsage(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "uploading location usage", "package", "api", "method", "UploadLocationUsage")
+	location := r.Context().Value(keys.LocationKey).(*data.Location)
+	if location.Kind != "filesystem" {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("usage can only be recorded for filesystem locations, location %d is a %s", location.Id, location.Kind)))
+		return
+	}
+	sampledAt := time.Now()
+	if param := r.URL.Query().Get("sampled_at"); param != "" {
+		var err error
+		if sampledAt, err = time.Parse(time.RFC3339, param); err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid sampled_at %q, expected RFC 3339", param)))
+			return
+		}
+	}
+	rows, err := quota.Parse(r.URL.Query().Get("format"), http.MaxBytesReader(w, r.Body, maxQuotaUploadBytes))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	resp := &StorageUsageUploadResponse{Unmatched: []string{}}
+	var samples []*data.StorageUsage
+	for _, row := range rows {
+		pirg, err := h.matchPirg(r.Context(), row)
+		if errors.Is(err, data.ErrNotFound) {
+			name := row.Group
+			if name == "" {
+				name = fmt.Sprintf("#%d", *row.Gid)
+			}
+			resp.Unmatched = append(resp.Unmatched, name)
+			continue
+		}
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		samples = append(samples, &data.StorageUsage{PirgId: pirg.Id, LocationId: location.Id, UsedBytes: row.UsedBytes, SampledAt: sampledAt})
+	}
+	if len(samples) > 0 {
+		if err := h.store.RecordStorageUsage(r.Context(), samples); err != nil {
+			render.Render(w, r, ErrStore(err))
+			return
+		}
+	}
+	resp.Recorded = len(samples)
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
+
+// matchPirg finds the pirg for a row of a quota report by gid, then by name
+func (h *StorageHandler) matchPirg(ctx context.Context, row quota.Row) (*data.Pirg, error) {
+	if row.Gid != nil {
+		pirg, err := h.store.GetPirgByGid(ctx, *row.Gid)
+		if !errors.Is(err, data.ErrNotFound) || row.Group == "" {
+			return pirg, err
+		}
+	}
+	return h.store.GetPirgByName(ctx, row.Group)
+}
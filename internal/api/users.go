@@ -2,7 +2,6 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -16,13 +15,21 @@ import (
 )
 
 type UserResponse struct {
-	Id         int       `json:"id"`
-	Username   string    `json:"username"`
-	Email      string    `json:"email"`
-	FirstName  string    `json:"firstname"`
-	LastName   string    `json:"lastname"`
-	CreatedAt  time.Time `json:"created_at"`
-	ModifiedAt time.Time `json:"modified_at"`
+	Id        int    `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Uid       *int   `json:"uid"`
+	Type      string `json:"type"`
+	// SponsorId and SponsorUsername name the pirg owner responsible for a guest
+	SponsorId       *int       `json:"sponsor_id"`
+	SponsorUsername string     `json:"sponsor_username,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	Active          bool       `json:"active"`
+	DeactivatedAt   *time.Time `json:"deactivated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ModifiedAt      time.Time  `json:"modified_at"`
 }
 
 func (u *UserResponse) Bind(r *http.Request) error {
@@ -33,30 +40,63 @@ func (u *UserResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func newUserResponse(u *data.User) *UserResponse {
-	return &UserResponse{
-		Id:        u.Id,
-		Username:  u.Username,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Email:     u.Email,
+// newUserResponse converts a user, with usernames giving the username of
+// their sponsor if they have one
+func newUserResponse(u *data.User, usernames map[int]string) *UserResponse {
+	resp := &UserResponse{
+		Id:            u.Id,
+		Username:      u.Username,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		Email:         u.Email,
+		Uid:           u.Uid,
+		Type:          u.Type,
+		SponsorId:     u.SponsorId,
+		ExpiresAt:     u.ExpiresAt,
+		Active:        u.Active(),
+		DeactivatedAt: u.DeactivatedAt,
+	}
+	if u.SponsorId != nil {
+		resp.SponsorUsername = usernames[*u.SponsorId]
 	}
+	return resp
 }
 
 // newUserResponseList converts a list of UserResponse objects into a list of render.Renderer objects
 func newUserResponseList(users []*data.User) []render.Renderer {
+	usernames := make(map[int]string, len(users))
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
 	list := []render.Renderer{}
 	for _, user := range users {
-		list = append(list, newUserResponse(user))
+		list = append(list, newUserResponse(user, usernames))
 	}
 	return list
 }
 
+// userResponse converts a single user, looking up their sponsor
+func (h *UserHandler) userResponse(ctx context.Context, u *data.User) *UserResponse {
+	usernames := make(map[int]string)
+	if u.SponsorId != nil {
+		if sponsor, err := h.store.GetUserById(ctx, *u.SponsorId); err == nil {
+			usernames[sponsor.Id] = sponsor.Username
+		}
+	}
+	return newUserResponse(u, usernames)
+}
+
+// UserRequest creates or updates a user. Type defaults to institutional,
+// guests also need a sponsor_id naming a pirg owner and an expires_at.
 type UserRequest struct {
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	FirstName string `json:"firstname"`
-	LastName  string `json:"lastname"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	FirstName string     `json:"firstname"`
+	LastName  string     `json:"lastname"`
+	Uid       *int       `json:"uid"`
+	Type      string     `json:"type"`
+	SponsorId *int       `json:"sponsor_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 func (u *UserRequest) Bind(r *http.Request) error {
@@ -67,17 +107,44 @@ func (u *UserRequest) Bind(r *http.Request) error {
 	return nil
 }
 
+// newUserRequest fills a UserRequest from the user. The pointers are
+// copied so binding a request body over it doesn't write through to the user.
 func newUserRequest(u *data.User) *UserRequest {
 	return &UserRequest{
 		Username:  u.Username,
 		FirstName: u.FirstName,
 		LastName:  u.LastName,
 		Email:     u.Email,
+		Uid:       copyOf(u.Uid),
+		Type:      u.Type,
+		SponsorId: copyOf(u.SponsorId),
+		ExpiresAt: copyOf(u.ExpiresAt),
+	}
+}
+
+// copyOf returns a pointer to a copy of the value p points to, or nil
+func copyOf[T any](p *T) *T {
+	if p == nil {
+		return nil
 	}
+	c := *p
+	return &c
+}
+
+// GuestRenewalRequest moves the expiry of a guest
+type GuestRenewalRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (g *GuestRenewalRequest) Bind(r *http.Request) error {
+	if g.ExpiresAt == nil || !g.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
 }
 
 type UserHandler struct {
-	dbConn *sql.DB
+	store data.Store
 }
 
 func UsersRouter(ctx context.Context) http.Handler {
@@ -90,13 +157,14 @@ func UsersRouter(ctx context.Context) http.Handler {
 		r.Get("/", h.GetUser)
 		r.Put("/", h.UpdateUser)
 		r.Delete("/", h.DeleteUser)
+		r.Post("/renew", h.RenewGuest)
 	})
 	return r
 }
 
 func newUserHandler(ctx context.Context) *UserHandler {
-	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
-	return &UserHandler{dbConn: dbConn}
+	store := ctx.Value(keys.StoreKey).(data.Store)
+	return &UserHandler{store: store}
 }
 
 // GetAllUsers returns all existing users
@@ -105,23 +173,23 @@ func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	// username query parameter exists, so we are looking for a specific user
 	// TODO(lcrown): why are both arms of this if statement running???
 	if searchUsername != "" {
-		slog.Debug("getting user by username", "package", "api", "method", "GetAllUsers")
-		user, err := data.GetUserByUsername(h.dbConn, searchUsername)
+		slog.DebugContext(r.Context(), "getting user by username", "package", "api", "method", "GetAllUsers")
+		user, err := h.store.GetUserByUsername(r.Context(), searchUsername)
 		if err != nil {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
-		resp := newUserResponse(user)
+		resp := h.userResponse(r.Context(), user)
 		if err := render.Render(w, r, resp); err != nil {
 			render.Render(w, r, ErrRender(err))
 			return
 		}
 	} else {
 		// username query parameter doesn't exist, so we are looking for all users
-		slog.Debug("getting all users", "package", "api", "method", "GetAllUsers")
+		slog.DebugContext(r.Context(), "getting all users", "package", "api", "method", "GetAllUsers")
 		var users []*data.User
 
-		users, err := data.GetAllUsers(h.dbConn)
+		users, err := h.store.GetAllUsers(r.Context())
 		if err != nil {
 			render.Render(w, r, ErrInternalServer(err))
 			return
@@ -137,7 +205,7 @@ func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 
 // CreateUser creates a new user
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("creating new user", "package", "api", "method", "CreateUser")
+	slog.DebugContext(r.Context(), "creating new user", "package", "api", "method", "CreateUser")
 	userReq := &UserRequest{}
 	if err := render.Bind(r, userReq); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
@@ -146,13 +214,13 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	dataUser := data.UserRequest(*userReq)
 
-	newUser, err := data.CreateUser(h.dbConn, &dataUser)
+	newUser, err := h.store.CreateUser(r.Context(), &dataUser)
 	if err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+		render.Render(w, r, ErrStore(err))
 		return
 	}
 
-	resp := newUserResponse(newUser)
+	resp := h.userResponse(r.Context(), newUser)
 	render.Status(r, http.StatusCreated)
 	render.Render(w, r, resp)
 }
@@ -166,13 +234,13 @@ func (h *UserHandler) UserCtx(next http.Handler) http.Handler {
 		var err error
 
 		userIdParam := chi.URLParam(r, "userID")
-		slog.Debug("loading specific user ctx", "id", userIdParam, "package", "api", "method", "UserCtx")
+		slog.DebugContext(r.Context(), "loading specific user ctx", "id", userIdParam, "package", "api", "method", "UserCtx")
 		userId, err := strconv.Atoi(userIdParam)
 		if err != nil {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
-		user, err = data.GetUserById(h.dbConn, userId)
+		user, err = h.store.GetUserById(r.Context(), userId)
 		if err != nil {
 			render.Render(w, r, ErrNotFound)
 			return
@@ -185,9 +253,9 @@ func (h *UserHandler) UserCtx(next http.Handler) http.Handler {
 
 // GetUser returns the user in the request context
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("getting user", "package", "api", "method", "GetUser")
+	slog.DebugContext(r.Context(), "getting user", "package", "api", "method", "GetUser")
 	user := r.Context().Value(keys.UserKey).(*data.User)
-	resp := newUserResponse(user)
+	resp := h.userResponse(r.Context(), user)
 	if err := render.Render(w, r, resp); err != nil {
 		render.Render(w, r, ErrRender(err))
 	}
@@ -195,7 +263,7 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser updates a user
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("updating user", "package", "api", "method", "UpdateUser")
+	slog.DebugContext(r.Context(), "updating user", "package", "api", "method", "UpdateUser")
 	// existing user comes from the request context because
 	// `userID` is part of the URL.
 	user := r.Context().Value(keys.UserKey).(*data.User)
@@ -208,31 +276,71 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	// only admins can change whether a user is a guest, their sponsor or
+	// their expiry, everyone else renews guests through RenewGuest
+	if role, _ := r.Context().Value(keys.RoleKey).(string); role != "admin" {
+		userReq.Type, userReq.SponsorId, userReq.ExpiresAt = user.Type, user.SponsorId, user.ExpiresAt
+	}
 	dataUserRequest := data.UserRequest(*userReq)
-	err := data.UpdateUser(h.dbConn, user.Id, &dataUserRequest)
+	err := h.store.UpdateUser(r.Context(), user.Id, &dataUserRequest)
 	if err != nil {
-		render.Render(w, r, ErrInternalServer(err))
+		render.Render(w, r, ErrStore(err))
 		return
 	}
-	updatedUser, err := data.GetUserById(h.dbConn, user.Id)
+	updatedUser, err := h.store.GetUserById(r.Context(), user.Id)
 	if err != nil {
 		render.Render(w, r, ErrNotFound)
 		return
 	}
 
-	resp := newUserResponse(updatedUser)
+	resp := h.userResponse(r.Context(), updatedUser)
 	render.Status(r, http.StatusOK)
 	render.Render(w, r, resp)
 }
 
 // DeleteUser deletes a user
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("deleting user", "package", "api", "method", "DeleteUser")
+	slog.DebugContext(r.Context(), "deleting user", "package", "api", "method", "DeleteUser")
 	user := r.Context().Value(keys.UserKey).(*data.User)
-	err := data.DeleteUser(h.dbConn, user.Id)
+	err := h.store.DeleteUser(r.Context(), user.Id)
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrStore(err))
 		return
 	}
 	render.Status(r, http.StatusNoContent)
 }
+
+// RenewGuest sets a new expiry for a guest, reactivating them if they had
+// expired. Admins and the guest's sponsor can renew.
+func (h *UserHandler) RenewGuest(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "renewing guest", "package", "api", "method", "RenewGuest")
+	user := r.Context().Value(keys.UserKey).(*data.User)
+	role, _ := r.Context().Value(keys.RoleKey).(string)
+	callerId, known := r.Context().Value(keys.CallerIdKey).(int)
+	if role != "admin" && (!known || user.SponsorId == nil || callerId != *user.SponsorId) {
+		render.Render(w, r, ErrForbidden)
+		return
+	}
+	if user.Type != data.UserGuest {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("only guests can be renewed, %s is %s", user.Username, user.Type)))
+		return
+	}
+	renewal := &GuestRenewalRequest{}
+	if err := render.Bind(r, renewal); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	userReq := data.UserRequest(*newUserRequest(user))
+	userReq.ExpiresAt = renewal.ExpiresAt
+	if err := h.store.UpdateUser(r.Context(), user.Id, &userReq); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	renewed, err := h.store.GetUserById(r.Context(), user.Id)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, h.userResponse(r.Context(), renewed))
+}
@@ -1,55 +1,27 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/lcrownover/hpcadmin-server/internal/data"
 )
 
-func TestAPICreateUser(t *testing.T) {
-	th := NewTestDataHandler()
-
-	// first we need to create a user, then get it back
-	ur := UserRequest{
-		Username:  "testapicreateuser",
-		Email:     "testapicreateuser@localhost",
-		FirstName: "TestAPI",
-		LastName:  "CreateUser",
-	}
-	userReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/users", bytes.NewBuffer([]byte(userReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
+func createTestUser(t *testing.T, ts *testServer, ur UserRequest) UserResponse {
+	t.Helper()
+	resp := ts.do(t, "POST", "/api/v1/users", ur)
+	expectStatus(t, resp, http.StatusCreated)
 	var userResponse UserResponse
-	err = json.NewDecoder(resp.Body).Decode(&userResponse)
-	if err != nil {
-		t.Fatal(err)
-	}
+	decodeResponse(t, resp, &userResponse)
+	return userResponse
+}
 
-	u, err := data.GetUserById(th.DB, userResponse.Id)
-	if err != nil {
-		t.Fatal(err)
-	}
+func expectUser(t *testing.T, u *data.User, ur UserRequest) {
+	t.Helper()
 	if u.Username != ur.Username {
 		t.Errorf("expected username %v got %v", ur.Username, u.Username)
 	}
@@ -64,284 +36,172 @@ func TestAPICreateUser(t *testing.T) {
 	}
 }
 
+func TestAPICreateUser(t *testing.T) {
+	ts := newTestServer(t)
+	ur := UserRequest{
+		Username:  "testapicreateuser",
+		Email:     "testapicreateuser@localhost",
+		FirstName: "TestAPI",
+		LastName:  "CreateUser",
+	}
+	userResponse := createTestUser(t, ts, ur)
+
+	u, err := ts.Store.GetUserById(context.Background(), userResponse.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUser(t, u, ur)
+
+	// the same username can't be used twice
+	resp := ts.do(t, "POST", "/api/v1/users", ur)
+	expectStatus(t, resp, http.StatusConflict)
+
+	// all fields are required
+	resp = ts.do(t, "POST", "/api/v1/users", UserRequest{Username: "testapimissingfields"})
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
 // TestGetAllUsers tests the GET /api/v1/users endpoint
 // it creates a user, then gets all users and checks that the created user is in the list
 func TestAPIGetAllUsers(t *testing.T) {
-	// first we need to create a user, then get it back
+	ts := newTestServer(t)
 	ur := UserRequest{
 		Username:  "testapigetallusers",
 		Email:     "testapigetallusers@localhost",
 		FirstName: "TestAPI",
 		LastName:  "GetAllUsers",
 	}
-	userReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/users", bytes.NewBuffer([]byte(userReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
+	createTestUser(t, ts, ur)
 
-	// now get all users
-	req, err = http.NewRequest("GET", "http://localhost:3333/api/v1/users", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
+	resp := ts.do(t, "GET", "/api/v1/users", nil)
+	expectStatus(t, resp, http.StatusOK)
 	var usersResponse []UserResponse
-	err = json.NewDecoder(resp.Body).Decode(&usersResponse)
-	if err != nil {
-		t.Fatal(err)
+	decodeResponse(t, resp, &usersResponse)
+	if len(usersResponse) != 1 || usersResponse[0].Username != ur.Username {
+		t.Errorf("expected to find only user %v in the list of users, got %+v", ur.Username, usersResponse)
 	}
 
-	// check if the usersResponse has more than 1 user
-	if len(usersResponse) < 1 {
-		t.Errorf("expected more than 1 user, got %v", len(usersResponse))
+	// searching by username returns the single user
+	resp = ts.do(t, "GET", "/api/v1/users?username="+ur.Username, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var userResponse UserResponse
+	decodeResponse(t, resp, &userResponse)
+	if userResponse.Username != ur.Username {
+		t.Errorf("expected username %v got %v", ur.Username, userResponse.Username)
 	}
 
-	// check if the user we created is in the list
-	found := false
-	for _, user := range usersResponse {
-		if user.Username == ur.Username {
-			found = true
-		}
-	}
-	if !found {
-		t.Errorf("expected to find user %v in the list of users", ur.Username)
-	}
+	resp = ts.do(t, "GET", "/api/v1/users?username=testapimissinguser", nil)
+	expectStatus(t, resp, http.StatusNotFound)
 }
 
 func TestAPIUpdateUser(t *testing.T) {
-	th := NewTestDataHandler()
-
-	// first we need to create a user, then get it back
+	ts := newTestServer(t)
 	ur := UserRequest{
 		Username:  "testapiupdateuser",
 		Email:     "testapiupdateuser@localhost",
 		FirstName: "TestAPI",
 		LastName:  "UpdateUser",
 	}
-	userReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/users", bytes.NewBuffer([]byte(userReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-	var userResponse UserResponse
-	err = json.NewDecoder(resp.Body).Decode(&userResponse)
-	if err != nil {
-		t.Fatal(err)
-	}
+	userResponse := createTestUser(t, ts, ur)
 
-	u, err := data.GetUserById(th.DB, userResponse.Id)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if u.Username != ur.Username {
-		t.Errorf("expected username %v got %v", ur.Username, u.Username)
-	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
-	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
-	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
-	}
-
-	// now update the user
 	ur2 := UserRequest{
 		Username:  "testapiupdateuser",
 		Email:     "testapiupdateuser2@localhost",
 		FirstName: "TestAPI2",
 		LastName:  "UpdateUser2",
 	}
-	userReq2, err := json.Marshal(ur2)
-	if err != nil {
-		t.Fatal(err)
-	}
-	updateURL := fmt.Sprintf("http://localhost:3333/api/v1/users/%d", userResponse.Id)
-	req, err = http.NewRequest("PUT", updateURL, bytes.NewBuffer([]byte(userReq2)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-	userResponse = UserResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&userResponse)
-	if err != nil {
-		t.Fatal(err)
-	}
-	u, err = data.GetUserById(th.DB, userResponse.Id)
+	resp := ts.do(t, "PUT", fmt.Sprintf("/api/v1/users/%d", userResponse.Id), ur2)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &userResponse)
+
+	u, err := ts.Store.GetUserById(context.Background(), userResponse.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u.Username != ur2.Username {
-		t.Errorf("expected username %v got %v", ur2.Username, u.Username)
-	}
-	if u.Email != ur2.Email {
-		t.Errorf("expected email %v got %v", ur2.Email, u.Email)
-	}
-	if u.FirstName != ur2.FirstName {
-		t.Errorf("expected firstname %v got %v", ur2.FirstName, u.FirstName)
-	}
-	if u.LastName != ur2.LastName {
-		t.Errorf("expected lastname %v got %v", ur2.LastName, u.LastName)
-	}
+	expectUser(t, u, ur2)
+
+	resp = ts.do(t, "PUT", "/api/v1/users/999", ur2)
+	expectStatus(t, resp, http.StatusNotFound)
 }
 
 func TestAPIDeleteUser(t *testing.T) {
-	th := NewTestDataHandler()
-
-	// first we need to create a user, then delete it
+	ts := newTestServer(t)
 	ur := UserRequest{
 		Username:  "testapideleteuser",
 		Email:     "testapideleteuser@localhost",
 		FirstName: "TestAPI",
 		LastName:  "deleteUser",
 	}
-	userReq, err := json.Marshal(ur)
-	if err != nil {
-		t.Fatal(err)
-	}
-	req, err := http.NewRequest("POST", "http://localhost:3333/api/v1/users", bytes.NewBuffer([]byte(userReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusCreated)
-	}
-	var userResponse UserResponse
-	err = json.NewDecoder(resp.Body).Decode(&userResponse)
-	if err != nil {
-		t.Fatal(err)
-	}
+	userResponse := createTestUser(t, ts, ur)
 
-	u, err := data.GetUserById(th.DB, userResponse.Id)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if u.Username != ur.Username {
-		t.Errorf("expected username %v got %v", ur.Username, u.Username)
-	}
-	if u.Email != ur.Email {
-		t.Errorf("expected email %v got %v", ur.Email, u.Email)
-	}
-	if u.FirstName != ur.FirstName {
-		t.Errorf("expected firstname %v got %v", ur.FirstName, u.FirstName)
-	}
-	if u.LastName != ur.LastName {
-		t.Errorf("expected lastname %v got %v", ur.LastName, u.LastName)
-	}
+	resp := ts.do(t, "DELETE", fmt.Sprintf("/api/v1/users/%d", userResponse.Id), nil)
+	expectStatus(t, resp, http.StatusOK)
 
-	// now delete the user
-	deleteURL := fmt.Sprintf("http://localhost:3333/api/v1/users/%d", userResponse.Id)
-	req, err = http.NewRequest("DELETE", deleteURL, bytes.NewBuffer([]byte(userReq)))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusOK)
+	_, err := ts.Store.GetUserById(context.Background(), userResponse.Id)
+	if !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("expected deleted user to be gone, got %v", err)
 	}
+	resp = ts.do(t, "GET", fmt.Sprintf("/api/v1/users/%d", userResponse.Id), nil)
+	expectStatus(t, resp, http.StatusNotFound)
+}
 
-	// now try to get the user again, should get an error
-	req, err = http.NewRequest("GET", "http://localhost:3333/api/v1/users", nil)
-	if err != nil {
+func TestAPIGuests(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	sponsor := createTestUser(t, ts, UserRequest{Username: "testapisponsor", Email: "testapisponsor@localhost", FirstName: "TestAPI", LastName: "Sponsor"})
+	other := createTestUser(t, ts, UserRequest{Username: "testapiother", Email: "testapiother@localhost", FirstName: "TestAPI", LastName: "Other"})
+	if _, err := ts.Store.CreatePirg(ctx, &data.PirgRequest{Name: "testapiguests", OwnerId: sponsor.Id, AdminIds: []int{sponsor.Id}, UserIds: []int{sponsor.Id}}); err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Api-Key", "testkey1")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatal(err)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+	ur := UserRequest{Username: "testapiguest", Email: "testapiguest@localhost", FirstName: "TestAPI", LastName: "Guest", Type: data.UserGuest, SponsorId: &sponsor.Id, ExpiresAt: &expiresAt}
+	guest := createTestUser(t, ts, ur)
+	if guest.Type != data.UserGuest || *guest.SponsorId != sponsor.Id || guest.SponsorUsername != sponsor.Username || !guest.Active {
+		t.Fatalf("unexpected guest %+v", guest)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("handler returned wrong status code: got %v want %v",
-			resp.StatusCode, http.StatusOK)
+
+	// guests need a sponsor who owns a pirg
+	noSponsor := ur
+	noSponsor.Username, noSponsor.SponsorId = "testapinosponsor", nil
+	expectStatus(t, ts.do(t, "POST", "/api/v1/users", noSponsor), http.StatusBadRequest)
+	notAnOwner := ur
+	notAnOwner.Username, notAnOwner.SponsorId = "testapinotanowner", &other.Id
+	expectStatus(t, ts.do(t, "POST", "/api/v1/users", notAnOwner), http.StatusBadRequest)
+
+	later := expiresAt.Add(24 * time.Hour)
+	renewal := GuestRenewalRequest{ExpiresAt: &later}
+	path := fmt.Sprintf("/api/v1/users/%d/renew", guest.Id)
+
+	ts.Role, ts.CallerId = "user", other.Id
+	expectStatus(t, ts.do(t, "POST", path, renewal), http.StatusForbidden)
+
+	ts.CallerId = sponsor.Id
+	resp := ts.do(t, "POST", path, renewal)
+	expectStatus(t, resp, http.StatusOK)
+	decodeResponse(t, resp, &guest)
+	if !guest.ExpiresAt.Equal(later) {
+		t.Errorf("expected the guest to expire at %v, got %v", later, guest.ExpiresAt)
 	}
-	var usersResponse []UserResponse
-	err = json.NewDecoder(resp.Body).Decode(&usersResponse)
+	expectStatus(t, ts.do(t, "POST", path, GuestRenewalRequest{}), http.StatusBadRequest)
+
+	// only guests can be renewed
+	ts.Role, ts.CallerId = "admin", 0
+	expectStatus(t, ts.do(t, "POST", fmt.Sprintf("/api/v1/users/%d/renew", other.Id), renewal), http.StatusBadRequest)
+
+	// guests can't extend themselves or stop being guests by updating
+	// their own user
+	ts.Role, ts.CallerId = "user", guest.Id
+	years := later.AddDate(5, 0, 0)
+	update := ur
+	update.FirstName, update.ExpiresAt = "Renamed", &years
+	expectStatus(t, ts.do(t, "PUT", fmt.Sprintf("/api/v1/users/%d", guest.Id), update), http.StatusOK)
+	update.Type, update.SponsorId, update.ExpiresAt = data.UserInstitutional, nil, nil
+	expectStatus(t, ts.do(t, "PUT", fmt.Sprintf("/api/v1/users/%d", guest.Id), update), http.StatusOK)
+	u, err := ts.Store.GetUserById(ctx, guest.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// make sure the user is not in the list
-	found := false
-	for _, user := range usersResponse {
-		if user.Username == ur.Username {
-			found = true
-		}
-	}
-	if found {
-		t.Error("found user that should have been deleted")
+	if u.FirstName != "Renamed" || u.Type != data.UserGuest || u.SponsorId == nil || *u.SponsorId != sponsor.Id || !u.ExpiresAt.Equal(later) {
+		t.Errorf("expected only the name of the guest to change, got %+v", u)
 	}
 }
@@ -2,11 +2,16 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 
 	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
 )
 
 // APIKeyLoader middleware checks the provided api key against the database
@@ -21,7 +26,7 @@ func (m *Middleware) APIKeyLoader(next http.Handler) http.Handler {
 			return
 		}
 
-		slog.Debug("api key was passed", "package", "auth", "method", "APIKeyLoader")
+		slog.DebugContext(r.Context(), "api key was passed", "package", "auth", "method", "APIKeyLoader")
 
 		// api key was passed
 		ctx := r.Context()
@@ -30,42 +35,71 @@ func (m *Middleware) APIKeyLoader(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, keys.APIKey, apiKey)
 
 		// lets check the cache
-		slog.Debug("checking api key cache", "package", "auth", "method", "APIKeyLoader")
-		cachedRole := ac.LookupCachedAPIKey(apiKey)
+		slog.DebugContext(r.Context(), "checking api key cache", "package", "auth", "method", "APIKeyLoader")
+		cachedRole, cachedUserId, cached := ac.LookupCachedAPIKey(apiKey)
+
+		// the key was looked up recently and wasn't found,
+		// so it's turned away without asking the database
+		if cached && cachedRole == "unknown" {
+			slog.DebugContext(r.Context(), "unknown api key found in cache", "package", "auth", "method", "APIKeyLoader")
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// if the role is not unknown,
 		// that means it's a valid role
-		if cachedRole != "unknown" {
-			slog.Debug("api key and valid role found in cache", "package", "auth", "method", "APIKeyLoader")
+		if cached {
+			slog.DebugContext(r.Context(), "api key and valid role found in cache", "package", "auth", "method", "APIKeyLoader")
 			// api key and valid role was found in cache,
 			// so we'll set the role, cache it, and continue
 			ctx = context.WithValue(ctx, keys.RoleKey, cachedRole)
+			ctx = context.WithValue(ctx, keys.CallerIdKey, cachedUserId)
+			logging.SetCaller(ctx, apiKeyCaller(cachedUserId))
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
 		// not found in cache, so we'll check the database
-		slog.Debug("checking api key database", "package", "auth", "method", "APIKeyLoader")
-		apiKeyEntry, err := data.GetAPIKeyEntry(m.db, apiKey)
-		if err != nil {
-			// error getting api key entry from database
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-		if apiKeyEntry == nil {
-			slog.Debug("api key not found in database", "package", "auth", "method", "APIKeyLoader")
+		slog.DebugContext(r.Context(), "checking api key database", "package", "auth", "method", "APIKeyLoader")
+		apiKeyEntry, err := m.store.GetAPIKeyEntry(ctx, apiKey)
+		if errors.Is(err, data.ErrNotFound) {
+			slog.DebugContext(r.Context(), "api key not found in database", "package", "auth", "method", "APIKeyLoader")
 			// api key wasnt found in the database
 			// cache the unknown key and continue
-			ac.CacheAPIKey(apiKey, "unknown")
+			ac.CacheAPIKey(apiKey, "unknown", 0)
 			next.ServeHTTP(w, r)
 			return
 		}
+		if err != nil {
+			// error getting api key entry from database
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
 
 		// api key found in database, cache it and continue
-		slog.Debug("api key found in database", "package", "auth", "method", "APIKeyLoader")
-		slog.Debug("caching api key", "package", "auth", "method", "APIKeyLoader")
-		ac.CacheAPIKey(apiKey, apiKeyEntry.Role)
+		slog.DebugContext(r.Context(), "api key found in database", "package", "auth", "method", "APIKeyLoader")
+		slog.DebugContext(r.Context(), "caching api key", "package", "auth", "method", "APIKeyLoader")
+		ac.CacheAPIKey(apiKey, apiKeyEntry.Role, apiKeyEntry.UserId)
 		ctx = context.WithValue(ctx, keys.RoleKey, apiKeyEntry.Role)
+		ctx = context.WithValue(ctx, keys.CallerIdKey, apiKeyEntry.UserId)
+		logging.SetCaller(ctx, apiKeyCaller(apiKeyEntry.UserId))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// apiKeyCaller identifies requests made with an api key by the key's user
+func apiKeyCaller(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// apiKeyBytes is how much randomness goes into a generated api key
+const apiKeyBytes = 32
+
+// NewAPIKey generates a random api key
+func NewAPIKey() (string, error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// clock is a fake time source that only moves when told to
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// useTestCache swaps the package cache for one on a fake clock
+func useTestCache(t *testing.T) *clock {
+	t.Helper()
+	c := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	prev := ac
+	ac = NewAuthCache()
+	ac.now = c.now
+	t.Cleanup(func() { ac = prev })
+	return c
+}
+
+// countingStore counts the api key lookups that reach the store
+type countingStore struct {
+	data.APIKeyStore
+	lookups int
+}
+
+func (s *countingStore) GetAPIKeyEntry(ctx context.Context, key string) (*data.APIKeyEntry, error) {
+	s.lookups++
+	return s.APIKeyStore.GetAPIKeyEntry(ctx, key)
+}
+
+// serveWithKey runs a request with the api key through the loader and the
+// role verifier and returns the status
+func serveWithKey(mw *Middleware, key string) int {
+	h := mw.APIKeyLoader(mw.RoleVerifier(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	req := httptest.NewRequest("GET", "/api/v1/users", nil)
+	req.Header.Set("X-API-Key", key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAPIKeyLoaderUnknownKeys(t *testing.T) {
+	c := useTestCache(t)
+	store := &countingStore{APIKeyStore: data.NewMemoryStore()}
+	mw := NewMiddleware(store)
+
+	for i := 0; i < 3; i++ {
+		if code := serveWithKey(mw, "badkey"); code != http.StatusUnauthorized {
+			t.Fatalf("expected an unknown key to be refused, got %d", code)
+		}
+	}
+	if store.lookups != 1 {
+		t.Fatalf("expected the unknown key to be looked up once, got %d", store.lookups)
+	}
+	c.advance(FailedAPIKeyTTL)
+	serveWithKey(mw, "badkey")
+	if store.lookups != 2 {
+		t.Fatalf("expected the unknown key to be looked up again once it expired, got %d", store.lookups)
+	}
+}
+
+func TestAuthCacheFailedKeyLimit(t *testing.T) {
+	c := useTestCache(t)
+	for i := 0; i < MaxFailedAPIKeys; i++ {
+		ac.CacheAPIKey(fmt.Sprintf("badkey%d", i), "unknown", 0)
+	}
+	ac.CacheAPIKey("onetoomany", "unknown", 0)
+	if _, _, ok := ac.LookupCachedAPIKey("onetoomany"); ok {
+		t.Error("expected unknown keys past the limit not to be cached")
+	}
+	// valid keys are cached regardless
+	ac.CacheAPIKey("goodkey", "admin", 1)
+	if role, _, ok := ac.LookupCachedAPIKey("goodkey"); !ok || role != "admin" {
+		t.Errorf("expected the valid key to be cached, got %q %v", role, ok)
+	}
+
+	// expired entries make room
+	c.advance(FailedAPIKeyTTL)
+	ac.CacheAPIKey("onetoomany", "unknown", 0)
+	if _, _, ok := ac.LookupCachedAPIKey("onetoomany"); !ok {
+		t.Error("expected the unknown key to be cached once the others expired")
+	}
+	if len(ac.APITokenCache) != 1 || ac.failedKeys != 1 {
+		t.Errorf("expected the expired keys to be dropped, got %d entries and %d failed", len(ac.APITokenCache), ac.failedKeys)
+	}
+}
+
+func TestAPIKeyLoaderRevokedKeys(t *testing.T) {
+	c := useTestCache(t)
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	user, err := store.CreateUser(ctx, &data.UserRequest{Username: "marka", Email: "marka@example.org", FirstName: "Mark", LastName: "Allen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateAPIKey(ctx, &data.APIKeyRequest{Key: "goodkey", Role: "user", UserId: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	mw := NewMiddleware(store)
+	if code := serveWithKey(mw, "goodkey"); code != http.StatusOK {
+		t.Fatalf("expected the key to be accepted, got %d", code)
+	}
+	if err := store.DeleteAPIKey(ctx, "goodkey"); err != nil {
+		t.Fatal(err)
+	}
+	c.advance(APIKeyTTL)
+	if code := serveWithKey(mw, "goodkey"); code != http.StatusUnauthorized {
+		t.Fatalf("expected the revoked key to be refused once the cache expired, got %d", code)
+	}
+}
+
+func TestAPIKeyLoaderDeactivatedGuests(t *testing.T) {
+	c := useTestCache(t)
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	sponsor, err := store.CreateUser(ctx, &data.UserRequest{Username: "marka", Email: "marka@example.org", FirstName: "Mark", LastName: "Allen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "racs", OwnerId: sponsor.Id, AdminIds: []int{sponsor.Id}, UserIds: []int{sponsor.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	guest, err := store.CreateUser(ctx, &data.UserRequest{Username: "guest", Email: "guest@example.org", FirstName: "Some", LastName: "Guest", Type: data.UserGuest, SponsorId: &sponsor.Id, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateAPIKey(ctx, &data.APIKeyRequest{Key: "guestkey", Role: "user", UserId: guest.Id}); err != nil {
+		t.Fatal(err)
+	}
+	mw := NewMiddleware(store)
+	if code := serveWithKey(mw, "guestkey"); code != http.StatusOK {
+		t.Fatalf("expected the guest's key to be accepted, got %d", code)
+	}
+	if _, err := store.DeactivateGuests(ctx, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	c.advance(APIKeyTTL)
+	if code := serveWithKey(mw, "guestkey"); code != http.StatusUnauthorized {
+		t.Fatalf("expected the cached key of a deactivated guest to be refused, got %d", code)
+	}
+}
@@ -2,17 +2,37 @@ package auth
 
 import (
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/lcrownover/hpcadmin-lib/pkg/oauth"
+	"github.com/lcrownover/hpcadmin-server/internal/metrics"
+)
+
+const (
+	// APIKeyTTL is how long a valid key is trusted before it's looked up
+	// again, so a key that was revoked stops working within it
+	APIKeyTTL = time.Minute
+	// FailedAPIKeyTTL is how long a key that wasn't found is turned away
+	// without asking the database again
+	FailedAPIKeyTTL = time.Minute
+	// MaxFailedAPIKeys bounds how many keys that weren't found are
+	// remembered. Past it, unknown keys are looked up every time.
+	MaxFailedAPIKeys = 10000
 )
 
 // AuthCache is the cache for the auth service
 // It caches the user's token and the user's data
+// It's shared by every request, so the maps are only used under mu
 
 type AuthCache struct {
+	mu            sync.RWMutex
 	JWTTokenCache map[string]TokenCache
 	APITokenCache map[string]APIKeyCache
+	// failedKeys counts the entries of APITokenCache for unknown keys
+	failedKeys int
+	now        func() time.Time
 }
 
 type TokenCache struct {
@@ -23,14 +43,22 @@ type TokenCache struct {
 }
 
 type APIKeyCache struct {
-	Key  string
-	Role string
+	Key    string
+	Role   string
+	UserId int
+	// ExpiresAt is when the entry has to be looked up again, never if zero
+	ExpiresAt time.Time
+}
+
+func (c APIKeyCache) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
 }
 
 func NewAuthCache() *AuthCache {
 	return &AuthCache{
 		JWTTokenCache: make(map[string]TokenCache),
 		APITokenCache: make(map[string]APIKeyCache),
+		now:           time.Now,
 	}
 }
 
@@ -69,19 +97,26 @@ func (a *AuthCache) TokenIsValid(token string) (*jwt.Token, bool, error) {
 // LookupCachedToken checks if the token is in the cache and returns it if it is
 func (a *AuthCache) LookupCachedToken(token string) (*jwt.Token, bool, error) {
 	slog.Debug("checking if token is in cache", "package", "auth", "method", "TokenIsValid")
-	if cache, ok := a.JWTTokenCache[token]; ok {
+	a.mu.RLock()
+	cache, ok := a.JWTTokenCache[token]
+	a.mu.RUnlock()
+	if ok {
 		slog.Debug("token is in cache", "package", "auth", "method", "TokenIsValid")
 		if cache.JWTToken.Valid && cache.ValidUntil > jwt.TimeFunc().Unix() {
 			slog.Debug("token is valid and not expired", "package", "auth", "method", "TokenIsValid")
-			return a.JWTTokenCache[token].JWTToken, true, nil
+			metrics.AuthCacheLookups.WithLabelValues("jwt", "hit").Inc()
+			return cache.JWTToken, true, nil
 		}
 	}
+	metrics.AuthCacheLookups.WithLabelValues("jwt", "miss").Inc()
 	return nil, false, nil
 }
 
 // CacheJWTToken adds the token to the cache
 func (a *AuthCache) CacheJWTToken(token string, jwtToken *jwt.Token) {
 	slog.Debug("adding to cache", "package", "auth", "method", "TokenIsValid")
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.JWTTokenCache[token] = TokenCache{
 		TokenString: token,
 		ValidUntil:  int64(jwtToken.Claims.(jwt.MapClaims)["exp"].(float64)),
@@ -89,24 +124,83 @@ func (a *AuthCache) CacheJWTToken(token string, jwtToken *jwt.Token) {
 	}
 }
 
-// LookupCachedAPIKey checks if the api key is in the cache 
-// returns the role if found, "unknown" if not found
-func (a *AuthCache) LookupCachedAPIKey(key string) string {
+// LookupCachedAPIKey checks if the api key is in the cache and returns
+// the role and user id if found. Keys that were looked up and not found are
+// cached too, with the role "unknown".
+func (a *AuthCache) LookupCachedAPIKey(key string) (string, int, bool) {
 	slog.Debug("checking if api key is in cache", "package", "auth", "method", "LookupCachedAPIKey")
-	if cache, ok := a.APITokenCache[key]; ok {
+	a.mu.RLock()
+	cache, ok := a.APITokenCache[key]
+	a.mu.RUnlock()
+	if ok && !cache.expired(a.now()) {
 		slog.Debug("api key is in cache", "package", "auth", "method", "LookupCachedAPIKey")
-		return cache.Role
+		metrics.AuthCacheLookups.WithLabelValues("api_key", "hit").Inc()
+		return cache.Role, cache.UserId, true
 	}
 	slog.Debug("api key not found in cache", "package", "auth", "method", "LookupCachedAPIKey")
-	return "unknown"
+	metrics.AuthCacheLookups.WithLabelValues("api_key", "miss").Inc()
+	return "unknown", 0, false
 }
 
-// CacheAPIKey adds the api key to the cache
-func (a *AuthCache) CacheAPIKey(key string, role string) {
+// CacheAPIKey adds the api key to the cache. Valid keys expire after
+// APIKeyTTL. Unknown keys expire after FailedAPIKeyTTL and aren't cached
+// once there are MaxFailedAPIKeys of them.
+func (a *AuthCache) CacheAPIKey(key string, role string, userId int) {
 	slog.Debug("adding api key to cache", "role", role, "package", "auth", "method", "CacheAPIKey")
-	a.APITokenCache[key] = APIKeyCache{
-		Key:  key,
-		Role: role,
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	entry := APIKeyCache{
+		Key:       key,
+		Role:      role,
+		UserId:    userId,
+		ExpiresAt: now.Add(APIKeyTTL),
 	}
+	if role == "unknown" {
+		if a.failedKeys >= MaxFailedAPIKeys {
+			a.prune(now)
+		}
+		if a.failedKeys >= MaxFailedAPIKeys {
+			slog.Debug("too many unknown api keys cached, not caching", "package", "auth", "method", "CacheAPIKey")
+			return
+		}
+		entry.ExpiresAt = now.Add(FailedAPIKeyTTL)
+	}
+	a.remove(key)
+	if role == "unknown" {
+		a.failedKeys++
+	}
+	a.APITokenCache[key] = entry
 	slog.Debug("cached api key", "package", "auth", "method", "CacheAPIKey")
 }
+
+// remove drops the api key from the cache, a.mu has to be held
+func (a *AuthCache) remove(key string) {
+	if cache, ok := a.APITokenCache[key]; ok {
+		if cache.Role == "unknown" {
+			a.failedKeys--
+		}
+		delete(a.APITokenCache, key)
+	}
+}
+
+// prune drops expired api keys and tokens, a.mu has to be held
+func (a *AuthCache) prune(now time.Time) {
+	for key, cache := range a.APITokenCache {
+		if cache.expired(now) {
+			a.remove(key)
+		}
+	}
+	for token, cache := range a.JWTTokenCache {
+		if cache.ValidUntil <= now.Unix() {
+			delete(a.JWTTokenCache, token)
+		}
+	}
+}
+
+// Prune drops expired api keys and tokens from the cache
+func (a *AuthCache) Prune(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(now)
+}
@@ -1,18 +1,25 @@
 package auth
 
 import (
-	"database/sql"
 	"net/http"
+	"time"
 
+	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
 )
 
 type Middleware struct {
-	db *sql.DB
+	store data.APIKeyStore
 }
 
-func NewMiddleware(db *sql.DB) *Middleware {
-	return &Middleware{db: db}
+func NewMiddleware(store data.APIKeyStore) *Middleware {
+	return &Middleware{store: store}
+}
+
+// Prune drops expired entries from the auth cache, so the middleware can be
+// run with the rate limit pruners
+func (m *Middleware) Prune(now time.Time) {
+	ac.Prune(now)
 }
 
 // AdminOnly middleware restricts access to just administrators.
@@ -2,7 +2,6 @@ package auth
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -10,10 +9,13 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt"
 	"github.com/lcrownover/hpcadmin-lib/pkg/oauth"
 	"github.com/lcrownover/hpcadmin-server/internal/api"
 	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
 	"github.com/lcrownover/hpcadmin-server/internal/keys"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/microsoft"
 )
@@ -24,8 +26,28 @@ func init() {
 	ac = NewAuthCache()
 }
 
+// tokenCaller identifies requests made with a bearer token by the first
+// identifying claim in it
+func tokenCaller(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "token"
+	}
+	for _, claim := range []string{"preferred_username", "upn", "oid"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			return "token:" + v
+		}
+	}
+	return "token"
+}
+
+// OIDCMetadataURL returns the openid configuration document for the azure ad tenant
+func OIDCMetadataURL(tenantID string) string {
+	return fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0/.well-known/openid-configuration", tenantID)
+}
+
 type OauthHandler struct {
-	dbConn       *sql.DB
+	store        data.Store
 	oauth2Config *oauth2.Config
 	tokenCh      chan string
 	tokenTimeout time.Duration
@@ -34,12 +56,12 @@ type OauthHandler struct {
 }
 
 func newOauthHandler(ctx context.Context) *OauthHandler {
-	dbConn := ctx.Value(keys.DBConnKey).(*sql.DB)
+	store := ctx.Value(keys.StoreKey).(data.Store)
 	tenantID := ctx.Value(keys.ConfigKey).(*config.ServerConfig).Oauth.TenantID
 	clientID := ctx.Value(keys.ConfigKey).(*config.ServerConfig).Oauth.ClientID
 	clientSecret := ctx.Value(keys.ConfigKey).(*config.ServerConfig).Oauth.ClientSecret
 
-	var redirectURL = fmt.Sprintf("http://%s/oauth/callback", ctx.Value(keys.ListenAddrKey).(string))
+	var redirectURL = ctx.Value(keys.ConfigKey).(*config.ServerConfig).BaseURL() + "/oauth/callback"
 	var oauth2Config = &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
@@ -48,7 +70,7 @@ func newOauthHandler(ctx context.Context) *OauthHandler {
 		Scopes:       []string{"openid", "profile", "offline_access"},
 	}
 	return &OauthHandler{
-		dbConn:       dbConn,
+		store:        store,
 		oauth2Config: oauth2Config,
 		tokenCh:      make(chan string, 1),
 		tokenTimeout: 5 * time.Minute,
@@ -106,7 +128,7 @@ func (m *Middleware) OauthLoader(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		slog.Debug("bearer token was passed", "package", "auth", "method", "OauthLoader")
+		slog.DebugContext(r.Context(), "bearer token was passed", "package", "auth", "method", "OauthLoader")
 		// authorization header is set, validate header value
 		if len(bearerString) < len("Bearer ") {
 			// bearer string doesn't contain "Bearer "
@@ -114,16 +136,17 @@ func (m *Middleware) OauthLoader(next http.Handler) http.Handler {
 			return
 		}
 		tokenString := bearerString[len("Bearer "):]
-		slog.Debug("validating token", "package", "auth", "method", "OauthLoader")
+		slog.DebugContext(r.Context(), "validating token", "package", "auth", "method", "OauthLoader")
 		jwtToken, isValid, err := ac.TokenIsValid(tokenString)
 		if err != nil || !isValid {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
 		ctx := context.WithValue(r.Context(), keys.JWTTokenKey, jwtToken)
-		slog.Debug("getting role from token", "package", "auth", "method", "OauthLoader")
+		slog.DebugContext(r.Context(), "getting role from token", "package", "auth", "method", "OauthLoader")
 		role := oauth.GetJWTRoleFromToken(jwtToken)
 		ctx = context.WithValue(ctx, keys.RoleKey, role)
+		logging.SetCaller(ctx, tokenCaller(jwtToken))
 		if role != "admin" {
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
@@ -1,35 +1,227 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
-	"strconv"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ServerConfig struct {
-	Host  string         `yaml:"host"`
-	Port  int            `yaml:"port"`
-	Oauth OauthConfig    `yaml:"oauth"`
-	DB    DatabaseConfig `yaml:"database"`
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// ExternalURL is the address clients use to reach the server, such as
+	// https://hpcadmin.example.edu, used to build the oauth redirect
+	ExternalURL   string              `yaml:"external_url"`
+	TLS           TLSConfig           `yaml:"tls"`
+	HTTP          HTTPConfig          `yaml:"http"`
+	Logging       LoggingConfig       `yaml:"logging"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
+	Oauth         OauthConfig         `yaml:"oauth"`
+	DB            DatabaseConfig      `yaml:"database"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Unix          UnixConfig          `yaml:"unix"`
+	LDAP          LDAPConfig          `yaml:"ldap"`
 }
 
+// TLSConfig enables native TLS when a certificate and key are set
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// MinVersion is "1.2" or "1.3", defaulting to 1.2
+	MinVersion string `yaml:"min_version"`
+	// ClientCAFile requires clients to present a certificate signed by one of these CAs
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ReloadInterval is how often the certificate files are checked for changes.
+	// They are also reloaded on SIGHUP.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// validTLSVersions are the supported values for tls.min_version
+var validTLSVersions = []string{"1.2", "1.3"}
+
+type LoggingConfig struct {
+	// Format is "text" or "json", defaulting to text
+	Format string `yaml:"format"`
+	// Level is debug, info, warn or error, defaulting to info
+	Level string `yaml:"level"`
+}
+
+// TracingConfig configures where opentelemetry spans are sent.
+// Tracing is off when Exporter is empty.
+type TracingConfig struct {
+	// Exporter is "otlp" or "stdout"
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the otlp http collector host:port, defaulting to localhost:4318
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// RateLimitConfig configures request rate limits and the lockout of
+// clients that keep failing authentication
+type RateLimitConfig struct {
+	// TrustProxyHeaders takes the client ip from X-Forwarded-For or
+	// X-Real-IP, only enable it behind a reverse proxy that sets them
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers"`
+	// Groups sets the limit for each route group: public, api and admin.
+	// auth limits each client ip before its api key or token is checked,
+	// so bad keys can't flood the database. Groups without a limit aren't
+	// rate limited.
+	Groups  map[string]RateConfig `yaml:"groups"`
+	Lockout LockoutConfig         `yaml:"lockout"`
+}
+
+// RateConfig is a token bucket refilled at Rate requests per second
+// holding up to Burst requests
+type RateConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// LockoutConfig locks out a client ip for Duration after MaxFailures
+// failed authentications within Window. Lockout is off when MaxFailures is 0.
+type LockoutConfig struct {
+	MaxFailures int           `yaml:"max_failures"`
+	Window      time.Duration `yaml:"window"`
+	Duration    time.Duration `yaml:"duration"`
+}
+
+// RateLimitGroups are the route groups that can be rate limited
+var RateLimitGroups = []string{"public", "api", "admin", "auth"}
+
+var validTracingExporters = []string{"otlp", "stdout"}
+
+var validLogFormats = []string{"text", "json"}
+var validLogLevels = []string{"debug", "info", "warn", "error"}
+
+// HTTPConfig holds the limits for the http server.
+// Zero values are replaced with defaults by the server package.
+type HTTPConfig struct {
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests get to finish
+	// after a shutdown signal before their connections are closed
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	MaxHeaderBytes  int           `yaml:"max_header_bytes"`
+}
+
+// NotificationsConfig configures the email sent when something happens to
+// a user's account. Nothing is sent unless an smtp host is set.
+type NotificationsConfig struct {
+	// From is the sender address, such as "HPC Admin <hpc@example.edu>"
+	From string     `yaml:"from"`
+	SMTP SMTPConfig `yaml:"smtp"`
+	// TemplateDir holds templates replacing the built in ones, named after
+	// their event, such as pirg_member_added.tmpl
+	TemplateDir string `yaml:"template_dir"`
+	// PollInterval is how often the outbox is checked for mail to send
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// MaxAttempts is how many times sending a message is tried before giving up
+	MaxAttempts int `yaml:"max_attempts"`
+	// ExpiringDays is how long before a membership expires the member is told
+	ExpiringDays int `yaml:"expiring_days"`
+}
+
+func (n NotificationsConfig) Enabled() bool {
+	return n.SMTP.Host != ""
+}
+
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	// Port defaults to 25
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password" secret:"true"`
+}
+
+// UnixConfig shapes the passwd and group exports. Unset values use the
+// defaults in the export package.
+type UnixConfig struct {
+	// UIDMin and UIDMax bound the uids given to users that don't have one
+	UIDMin int `yaml:"uid_min"`
+	UIDMax int `yaml:"uid_max"`
+	// GIDMin and GIDMax bound the gids given to pirgs and their groups
+	GIDMin int `yaml:"gid_min"`
+	GIDMax int `yaml:"gid_max"`
+	// PrimaryGID is the primary group of every user
+	PrimaryGID int    `yaml:"primary_gid"`
+	Shell      string `yaml:"shell"`
+	// HomeTemplate is a text/template for home directories given the user,
+	// such as /home/{{.Username}}
+	HomeTemplate string `yaml:"home_template"`
+}
+
+// LDAPConfig shapes the LDIF export. The accounts and groups in it use the
+// ids, shell and home directories of the unix exports.
+type LDAPConfig struct {
+	// BaseDN is the suffix of every entry, such as dc=example,dc=edu. The
+	// LDIF export is off until it's set.
+	BaseDN string `yaml:"base_dn"`
+	// PeopleOU and GroupsOU hold the users and the groups below the
+	// BaseDN, defaulting to ou=people and ou=groups
+	PeopleOU string `yaml:"people_ou"`
+	GroupsOU string `yaml:"groups_ou"`
+	// Attributes renames the attributes that are written, keyed by their
+	// default name, such as mail: campusMail
+	Attributes map[string]string `yaml:"attributes"`
+	// SnapshotRetention is how far back changes can be asked for,
+	// defaulting to 30 days
+	SnapshotRetention time.Duration `yaml:"snapshot_retention"`
+}
+
+func (l LDAPConfig) Enabled() bool {
+	return l.BaseDN != ""
+}
+
+// LDAPAttributes are the attributes of the LDIF export that can be renamed
+var LDAPAttributes = []string{"uid", "cn", "sn", "givenName", "displayName", "mail", "uidNumber", "gidNumber", "homeDirectory", "loginShell", "memberUid", "member"}
+
+var ldapAttributeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
 type OauthConfig struct {
 	TenantID     string `yaml:"tenant_id"`
 	ClientID     string `yaml:"client_id"`
-	ClientSecret string `yaml:"client_secret"`
+	ClientSecret string `yaml:"client_secret" secret:"true"`
 }
 
 type DatabaseConfig struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	DBName   string `yaml:"dbname"`
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password" secret:"true"`
+	DBName          string        `yaml:"dbname"`
+	SSLMode         string        `yaml:"sslmode"`
+	SSLRootCert     string        `yaml:"sslrootcert"`
+	SSLCert         string        `yaml:"sslcert"`
+	SSLKey          string        `yaml:"sslkey"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+	// DSN is a full connection string that overrides the fields above
+	DSN string `yaml:"dsn" secret:"true"`
 }
 
+// validSSLModes are the sslmode values supported by the postgres driver
+var validSSLModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
 // Load loads the configuration from the given path
 // If the path is empty, it will load the default configuration
 // file from /etc/hpcadmin-server/config.yaml
@@ -62,100 +254,239 @@ func LoadFile(configPath string) (*ServerConfig, error) {
 	return cfg, nil
 }
 
-func LoadEnvironment(cfg *ServerConfig) *ServerConfig {
-	// HPCADMIN_SERVER_HOST
-	if host, found := os.LookupEnv("HPCADMIN_SERVER_HOST"); found {
-		slog.Debug("found host override", "package", "config", "method", "LoadEnvironment", "host", host)
-		cfg.Host = host
-	}
-	// HPCADMIN_SERVER_PORT
-	if port, found := os.LookupEnv("HPCADMIN_SERVER_PORT"); found {
-		slog.Debug("found port override", "package", "config", "method", "LoadEnvironment", "port", port)
-		iport, err := strconv.Atoi(port)
-		if err != nil {
-			slog.Warn("Invalid port number", "package", "config", "method", "LoadEnvironment", "port", port)
-		} else {
-			cfg.Port = iport
+// BaseURL returns the external url of the server without a trailing slash,
+// falling back to the listen address when external_url isn't set
+func (cfg *ServerConfig) BaseURL() string {
+	if cfg.ExternalURL != "" {
+		return strings.TrimRight(cfg.ExternalURL, "/")
+	}
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, cfg.Host, cfg.Port)
+}
+
+// Validate checks the whole configuration, returning every problem
+// found joined into one error
+func Validate(cfg *ServerConfig) error {
+	var errs []error
+	if cfg.Host == "" {
+		errs = append(errs, fmt.Errorf("missing host"))
+	}
+	if cfg.Port == 0 {
+		errs = append(errs, fmt.Errorf("missing port"))
+	}
+	errs = append(errs, validateTLS(&cfg.TLS))
+	if cfg.ExternalURL != "" {
+		u, err := url.Parse(cfg.ExternalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("external_url must be an absolute http or https url: %q", cfg.ExternalURL))
 		}
 	}
-	// HPCADMIN_SERVER_DATABASE_HOST
-	if dbhost, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_HOST"); found {
-		slog.Debug("found database host override", "package", "config", "method", "LoadEnvironment", "host", dbhost)
-		cfg.DB.Host = dbhost
-	}
-	// HPCADMIN_SERVER_DATABASE_PORT
-	if dbport, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_PORT"); found {
-		slog.Debug("found database port override", "package", "config", "method", "LoadEnvironment", "port", dbport)
-		idbport, err := strconv.Atoi(dbport)
-		if err != nil {
-			slog.Warn("Invalid database port number", "package", "config", "method", "LoadEnvironment", "port", dbport)
-		} else {
-			cfg.DB.Port = idbport
+	errs = append(errs, validateHTTP(&cfg.HTTP))
+	if cfg.Tracing.Exporter != "" && !slices.Contains(validTracingExporters, cfg.Tracing.Exporter) {
+		errs = append(errs, fmt.Errorf("invalid tracing exporter %q, must be one of %v", cfg.Tracing.Exporter, validTracingExporters))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing sample_ratio must be between 0 and 1"))
+	}
+	errs = append(errs, validateRateLimit(&cfg.RateLimit))
+	errs = append(errs, ValidateDatabase(cfg))
+	errs = append(errs, validateNotifications(&cfg.Notifications))
+	errs = append(errs, validateUnix(&cfg.Unix))
+	errs = append(errs, validateLDAP(&cfg.LDAP))
+	if cfg.Oauth.TenantID == "" {
+		errs = append(errs, fmt.Errorf("missing oauth tenant ID"))
+	}
+	if cfg.Oauth.ClientID == "" {
+		errs = append(errs, fmt.Errorf("missing oauth client ID"))
+	}
+	if cfg.Oauth.ClientSecret == "" {
+		errs = append(errs, fmt.Errorf("missing oauth client secret"))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateDatabase checks only the logging and database settings, for
+// the commands that work with the database directly and never serve
+// requests
+func ValidateDatabase(cfg *ServerConfig) error {
+	var errs []error
+	errs = append(errs, validateLogging(&cfg.Logging))
+	errs = append(errs, validateDatabase(&cfg.DB))
+	return errors.Join(errs...)
+}
+
+// ValidateExport checks the settings the export commands use, the
+// database ones plus the unix and ldap sections
+func ValidateExport(cfg *ServerConfig) error {
+	var errs []error
+	errs = append(errs, ValidateDatabase(cfg))
+	errs = append(errs, validateUnix(&cfg.Unix))
+	errs = append(errs, validateLDAP(&cfg.LDAP))
+	return errors.Join(errs...)
+}
+
+func validateLogging(l *LoggingConfig) error {
+	var errs []error
+	if l.Format != "" && !slices.Contains(validLogFormats, l.Format) {
+		errs = append(errs, fmt.Errorf("invalid logging format %q, must be one of %v", l.Format, validLogFormats))
+	}
+	if l.Level != "" && !slices.Contains(validLogLevels, strings.ToLower(l.Level)) {
+		errs = append(errs, fmt.Errorf("invalid logging level %q, must be one of %v", l.Level, validLogLevels))
+	}
+	return errors.Join(errs...)
+}
+
+func validateTLS(t *TLSConfig) error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls cert_file and key_file must be set together"))
+	}
+	if !t.Enabled() && t.ClientCAFile != "" {
+		errs = append(errs, fmt.Errorf("tls client_ca_file requires cert_file and key_file"))
+	}
+	if t.MinVersion != "" && !slices.Contains(validTLSVersions, t.MinVersion) {
+		errs = append(errs, fmt.Errorf("invalid tls min_version %q, must be one of %v", t.MinVersion, validTLSVersions))
+	}
+	if t.ReloadInterval < 0 {
+		errs = append(errs, fmt.Errorf("tls reload_interval must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func validateHTTP(h *HTTPConfig) error {
+	var errs []error
+	if h.ReadTimeout < 0 || h.ReadHeaderTimeout < 0 || h.WriteTimeout < 0 || h.IdleTimeout < 0 || h.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("http timeouts must not be negative"))
+	}
+	if h.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("http max_header_bytes must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func validateRateLimit(rl *RateLimitConfig) error {
+	var errs []error
+	for group, rc := range rl.Groups {
+		if !slices.Contains(RateLimitGroups, group) {
+			errs = append(errs, fmt.Errorf("invalid rate_limit group %q, must be one of %v", group, RateLimitGroups))
+		}
+		if rc.Rate < 0 || rc.Burst < 0 {
+			errs = append(errs, fmt.Errorf("rate_limit group %s rate and burst must not be negative", group))
+		}
+		if rc.Rate > 0 && rc.Burst == 0 {
+			errs = append(errs, fmt.Errorf("rate_limit group %s needs a burst of at least 1", group))
 		}
 	}
-	// HPCADMIN_SERVER_DATABASE_USER
-	if dbuser, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_USER"); found {
-		slog.Debug("found database user override", "package", "config", "method", "LoadEnvironment", "user", dbuser)
-		cfg.DB.User = dbuser
+	l := rl.Lockout
+	if l.MaxFailures < 0 || l.Window < 0 || l.Duration < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit lockout settings must not be negative"))
 	}
-	// HPCADMIN_SERVER_DATABASE_PASSWORD
-	if dbpassword, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_USER"); found {
-		slog.Debug("found database user override", "package", "config", "method", "LoadEnvironment", "password", "REDACTED")
-		cfg.DB.Password = dbpassword
+	if l.MaxFailures > 0 && (l.Window == 0 || l.Duration == 0) {
+		errs = append(errs, fmt.Errorf("rate_limit lockout needs a window and duration when max_failures is set"))
 	}
-	// HPCADMIN_SERVER_DATABASE_DBNAME
-	if dbname, found := os.LookupEnv("HPCADMIN_SERVER_DATABASE_DBNAME"); found {
-		slog.Debug("found database user override", "package", "config", "method", "LoadEnvironment", "dbname", dbname)
-		cfg.DB.DBName = dbname
+	return errors.Join(errs...)
+}
+
+func validateDatabase(db *DatabaseConfig) error {
+	var errs []error
+	// a full DSN carries its own connection settings
+	if db.DSN == "" {
+		if db.Host == "" {
+			errs = append(errs, fmt.Errorf("missing database host"))
+		}
+		if db.Port == 0 {
+			errs = append(errs, fmt.Errorf("missing database port"))
+		}
+		if db.User == "" {
+			errs = append(errs, fmt.Errorf("missing database user"))
+		}
+		if db.Password == "" {
+			errs = append(errs, fmt.Errorf("missing database password"))
+		}
+		if db.DBName == "" {
+			errs = append(errs, fmt.Errorf("missing database name"))
+		}
+		if db.SSLMode != "" && !slices.Contains(validSSLModes, db.SSLMode) {
+			errs = append(errs, fmt.Errorf("invalid database sslmode %q, must be one of %v", db.SSLMode, validSSLModes))
+		}
+		if (db.SSLCert == "") != (db.SSLKey == "") {
+			errs = append(errs, fmt.Errorf("database sslcert and sslkey must be set together"))
+		}
 	}
-	// HPCADMIN_SERVER_OAUTH_TENANT_ID
-	if tenantID, found := os.LookupEnv("HPCADMIN_SERVER_OAUTH_TENANT_ID"); found {
-		slog.Debug("found oauth tenantID override", "package", "config", "method", "LoadEnvironment", "tenantID", tenantID)
-		cfg.Oauth.TenantID = tenantID
+	if db.MaxOpenConns < 0 || db.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("database connection pool sizes must not be negative"))
 	}
-	// HPCADMIN_SERVER_OAUTH_CLIENT_ID
-	if clientID, found := os.LookupEnv("HPCADMIN_SERVER_OAUTH_CLIENT_ID"); found {
-		slog.Debug("found oauth clientID override", "package", "config", "method", "LoadEnvironment", "clientID", clientID)
-		cfg.Oauth.ClientID = clientID
+	if db.MaxOpenConns > 0 && db.MaxIdleConns > db.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("database max_idle_conns (%d) must not exceed max_open_conns (%d)", db.MaxIdleConns, db.MaxOpenConns))
 	}
-	// HPCADMIN_SERVER_OAUTH_CLIENT_SECRET
-	if clientSecret, found := os.LookupEnv("HPCADMIN_SERVER_OAUTH_CLIENT_SECRET"); found {
-		slog.Debug("found oauth clientSecret override", "package", "config", "method", "LoadEnvironment", "clientSecret", "REDACTED")
-		cfg.Oauth.ClientSecret = clientSecret
+	if db.ConnMaxLifetime < 0 || db.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("database durations must not be negative"))
 	}
-	return cfg
+	return errors.Join(errs...)
 }
 
-func Validate(cfg *ServerConfig) error {
-	if cfg.Host == "" {
-		return fmt.Errorf("missing host")
+func validateNotifications(n *NotificationsConfig) error {
+	var errs []error
+	if n.Enabled() && n.From == "" {
+		errs = append(errs, fmt.Errorf("notifications need a from address when an smtp host is set"))
 	}
-	if cfg.Port == 0 {
-		return fmt.Errorf("missing port")
+	if n.SMTP.Port < 0 || n.SMTP.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid notifications smtp port %d", n.SMTP.Port))
 	}
-	if cfg.DB.Host == "" {
-		return fmt.Errorf("missing database host")
+	if (n.SMTP.Username == "") != (n.SMTP.Password == "") {
+		errs = append(errs, fmt.Errorf("notifications smtp username and password must be set together"))
 	}
-	if cfg.DB.Port == 0 {
-		return fmt.Errorf("missing database port")
+	if n.PollInterval < 0 || n.MaxAttempts < 0 || n.ExpiringDays < 0 {
+		errs = append(errs, fmt.Errorf("notifications settings must not be negative"))
 	}
-	if cfg.DB.User == "" {
-		return fmt.Errorf("missing database user")
+	return errors.Join(errs...)
+}
+
+func validateUnix(u *UnixConfig) error {
+	var errs []error
+	if u.UIDMin < 0 || u.UIDMax < 0 || u.GIDMin < 0 || u.GIDMax < 0 || u.PrimaryGID < 0 {
+		errs = append(errs, fmt.Errorf("unix ids must not be negative"))
 	}
-	if cfg.DB.Password == "" {
-		return fmt.Errorf("missing database password")
+	if u.UIDMax != 0 && u.UIDMax < u.UIDMin {
+		errs = append(errs, fmt.Errorf("unix uid_max must not be below uid_min"))
 	}
-	if cfg.DB.DBName == "" {
-		return fmt.Errorf("missing database name")
+	if u.GIDMax != 0 && u.GIDMax < u.GIDMin {
+		errs = append(errs, fmt.Errorf("unix gid_max must not be below gid_min"))
 	}
-	if cfg.Oauth.TenantID == "" {
-		return fmt.Errorf("missing oauth tenant ID")
+	if strings.ContainsAny(u.Shell, ":\n") {
+		errs = append(errs, fmt.Errorf("invalid unix shell %q", u.Shell))
 	}
-	if cfg.Oauth.ClientID == "" {
-		return fmt.Errorf("missing oauth client ID")
+	if u.HomeTemplate != "" {
+		if _, err := template.New("home").Parse(u.HomeTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("invalid unix home_template: %v", err))
+		}
 	}
-	if cfg.Oauth.ClientSecret == "" {
-		return fmt.Errorf("missing oauth client secret")
+	return errors.Join(errs...)
+}
+
+func validateLDAP(l *LDAPConfig) error {
+	var errs []error
+	for _, dn := range []string{l.BaseDN, l.PeopleOU, l.GroupsOU} {
+		if dn != "" && !strings.Contains(dn, "=") {
+			errs = append(errs, fmt.Errorf("invalid ldap dn %q", dn))
+		}
+	}
+	if !l.Enabled() && (l.PeopleOU != "" || l.GroupsOU != "") {
+		errs = append(errs, fmt.Errorf("ldap people_ou and groups_ou require a base_dn"))
+	}
+	for from, to := range l.Attributes {
+		if !slices.Contains(LDAPAttributes, from) {
+			errs = append(errs, fmt.Errorf("invalid ldap attribute %q, must be one of %v", from, LDAPAttributes))
+		}
+		if !ldapAttributeName.MatchString(to) {
+			errs = append(errs, fmt.Errorf("invalid ldap attribute name %q for %s", to, from))
+		}
+	}
+	if l.SnapshotRetention < 0 {
+		errs = append(errs, fmt.Errorf("ldap snapshot_retention must not be negative"))
 	}
-	return nil
+	return errors.Join(errs...)
 }
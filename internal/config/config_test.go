@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // copied from tests/data/testconfig.yaml
@@ -16,6 +17,7 @@ import (
 //   user: hpcadmin
 //   password: "superfancytestpasswordthatnobodyknows&"
 //   dbname: hpcadmin_test
+//   sslmode: disable
 // oauth:
 //   tenant_id: mock
 //   client_id: mock
@@ -35,6 +37,7 @@ func TestLoadFile(t *testing.T) {
 				User:     "hpcadmin",
 				Password: "superfancytestpasswordthatnobodyknows&",
 				DBName:   "hpcadmin_test",
+				SSLMode:  "disable",
 			},
 			Oauth: OauthConfig{
 				TenantID:     "mock",
@@ -69,3 +72,174 @@ func TestLoadFile(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateTLS(t *testing.T) {
+	if err := validateTLS(&TLSConfig{}); err != nil {
+		t.Errorf("Unexpected error for disabled tls: %v", err)
+	}
+	if err := validateTLS(&TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.3"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateTLS(&TLSConfig{CertFile: "cert.pem"}); err == nil {
+		t.Errorf("Expected error for cert_file without key_file")
+	}
+	if err := validateTLS(&TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.0"}); err == nil {
+		t.Errorf("Expected error for invalid min_version")
+	}
+	if err := validateTLS(&TLSConfig{ClientCAFile: "ca.pem"}); err == nil {
+		t.Errorf("Expected error for client_ca_file without tls")
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	cfg := &ServerConfig{Host: "localhost", Port: 3333}
+	if got := cfg.BaseURL(); got != "http://localhost:3333" {
+		t.Errorf("Expected http://localhost:3333, got %s", got)
+	}
+	cfg.TLS = TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}
+	if got := cfg.BaseURL(); got != "https://localhost:3333" {
+		t.Errorf("Expected https://localhost:3333, got %s", got)
+	}
+	cfg.ExternalURL = "https://hpcadmin.example.edu/"
+	if got := cfg.BaseURL(); got != "https://hpcadmin.example.edu" {
+		t.Errorf("Expected https://hpcadmin.example.edu, got %s", got)
+	}
+}
+
+func TestValidateHTTP(t *testing.T) {
+	if err := validateHTTP(&HTTPConfig{}); err != nil {
+		t.Errorf("Unexpected error for unset http config: %v", err)
+	}
+	if err := validateHTTP(&HTTPConfig{ShutdownTimeout: -time.Second}); err == nil {
+		t.Errorf("Expected error for negative shutdown timeout")
+	}
+	if err := validateHTTP(&HTTPConfig{MaxHeaderBytes: -1}); err == nil {
+		t.Errorf("Expected error for negative max_header_bytes")
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	if err := validateRateLimit(&RateLimitConfig{}); err != nil {
+		t.Errorf("Unexpected error for unset rate limit config: %v", err)
+	}
+	valid := RateLimitConfig{
+		Groups:  map[string]RateConfig{"api": {Rate: 10, Burst: 20}},
+		Lockout: LockoutConfig{MaxFailures: 5, Window: time.Minute, Duration: 5 * time.Minute},
+	}
+	if err := validateRateLimit(&valid); err != nil {
+		t.Errorf("Unexpected error for valid rate limit config: %v", err)
+	}
+	if err := validateRateLimit(&RateLimitConfig{Groups: map[string]RateConfig{"everything": {Rate: 1, Burst: 1}}}); err == nil {
+		t.Errorf("Expected error for unknown group")
+	}
+	if err := validateRateLimit(&RateLimitConfig{Groups: map[string]RateConfig{"api": {Rate: 1}}}); err == nil {
+		t.Errorf("Expected error for a rate without a burst")
+	}
+	if err := validateRateLimit(&RateLimitConfig{Lockout: LockoutConfig{MaxFailures: 5}}); err == nil {
+		t.Errorf("Expected error for a lockout without a window")
+	}
+}
+
+func TestValidateNotifications(t *testing.T) {
+	if err := validateNotifications(&NotificationsConfig{}); err != nil {
+		t.Errorf("Unexpected error for unset notifications config: %v", err)
+	}
+	valid := NotificationsConfig{From: "hpc@example.org", SMTP: SMTPConfig{Host: "localhost", Port: 587, Username: "hpc", Password: "secret"}}
+	if err := validateNotifications(&valid); err != nil {
+		t.Errorf("Unexpected error for valid notifications config: %v", err)
+	}
+	if err := validateNotifications(&NotificationsConfig{SMTP: SMTPConfig{Host: "localhost"}}); err == nil {
+		t.Errorf("Expected error for an smtp host without a from address")
+	}
+	if err := validateNotifications(&NotificationsConfig{SMTP: SMTPConfig{Username: "hpc"}}); err == nil {
+		t.Errorf("Expected error for an smtp username without a password")
+	}
+	if err := validateNotifications(&NotificationsConfig{MaxAttempts: -1}); err == nil {
+		t.Errorf("Expected error for negative max_attempts")
+	}
+}
+
+func TestValidateUnix(t *testing.T) {
+	if err := validateUnix(&UnixConfig{}); err != nil {
+		t.Errorf("Unexpected error for unset unix config: %v", err)
+	}
+	valid := UnixConfig{UIDMin: 100000, UIDMax: 199999, GIDMin: 200000, GIDMax: 299999, PrimaryGID: 100, Shell: "/bin/bash", HomeTemplate: "/home/{{.Username}}"}
+	if err := validateUnix(&valid); err != nil {
+		t.Errorf("Unexpected error for valid unix config: %v", err)
+	}
+	if err := validateUnix(&UnixConfig{UIDMin: 2000, UIDMax: 1000}); err == nil {
+		t.Errorf("Expected error for uid_max below uid_min")
+	}
+	if err := validateUnix(&UnixConfig{HomeTemplate: "/home/{{.Username"}); err == nil {
+		t.Errorf("Expected error for an invalid home_template")
+	}
+	if err := validateUnix(&UnixConfig{Shell: "/bin/bash:x"}); err == nil {
+		t.Errorf("Expected error for a shell with a colon")
+	}
+}
+
+func TestValidateLDAP(t *testing.T) {
+	if err := validateLDAP(&LDAPConfig{}); err != nil {
+		t.Errorf("Unexpected error for unset ldap config: %v", err)
+	}
+	valid := LDAPConfig{BaseDN: "dc=example,dc=edu", PeopleOU: "ou=accounts", Attributes: map[string]string{"mail": "campusMail"}}
+	if err := validateLDAP(&valid); err != nil {
+		t.Errorf("Unexpected error for valid ldap config: %v", err)
+	}
+	if err := validateLDAP(&LDAPConfig{BaseDN: "example.edu"}); err == nil {
+		t.Errorf("Expected error for a base_dn that isn't a dn")
+	}
+	if err := validateLDAP(&LDAPConfig{PeopleOU: "ou=accounts"}); err == nil {
+		t.Errorf("Expected error for a people_ou without a base_dn")
+	}
+	if err := validateLDAP(&LDAPConfig{BaseDN: "dc=example,dc=edu", Attributes: map[string]string{"email": "mail"}}); err == nil {
+		t.Errorf("Expected error for renaming an unknown attribute")
+	}
+	if err := validateLDAP(&LDAPConfig{BaseDN: "dc=example,dc=edu", Attributes: map[string]string{"mail": "campus mail"}}); err == nil {
+		t.Errorf("Expected error for an invalid attribute name")
+	}
+}
+
+func TestValidateDatabase(t *testing.T) {
+	valid := DatabaseConfig{
+		Host:     "localhost",
+		Port:     5432,
+		User:     "hpcadmin",
+		Password: "password",
+		DBName:   "hpcadmin_test",
+	}
+	t.Run("Valid", func(t *testing.T) {
+		db := valid
+		if err := validateDatabase(&db); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+	t.Run("InvalidSSLMode", func(t *testing.T) {
+		db := valid
+		db.SSLMode = "sometimes"
+		if err := validateDatabase(&db); err == nil {
+			t.Errorf("Expected error for invalid sslmode")
+		}
+	})
+	t.Run("CertWithoutKey", func(t *testing.T) {
+		db := valid
+		db.SSLCert = "/etc/hpcadmin-server/client.pem"
+		if err := validateDatabase(&db); err == nil {
+			t.Errorf("Expected error for sslcert without sslkey")
+		}
+	})
+	t.Run("IdleExceedsOpen", func(t *testing.T) {
+		db := valid
+		db.MaxOpenConns = 5
+		db.MaxIdleConns = 10
+		if err := validateDatabase(&db); err == nil {
+			t.Errorf("Expected error for max_idle_conns > max_open_conns")
+		}
+	})
+	t.Run("DSNOnly", func(t *testing.T) {
+		db := DatabaseConfig{DSN: "postgresql://hpcadmin@localhost/hpcadmin"}
+		if err := validateDatabase(&db); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable override
+const EnvPrefix = "HPCADMIN_SERVER"
+
+const redacted = "REDACTED"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadEnvironment overrides the configuration with environment variables
+// named after the yaml path of each setting, so database.host is set by
+// HPCADMIN_SERVER_DATABASE_HOST. Any setting can instead be read from the
+// file named by the same variable with a _FILE suffix, which is how docker
+// and kubernetes hand out secrets. Every bad variable is reported.
+func LoadEnvironment(cfg *ServerConfig) (*ServerConfig, error) {
+	err := loadEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix)
+	return cfg, err
+}
+
+func loadEnv(v reflect.Value, prefix string) error {
+	var errs []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := yamlName(field)
+		if !ok {
+			continue
+		}
+		env := prefix + "_" + strings.ToUpper(name)
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Struct:
+			errs = append(errs, loadEnv(fv, env))
+			continue
+		case fv.Kind() == reflect.Map || fv.Kind() == reflect.Slice:
+			// only settable from the configuration file
+			continue
+		}
+
+		value, found, err := lookupEnv(env)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		logValue := value
+		if isSecret(field) {
+			logValue = redacted
+		}
+		slog.Debug("found environment override", "package", "config", "method", "LoadEnvironment", "variable", env, "value", logValue)
+		if err := setField(fv, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", env, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lookupEnv reads the variable, or the file named by the variable's _FILE
+// form with any trailing newline removed
+func lookupEnv(env string) (string, bool, error) {
+	value, found := os.LookupEnv(env)
+	path, fileFound := os.LookupEnv(env + "_FILE")
+	if !fileFound {
+		return value, found, nil
+	}
+	if found {
+		return "", false, fmt.Errorf("only one of %s and %s_FILE can be set", env, env)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s_FILE: %v", env, err)
+	}
+	return strings.TrimRight(string(contents), "\r\n"), true, nil
+}
+
+func setField(fv reflect.Value, value string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("%s settings can't be set from the environment", fv.Kind())
+	}
+	return nil
+}
+
+func yamlName(field reflect.StructField) (string, bool) {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" || name == "-" {
+		return "", false
+	}
+	return name, true
+}
+
+func isSecret(field reflect.StructField) bool {
+	return field.Tag.Get("secret") == "true"
+}
+
+// Redacted returns a copy of the configuration with the secrets blanked out
+func Redacted(cfg *ServerConfig) *ServerConfig {
+	c := *cfg
+	redact(reflect.ValueOf(&c).Elem())
+	return &c
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			redact(fv)
+			continue
+		}
+		if isSecret(t.Field(i)) && fv.Kind() == reflect.String && fv.String() != "" {
+			fv.SetString(redacted)
+		}
+	}
+}
+
+// WriteRedacted writes the configuration as yaml with the secrets blanked out
+func WriteRedacted(w io.Writer, cfg *ServerConfig) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(Redacted(cfg)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
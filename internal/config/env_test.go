@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadEnvironment(t *testing.T) {
+	t.Setenv("HPCADMIN_SERVER_PORT", "8080")
+	t.Setenv("HPCADMIN_SERVER_DATABASE_PASSWORD", "envpassword")
+	t.Setenv("HPCADMIN_SERVER_HTTP_SHUTDOWN_TIMEOUT", "45s")
+	t.Setenv("HPCADMIN_SERVER_TRACING_INSECURE", "true")
+	t.Setenv("HPCADMIN_SERVER_TRACING_SAMPLE_RATIO", "0.25")
+	t.Setenv("HPCADMIN_SERVER_RATE_LIMIT_LOCKOUT_MAX_FAILURES", "7")
+
+	cfg, err := LoadEnvironment(&ServerConfig{Host: "localhost", DB: DatabaseConfig{User: "hpcadmin"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Port != 8080 {
+		t.Errorf("Expected port 8080, got %d", cfg.Port)
+	}
+	if cfg.DB.Password != "envpassword" || cfg.DB.User != "hpcadmin" {
+		t.Errorf("Expected password override to leave the user alone, got user %q password %q", cfg.DB.User, cfg.DB.Password)
+	}
+	if cfg.HTTP.ShutdownTimeout != 45*time.Second {
+		t.Errorf("Expected shutdown timeout 45s, got %v", cfg.HTTP.ShutdownTimeout)
+	}
+	if !cfg.Tracing.Insecure || cfg.Tracing.SampleRatio != 0.25 {
+		t.Errorf("Expected tracing overrides, got %+v", cfg.Tracing)
+	}
+	if cfg.RateLimit.Lockout.MaxFailures != 7 {
+		t.Errorf("Expected lockout max failures 7, got %d", cfg.RateLimit.Lockout.MaxFailures)
+	}
+	if cfg.Host != "localhost" {
+		t.Errorf("Expected unset variables to leave the host alone, got %q", cfg.Host)
+	}
+}
+
+func TestLoadEnvironmentFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client_secret")
+	if err := os.WriteFile(path, []byte("filesecret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HPCADMIN_SERVER_OAUTH_CLIENT_SECRET_FILE", path)
+
+	cfg, err := LoadEnvironment(&ServerConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Oauth.ClientSecret != "filesecret" {
+		t.Errorf("Expected client secret from file without the newline, got %q", cfg.Oauth.ClientSecret)
+	}
+
+	t.Setenv("HPCADMIN_SERVER_OAUTH_CLIENT_SECRET", "envsecret")
+	if _, err := LoadEnvironment(&ServerConfig{}); err == nil {
+		t.Errorf("Expected error when both the variable and its _FILE form are set")
+	}
+}
+
+func TestLoadEnvironmentReportsEveryError(t *testing.T) {
+	t.Setenv("HPCADMIN_SERVER_PORT", "notaport")
+	t.Setenv("HPCADMIN_SERVER_DATABASE_CONNECT_TIMEOUT", "soon")
+	t.Setenv("HPCADMIN_SERVER_DATABASE_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := LoadEnvironment(&ServerConfig{})
+	if err == nil {
+		t.Fatal("Expected error for invalid variables")
+	}
+	for _, env := range []string{"HPCADMIN_SERVER_PORT", "HPCADMIN_SERVER_DATABASE_CONNECT_TIMEOUT", "HPCADMIN_SERVER_DATABASE_PASSWORD_FILE"} {
+		if !strings.Contains(err.Error(), env) {
+			t.Errorf("Expected error to mention %s, got %v", env, err)
+		}
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	err := Validate(&ServerConfig{Port: 3333, DB: DatabaseConfig{DSN: "postgres://"}, Logging: LoggingConfig{Format: "xml"}})
+	if err == nil {
+		t.Fatal("Expected error for incomplete config")
+	}
+	for _, problem := range []string{"missing host", "invalid logging format", "missing oauth tenant ID", "missing oauth client secret"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected error to report %q, got %v", problem, err)
+		}
+	}
+}
+
+func TestValidateDatabaseOnly(t *testing.T) {
+	cfg := &ServerConfig{DB: DatabaseConfig{DSN: "postgres://"}}
+	if err := ValidateDatabase(cfg); err != nil {
+		t.Errorf("Expected a database-only config to be valid, got %v", err)
+	}
+	if err := Validate(cfg); err == nil {
+		t.Errorf("Expected the full validation to need the server settings")
+	}
+	cfg.Logging.Format = "xml"
+	if err := ValidateDatabase(cfg); err == nil || !strings.Contains(err.Error(), "invalid logging format") {
+		t.Errorf("Expected error to report the logging format, got %v", err)
+	}
+	cfg.Logging.Format = ""
+	cfg.Unix.Shell = "/bin/bash:x"
+	if err := ValidateExport(cfg); err == nil {
+		t.Errorf("Expected error for an invalid unix shell")
+	}
+}
+
+func TestWriteRedacted(t *testing.T) {
+	cfg := &ServerConfig{
+		Host:  "localhost",
+		DB:    DatabaseConfig{User: "hpcadmin", Password: "supersecretpassword"},
+		Oauth: OauthConfig{ClientID: "client", ClientSecret: "supersecretclient"},
+	}
+	var buf bytes.Buffer
+	if err := WriteRedacted(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "supersecret") {
+		t.Errorf("Expected secrets to be redacted: %s", out)
+	}
+	if !strings.Contains(out, "password: REDACTED") || !strings.Contains(out, "user: hpcadmin") {
+		t.Errorf("Expected redacted password alongside the other settings: %s", out)
+	}
+	if cfg.DB.Password != "supersecretpassword" {
+		t.Errorf("Expected the original config to be left alone")
+	}
+}
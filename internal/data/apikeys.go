@@ -1,33 +1,72 @@
 package data
 
 import (
-	"database/sql"
-	"fmt"
+	"context"
 	"log/slog"
 	"time"
 )
 
 type APIKeyEntry struct {
-	Key       string
-	Role      string
-	CreatedAt time.Time
+	Key        string
+	Role       string
+	UserId     int
+	CreatedAt  time.Time
 	ModifiedAt time.Time
 }
 
+type APIKeyRequest struct {
+	Key    string
+	Role   string
+	UserId int
+}
+
 // GetAPIKeyEntry looks for the provided key in the database
-// and returns the APIKeyEntry if found, nil if not found, or an error
-func GetAPIKeyEntry(db *sql.DB, key string) (*APIKeyEntry, error) {
-	slog.Debug("querying database for api key", "package", "data", "method", "GetAPIKeyEntry")
+// and returns the APIKeyEntry if found, ErrNotFound if not found or if the
+// key's user is deactivated, or an error
+func (s *PostgresStore) GetAPIKeyEntry(ctx context.Context, key string) (*APIKeyEntry, error) {
+	ctx, span := startSpan(ctx, "GetAPIKeyEntry")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for api key", "package", "data", "method", "GetAPIKeyEntry")
 	var k APIKeyEntry
-	err := db.QueryRow("SELECT key, role, created_at, modified_at FROM api_keys WHERE key = $1", key).Scan(&k.Key, &k.Role, &k.CreatedAt, &k.ModifiedAt)
+	err := s.q.QueryRowContext(ctx, `SELECT k.key, k.role, k.user_id, k.created_at, k.modified_at
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key = $1 AND u.deactivated_at IS NULL`, key).Scan(&k.Key, &k.Role, &k.UserId, &k.CreatedAt, &k.ModifiedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			slog.Debug("api key not found in database", "package", "data", "method", "GetAPIKeyEntry")
-			return nil, fmt.Errorf("no APIKeyToken found with provided key")
+		err = mapError(err)
+		if err == ErrNotFound {
+			slog.DebugContext(ctx, "api key not found in database", "package", "data", "method", "GetAPIKeyEntry")
+			return nil, err
 		}
-		slog.Debug("failed to look up key from database", "package", "data", "method", "GetAPIKeyEntry", "error", err)
+		slog.DebugContext(ctx, "failed to look up key from database", "package", "data", "method", "GetAPIKeyEntry", "error", err)
 		return nil, err
 	}
-	slog.Debug("found api key in database", "package", "data", "method", "GetAPIKeyEntry")
-	return &k, err
+	slog.DebugContext(ctx, "found api key in database", "package", "data", "method", "GetAPIKeyEntry")
+	return &k, nil
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key *APIKeyRequest) (*APIKeyEntry, error) {
+	ctx, span := startSpan(ctx, "CreateAPIKey")
+	defer span.End()
+	slog.DebugContext(ctx, "creating api key in database", "package", "data", "method", "CreateAPIKey", "role", key.Role, "user_id", key.UserId)
+	var k APIKeyEntry
+	err := s.q.QueryRowContext(ctx, "INSERT INTO api_keys (key, role, user_id) VALUES ($1, $2, $3) RETURNING key, role, user_id, created_at, modified_at", key.Key, key.Role, key.UserId).Scan(&k.Key, &k.Role, &k.UserId, &k.CreatedAt, &k.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &k, nil
+}
+
+func (s *PostgresStore) DeleteAPIKey(ctx context.Context, key string) error {
+	ctx, span := startSpan(ctx, "DeleteAPIKey")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting api key from database", "package", "data", "method", "DeleteAPIKey")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM api_keys WHERE key = $1", key)
+	return checkAffectedRows(res, err)
+}
+
+func (s *PostgresStore) GetAdminUserIds(ctx context.Context) ([]int, error) {
+	ctx, span := startSpan(ctx, "GetAdminUserIds")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for admin key holders", "package", "data", "method", "GetAdminUserIds")
+	return s.queryIds(ctx, "SELECT DISTINCT user_id FROM api_keys WHERE role = 'admin' ORDER BY user_id")
 }
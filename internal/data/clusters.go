@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Cluster is a compute cluster that pirgs can be given access to
+type Cluster struct {
+	Id          int
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	ModifiedAt  time.Time
+}
+
+type ClusterRequest struct {
+	Name        string
+	Description string
+}
+
+// ClusterPirg enables a pirg on a cluster for the listed pirg users
+type ClusterPirg struct {
+	ClusterId  int
+	PirgId     int
+	UserIds    []int
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+}
+
+const clusterColumns = "id, name, description, created_at, modified_at"
+
+func scanCluster(row interface{ Scan(...any) error }) (*Cluster, error) {
+	var c Cluster
+	err := row.Scan(&c.Id, &c.Name, &c.Description, &c.CreatedAt, &c.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &c, nil
+}
+
+func (s *PostgresStore) GetAllClusters(ctx context.Context) ([]*Cluster, error) {
+	ctx, span := startSpan(ctx, "GetAllClusters")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all clusters from database", "package", "data", "method", "GetAllClusters")
+	var clusters []*Cluster
+	rows, err := s.q.QueryContext(ctx, "SELECT "+clusterColumns+" FROM clusters ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCluster(rows)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, rows.Err()
+}
+
+func (s *PostgresStore) GetClusterById(ctx context.Context, id int) (*Cluster, error) {
+	ctx, span := startSpan(ctx, "GetClusterById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for cluster by id", "id", id, "package", "data", "method", "GetClusterById")
+	return scanCluster(s.q.QueryRowContext(ctx, "SELECT "+clusterColumns+" FROM clusters WHERE id = $1", id))
+}
+
+func (s *PostgresStore) GetClusterByName(ctx context.Context, name string) (*Cluster, error) {
+	ctx, span := startSpan(ctx, "GetClusterByName")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for cluster by name", "name", name, "package", "data", "method", "GetClusterByName")
+	return scanCluster(s.q.QueryRowContext(ctx, "SELECT "+clusterColumns+" FROM clusters WHERE name = $1", name))
+}
+
+func (s *PostgresStore) CreateCluster(ctx context.Context, cr *ClusterRequest) (*Cluster, error) {
+	ctx, span := startSpan(ctx, "CreateCluster")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new cluster in database", "package", "data", "method", "CreateCluster")
+	row := s.q.QueryRowContext(ctx, "INSERT INTO clusters (name, description) VALUES ($1, $2) RETURNING "+clusterColumns, cr.Name, cr.Description)
+	return scanCluster(row)
+}
+
+func (s *PostgresStore) UpdateCluster(ctx context.Context, id int, cr *ClusterRequest) (*Cluster, error) {
+	ctx, span := startSpan(ctx, "UpdateCluster")
+	defer span.End()
+	slog.DebugContext(ctx, "updating cluster in database", "id", id, "package", "data", "method", "UpdateCluster")
+	row := s.q.QueryRowContext(ctx, "UPDATE clusters SET name = $1, description = $2 WHERE id = $3 RETURNING "+clusterColumns, cr.Name, cr.Description, id)
+	return scanCluster(row)
+}
+
+// DeleteCluster removes a cluster along with every pirg's access to it.
+// Clusters that still have partitions or compute usage can't be deleted.
+func (s *PostgresStore) DeleteCluster(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteCluster")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting cluster from database", "id", id, "package", "data", "method", "DeleteCluster")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM clusters WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+// GetClusterPirgs returns the pirgs enabled on the cluster
+func (s *PostgresStore) GetClusterPirgs(ctx context.Context, clusterId int) ([]*ClusterPirg, error) {
+	ctx, span := startSpan(ctx, "GetClusterPirgs")
+	defer span.End()
+	slog.DebugContext(ctx, "getting pirgs enabled on cluster from database", "cluster_id", clusterId, "package", "data", "method", "GetClusterPirgs")
+	return s.queryClusterPirgs(ctx, "WHERE cluster_id = $1 ORDER BY pirg_id", clusterId)
+}
+
+// GetPirgClusters returns the clusters the pirg is enabled on
+func (s *PostgresStore) GetPirgClusters(ctx context.Context, pirgId int) ([]*ClusterPirg, error) {
+	ctx, span := startSpan(ctx, "GetPirgClusters")
+	defer span.End()
+	slog.DebugContext(ctx, "getting clusters for pirg from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgClusters")
+	return s.queryClusterPirgs(ctx, "WHERE pirg_id = $1 ORDER BY cluster_id", pirgId)
+}
+
+func (s *PostgresStore) GetClusterPirg(ctx context.Context, clusterId int, pirgId int) (*ClusterPirg, error) {
+	ctx, span := startSpan(ctx, "GetClusterPirg")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for pirg on cluster", "cluster_id", clusterId, "pirg_id", pirgId, "package", "data", "method", "GetClusterPirg")
+	cps, err := s.queryClusterPirgs(ctx, "WHERE cluster_id = $1 AND pirg_id = $2", clusterId, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	if len(cps) == 0 {
+		return nil, ErrNotFound
+	}
+	return cps[0], nil
+}
+
+// queryClusterPirgs selects from clusters_pirgs with the given where clause
+// and loads the enabled users of each row
+func (s *PostgresStore) queryClusterPirgs(ctx context.Context, where string, args ...any) ([]*ClusterPirg, error) {
+	var cps []*ClusterPirg
+	rows, err := s.q.QueryContext(ctx, "SELECT cluster_id, pirg_id, created_at, modified_at FROM clusters_pirgs "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cp ClusterPirg
+		if err := rows.Scan(&cp.ClusterId, &cp.PirgId, &cp.CreatedAt, &cp.ModifiedAt); err != nil {
+			return nil, err
+		}
+		cps = append(cps, &cp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// the rows have to be read before the users can be looked up
+	rows.Close()
+	for _, cp := range cps {
+		cp.UserIds, err = s.queryIds(ctx, "SELECT user_id FROM clusters_pirgs_users WHERE cluster_id = $1 AND pirg_id = $2 ORDER BY user_id", cp.ClusterId, cp.PirgId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cps, nil
+}
+
+// SetClusterPirg enables the pirg on the cluster for exactly the given
+// users, who have to be users of the pirg
+func (s *PostgresStore) SetClusterPirg(ctx context.Context, clusterId int, pirgId int, userIds []int) (*ClusterPirg, error) {
+	ctx, span := startSpan(ctx, "SetClusterPirg")
+	defer span.End()
+	slog.DebugContext(ctx, "enabling pirg on cluster in database", "cluster_id", clusterId, "pirg_id", pirgId, "package", "data", "method", "SetClusterPirg")
+	if _, err := s.GetClusterById(ctx, clusterId); err != nil {
+		return nil, err
+	}
+	pirg, err := s.GetPirgById(ctx, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateClusterUsers(pirg, userIds); err != nil {
+		return nil, err
+	}
+	var cp *ClusterPirg
+	err = s.withTx(ctx, func(tx *PostgresStore) error {
+		_, err := tx.q.ExecContext(ctx, "INSERT INTO clusters_pirgs (cluster_id, pirg_id) VALUES ($1, $2) ON CONFLICT (cluster_id, pirg_id) DO NOTHING", clusterId, pirgId)
+		if err != nil {
+			return mapError(err)
+		}
+		existing, err := tx.queryIds(ctx, "SELECT user_id FROM clusters_pirgs_users WHERE cluster_id = $1 AND pirg_id = $2", clusterId, pirgId)
+		if err != nil {
+			return err
+		}
+		for _, userId := range existing {
+			if !slices.Contains(userIds, userId) {
+				if _, err := tx.q.ExecContext(ctx, "DELETE FROM clusters_pirgs_users WHERE cluster_id = $1 AND pirg_id = $2 AND user_id = $3", clusterId, pirgId, userId); err != nil {
+					return err
+				}
+			}
+		}
+		for _, userId := range uniqueIds(userIds) {
+			if !slices.Contains(existing, userId) {
+				if _, err := tx.q.ExecContext(ctx, "INSERT INTO clusters_pirgs_users (cluster_id, pirg_id, user_id) VALUES ($1, $2, $3)", clusterId, pirgId, userId); err != nil {
+					return mapError(err)
+				}
+			}
+		}
+		cp, err = tx.GetClusterPirg(ctx, clusterId, pirgId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// DeleteClusterPirg takes the pirg's access to the cluster away
+func (s *PostgresStore) DeleteClusterPirg(ctx context.Context, clusterId int, pirgId int) error {
+	ctx, span := startSpan(ctx, "DeleteClusterPirg")
+	defer span.End()
+	slog.DebugContext(ctx, "disabling pirg on cluster in database", "cluster_id", clusterId, "pirg_id", pirgId, "package", "data", "method", "DeleteClusterPirg")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM clusters_pirgs WHERE cluster_id = $1 AND pirg_id = $2", clusterId, pirgId)
+	return checkAffectedRows(res, err)
+}
+
+// validateClusterUsers makes sure only users of the pirg are enabled on
+// a cluster. It's shared by every store implementation.
+func validateClusterUsers(pirg *Pirg, userIds []int) error {
+	for _, userId := range userIds {
+		if !slices.Contains(pirg.UserIds, userId) {
+			return fmt.Errorf("user %d is not a user of pirg %s", userId, pirg.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ComputeResources are the kinds of hours a compute allocation can hold
+var ComputeResources = []string{"cpu", "gpu"}
+
+// ComputeExhaustionFraction is how much of an allocation can be used
+// before it's flagged as nearing exhaustion
+const ComputeExhaustionFraction = 0.9
+
+// ComputeAllocation is a number of cpu or gpu hours given to a pirg to use
+// from StartDate through EndDate
+type ComputeAllocation struct {
+	Id         int
+	PirgId     int
+	Resource   string
+	Hours      float64
+	StartDate  time.Time
+	EndDate    time.Time
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+}
+
+type ComputeAllocationRequest struct {
+	Resource  string
+	Hours     float64
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Window returns the range of job end times that count against the allocation
+func (a *ComputeAllocation) Window() (from time.Time, to time.Time) {
+	return a.StartDate, a.EndDate.AddDate(0, 0, 1)
+}
+
+// JobUsage is a finished job charged to a pirg. UserId is nil if the
+// username isn't known. Account is the name of the pirg, which is kept
+// once the pirg is deleted.
+type JobUsage struct {
+	ClusterId int
+	JobId     string
+	PirgId    int
+	Account   string
+	UserId    *int
+	Username  string
+	CPUHours  float64
+	GPUHours  float64
+	EndedAt   time.Time
+}
+
+// UserUsage totals the jobs a user ran in a pirg
+type UserUsage struct {
+	UserId   *int
+	Username string
+	Jobs     int
+	CPUHours float64
+	GPUHours float64
+}
+
+// ComputeBalance is how much of an allocation has been used and how fast
+type ComputeBalance struct {
+	Allocation     *ComputeAllocation
+	UsedHours      float64
+	RemainingHours float64
+	UsedFraction   float64
+	// BurnRate is the hours used per day since the allocation started
+	BurnRate float64
+	// ProjectedExhaustion is when the allocation runs out at the current
+	// burn rate, if that's before it ends
+	ProjectedExhaustion *time.Time
+	// Flagged is set when the allocation is nearly used up or is projected
+	// to run out before it ends
+	Flagged bool
+}
+
+// NewComputeBalance works out the balance of the allocation from the usage
+// of the pirg inside the allocation's window
+func NewComputeBalance(a *ComputeAllocation, usage []*UserUsage, now time.Time) *ComputeBalance {
+	b := &ComputeBalance{Allocation: a}
+	for _, u := range usage {
+		if a.Resource == "gpu" {
+			b.UsedHours += u.GPUHours
+		} else {
+			b.UsedHours += u.CPUHours
+		}
+	}
+	b.RemainingHours = a.Hours - b.UsedHours
+	b.UsedFraction = b.UsedHours / a.Hours
+
+	from, to := a.Window()
+	elapsed := now
+	if elapsed.After(to) {
+		elapsed = to
+	}
+	days := elapsed.Sub(from).Hours() / 24
+	if days > 0 {
+		// a partial first day counts as a whole one
+		b.BurnRate = b.UsedHours / max(days, 1)
+	}
+	if b.BurnRate > 0 && now.Before(to) {
+		exhaustion := now.Add(time.Duration(b.RemainingHours / b.BurnRate * 24 * float64(time.Hour)))
+		if exhaustion.Before(to) {
+			b.ProjectedExhaustion = &exhaustion
+		}
+	}
+	b.Flagged = b.UsedFraction >= ComputeExhaustionFraction || b.ProjectedExhaustion != nil
+	return b
+}
+
+// truncateDate drops the time of day, matching what postgres keeps of a DATE
+func truncateDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// validateComputeAllocation checks the request and truncates its dates.
+// It's shared by every store implementation.
+func validateComputeAllocation(cr *ComputeAllocationRequest) error {
+	if !slices.Contains(ComputeResources, cr.Resource) {
+		return fmt.Errorf("invalid compute resource %q, must be one of %v", cr.Resource, ComputeResources)
+	}
+	if cr.Hours <= 0 {
+		return fmt.Errorf("hours must be positive")
+	}
+	cr.StartDate = truncateDate(cr.StartDate)
+	cr.EndDate = truncateDate(cr.EndDate)
+	if cr.EndDate.Before(cr.StartDate) {
+		return fmt.Errorf("end date must not be before start date")
+	}
+	return nil
+}
+
+const computeAllocationColumns = "id, pirg_id, resource, hours, start_date, end_date, created_at, modified_at"
+
+func scanComputeAllocation(row interface{ Scan(...any) error }) (*ComputeAllocation, error) {
+	var a ComputeAllocation
+	err := row.Scan(&a.Id, &a.PirgId, &a.Resource, &a.Hours, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &a, nil
+}
+
+func (s *PostgresStore) queryComputeAllocations(ctx context.Context, where string, args ...any) ([]*ComputeAllocation, error) {
+	var allocations []*ComputeAllocation
+	rows, err := s.q.QueryContext(ctx, "SELECT "+computeAllocationColumns+" FROM compute_allocations "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanComputeAllocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+func (s *PostgresStore) GetAllComputeAllocations(ctx context.Context) ([]*ComputeAllocation, error) {
+	ctx, span := startSpan(ctx, "GetAllComputeAllocations")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all compute allocations from database", "package", "data", "method", "GetAllComputeAllocations")
+	return s.queryComputeAllocations(ctx, "")
+}
+
+func (s *PostgresStore) GetPirgComputeAllocations(ctx context.Context, pirgId int) ([]*ComputeAllocation, error) {
+	ctx, span := startSpan(ctx, "GetPirgComputeAllocations")
+	defer span.End()
+	slog.DebugContext(ctx, "getting compute allocations for pirg from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgComputeAllocations")
+	return s.queryComputeAllocations(ctx, "WHERE pirg_id = $1", pirgId)
+}
+
+func (s *PostgresStore) GetComputeAllocationById(ctx context.Context, id int) (*ComputeAllocation, error) {
+	ctx, span := startSpan(ctx, "GetComputeAllocationById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for compute allocation", "id", id, "package", "data", "method", "GetComputeAllocationById")
+	return scanComputeAllocation(s.q.QueryRowContext(ctx, "SELECT "+computeAllocationColumns+" FROM compute_allocations WHERE id = $1", id))
+}
+
+func (s *PostgresStore) CreateComputeAllocation(ctx context.Context, pirgId int, cr *ComputeAllocationRequest) (*ComputeAllocation, error) {
+	ctx, span := startSpan(ctx, "CreateComputeAllocation")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new compute allocation in database", "pirg_id", pirgId, "package", "data", "method", "CreateComputeAllocation")
+	if err := validateComputeAllocation(cr); err != nil {
+		return nil, err
+	}
+	row := s.q.QueryRowContext(ctx, "INSERT INTO compute_allocations (pirg_id, resource, hours, start_date, end_date) VALUES ($1, $2, $3, $4, $5) RETURNING "+computeAllocationColumns,
+		pirgId, cr.Resource, cr.Hours, cr.StartDate, cr.EndDate)
+	return scanComputeAllocation(row)
+}
+
+func (s *PostgresStore) UpdateComputeAllocation(ctx context.Context, id int, cr *ComputeAllocationRequest) (*ComputeAllocation, error) {
+	ctx, span := startSpan(ctx, "UpdateComputeAllocation")
+	defer span.End()
+	slog.DebugContext(ctx, "updating compute allocation in database", "id", id, "package", "data", "method", "UpdateComputeAllocation")
+	if err := validateComputeAllocation(cr); err != nil {
+		return nil, err
+	}
+	row := s.q.QueryRowContext(ctx, "UPDATE compute_allocations SET resource = $1, hours = $2, start_date = $3, end_date = $4 WHERE id = $5 RETURNING "+computeAllocationColumns,
+		cr.Resource, cr.Hours, cr.StartDate, cr.EndDate, id)
+	return scanComputeAllocation(row)
+}
+
+func (s *PostgresStore) DeleteComputeAllocation(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteComputeAllocation")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting compute allocation from database", "id", id, "package", "data", "method", "DeleteComputeAllocation")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM compute_allocations WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+// RecordJobUsage stores finished jobs. A job that was already recorded
+// for the cluster is replaced, so the same sacct output can be loaded
+// more than once.
+func (s *PostgresStore) RecordJobUsage(ctx context.Context, jobs []*JobUsage) error {
+	ctx, span := startSpan(ctx, "RecordJobUsage")
+	defer span.End()
+	slog.DebugContext(ctx, "recording job usage in database", "jobs", len(jobs), "package", "data", "method", "RecordJobUsage")
+	return s.withTx(ctx, func(tx *PostgresStore) error {
+		for _, job := range jobs {
+			_, err := tx.q.ExecContext(ctx, `INSERT INTO compute_usage (cluster_id, job_id, pirg_id, account, user_id, username, cpu_hours, gpu_hours, ended_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				ON CONFLICT (cluster_id, job_id) DO UPDATE SET pirg_id = EXCLUDED.pirg_id, account = EXCLUDED.account, user_id = EXCLUDED.user_id,
+					username = EXCLUDED.username, cpu_hours = EXCLUDED.cpu_hours, gpu_hours = EXCLUDED.gpu_hours, ended_at = EXCLUDED.ended_at`,
+				job.ClusterId, job.JobId, job.PirgId, job.Account, job.UserId, job.Username, job.CPUHours, job.GPUHours, job.EndedAt.UTC())
+			if err != nil {
+				return mapError(err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetPirgUsage totals the pirg's jobs for each user that ended from from
+// up to, but not including, to
+func (s *PostgresStore) GetPirgUsage(ctx context.Context, pirgId int, from time.Time, to time.Time) ([]*UserUsage, error) {
+	ctx, span := startSpan(ctx, "GetPirgUsage")
+	defer span.End()
+	slog.DebugContext(ctx, "getting compute usage for pirg from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgUsage")
+	rows, err := s.q.QueryContext(ctx, `SELECT user_id, username, COUNT(*), SUM(cpu_hours), SUM(gpu_hours) FROM compute_usage
+		WHERE pirg_id = $1 AND ended_at >= $2 AND ended_at < $3
+		GROUP BY user_id, username
+		ORDER BY username, user_id`, pirgId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var usage []*UserUsage
+	for rows.Next() {
+		var u UserUsage
+		var userId sql.NullInt64
+		if err := rows.Scan(&userId, &u.Username, &u.Jobs, &u.CPUHours, &u.GPUHours); err != nil {
+			return nil, err
+		}
+		u.UserId = nullIntPtr(userId)
+		usage = append(usage, &u)
+	}
+	return usage, rows.Err()
+}
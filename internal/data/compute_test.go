@@ -0,0 +1,39 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewComputeBalance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	allocation := &ComputeAllocation{Resource: "cpu", Hours: 1000, StartDate: start, EndDate: start.AddDate(0, 0, 99)}
+
+	// 100 hours in 10 days will last the 100 day allocation
+	b := NewComputeBalance(allocation, []*UserUsage{{CPUHours: 60, GPUHours: 500}, {CPUHours: 40}}, start.AddDate(0, 0, 10))
+	if b.UsedHours != 100 || b.RemainingHours != 900 || b.BurnRate != 10 {
+		t.Fatalf("unexpected balance %+v", b)
+	}
+	if b.Flagged || b.ProjectedExhaustion != nil {
+		t.Fatalf("expected allocation not to be flagged, got %+v", b)
+	}
+
+	// 500 hours in 10 days runs out on day 20
+	b = NewComputeBalance(allocation, []*UserUsage{{CPUHours: 500}}, start.AddDate(0, 0, 10))
+	if !b.Flagged || b.ProjectedExhaustion == nil || !b.ProjectedExhaustion.Equal(start.AddDate(0, 0, 20)) {
+		t.Fatalf("expected allocation to run out on day 20, got %+v", b)
+	}
+
+	// nearly used up at the very end
+	b = NewComputeBalance(allocation, []*UserUsage{{CPUHours: 950}}, start.AddDate(0, 0, 120))
+	if !b.Flagged || b.ProjectedExhaustion != nil || b.BurnRate != 9.5 {
+		t.Fatalf("expected a used up allocation to be flagged, got %+v", b)
+	}
+
+	// gpu allocations count gpu hours
+	gpu := &ComputeAllocation{Resource: "gpu", Hours: 100, StartDate: start, EndDate: start.AddDate(0, 0, 99)}
+	b = NewComputeBalance(gpu, []*UserUsage{{CPUHours: 1000, GPUHours: 1}}, start)
+	if b.UsedHours != 1 || b.BurnRate != 0 || b.Flagged {
+		t.Fatalf("unexpected gpu balance %+v", b)
+	}
+}
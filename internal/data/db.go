@@ -1,49 +1,140 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
+const (
+	// defaultConnectTimeout is how long NewDBConn keeps retrying
+	// when the request doesn't set a ConnectTimeout
+	defaultConnectTimeout = 30 * time.Second
+	initialConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 8 * time.Second
+	// defaultSSLMode keeps existing deployments without TLS working,
+	// the driver's own default is require
+	defaultSSLMode = "disable"
+)
+
 type DBRequest struct {
-	Host       string
-	Port       int
-	User       string
-	Password   string
-	DBName     string
-	DisableSSL bool
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DBName   string
+
+	// SSLMode is passed through to the driver as sslmode.
+	// Leaving it empty uses defaultSSLMode.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// ConnectTimeout is the total time to keep retrying the initial
+	// connection while the database comes up
+	ConnectTimeout time.Duration
+
+	// DSN overrides every connection field above when set
+	DSN string
 }
 
-func NewDBRequest(host string, port int, user, password, dbname string, disableSSL bool) (DBRequest, error) {
-	return DBRequest{
-		Host:       host,
-		Port:       port,
-		User:       user,
-		Password:   password,
-		DBName:     dbname,
-		DisableSSL: disableSSL,
-	}, nil
+// ConnString builds the connection string for the request.
+// If a DSN override was provided, it is returned unchanged.
+func (dbr DBRequest) ConnString() string {
+	if dbr.DSN != "" {
+		return dbr.DSN
+	}
+	host := dbr.Host
+	if dbr.Port != 0 {
+		host = net.JoinHostPort(dbr.Host, strconv.Itoa(dbr.Port))
+	}
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(dbr.User, dbr.Password),
+		Host:   host,
+		Path:   "/" + dbr.DBName,
+	}
+	q := url.Values{}
+	q.Set("sslmode", defaultSSLMode)
+	if dbr.SSLMode != "" {
+		q.Set("sslmode", dbr.SSLMode)
+	}
+	if dbr.SSLRootCert != "" {
+		q.Set("sslrootcert", dbr.SSLRootCert)
+	}
+	if dbr.SSLCert != "" {
+		q.Set("sslcert", dbr.SSLCert)
+	}
+	if dbr.SSLKey != "" {
+		q.Set("sslkey", dbr.SSLKey)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
+// NewDBConn opens the connection pool described by dbr and waits for the
+// database to answer, retrying with backoff until ConnectTimeout elapses
 func NewDBConn(dbr DBRequest) (*sql.DB, error) {
-	connStr := fmt.Sprintf("postgresql://%s:%s@%s/%s", dbr.User, dbr.Password, dbr.Host, dbr.DBName)
-	if dbr.DisableSSL {
-		connStr = connStr + "?sslmode=disable"
-	}
-	dbConn, err := sql.Open("postgres", connStr)
+	dbConn, err := sql.Open("postgres", dbr.ConnString())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err.Error())
 	}
-	if err = dbConn.Ping(); err != nil {
+	if dbr.MaxOpenConns > 0 {
+		dbConn.SetMaxOpenConns(dbr.MaxOpenConns)
+	}
+	if dbr.MaxIdleConns > 0 {
+		dbConn.SetMaxIdleConns(dbr.MaxIdleConns)
+	}
+	if dbr.ConnMaxLifetime > 0 {
+		dbConn.SetConnMaxLifetime(dbr.ConnMaxLifetime)
+	}
+	timeout := dbr.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
+	}
+	if err = waitForDB(dbConn, timeout); err != nil {
+		dbConn.Close()
 		return nil, fmt.Errorf("failed to connect to database: %v", err.Error())
 	}
 	return dbConn, nil
 }
 
+// waitForDB pings the database until it responds or the timeout is reached
+func waitForDB(db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := initialConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		slog.Warn("database not ready, retrying", "package", "data", "method", "waitForDB", "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
 func WipeDB(db *sql.DB) error {
-	tables := []string{"pirgs_users", "pirgs_groups", "pirgs_admins", "groups_users", "pirgs", "users"}
+	// ordered so that dependent rows are removed before the rows they reference
+	tables := []string{"notifications", "audit_log", "access_review_items", "access_reviews", "groups_users", "pirgs_groups", "clusters_pirgs_users", "clusters_pirgs", "pirgs_users_qos", "pirgs_users_partitions", "pirgs_qos", "pirgs_partitions", "qos", "partitions", "compute_usage", "compute_allocations", "clusters", "pirgs_admins", "pirgs_users", "storage_allocations", "storage_usage", "locations", "pirgs", "api_keys", "users"}
 	for _, table := range tables {
 		q := fmt.Sprintf("DELETE FROM %s", table)
 		_, err := db.Exec(q)
@@ -53,3 +144,15 @@ func WipeDB(db *sql.DB) error {
 	}
 	return nil
 }
+
+// GetSchemaVersion returns the schema version recorded by golang-migrate
+// and whether the last migration failed partway through
+func GetSchemaVersion(ctx context.Context, db *sql.DB) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err != nil {
+		return 0, false, mapError(err)
+	}
+	return version, dirty, nil
+}
@@ -1,54 +1,141 @@
 package data
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"net/url"
 	"os"
 	"strconv"
+	"testing"
 )
 
 type testDataHandler struct {
 	DB *sql.DB
 }
 
-func NewTestDataHandler() *testDataHandler {
+// NewTestDataHandler connects to the test database described by the
+// HPCADMIN_TEST_DATABASE_* variables, skipping the test if they aren't set
+func NewTestDataHandler(t *testing.T) *testDataHandler {
+	t.Helper()
 	host, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_HOST")
 	if !found {
-		panic("HPCADMIN_TEST_DATABASE_HOST not set")
+		t.Skip("HPCADMIN_TEST_DATABASE_HOST not set, skipping postgres tests")
 	}
 	portStr, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_PORT")
 	if !found {
-		panic("HPCADMIN_TEST_DATABASE_PORT not set")
+		t.Fatal("HPCADMIN_TEST_DATABASE_PORT not set")
 	}
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		panic("HPCADMIN_TEST_DATABASE_PORT not an integer")
+		t.Fatal("HPCADMIN_TEST_DATABASE_PORT not an integer")
 	}
 	user, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_USERNAME")
 	if !found {
-		panic("HPCADMIN_TEST_DATABASE_USERNAME not set")
+		t.Fatal("HPCADMIN_TEST_DATABASE_USERNAME not set")
 	}
 	password, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_PASSWORD")
 	if !found {
-		panic("HPCADMIN_TEST_DATABASE_PASSWORD not set")
+		t.Fatal("HPCADMIN_TEST_DATABASE_PASSWORD not set")
 	}
 	dbname, found := os.LookupEnv("HPCADMIN_TEST_DATABASE_NAME")
 	if !found {
-		panic("HPCADMIN_TEST_DATABASE_NAME not set")
+		t.Fatal("HPCADMIN_TEST_DATABASE_NAME not set")
 	}
 	dbr := DBRequest{
-		Host:       host,
-		Port:       port,
-		User:       user,
-		Password:   password,
-		DBName:     dbname,
-		DisableSSL: true,
+		Host:     host,
+		Port:     port,
+		User:     user,
+		Password: password,
+		DBName:   dbname,
+		SSLMode:  "disable",
 	}
 	db, err := NewDBConn(dbr)
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { db.Close() })
 	return &testDataHandler{
 		DB: db,
 	}
 }
+
+func TestConnString(t *testing.T) {
+	t.Run("EscapesPassword", func(t *testing.T) {
+		dbr := DBRequest{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "hpcadmin",
+			Password: "superfancytestpasswordthatnobodyknows&@/?#",
+			DBName:   "hpcadmin_test",
+			SSLMode:  "disable",
+		}
+		u, err := url.Parse(dbr.ConnString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		password, _ := u.User.Password()
+		if password != dbr.Password {
+			t.Fatalf("expected password %v got %v", dbr.Password, password)
+		}
+		if u.Host != "localhost:5432" {
+			t.Fatalf("expected host localhost:5432 got %v", u.Host)
+		}
+		if u.Query().Get("sslmode") != "disable" {
+			t.Fatalf("expected sslmode disable got %v", u.Query().Get("sslmode"))
+		}
+	})
+	t.Run("CertificatePaths", func(t *testing.T) {
+		dbr := DBRequest{
+			Host:        "db.example.org",
+			Port:        6432,
+			User:        "hpcadmin",
+			Password:    "password",
+			DBName:      "hpcadmin",
+			SSLMode:     "verify-full",
+			SSLRootCert: "/etc/hpcadmin-server/ca.pem",
+			SSLCert:     "/etc/hpcadmin-server/client.pem",
+			SSLKey:      "/etc/hpcadmin-server/client.key",
+		}
+		u, err := url.Parse(dbr.ConnString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		if q.Get("sslrootcert") != dbr.SSLRootCert || q.Get("sslcert") != dbr.SSLCert || q.Get("sslkey") != dbr.SSLKey {
+			t.Fatalf("expected certificate paths in connection string, got %v", u.RawQuery)
+		}
+	})
+	t.Run("DefaultSSLMode", func(t *testing.T) {
+		dbr := DBRequest{Host: "localhost", User: "hpcadmin", DBName: "hpcadmin"}
+		u, err := url.Parse(dbr.ConnString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Query().Get("sslmode") != "disable" {
+			t.Fatalf("expected sslmode to default to disable, got %v", u.Query().Get("sslmode"))
+		}
+	})
+	t.Run("DSNOverride", func(t *testing.T) {
+		dbr := DBRequest{
+			Host: "ignored",
+			DSN:  "host=/var/run/postgresql dbname=hpcadmin",
+		}
+		if dbr.ConnString() != dbr.DSN {
+			t.Fatalf("expected DSN override %v got %v", dbr.DSN, dbr.ConnString())
+		}
+	})
+}
+
+func TestGetSchemaVersion(t *testing.T) {
+	th := NewTestDataHandler(t)
+	version, dirty, err := GetSchemaVersion(context.Background(), th.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirty {
+		t.Errorf("expected clean schema at version %d", version)
+	}
+	if version < 1 {
+		t.Errorf("expected a migrated schema, got version %d", version)
+	}
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// DirectorySnapshot is the content of an LDIF export. Entries is json
+// encoded by the export package, the store only keeps it.
+type DirectorySnapshot struct {
+	Id        int
+	Digest    string
+	Entries   []byte
+	CreatedAt time.Time
+}
+
+const directorySnapshotColumns = "id, digest, entries, created_at"
+
+func scanDirectorySnapshot(row interface{ Scan(...any) error }) (*DirectorySnapshot, error) {
+	var snapshot DirectorySnapshot
+	if err := row.Scan(&snapshot.Id, &snapshot.Digest, &snapshot.Entries, &snapshot.CreatedAt); err != nil {
+		return nil, mapError(err)
+	}
+	return &snapshot, nil
+}
+
+// SaveDirectorySnapshot saves the entries unless the latest snapshot has the
+// same digest, in which case that one is returned instead
+func (s *PostgresStore) SaveDirectorySnapshot(ctx context.Context, digest string, entries []byte) (*DirectorySnapshot, error) {
+	ctx, span := startSpan(ctx, "SaveDirectorySnapshot")
+	defer span.End()
+	slog.DebugContext(ctx, "saving directory snapshot in database", "digest", digest, "package", "data", "method", "SaveDirectorySnapshot")
+	var snapshot *DirectorySnapshot
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		// servers exporting at the same time would save the same snapshot twice
+		if _, err := tx.q.ExecContext(ctx, "LOCK TABLE directory_snapshots IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		latest, err := scanDirectorySnapshot(tx.q.QueryRowContext(ctx, "SELECT "+directorySnapshotColumns+" FROM directory_snapshots ORDER BY created_at DESC, id DESC LIMIT 1"))
+		if err == nil && latest.Digest == digest {
+			snapshot = latest
+			return nil
+		}
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		// created_at is compared with times from the server, so it's not left to NOW()
+		snapshot, err = scanDirectorySnapshot(tx.q.QueryRowContext(ctx, "INSERT INTO directory_snapshots (digest, entries, created_at) VALUES ($1, $2, $3) RETURNING "+directorySnapshotColumns,
+			digest, entries, time.Now().UTC()))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// GetDirectorySnapshot returns the latest snapshot saved at or before the
+// time, or ErrNotFound if there isn't one
+func (s *PostgresStore) GetDirectorySnapshot(ctx context.Context, at time.Time) (*DirectorySnapshot, error) {
+	ctx, span := startSpan(ctx, "GetDirectorySnapshot")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for directory snapshot", "at", at, "package", "data", "method", "GetDirectorySnapshot")
+	return scanDirectorySnapshot(s.q.QueryRowContext(ctx, "SELECT "+directorySnapshotColumns+" FROM directory_snapshots WHERE created_at <= $1 ORDER BY created_at DESC, id DESC LIMIT 1", at.UTC()))
+}
+
+// PruneDirectorySnapshots deletes the snapshots saved before the time,
+// except for the latest of them, which is still the snapshot at the time.
+// It returns how many were deleted.
+func (s *PostgresStore) PruneDirectorySnapshots(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := startSpan(ctx, "PruneDirectorySnapshots")
+	defer span.End()
+	slog.DebugContext(ctx, "pruning directory snapshots in database", "before", before, "package", "data", "method", "PruneDirectorySnapshots")
+	res, err := s.q.ExecContext(ctx, `DELETE FROM directory_snapshots WHERE created_at < $1 AND id <> (
+		SELECT id FROM directory_snapshots WHERE created_at < $1 ORDER BY created_at DESC, id DESC LIMIT 1
+	)`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PirgGroup is a unix group within a pirg, such as one for a lab's
+// students. Its users are always members of the pirg.
+type PirgGroup struct {
+	Id      int
+	PirgId  int
+	Name    string
+	Gid     *int
+	UserIds []int
+	// CreatedAt and ModifiedAt are the times of the group row itself
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+}
+
+type PirgGroupRequest struct {
+	Name    string
+	Gid     *int
+	UserIds []int
+}
+
+// validatePirgGroupRequest is shared by every store implementation
+func validatePirgGroupRequest(pirg *Pirg, gr *PirgGroupRequest) error {
+	if gr.Name == "" || strings.ContainsAny(gr.Name, ":,\n\t ") {
+		return fmt.Errorf("invalid group name %q", gr.Name)
+	}
+	if gr.Gid != nil && *gr.Gid <= 0 {
+		return fmt.Errorf("gid must be positive")
+	}
+	for _, userId := range gr.UserIds {
+		if !slices.Contains(pirg.UserIds, userId) {
+			return fmt.Errorf("user %d isn't a member of pirg %s", userId, pirg.Name)
+		}
+	}
+	return nil
+}
+
+const pirgGroupColumns = "id, pirg_id, name, gid, created_at, modified_at"
+
+func (s *PostgresStore) queryPirgGroups(ctx context.Context, where string, args ...any) ([]*PirgGroup, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT "+pirgGroupColumns+" FROM pirgs_groups "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*PirgGroup
+	for rows.Next() {
+		group, err := scanPirgGroup(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// members are loaded once the rows are closed, a transaction can only run one query at a time
+	for _, group := range groups {
+		group.UserIds, err = s.queryIds(ctx, "SELECT user_id FROM groups_users WHERE group_id = $1 ORDER BY user_id", group.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func scanPirgGroup(row interface{ Scan(...any) error }) (*PirgGroup, error) {
+	var group PirgGroup
+	var gid sql.NullInt64
+	if err := row.Scan(&group.Id, &group.PirgId, &group.Name, &gid, &group.CreatedAt, &group.ModifiedAt); err != nil {
+		return nil, mapError(err)
+	}
+	group.Gid = nullIntPtr(gid)
+	return &group, nil
+}
+
+// GetAllPirgGroups returns the groups of every pirg, ordered by id
+func (s *PostgresStore) GetAllPirgGroups(ctx context.Context) ([]*PirgGroup, error) {
+	ctx, span := startSpan(ctx, "GetAllPirgGroups")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all pirg groups from database", "package", "data", "method", "GetAllPirgGroups")
+	return s.queryPirgGroups(ctx, "")
+}
+
+// GetPirgGroups returns the groups of the pirg, ordered by id
+func (s *PostgresStore) GetPirgGroups(ctx context.Context, pirgId int) ([]*PirgGroup, error) {
+	ctx, span := startSpan(ctx, "GetPirgGroups")
+	defer span.End()
+	slog.DebugContext(ctx, "getting pirg groups from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgGroups")
+	return s.queryPirgGroups(ctx, "WHERE pirg_id = $1", pirgId)
+}
+
+func (s *PostgresStore) GetPirgGroupById(ctx context.Context, id int) (*PirgGroup, error) {
+	ctx, span := startSpan(ctx, "GetPirgGroupById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for pirg group", "id", id, "package", "data", "method", "GetPirgGroupById")
+	groups, err := s.queryPirgGroups(ctx, "WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, ErrNotFound
+	}
+	return groups[0], nil
+}
+
+// CreatePirgGroup adds a group to the pirg with its members in a single transaction
+func (s *PostgresStore) CreatePirgGroup(ctx context.Context, pirgId int, gr *PirgGroupRequest) (*PirgGroup, error) {
+	ctx, span := startSpan(ctx, "CreatePirgGroup")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new pirg group in database", "pirg_id", pirgId, "name", gr.Name, "package", "data", "method", "CreatePirgGroup")
+	var group *PirgGroup
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		pirg, err := tx.GetPirgById(ctx, pirgId)
+		if err != nil {
+			return err
+		}
+		if err := validatePirgGroupRequest(pirg, gr); err != nil {
+			return err
+		}
+		var id int
+		err = tx.q.QueryRowContext(ctx, "INSERT INTO pirgs_groups (pirg_id, name, gid) VALUES ($1, $2, $3) RETURNING id", pirgId, gr.Name, gr.Gid).Scan(&id)
+		if err != nil {
+			return mapError(err)
+		}
+		if err := tx.setPirgGroupUsers(ctx, pirgId, id, gr.UserIds); err != nil {
+			return err
+		}
+		group, err = tx.GetPirgGroupById(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// UpdatePirgGroup renames the group, changes its gid and replaces its members
+func (s *PostgresStore) UpdatePirgGroup(ctx context.Context, id int, gr *PirgGroupRequest) (*PirgGroup, error) {
+	ctx, span := startSpan(ctx, "UpdatePirgGroup")
+	defer span.End()
+	slog.DebugContext(ctx, "updating pirg group in database", "id", id, "package", "data", "method", "UpdatePirgGroup")
+	var group *PirgGroup
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		existing, err := tx.GetPirgGroupById(ctx, id)
+		if err != nil {
+			return err
+		}
+		pirg, err := tx.GetPirgById(ctx, existing.PirgId)
+		if err != nil {
+			return err
+		}
+		if err := validatePirgGroupRequest(pirg, gr); err != nil {
+			return err
+		}
+		res, err := tx.q.ExecContext(ctx, "UPDATE pirgs_groups SET name = $1, gid = $2 WHERE id = $3", gr.Name, gr.Gid, id)
+		if err := checkAffectedRows(res, err); err != nil {
+			return err
+		}
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM groups_users WHERE group_id = $1", id); err != nil {
+			return err
+		}
+		if err := tx.setPirgGroupUsers(ctx, existing.PirgId, id, gr.UserIds); err != nil {
+			return err
+		}
+		group, err = tx.GetPirgGroupById(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *PostgresStore) setPirgGroupUsers(ctx context.Context, pirgId int, groupId int, userIds []int) error {
+	for _, userId := range uniqueIds(userIds) {
+		_, err := s.q.ExecContext(ctx, "INSERT INTO groups_users (group_id, pirg_id, user_id) VALUES ($1, $2, $3)", groupId, pirgId, userId)
+		if err != nil {
+			return mapError(err)
+		}
+	}
+	return nil
+}
+
+// DeletePirgGroup removes the group and its memberships
+func (s *PostgresStore) DeletePirgGroup(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeletePirgGroup")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting pirg group from database", "id", id, "package", "data", "method", "DeletePirgGroup")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM pirgs_groups WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SlurmLimits are the limits set on a slurm association. Nil and empty
+// values leave the limit unset.
+type SlurmLimits struct {
+	Fairshare *int
+	// GrpTRES is a comma separated list of TRES, such as "cpu=100,mem=500G,gres/gpu=4"
+	GrpTRES string
+	MaxJobs *int
+	// MaxWall is a slurm time limit, such as "2-00:00:00"
+	MaxWall string
+}
+
+func (l *SlurmLimits) empty() bool {
+	return l.Fairshare == nil && l.GrpTRES == "" && l.MaxJobs == nil && l.MaxWall == ""
+}
+
+// MemberLimits are the limits on a single member's association in the pirg
+type MemberLimits struct {
+	UserId int
+	SlurmLimits
+}
+
+// PirgLimits are the limits on the pirg's account and on its members
+type PirgLimits struct {
+	PirgId int
+	SlurmLimits
+	Members []MemberLimits
+}
+
+// tresPattern matches a single TRES limit. Gres can have a type such as
+// gpu:a100, the colon is safe because the export single quotes the list.
+var tresPattern = regexp.MustCompile(`^(cpu|mem|energy|node|billing|vmem|pages|gres/[A-Za-z0-9_.-]+(:[A-Za-z0-9_.-]+)?|(license|bb|fs|ic)/[A-Za-z0-9_.-]+)=(-1|[0-9]+[KMGTP]?)$`)
+
+// ValidateTRES checks a comma separated list of TRES limits
+func ValidateTRES(tres string) error {
+	var seen []string
+	for _, item := range strings.Split(tres, ",") {
+		if !tresPattern.MatchString(item) {
+			return fmt.Errorf("invalid tres %q, must look like cpu=100 or gres/gpu=4", item)
+		}
+		name, _, _ := strings.Cut(item, "=")
+		if slices.Contains(seen, name) {
+			return fmt.Errorf("tres %s is listed more than once", name)
+		}
+		seen = append(seen, name)
+	}
+	return nil
+}
+
+// WallMinutes parses a slurm time limit into minutes. It accepts "minutes",
+// "minutes:seconds", "hours:minutes:seconds", "days-hours",
+// "days-hours:minutes" and "days-hours:minutes:seconds". Seconds are
+// rounded up to the next minute, like slurm does.
+func WallMinutes(wall string) (int, error) {
+	invalid := fmt.Errorf("invalid time limit %q, must look like 90, 12:00:00 or 2-00:00:00", wall)
+	days := 0
+	rest := wall
+	if d, r, ok := strings.Cut(wall, "-"); ok {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 {
+			return 0, invalid
+		}
+		days, rest = n, r
+	}
+	var parts []int
+	for _, p := range strings.Split(rest, ":") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, invalid
+		}
+		parts = append(parts, n)
+	}
+	var hours, minutes, seconds int
+	switch {
+	case len(parts) > 3:
+		return 0, invalid
+	case wall != rest:
+		// days-hours[:minutes[:seconds]]
+		hours = parts[0]
+		if len(parts) > 1 {
+			minutes = parts[1]
+		}
+		if len(parts) > 2 {
+			seconds = parts[2]
+		}
+	case len(parts) == 1:
+		minutes = parts[0]
+	case len(parts) == 2:
+		minutes, seconds = parts[0], parts[1]
+	default:
+		hours, minutes, seconds = parts[0], parts[1], parts[2]
+	}
+	total := ((days*24+hours)*60 + minutes)
+	if seconds > 0 {
+		total += (seconds + 59) / 60
+	}
+	return total, nil
+}
+
+func validateSlurmLimits(l *SlurmLimits) error {
+	if l.Fairshare != nil && *l.Fairshare < 0 {
+		return fmt.Errorf("fairshare must not be negative")
+	}
+	if l.MaxJobs != nil && *l.MaxJobs < 0 {
+		return fmt.Errorf("max jobs must not be negative")
+	}
+	if l.GrpTRES != "" {
+		if err := ValidateTRES(l.GrpTRES); err != nil {
+			return err
+		}
+	}
+	if l.MaxWall != "" {
+		if _, err := WallMinutes(l.MaxWall); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePirgLimits checks the limits and makes sure members are users
+// of the pirg. It's shared by every store implementation.
+func validatePirgLimits(pirg *Pirg, pl *PirgLimits) error {
+	if err := validateSlurmLimits(&pl.SlurmLimits); err != nil {
+		return fmt.Errorf("pirg %s: %w", pirg.Name, err)
+	}
+	var seen []int
+	for _, member := range pl.Members {
+		if !slices.Contains(pirg.UserIds, member.UserId) {
+			return fmt.Errorf("user %d is not a user of pirg %s", member.UserId, pirg.Name)
+		}
+		if slices.Contains(seen, member.UserId) {
+			return fmt.Errorf("user %d is listed more than once", member.UserId)
+		}
+		seen = append(seen, member.UserId)
+		if err := validateSlurmLimits(&member.SlurmLimits); err != nil {
+			return fmt.Errorf("user %d: %w", member.UserId, err)
+		}
+	}
+	return nil
+}
+
+const limitColumns = "fairshare, grp_tres, max_jobs, max_wall"
+
+// scanLimits scans the limit columns following any other destinations
+func scanLimits(row interface{ Scan(...any) error }, l *SlurmLimits, dest ...any) error {
+	var fairshare, maxJobs sql.NullInt64
+	dest = append(dest, &fairshare, &l.GrpTRES, &maxJobs, &l.MaxWall)
+	if err := row.Scan(dest...); err != nil {
+		return mapError(err)
+	}
+	l.Fairshare = nullIntPtr(fairshare)
+	l.MaxJobs = nullIntPtr(maxJobs)
+	return nil
+}
+
+func nullIntPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
+
+// GetPirgLimits returns the limits on the pirg's account and on the
+// members that have their own
+func (s *PostgresStore) GetPirgLimits(ctx context.Context, pirgId int) (*PirgLimits, error) {
+	ctx, span := startSpan(ctx, "GetPirgLimits")
+	defer span.End()
+	slog.DebugContext(ctx, "getting slurm limits for pirg from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgLimits")
+	pl := &PirgLimits{PirgId: pirgId}
+	row := s.q.QueryRowContext(ctx, "SELECT "+limitColumns+" FROM pirgs WHERE id = $1", pirgId)
+	if err := scanLimits(row, &pl.SlurmLimits); err != nil {
+		return nil, err
+	}
+	rows, err := s.q.QueryContext(ctx, "SELECT user_id, "+limitColumns+` FROM pirgs_users
+		WHERE pirg_id = $1 AND (fairshare IS NOT NULL OR grp_tres <> '' OR max_jobs IS NOT NULL OR max_wall <> '')
+		ORDER BY user_id`, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member MemberLimits
+		if err := scanLimits(rows, &member.SlurmLimits, &member.UserId); err != nil {
+			return nil, err
+		}
+		pl.Members = append(pl.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// SetPirgLimits replaces the limits on the pirg's account and on its
+// members. Members that aren't listed have their limits cleared.
+func (s *PostgresStore) SetPirgLimits(ctx context.Context, pirgId int, pl *PirgLimits) (*PirgLimits, error) {
+	ctx, span := startSpan(ctx, "SetPirgLimits")
+	defer span.End()
+	slog.DebugContext(ctx, "setting slurm limits for pirg in database", "pirg_id", pirgId, "package", "data", "method", "SetPirgLimits")
+	pirg, err := s.GetPirgById(ctx, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePirgLimits(pirg, pl); err != nil {
+		return nil, err
+	}
+	err = s.withTx(ctx, func(tx *PostgresStore) error {
+		_, err := tx.q.ExecContext(ctx, "UPDATE pirgs SET fairshare = $1, grp_tres = $2, max_jobs = $3, max_wall = $4 WHERE id = $5",
+			pl.Fairshare, pl.GrpTRES, pl.MaxJobs, pl.MaxWall, pirgId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.q.ExecContext(ctx, "UPDATE pirgs_users SET fairshare = NULL, grp_tres = '', max_jobs = NULL, max_wall = '' WHERE pirg_id = $1", pirgId)
+		if err != nil {
+			return err
+		}
+		for _, member := range pl.Members {
+			_, err := tx.q.ExecContext(ctx, "UPDATE pirgs_users SET fairshare = $1, grp_tres = $2, max_jobs = $3, max_wall = $4 WHERE pirg_id = $5 AND user_id = $6",
+				member.Fairshare, member.GrpTRES, member.MaxJobs, member.MaxWall, pirgId, member.UserId)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetPirgLimits(ctx, pirgId)
+}
@@ -0,0 +1,43 @@
+package data
+
+import "testing"
+
+func TestValidateTRES(t *testing.T) {
+	for _, tres := range []string{"cpu=100", "cpu=100,mem=500G,gres/gpu=4", "node=-1", "license/matlab=2,billing=1000", "gres/gpu:a100=2,gres/gpu=4"} {
+		if err := ValidateTRES(tres); err != nil {
+			t.Errorf("expected %q to be valid, got %v", tres, err)
+		}
+	}
+	for _, tres := range []string{"", "cpus=100", "cpu", "cpu=lots", "cpu=1,cpu=2", "license/matlab:r2024=2", "gres/gpu:=2", "gres/gpu:a100:2=1", "cpu=100,"} {
+		if err := ValidateTRES(tres); err == nil {
+			t.Errorf("expected %q to be invalid", tres)
+		}
+	}
+}
+
+func TestWallMinutes(t *testing.T) {
+	tests := map[string]int{
+		"90":         90,
+		"90:30":      91,
+		"12:00:00":   720,
+		"2-00":       2880,
+		"1-12:30":    2190,
+		"2-00:00:00": 2880,
+		"0:0:1":      1,
+	}
+	for wall, want := range tests {
+		got, err := WallMinutes(wall)
+		if err != nil {
+			t.Errorf("parsing %q: %v", wall, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("expected %q to be %d minutes, got %d", wall, want, got)
+		}
+	}
+	for _, wall := range []string{"", "-1", "1-", "a:b", "1:2:3:4", "1-2:3:4:5", "UNLIMITED"} {
+		if _, err := WallMinutes(wall); err == nil {
+			t.Errorf("expected %q to be invalid", wall)
+		}
+	}
+}
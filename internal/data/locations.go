@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LocationKinds are the kinds of locations that can be tracked
+var LocationKinds = []string{"site", "datacenter", "cluster", "filesystem"}
+
+type Location struct {
+	Id          int
+	Name        string
+	Kind        string
+	ParentId    *int
+	Description string
+	CreatedAt   time.Time
+	ModifiedAt  time.Time
+}
+
+type LocationRequest struct {
+	Name        string
+	Kind        string
+	ParentId    *int
+	Description string
+}
+
+const locationColumns = "id, name, kind, parent_id, description, created_at, modified_at"
+
+func scanLocation(row interface{ Scan(...any) error }) (*Location, error) {
+	var loc Location
+	var parentId sql.NullInt64
+	err := row.Scan(&loc.Id, &loc.Name, &loc.Kind, &parentId, &loc.Description, &loc.CreatedAt, &loc.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if parentId.Valid {
+		id := int(parentId.Int64)
+		loc.ParentId = &id
+	}
+	return &loc, nil
+}
+
+func (s *PostgresStore) GetAllLocations(ctx context.Context) ([]*Location, error) {
+	ctx, span := startSpan(ctx, "GetAllLocations")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all locations from database", "package", "data", "method", "GetAllLocations")
+	var locations []*Location
+	rows, err := s.q.QueryContext(ctx, "SELECT "+locationColumns+" FROM locations ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		loc, err := scanLocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, loc)
+	}
+	return locations, rows.Err()
+}
+
+func (s *PostgresStore) GetLocationById(ctx context.Context, id int) (*Location, error) {
+	ctx, span := startSpan(ctx, "GetLocationById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for location by id", "id", id, "package", "data", "method", "GetLocationById")
+	return scanLocation(s.q.QueryRowContext(ctx, "SELECT "+locationColumns+" FROM locations WHERE id = $1", id))
+}
+
+func (s *PostgresStore) CreateLocation(ctx context.Context, lr *LocationRequest) (*Location, error) {
+	ctx, span := startSpan(ctx, "CreateLocation")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new location in database", "package", "data", "method", "CreateLocation")
+	if lr.ParentId != nil {
+		if _, err := s.GetLocationById(ctx, *lr.ParentId); err != nil {
+			return nil, fmt.Errorf("parent location does not exist with id: %d", *lr.ParentId)
+		}
+	}
+	row := s.q.QueryRowContext(ctx, "INSERT INTO locations (name, kind, parent_id, description) VALUES ($1, $2, $3, $4) RETURNING "+locationColumns, lr.Name, lr.Kind, lr.ParentId, lr.Description)
+	return scanLocation(row)
+}
+
+func (s *PostgresStore) UpdateLocation(ctx context.Context, id int, lr *LocationRequest) (*Location, error) {
+	ctx, span := startSpan(ctx, "UpdateLocation")
+	defer span.End()
+	slog.DebugContext(ctx, "updating location in database", "id", id, "package", "data", "method", "UpdateLocation")
+	if lr.ParentId != nil {
+		// the new parent can't be the location itself or anything below it
+		descendants, err := s.getLocationSubtreeIds(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range descendants {
+			if d == *lr.ParentId {
+				return nil, fmt.Errorf("location %d can't be its own ancestor", id)
+			}
+		}
+		if _, err := s.GetLocationById(ctx, *lr.ParentId); err != nil {
+			return nil, fmt.Errorf("parent location does not exist with id: %d", *lr.ParentId)
+		}
+	}
+	res, err := s.q.ExecContext(ctx, "UPDATE locations SET name = $1, kind = $2, parent_id = $3, description = $4 WHERE id = $5", lr.Name, lr.Kind, lr.ParentId, lr.Description, id)
+	if err = checkAffectedRows(res, err); err != nil {
+		return nil, err
+	}
+	return s.GetLocationById(ctx, id)
+}
+
+// DeleteLocation removes a location. Locations that still have child
+// locations or storage allocations can't be deleted.
+func (s *PostgresStore) DeleteLocation(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteLocation")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting location from database", "id", id, "package", "data", "method", "DeleteLocation")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM locations WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+// GetLocationPirgs returns the pirgs that have storage allocated at the
+// location or anywhere below it
+func (s *PostgresStore) GetLocationPirgs(ctx context.Context, id int) ([]*Pirg, error) {
+	ctx, span := startSpan(ctx, "GetLocationPirgs")
+	defer span.End()
+	slog.DebugContext(ctx, "getting pirgs for location from database", "id", id, "package", "data", "method", "GetLocationPirgs")
+	pirgIds, err := s.queryIds(ctx, locationSubtreeQuery+`
+		SELECT DISTINCT sa.pirg_id FROM storage_allocations sa
+		JOIN subtree s ON sa.location_id = s.id
+		ORDER BY sa.pirg_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.getPirgsById(ctx, pirgIds)
+}
+
+// locationSubtreeQuery selects the location passed as $1 and all of its descendants as "subtree"
+const locationSubtreeQuery = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM locations WHERE id = $1
+		UNION
+		SELECT l.id FROM locations l JOIN subtree s ON l.parent_id = s.id
+	)`
+
+// getLocationSubtreeIds returns the id of the location and all of its descendants
+func (s *PostgresStore) getLocationSubtreeIds(ctx context.Context, id int) ([]int, error) {
+	return s.queryIds(ctx, locationSubtreeQuery+" SELECT id FROM subtree", id)
+}
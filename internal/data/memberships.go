@@ -0,0 +1,250 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Membership is a user's membership in a pirg. A nil StartsAt or ExpiresAt
+// leaves that end of the term open.
+type Membership struct {
+	PirgId    int
+	UserId    int
+	StartsAt  *time.Time
+	ExpiresAt *time.Time
+}
+
+// Active reports whether the membership's term includes now
+func (m *Membership) Active(now time.Time) bool {
+	if m.StartsAt != nil && now.Before(*m.StartsAt) {
+		return false
+	}
+	return m.ExpiresAt == nil || now.Before(*m.ExpiresAt)
+}
+
+// MembershipTerm bounds a membership. Nil times leave that end open.
+type MembershipTerm struct {
+	StartsAt  *time.Time
+	ExpiresAt *time.Time
+}
+
+// AuditMembershipExpired is logged when the sweeper removes an expired membership
+const AuditMembershipExpired = "membership_expired"
+
+// AuditActorSweeper is the actor of changes made by the background sweeper
+const AuditActorSweeper = "sweeper"
+
+// AuditEntry records a change the server made on its own. PirgId and
+// UserId may name rows that have since been deleted.
+type AuditEntry struct {
+	Id        int
+	Action    string
+	Actor     string
+	PirgId    *int
+	UserId    *int
+	Detail    string
+	CreatedAt time.Time
+}
+
+// validateMembershipTerm is shared by every store implementation
+func validateMembershipTerm(pirg *Pirg, userId int, term *MembershipTerm) error {
+	if term.StartsAt != nil && term.ExpiresAt != nil && !term.ExpiresAt.After(*term.StartsAt) {
+		return fmt.Errorf("membership must expire after it starts")
+	}
+	if userId == pirg.OwnerId && (term.StartsAt != nil || term.ExpiresAt != nil) {
+		return fmt.Errorf("the owner's membership of pirg %s can't have a term", pirg.Name)
+	}
+	return nil
+}
+
+// utcTime converts an optional time for a TIMESTAMP column, which doesn't keep the zone
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func nullTimePtr(n sql.NullTime) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := n.Time
+	return &t
+}
+
+const membershipColumns = "pirg_id, user_id, starts_at, expires_at"
+
+func scanMemberships(rows *sql.Rows) ([]*Membership, error) {
+	defer rows.Close()
+	var memberships []*Membership
+	for rows.Next() {
+		var m Membership
+		var startsAt, expiresAt sql.NullTime
+		if err := rows.Scan(&m.PirgId, &m.UserId, &startsAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		m.StartsAt, m.ExpiresAt = nullTimePtr(startsAt), nullTimePtr(expiresAt)
+		memberships = append(memberships, &m)
+	}
+	return memberships, rows.Err()
+}
+
+// GetPirgMemberships returns the memberships of the pirg's users, ordered by user id
+func (s *PostgresStore) GetPirgMemberships(ctx context.Context, pirgId int) ([]*Membership, error) {
+	ctx, span := startSpan(ctx, "GetPirgMemberships")
+	defer span.End()
+	slog.DebugContext(ctx, "getting pirg memberships from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgMemberships")
+	if _, err := s.GetPirgById(ctx, pirgId); err != nil {
+		return nil, err
+	}
+	rows, err := s.q.QueryContext(ctx, "SELECT "+membershipColumns+" FROM pirgs_users WHERE pirg_id = $1 ORDER BY user_id", pirgId)
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+// GetAllMemberships returns the memberships of every pirg, ordered by pirg
+// id then user id
+func (s *PostgresStore) GetAllMemberships(ctx context.Context) ([]*Membership, error) {
+	ctx, span := startSpan(ctx, "GetAllMemberships")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all memberships from database", "package", "data", "method", "GetAllMemberships")
+	rows, err := s.q.QueryContext(ctx, "SELECT "+membershipColumns+" FROM pirgs_users ORDER BY pirg_id, user_id")
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+// SetMembershipTerm sets when the user's membership of the pirg starts and expires
+func (s *PostgresStore) SetMembershipTerm(ctx context.Context, pirgId int, userId int, term *MembershipTerm) (*Membership, error) {
+	ctx, span := startSpan(ctx, "SetMembershipTerm")
+	defer span.End()
+	slog.DebugContext(ctx, "setting membership term in database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "SetMembershipTerm")
+	pirg, err := s.GetPirgById(ctx, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMembershipTerm(pirg, userId, term); err != nil {
+		return nil, err
+	}
+	rows, err := s.q.QueryContext(ctx, "UPDATE pirgs_users SET starts_at = $1, expires_at = $2 WHERE pirg_id = $3 AND user_id = $4 RETURNING "+membershipColumns,
+		utcTime(term.StartsAt), utcTime(term.ExpiresAt), pirgId, userId)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := scanMemberships(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return nil, fmt.Errorf("user %d isn't a member of pirg %s: %w", userId, pirg.Name, ErrNotFound)
+	}
+	return memberships[0], nil
+}
+
+// GetExpiringMemberships returns the memberships that expire by the given
+// time, including ones that already have, soonest first. Owners
+// never expire so they're left out.
+func (s *PostgresStore) GetExpiringMemberships(ctx context.Context, by time.Time) ([]*Membership, error) {
+	ctx, span := startSpan(ctx, "GetExpiringMemberships")
+	defer span.End()
+	slog.DebugContext(ctx, "getting expiring memberships from database", "by", by, "package", "data", "method", "GetExpiringMemberships")
+	rows, err := s.q.QueryContext(ctx, `SELECT pu.pirg_id, pu.user_id, pu.starts_at, pu.expires_at
+		FROM pirgs_users pu JOIN pirgs p ON p.id = pu.pirg_id
+		WHERE pu.expires_at <= $1 AND pu.user_id != p.owner_id
+		ORDER BY pu.expires_at, pu.pirg_id, pu.user_id`, by.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+// RemoveExpiredMemberships takes the users whose membership expired by now
+// out of their pirgs and writes an audit entry for each, in a single
+// transaction. It returns the memberships that were removed.
+func (s *PostgresStore) RemoveExpiredMemberships(ctx context.Context, now time.Time) ([]*Membership, error) {
+	ctx, span := startSpan(ctx, "RemoveExpiredMemberships")
+	defer span.End()
+	slog.DebugContext(ctx, "removing expired memberships from database", "now", now, "package", "data", "method", "RemoveExpiredMemberships")
+	var expired []*Membership
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		var err error
+		expired, err = tx.GetExpiringMemberships(ctx, now)
+		if err != nil {
+			return err
+		}
+		for _, m := range expired {
+			pirg, err := tx.GetPirgById(ctx, m.PirgId)
+			if err != nil {
+				return err
+			}
+			user, err := tx.GetUserById(ctx, m.UserId)
+			if err != nil {
+				return err
+			}
+			if err = tx.removePirgMember(ctx, m.PirgId, m.UserId); err != nil {
+				return err
+			}
+			_, err = tx.q.ExecContext(ctx, "INSERT INTO audit_log (action, actor, pirg_id, user_id, detail) VALUES ($1, $2, $3, $4, $5)",
+				AuditMembershipExpired, AuditActorSweeper, m.PirgId, m.UserId, expiredDetail(pirg, user, m))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// GetAuditLog returns the latest audit entries, newest first
+func (s *PostgresStore) GetAuditLog(ctx context.Context, limit int) ([]*AuditEntry, error) {
+	ctx, span := startSpan(ctx, "GetAuditLog")
+	defer span.End()
+	slog.DebugContext(ctx, "getting audit log from database", "limit", limit, "package", "data", "method", "GetAuditLog")
+	rows, err := s.q.QueryContext(ctx, "SELECT id, action, actor, pirg_id, user_id, detail, created_at FROM audit_log ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var pirgId, userId sql.NullInt64
+		if err := rows.Scan(&e.Id, &e.Action, &e.Actor, &pirgId, &userId, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.PirgId, e.UserId = nullIntPtr(pirgId), nullIntPtr(userId)
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// expiredDetail describes a membership removed by the sweeper in its audit entry
+func expiredDetail(pirg *Pirg, user *User, m *Membership) string {
+	return fmt.Sprintf("removed %s from %s, membership expired %s", user.Username, pirg.Name, m.ExpiresAt.Format(time.RFC3339))
+}
+
+// sortExpiringMemberships orders memberships like postgres returns expiring ones
+func sortExpiringMemberships(memberships []*Membership) {
+	sort.Slice(memberships, func(i, j int) bool {
+		a, b := memberships[i], memberships[j]
+		if !a.ExpiresAt.Equal(*b.ExpiresAt) {
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		}
+		if a.PirgId != b.PirgId {
+			return a.PirgId < b.PirgId
+		}
+		return a.UserId < b.UserId
+	})
+}
@@ -0,0 +1,2372 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements Store in memory with the same semantics as
+// PostgresStore, including the cascade and restrict rules of the schema.
+// It is meant for tests and local development.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// txMu lets only one transaction run at a time
+	txMu sync.Mutex
+	memoryState
+}
+
+// memoryState is everything a transaction rolls back
+type memoryState struct {
+	// seq holds the last id handed out for each table
+	seq map[string]int
+
+	users     map[int]*User
+	pirgs     map[int]*Pirg
+	apiKeys   map[string]*APIKeyEntry
+	locations map[int]*Location
+	storage   map[int]*StorageAllocation
+	clusters  map[int]*Cluster
+	// clusterPirgs is keyed by cluster id and pirg id
+	clusterPirgs map[[2]int]*ClusterPirg
+	qos          map[int]*QOS
+	partitions   map[int]*Partition
+	// pirgSlurm and pirgLimits are keyed by pirg id
+	pirgSlurm  map[int]*PirgSlurm
+	pirgLimits map[int]*PirgLimits
+	compute    map[int]*ComputeAllocation
+	// storageUsage is kept in the order it was recorded
+	storageUsage []*StorageUsage
+	reviews      map[int]*AccessReview
+	// reviewItems is keyed by review id
+	reviewItems map[int][]*AccessReviewItem
+	// usage is keyed by cluster id and job id
+	usage map[jobKey]*JobUsage
+	// terms is keyed by pirg id and user id, members without one aren't in it
+	terms map[[2]int]*MembershipTerm
+	audit []*AuditEntry
+	// notifications is kept in the order they were queued
+	notifications []*Notification
+	groups        map[int]*PirgGroup
+	// lastUnixIds is the highest uid and gid handed out, keyed by kind
+	lastUnixIds map[string]int
+	// snapshots is kept in the order they were saved
+	snapshots []*DirectorySnapshot
+}
+
+type jobKey struct {
+	clusterId int
+	jobId     string
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{memoryState: memoryState{
+		seq:       make(map[string]int),
+		users:     make(map[int]*User),
+		pirgs:     make(map[int]*Pirg),
+		apiKeys:   make(map[string]*APIKeyEntry),
+		locations: make(map[int]*Location),
+		storage:   make(map[int]*StorageAllocation),
+		clusters:  make(map[int]*Cluster),
+
+		clusterPirgs: make(map[[2]int]*ClusterPirg),
+		qos:          make(map[int]*QOS),
+		partitions:   make(map[int]*Partition),
+		pirgSlurm:    make(map[int]*PirgSlurm),
+		pirgLimits:   make(map[int]*PirgLimits),
+		compute:      make(map[int]*ComputeAllocation),
+		usage:        make(map[jobKey]*JobUsage),
+		reviews:      make(map[int]*AccessReview),
+		reviewItems:  make(map[int][]*AccessReviewItem),
+		terms:        make(map[[2]int]*MembershipTerm),
+		groups:       make(map[int]*PirgGroup),
+		lastUnixIds:  make(map[string]int),
+	}}
+}
+
+func (m *MemoryStore) nextId(table string) int {
+	m.seq[table]++
+	return m.seq[table]
+}
+
+// now matches the precision postgres stores timestamps with
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// sortedKeys returns the keys of an id-keyed map in ascending order
+func sortedKeys[T any](items map[int]T) []int {
+	keys := make([]int, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+//
+// Users
+//
+
+func copyUser(u *User) *User {
+	c := *u
+	c.Uid, c.SponsorId = copyIntPtr(u.Uid), copyIntPtr(u.SponsorId)
+	c.ExpiresAt, c.DeactivatedAt = storedTime(u.ExpiresAt), storedTime(u.DeactivatedAt)
+	return &c
+}
+
+func (m *MemoryStore) GetAllUsers(ctx context.Context) ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var users []*User
+	for _, id := range sortedKeys(m.users) {
+		users = append(users, copyUser(m.users[id]))
+	}
+	return users, nil
+}
+
+func (m *MemoryStore) CountUsers(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.users), nil
+}
+
+func (m *MemoryStore) GetUserById(ctx context.Context, id int) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (m *MemoryStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user := m.findUserByUsername(username)
+	if user == nil {
+		return nil, ErrNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (m *MemoryStore) findUserByUsername(username string) *User {
+	for _, user := range m.users {
+		if user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
+
+// checkUserUnique enforces the unique username and email columns, ignoring the user being updated
+func (m *MemoryStore) checkUserUnique(id int, user *UserRequest) error {
+	for _, existing := range m.users {
+		if existing.Id == id {
+			continue
+		}
+		if existing.Username == user.Username {
+			return fmt.Errorf("%w: user with username %s already exists", ErrConflict, user.Username)
+		}
+		if existing.Email == user.Email {
+			return fmt.Errorf("%w: user with email %s already exists", ErrConflict, user.Email)
+		}
+		if user.Uid != nil && sameIntPtr(existing.Uid, user.Uid) {
+			return fmt.Errorf("%w: user with uid %d already exists", ErrConflict, *user.Uid)
+		}
+	}
+	return nil
+}
+
+// sponsor looks up a user and whether they own a pirg. The user is nil
+// if they don't exist.
+func (m *MemoryStore) sponsor(id int) (*User, bool) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, false
+	}
+	for _, pirg := range m.pirgs {
+		if pirg.OwnerId == id {
+			return user, true
+		}
+	}
+	return user, false
+}
+
+func (m *MemoryStore) validateUser(userId int, user *UserRequest) error {
+	if err := validateUserRequest(userId, user); err != nil {
+		return err
+	}
+	if user.SponsorId == nil {
+		return nil
+	}
+	sponsor, owns := m.sponsor(*user.SponsorId)
+	return validateSponsor(*user.SponsorId, sponsor, owns)
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, user *UserRequest) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.validateUser(0, user); err != nil {
+		return nil, err
+	}
+	if err := m.checkUserUnique(0, user); err != nil {
+		return nil, err
+	}
+	ts := now()
+	newUser := &User{
+		Id:         m.nextId("users"),
+		Username:   user.Username,
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Uid:        copyIntPtr(user.Uid),
+		Type:       user.Type,
+		SponsorId:  copyIntPtr(user.SponsorId),
+		ExpiresAt:  storedTime(user.ExpiresAt),
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	m.users[newUser.Id] = newUser
+	return copyUser(newUser), nil
+}
+
+func (m *MemoryStore) UpdateUser(ctx context.Context, userId int, user *UserRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.users[userId]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := m.validateUser(userId, user); err != nil {
+		return err
+	}
+	if err := m.checkUserUnique(userId, user); err != nil {
+		return err
+	}
+	existing.Username = user.Username
+	existing.Email = user.Email
+	existing.FirstName = user.FirstName
+	existing.LastName = user.LastName
+	existing.Uid = copyIntPtr(user.Uid)
+	existing.Type = user.Type
+	existing.SponsorId = copyIntPtr(user.SponsorId)
+	existing.ExpiresAt = storedTime(user.ExpiresAt)
+	if user.reactivates(time.Now()) {
+		existing.DeactivatedAt = nil
+	}
+	existing.ModifiedAt = now()
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[id]; !ok {
+		return ErrNotFound
+	}
+	for _, pirg := range m.pirgs {
+		if pirg.OwnerId == id {
+			return fmt.Errorf("%w: user %d owns pirg %s", ErrConflict, id, pirg.Name)
+		}
+	}
+	for _, pirg := range m.pirgs {
+		pirg.AdminIds = removeId(pirg.AdminIds, id)
+		pirg.UserIds = removeId(pirg.UserIds, id)
+	}
+	for _, cp := range m.clusterPirgs {
+		cp.UserIds = removeId(cp.UserIds, id)
+	}
+	for _, group := range m.groups {
+		group.UserIds = removeId(group.UserIds, id)
+	}
+	for _, ps := range m.pirgSlurm {
+		ps.Members = slices.DeleteFunc(ps.Members, func(ms MemberSlurm) bool { return ms.UserId == id })
+	}
+	for _, pl := range m.pirgLimits {
+		pl.Members = slices.DeleteFunc(pl.Members, func(ml MemberLimits) bool { return ml.UserId == id })
+	}
+	for key := range m.terms {
+		if key[1] == id {
+			delete(m.terms, key)
+		}
+	}
+	for _, job := range m.usage {
+		if job.UserId != nil && *job.UserId == id {
+			job.UserId = nil
+		}
+	}
+	for reviewId, items := range m.reviewItems {
+		m.reviewItems[reviewId] = slices.DeleteFunc(items, func(item *AccessReviewItem) bool { return item.UserId == id })
+		for _, item := range items {
+			if item.DecidedBy != nil && *item.DecidedBy == id {
+				item.DecidedBy = nil
+			}
+		}
+	}
+	for key, entry := range m.apiKeys {
+		if entry.UserId == id {
+			delete(m.apiKeys, key)
+		}
+	}
+	for _, user := range m.users {
+		if user.SponsorId != nil && *user.SponsorId == id {
+			user.SponsorId = nil
+		}
+	}
+	delete(m.users, id)
+	return nil
+}
+
+func (m *MemoryStore) DeactivateGuests(ctx context.Context, asOf time.Time) ([]*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deactivated []*User
+	for _, id := range sortedKeys(m.users) {
+		guest := m.users[id]
+		if guest.Type != UserGuest || !guest.Active() {
+			continue
+		}
+		var sponsor *User
+		var owns bool
+		if guest.SponsorId != nil {
+			sponsor, owns = m.sponsor(*guest.SponsorId)
+		}
+		reason := guestDeactivation(guest, sponsor, owns, asOf)
+		if reason == "" {
+			continue
+		}
+		guest.DeactivatedAt = storedTime(&asOf)
+		m.audit = append(m.audit, &AuditEntry{
+			Id:        m.nextId("audit_log"),
+			Action:    AuditGuestDeactivated,
+			Actor:     AuditActorSweeper,
+			UserId:    copyIntPtr(&guest.Id),
+			Detail:    deactivatedDetail(guest, reason),
+			CreatedAt: now(),
+		})
+		deactivated = append(deactivated, copyUser(guest))
+	}
+	return deactivated, nil
+}
+
+// removeId returns ids without id, or nil if nothing is left
+func removeId(ids []int, id int) []int {
+	var kept []int
+	for _, i := range ids {
+		if i != id {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+//
+// API Keys
+//
+
+func (m *MemoryStore) GetAPIKeyEntry(ctx context.Context, key string) (*APIKeyEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.apiKeys[key]
+	if !ok || !m.users[entry.UserId].Active() {
+		return nil, ErrNotFound
+	}
+	c := *entry
+	return &c, nil
+}
+
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, key *APIKeyRequest) (*APIKeyEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.apiKeys[key.Key]; ok {
+		return nil, fmt.Errorf("%w: api key already exists", ErrConflict)
+	}
+	if _, ok := m.users[key.UserId]; !ok {
+		return nil, fmt.Errorf("%w: user does not exist with id: %d", ErrConflict, key.UserId)
+	}
+	ts := now()
+	entry := &APIKeyEntry{
+		Key:        key.Key,
+		Role:       key.Role,
+		UserId:     key.UserId,
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	m.apiKeys[key.Key] = entry
+	c := *entry
+	return &c, nil
+}
+
+func (m *MemoryStore) DeleteAPIKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.apiKeys[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.apiKeys, key)
+	return nil
+}
+
+func (m *MemoryStore) GetAdminUserIds(ctx context.Context) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	holders := make(map[int]bool)
+	for _, entry := range m.apiKeys {
+		if entry.Role == "admin" {
+			holders[entry.UserId] = true
+		}
+	}
+	ids := make([]int, 0, len(holders))
+	for id := range holders {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+//
+// Pirgs
+//
+
+func copyPirg(p *Pirg) *Pirg {
+	c := *p
+	c.Gid = copyIntPtr(p.Gid)
+	c.AdminIds = slices.Clone(p.AdminIds)
+	c.UserIds = slices.Clone(p.UserIds)
+	return &c
+}
+
+// sortedUniqueIds returns a sorted copy of ids without duplicates,
+// or nil if ids is empty, matching what postgres returns
+func sortedUniqueIds(ids []int) []int {
+	unique := uniqueIds(ids)
+	sort.Ints(unique)
+	return unique
+}
+
+func (m *MemoryStore) GetAllPirgs(ctx context.Context) ([]*Pirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var pirgs []*Pirg
+	for _, id := range sortedKeys(m.pirgs) {
+		pirgs = append(pirgs, copyPirg(m.pirgs[id]))
+	}
+	return pirgs, nil
+}
+
+func (m *MemoryStore) CountPirgs(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.pirgs), nil
+}
+
+func (m *MemoryStore) GetPirgById(ctx context.Context, id int) (*Pirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pirg, ok := m.pirgs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPirg(pirg), nil
+}
+
+func (m *MemoryStore) GetPirgByName(ctx context.Context, name string) (*Pirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, pirg := range m.pirgs {
+		if pirg.Name == name {
+			return copyPirg(pirg), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) GetPirgByGid(ctx context.Context, gid int) (*Pirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, pirg := range m.pirgs {
+		if pirg.Gid != nil && *pirg.Gid == gid {
+			return copyPirg(pirg), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// validatePirg mirrors validatePirgRequest and the unique pirg name and gid
+func (m *MemoryStore) validatePirg(id int, pr *PirgRequest) error {
+	if pr.Gid != nil && *pr.Gid <= 0 {
+		return fmt.Errorf("gid must be positive")
+	}
+	if _, ok := m.users[pr.OwnerId]; !ok {
+		return fmt.Errorf("validating owner_id failed: user does not exist with id: %d", pr.OwnerId)
+	}
+	for _, adminId := range pr.AdminIds {
+		if _, ok := m.users[adminId]; !ok {
+			return fmt.Errorf("validating admin_id failed: user does not exist with id: %d", adminId)
+		}
+	}
+	for _, userId := range pr.UserIds {
+		if _, ok := m.users[userId]; !ok {
+			return fmt.Errorf("validating user_id failed: user does not exist with id: %d", userId)
+		}
+	}
+	for _, existing := range m.pirgs {
+		if existing.Id != id && existing.Name == pr.Name {
+			return fmt.Errorf("%w: pirg with name %s already exists", ErrConflict, pr.Name)
+		}
+		if existing.Id != id && pr.Gid != nil && sameIntPtr(existing.Gid, pr.Gid) {
+			return fmt.Errorf("%w: pirg with gid %d already exists", ErrConflict, *pr.Gid)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreatePirg(ctx context.Context, pr *PirgRequest) (*Pirg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.validatePirg(0, pr); err != nil {
+		return nil, err
+	}
+	ts := now()
+	pirg := &Pirg{
+		Id:         m.nextId("pirgs"),
+		Name:       pr.Name,
+		OwnerId:    pr.OwnerId,
+		Gid:        copyIntPtr(pr.Gid),
+		AdminIds:   sortedUniqueIds(pr.AdminIds),
+		UserIds:    sortedUniqueIds(pr.UserIds),
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	m.pirgs[pirg.Id] = pirg
+	return copyPirg(pirg), nil
+}
+
+func (m *MemoryStore) UpdatePirg(ctx context.Context, id int, pr *PirgRequest) (*Pirg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pirg, ok := m.pirgs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := m.validatePirg(id, pr); err != nil {
+		return nil, err
+	}
+	if pr.Name != pirg.Name || pr.OwnerId != pirg.OwnerId || !sameIntPtr(pr.Gid, pirg.Gid) {
+		pirg.Name = pr.Name
+		pirg.OwnerId = pr.OwnerId
+		pirg.Gid = copyIntPtr(pr.Gid)
+		pirg.ModifiedAt = now()
+	}
+	pirg.AdminIds = sortedUniqueIds(pr.AdminIds)
+	pirg.UserIds = sortedUniqueIds(pr.UserIds)
+	m.prunePirgMembers(pirg)
+	return copyPirg(pirg), nil
+}
+
+// prunePirgMembers drops what users taken out of the pirg had in it, like
+// the cascades on pirgs_users
+func (m *MemoryStore) prunePirgMembers(pirg *Pirg) {
+	id := pirg.Id
+	// users taken out of the pirg lose their cluster access
+	for _, cp := range m.clusterPirgs {
+		if cp.PirgId != id {
+			continue
+		}
+		var kept []int
+		for _, userId := range cp.UserIds {
+			if slices.Contains(pirg.UserIds, userId) {
+				kept = append(kept, userId)
+			}
+		}
+		cp.UserIds = kept
+	}
+	// and the pirg's groups
+	for _, group := range m.groups {
+		if group.PirgId == id {
+			group.UserIds = slices.DeleteFunc(group.UserIds, func(userId int) bool { return !slices.Contains(pirg.UserIds, userId) })
+		}
+	}
+	// and their own slurm grants and limits
+	if ps, ok := m.pirgSlurm[id]; ok {
+		ps.Members = slices.DeleteFunc(ps.Members, func(ms MemberSlurm) bool {
+			return !slices.Contains(pirg.UserIds, ms.UserId)
+		})
+	}
+	if pl, ok := m.pirgLimits[id]; ok {
+		pl.Members = slices.DeleteFunc(pl.Members, func(ml MemberLimits) bool {
+			return !slices.Contains(pirg.UserIds, ml.UserId)
+		})
+	}
+	// and the term of their membership
+	for key := range m.terms {
+		if key[0] == id && !slices.Contains(pirg.UserIds, key[1]) {
+			delete(m.terms, key)
+		}
+	}
+}
+
+// removePirgMember mirrors the postgres removePirgMember
+func (m *MemoryStore) removePirgMember(pirgId int, userId int) {
+	pirg, ok := m.pirgs[pirgId]
+	if !ok || pirg.OwnerId == userId {
+		return
+	}
+	pirg.AdminIds = removeId(pirg.AdminIds, userId)
+	pirg.UserIds = removeId(pirg.UserIds, userId)
+	m.prunePirgMembers(pirg)
+}
+
+func (m *MemoryStore) DeletePirg(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pirgs[id]; !ok {
+		return ErrNotFound
+	}
+	for allocationId, allocation := range m.storage {
+		if allocation.PirgId == id {
+			delete(m.storage, allocationId)
+		}
+	}
+	for key, cp := range m.clusterPirgs {
+		if cp.PirgId == id {
+			delete(m.clusterPirgs, key)
+		}
+	}
+	for groupId, group := range m.groups {
+		if group.PirgId == id {
+			delete(m.groups, groupId)
+		}
+	}
+	for allocationId, allocation := range m.compute {
+		if allocation.PirgId == id {
+			delete(m.compute, allocationId)
+		}
+	}
+	// usage is kept for historical reports under its account name
+	for _, job := range m.usage {
+		if job.PirgId == id {
+			job.PirgId = 0
+		}
+	}
+	m.storageUsage = slices.DeleteFunc(m.storageUsage, func(su *StorageUsage) bool { return su.PirgId == id })
+	for reviewId, items := range m.reviewItems {
+		m.reviewItems[reviewId] = slices.DeleteFunc(items, func(item *AccessReviewItem) bool { return item.PirgId == id })
+	}
+	for key := range m.terms {
+		if key[0] == id {
+			delete(m.terms, key)
+		}
+	}
+	delete(m.pirgSlurm, id)
+	delete(m.pirgLimits, id)
+	delete(m.pirgs, id)
+	return nil
+}
+
+//
+// Locations
+//
+
+func copyLocation(l *Location) *Location {
+	c := *l
+	if l.ParentId != nil {
+		parentId := *l.ParentId
+		c.ParentId = &parentId
+	}
+	return &c
+}
+
+func (m *MemoryStore) GetAllLocations(ctx context.Context) ([]*Location, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var locations []*Location
+	for _, id := range sortedKeys(m.locations) {
+		locations = append(locations, copyLocation(m.locations[id]))
+	}
+	return locations, nil
+}
+
+func (m *MemoryStore) GetLocationById(ctx context.Context, id int) (*Location, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	location, ok := m.locations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyLocation(location), nil
+}
+
+// validateLocation checks the parent and the unique name among siblings
+func (m *MemoryStore) validateLocation(id int, lr *LocationRequest) error {
+	if lr.ParentId != nil {
+		if _, ok := m.locations[*lr.ParentId]; !ok {
+			return fmt.Errorf("parent location does not exist with id: %d", *lr.ParentId)
+		}
+	}
+	for _, existing := range m.locations {
+		if existing.Id != id && existing.Name == lr.Name && sameIntPtr(existing.ParentId, lr.ParentId) {
+			return fmt.Errorf("%w: location %s already exists", ErrConflict, lr.Name)
+		}
+	}
+	return nil
+}
+
+// sameIntPtr reports whether both are nil or point to the same value
+func sameIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func (m *MemoryStore) CreateLocation(ctx context.Context, lr *LocationRequest) (*Location, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.validateLocation(0, lr); err != nil {
+		return nil, err
+	}
+	ts := now()
+	location := copyLocation(&Location{
+		Id:          m.nextId("locations"),
+		Name:        lr.Name,
+		Kind:        lr.Kind,
+		ParentId:    lr.ParentId,
+		Description: lr.Description,
+		CreatedAt:   ts,
+		ModifiedAt:  ts,
+	})
+	m.locations[location.Id] = location
+	return copyLocation(location), nil
+}
+
+func (m *MemoryStore) UpdateLocation(ctx context.Context, id int, lr *LocationRequest) (*Location, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lr.ParentId != nil && slices.Contains(m.locationSubtreeIds(id), *lr.ParentId) {
+		return nil, fmt.Errorf("location %d can't be its own ancestor", id)
+	}
+	if err := m.validateLocation(id, lr); err != nil {
+		return nil, err
+	}
+	location, ok := m.locations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	updated := copyLocation(&Location{
+		Id:          id,
+		Name:        lr.Name,
+		Kind:        lr.Kind,
+		ParentId:    lr.ParentId,
+		Description: lr.Description,
+		CreatedAt:   location.CreatedAt,
+		ModifiedAt:  now(),
+	})
+	m.locations[id] = updated
+	return copyLocation(updated), nil
+}
+
+func (m *MemoryStore) DeleteLocation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.locations[id]; !ok {
+		return ErrNotFound
+	}
+	for _, location := range m.locations {
+		if location.ParentId != nil && *location.ParentId == id {
+			return fmt.Errorf("%w: location %d has child locations", ErrConflict, id)
+		}
+	}
+	for _, allocation := range m.storage {
+		if allocation.LocationId == id {
+			return fmt.Errorf("%w: location %d has storage allocations", ErrConflict, id)
+		}
+	}
+	m.storageUsage = slices.DeleteFunc(m.storageUsage, func(su *StorageUsage) bool { return su.LocationId == id })
+	delete(m.locations, id)
+	return nil
+}
+
+func (m *MemoryStore) GetLocationPirgs(ctx context.Context, id int) ([]*Pirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	subtree := m.locationSubtreeIds(id)
+	var pirgIds []int
+	for _, allocation := range m.storage {
+		if slices.Contains(subtree, allocation.LocationId) {
+			pirgIds = append(pirgIds, allocation.PirgId)
+		}
+	}
+	var pirgs []*Pirg
+	for _, pirgId := range sortedUniqueIds(pirgIds) {
+		pirgs = append(pirgs, copyPirg(m.pirgs[pirgId]))
+	}
+	return pirgs, nil
+}
+
+// locationSubtreeIds returns the id of the location and all of its descendants
+func (m *MemoryStore) locationSubtreeIds(id int) []int {
+	if _, ok := m.locations[id]; !ok {
+		return nil
+	}
+	subtree := []int{id}
+	for i := 0; i < len(subtree); i++ {
+		for _, location := range m.locations {
+			if location.ParentId != nil && *location.ParentId == subtree[i] {
+				subtree = append(subtree, location.Id)
+			}
+		}
+	}
+	return subtree
+}
+
+//
+// Storage allocations
+//
+
+func copyStorageAllocation(s *StorageAllocation) *StorageAllocation {
+	c := *s
+	return &c
+}
+
+func (m *MemoryStore) GetPirgStorageAllocations(ctx context.Context, pirgId int) ([]*StorageAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var allocations []*StorageAllocation
+	for _, id := range sortedKeys(m.storage) {
+		if m.storage[id].PirgId == pirgId {
+			allocations = append(allocations, copyStorageAllocation(m.storage[id]))
+		}
+	}
+	return allocations, nil
+}
+
+func (m *MemoryStore) GetAllStorageAllocations(ctx context.Context) ([]*StorageAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var allocations []*StorageAllocation
+	for _, id := range sortedKeys(m.storage) {
+		allocations = append(allocations, copyStorageAllocation(m.storage[id]))
+	}
+	return allocations, nil
+}
+
+func (m *MemoryStore) GetStorageAllocationById(ctx context.Context, id int) (*StorageAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	allocation, ok := m.storage[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyStorageAllocation(allocation), nil
+}
+
+// validateStorageAllocation mirrors validateStorageLocation and the unique (location_id, path)
+func (m *MemoryStore) validateStorageAllocation(id int, sr *StorageAllocationRequest) error {
+	location, ok := m.locations[sr.LocationId]
+	if !ok {
+		return fmt.Errorf("location does not exist with id: %d", sr.LocationId)
+	}
+	if location.Kind != "filesystem" {
+		return fmt.Errorf("storage can only be allocated on filesystem locations, location %d is a %s", sr.LocationId, location.Kind)
+	}
+	for _, existing := range m.storage {
+		if existing.Id != id && existing.LocationId == sr.LocationId && existing.Path == sr.Path {
+			return fmt.Errorf("%w: storage allocation for %s already exists", ErrConflict, sr.Path)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateStorageAllocation(ctx context.Context, pirgId int, sr *StorageAllocationRequest) (*StorageAllocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.validateStorageAllocation(0, sr); err != nil {
+		return nil, err
+	}
+	if _, ok := m.pirgs[pirgId]; !ok {
+		return nil, fmt.Errorf("%w: pirg does not exist with id: %d", ErrConflict, pirgId)
+	}
+	ts := now()
+	allocation := &StorageAllocation{
+		Id:             m.nextId("storage_allocations"),
+		PirgId:         pirgId,
+		LocationId:     sr.LocationId,
+		Path:           sr.Path,
+		SoftLimitBytes: sr.SoftLimitBytes,
+		HardLimitBytes: sr.HardLimitBytes,
+		CreatedAt:      ts,
+		ModifiedAt:     ts,
+	}
+	m.storage[allocation.Id] = allocation
+	return copyStorageAllocation(allocation), nil
+}
+
+func (m *MemoryStore) UpdateStorageAllocation(ctx context.Context, id int, sr *StorageAllocationRequest) (*StorageAllocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	allocation, ok := m.storage[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := m.validateStorageAllocation(id, sr); err != nil {
+		return nil, err
+	}
+	allocation.LocationId = sr.LocationId
+	allocation.Path = sr.Path
+	allocation.SoftLimitBytes = sr.SoftLimitBytes
+	allocation.HardLimitBytes = sr.HardLimitBytes
+	allocation.ModifiedAt = now()
+	return copyStorageAllocation(allocation), nil
+}
+
+func (m *MemoryStore) DeleteStorageAllocation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.storage[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.storage, id)
+	return nil
+}
+
+func (m *MemoryStore) RecordStorageUsage(ctx context.Context, samples []*StorageUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sample := range samples {
+		if _, ok := m.pirgs[sample.PirgId]; !ok {
+			return fmt.Errorf("%w: pirg does not exist with id: %d", ErrConflict, sample.PirgId)
+		}
+		if _, ok := m.locations[sample.LocationId]; !ok {
+			return fmt.Errorf("%w: location does not exist with id: %d", ErrConflict, sample.LocationId)
+		}
+		if sample.UsedBytes < 0 {
+			return fmt.Errorf("used bytes must not be negative")
+		}
+	}
+	for _, sample := range samples {
+		sample.Id = m.nextId("storage_usage")
+		stored := *sample
+		// postgres keeps timestamps in UTC to the microsecond
+		stored.SampledAt = sample.SampledAt.UTC().Truncate(time.Microsecond)
+		m.storageUsage = append(m.storageUsage, &stored)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetStorageUsage(ctx context.Context, pirgId int, locationId int, from time.Time, to time.Time) ([]*StorageUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var samples []*StorageUsage
+	for _, su := range m.storageUsage {
+		if su.PirgId == pirgId && su.LocationId == locationId && !su.SampledAt.Before(from) && su.SampledAt.Before(to) {
+			c := *su
+			samples = append(samples, &c)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].SampledAt.Before(samples[j].SampledAt) })
+	return samples, nil
+}
+
+//
+// Clusters
+//
+
+func copyCluster(c *Cluster) *Cluster {
+	cc := *c
+	return &cc
+}
+
+func copyClusterPirg(cp *ClusterPirg) *ClusterPirg {
+	c := *cp
+	c.UserIds = slices.Clone(cp.UserIds)
+	return &c
+}
+
+func (m *MemoryStore) GetAllClusters(ctx context.Context) ([]*Cluster, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var clusters []*Cluster
+	for _, id := range sortedKeys(m.clusters) {
+		clusters = append(clusters, copyCluster(m.clusters[id]))
+	}
+	return clusters, nil
+}
+
+func (m *MemoryStore) GetClusterById(ctx context.Context, id int) (*Cluster, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cluster, ok := m.clusters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyCluster(cluster), nil
+}
+
+func (m *MemoryStore) GetClusterByName(ctx context.Context, name string) (*Cluster, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, cluster := range m.clusters {
+		if cluster.Name == name {
+			return copyCluster(cluster), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// checkClusterUnique enforces the unique cluster name, ignoring the cluster being updated
+func (m *MemoryStore) checkClusterUnique(id int, cr *ClusterRequest) error {
+	for _, existing := range m.clusters {
+		if existing.Id != id && existing.Name == cr.Name {
+			return fmt.Errorf("%w: cluster with name %s already exists", ErrConflict, cr.Name)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateCluster(ctx context.Context, cr *ClusterRequest) (*Cluster, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkClusterUnique(0, cr); err != nil {
+		return nil, err
+	}
+	ts := now()
+	cluster := &Cluster{
+		Id:          m.nextId("clusters"),
+		Name:        cr.Name,
+		Description: cr.Description,
+		CreatedAt:   ts,
+		ModifiedAt:  ts,
+	}
+	m.clusters[cluster.Id] = cluster
+	return copyCluster(cluster), nil
+}
+
+func (m *MemoryStore) UpdateCluster(ctx context.Context, id int, cr *ClusterRequest) (*Cluster, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cluster, ok := m.clusters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := m.checkClusterUnique(id, cr); err != nil {
+		return nil, err
+	}
+	cluster.Name = cr.Name
+	cluster.Description = cr.Description
+	cluster.ModifiedAt = now()
+	return copyCluster(cluster), nil
+}
+
+func (m *MemoryStore) DeleteCluster(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clusters[id]; !ok {
+		return ErrNotFound
+	}
+	for _, partition := range m.partitions {
+		if partition.ClusterId == id {
+			return fmt.Errorf("%w: cluster %d has partitions", ErrConflict, id)
+		}
+	}
+	for _, job := range m.usage {
+		if job.ClusterId == id {
+			return fmt.Errorf("%w: cluster %d has compute usage", ErrConflict, id)
+		}
+	}
+	for key, cp := range m.clusterPirgs {
+		if cp.ClusterId == id {
+			delete(m.clusterPirgs, key)
+		}
+	}
+	delete(m.clusters, id)
+	return nil
+}
+
+// sortedClusterPirgs returns the matching cluster pirgs ordered by cluster and pirg id
+func (m *MemoryStore) sortedClusterPirgs(match func(cp *ClusterPirg) bool) []*ClusterPirg {
+	var cps []*ClusterPirg
+	for _, cp := range m.clusterPirgs {
+		if match(cp) {
+			cps = append(cps, copyClusterPirg(cp))
+		}
+	}
+	sort.Slice(cps, func(i, j int) bool {
+		if cps[i].ClusterId != cps[j].ClusterId {
+			return cps[i].ClusterId < cps[j].ClusterId
+		}
+		return cps[i].PirgId < cps[j].PirgId
+	})
+	return cps
+}
+
+func (m *MemoryStore) GetClusterPirgs(ctx context.Context, clusterId int) ([]*ClusterPirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedClusterPirgs(func(cp *ClusterPirg) bool { return cp.ClusterId == clusterId }), nil
+}
+
+func (m *MemoryStore) GetPirgClusters(ctx context.Context, pirgId int) ([]*ClusterPirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedClusterPirgs(func(cp *ClusterPirg) bool { return cp.PirgId == pirgId }), nil
+}
+
+func (m *MemoryStore) GetClusterPirg(ctx context.Context, clusterId int, pirgId int) (*ClusterPirg, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cp, ok := m.clusterPirgs[[2]int{clusterId, pirgId}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyClusterPirg(cp), nil
+}
+
+func (m *MemoryStore) SetClusterPirg(ctx context.Context, clusterId int, pirgId int, userIds []int) (*ClusterPirg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clusters[clusterId]; !ok {
+		return nil, ErrNotFound
+	}
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := validateClusterUsers(pirg, userIds); err != nil {
+		return nil, err
+	}
+	key := [2]int{clusterId, pirgId}
+	cp, ok := m.clusterPirgs[key]
+	if !ok {
+		ts := now()
+		cp = &ClusterPirg{ClusterId: clusterId, PirgId: pirgId, CreatedAt: ts, ModifiedAt: ts}
+		m.clusterPirgs[key] = cp
+	}
+	cp.UserIds = sortedUniqueIds(userIds)
+	return copyClusterPirg(cp), nil
+}
+
+func (m *MemoryStore) DeleteClusterPirg(ctx context.Context, clusterId int, pirgId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]int{clusterId, pirgId}
+	if _, ok := m.clusterPirgs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.clusterPirgs, key)
+	return nil
+}
+
+//
+// Slurm
+//
+
+func copyQOS(q *QOS) *QOS {
+	c := *q
+	return &c
+}
+
+func (m *MemoryStore) GetAllQOS(ctx context.Context) ([]*QOS, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var qos []*QOS
+	for _, id := range sortedKeys(m.qos) {
+		qos = append(qos, copyQOS(m.qos[id]))
+	}
+	return qos, nil
+}
+
+func (m *MemoryStore) GetQOSById(ctx context.Context, id int) (*QOS, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	q, ok := m.qos[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyQOS(q), nil
+}
+
+// checkQOSUnique enforces the unique qos name, ignoring the qos being updated
+func (m *MemoryStore) checkQOSUnique(id int, qr *QOSRequest) error {
+	for _, existing := range m.qos {
+		if existing.Id != id && existing.Name == qr.Name {
+			return fmt.Errorf("%w: qos with name %s already exists", ErrConflict, qr.Name)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateQOS(ctx context.Context, qr *QOSRequest) (*QOS, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkQOSUnique(0, qr); err != nil {
+		return nil, err
+	}
+	ts := now()
+	q := &QOS{
+		Id:          m.nextId("qos"),
+		Name:        qr.Name,
+		Description: qr.Description,
+		CreatedAt:   ts,
+		ModifiedAt:  ts,
+	}
+	m.qos[q.Id] = q
+	return copyQOS(q), nil
+}
+
+func (m *MemoryStore) UpdateQOS(ctx context.Context, id int, qr *QOSRequest) (*QOS, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q, ok := m.qos[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := m.checkQOSUnique(id, qr); err != nil {
+		return nil, err
+	}
+	q.Name = qr.Name
+	q.Description = qr.Description
+	q.ModifiedAt = now()
+	return copyQOS(q), nil
+}
+
+func (m *MemoryStore) DeleteQOS(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.qos[id]; !ok {
+		return ErrNotFound
+	}
+	for _, ps := range m.pirgSlurm {
+		if ps.grantsQOS(id) {
+			return fmt.Errorf("%w: qos %d is granted to pirg %d", ErrConflict, id, ps.PirgId)
+		}
+	}
+	delete(m.qos, id)
+	return nil
+}
+
+func copyPartition(p *Partition) *Partition {
+	c := *p
+	return &c
+}
+
+func (m *MemoryStore) GetAllPartitions(ctx context.Context) ([]*Partition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var partitions []*Partition
+	for _, id := range sortedKeys(m.partitions) {
+		partitions = append(partitions, copyPartition(m.partitions[id]))
+	}
+	return partitions, nil
+}
+
+func (m *MemoryStore) GetPartitionById(ctx context.Context, id int) (*Partition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.partitions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPartition(p), nil
+}
+
+// validatePartition checks the cluster exists and enforces unique
+// partition names within a cluster
+func (m *MemoryStore) validatePartition(id int, pr *PartitionRequest) error {
+	if _, ok := m.clusters[pr.ClusterId]; !ok {
+		return fmt.Errorf("%w: cluster %d does not exist", ErrConflict, pr.ClusterId)
+	}
+	for _, existing := range m.partitions {
+		if existing.Id != id && existing.ClusterId == pr.ClusterId && existing.Name == pr.Name {
+			return fmt.Errorf("%w: partition %s already exists on cluster %d", ErrConflict, pr.Name, pr.ClusterId)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreatePartition(ctx context.Context, pr *PartitionRequest) (*Partition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.validatePartition(0, pr); err != nil {
+		return nil, err
+	}
+	ts := now()
+	p := &Partition{
+		Id:          m.nextId("partitions"),
+		ClusterId:   pr.ClusterId,
+		Name:        pr.Name,
+		Description: pr.Description,
+		CreatedAt:   ts,
+		ModifiedAt:  ts,
+	}
+	m.partitions[p.Id] = p
+	return copyPartition(p), nil
+}
+
+func (m *MemoryStore) UpdatePartition(ctx context.Context, id int, pr *PartitionRequest) (*Partition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.partitions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := m.validatePartition(id, pr); err != nil {
+		return nil, err
+	}
+	p.ClusterId = pr.ClusterId
+	p.Name = pr.Name
+	p.Description = pr.Description
+	p.ModifiedAt = now()
+	return copyPartition(p), nil
+}
+
+func (m *MemoryStore) DeletePartition(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.partitions[id]; !ok {
+		return ErrNotFound
+	}
+	for _, ps := range m.pirgSlurm {
+		if ps.grantsPartition(id) {
+			return fmt.Errorf("%w: partition %d is granted to pirg %d", ErrConflict, id, ps.PirgId)
+		}
+	}
+	delete(m.partitions, id)
+	return nil
+}
+
+// grantsQOS reports whether the pirg or any of its members has the qos
+func (ps *PirgSlurm) grantsQOS(id int) bool {
+	if slices.Contains(ps.QOSIds, id) {
+		return true
+	}
+	return slices.ContainsFunc(ps.Members, func(ms MemberSlurm) bool { return slices.Contains(ms.QOSIds, id) })
+}
+
+// grantsPartition reports whether the pirg or any of its members has the partition
+func (ps *PirgSlurm) grantsPartition(id int) bool {
+	if slices.Contains(ps.PartitionIds, id) {
+		return true
+	}
+	return slices.ContainsFunc(ps.Members, func(ms MemberSlurm) bool { return slices.Contains(ms.PartitionIds, id) })
+}
+
+func copySlurmGrants(g SlurmGrants) SlurmGrants {
+	c := SlurmGrants{
+		QOSIds:       sortedUniqueIds(g.QOSIds),
+		PartitionIds: sortedUniqueIds(g.PartitionIds),
+	}
+	if g.DefaultQOSId != nil {
+		id := *g.DefaultQOSId
+		c.DefaultQOSId = &id
+	}
+	return c
+}
+
+func copyPirgSlurm(ps *PirgSlurm) *PirgSlurm {
+	c := &PirgSlurm{PirgId: ps.PirgId, SlurmGrants: copySlurmGrants(ps.SlurmGrants)}
+	for _, ms := range ps.Members {
+		c.Members = append(c.Members, MemberSlurm{UserId: ms.UserId, SlurmGrants: copySlurmGrants(ms.SlurmGrants)})
+	}
+	return c
+}
+
+func (m *MemoryStore) GetPirgSlurm(ctx context.Context, pirgId int) (*PirgSlurm, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.pirgs[pirgId]; !ok {
+		return nil, ErrNotFound
+	}
+	ps, ok := m.pirgSlurm[pirgId]
+	if !ok {
+		return &PirgSlurm{PirgId: pirgId}, nil
+	}
+	return copyPirgSlurm(ps), nil
+}
+
+// checkGrantsExist mirrors the foreign keys on the grant tables
+func (m *MemoryStore) checkGrantsExist(g *SlurmGrants) error {
+	for _, id := range g.QOSIds {
+		if _, ok := m.qos[id]; !ok {
+			return fmt.Errorf("%w: qos %d does not exist", ErrConflict, id)
+		}
+	}
+	for _, id := range g.PartitionIds {
+		if _, ok := m.partitions[id]; !ok {
+			return fmt.Errorf("%w: partition %d does not exist", ErrConflict, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetPirgSlurm(ctx context.Context, pirgId int, ps *PirgSlurm) (*PirgSlurm, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := validatePirgSlurm(pirg, ps); err != nil {
+		return nil, err
+	}
+	if err := m.checkGrantsExist(&ps.SlurmGrants); err != nil {
+		return nil, err
+	}
+	for _, ms := range ps.Members {
+		if err := m.checkGrantsExist(&ms.SlurmGrants); err != nil {
+			return nil, err
+		}
+	}
+	stored := copyPirgSlurm(ps)
+	stored.PirgId = pirgId
+	sort.Slice(stored.Members, func(i, j int) bool { return stored.Members[i].UserId < stored.Members[j].UserId })
+	m.pirgSlurm[pirgId] = stored
+	return copyPirgSlurm(stored), nil
+}
+
+func copyIntPtr(i *int) *int {
+	if i == nil {
+		return nil
+	}
+	c := *i
+	return &c
+}
+
+func copySlurmLimits(l SlurmLimits) SlurmLimits {
+	c := l
+	c.Fairshare = copyIntPtr(l.Fairshare)
+	c.MaxJobs = copyIntPtr(l.MaxJobs)
+	return c
+}
+
+func copyPirgLimits(pl *PirgLimits) *PirgLimits {
+	c := &PirgLimits{PirgId: pl.PirgId, SlurmLimits: copySlurmLimits(pl.SlurmLimits)}
+	for _, ml := range pl.Members {
+		c.Members = append(c.Members, MemberLimits{UserId: ml.UserId, SlurmLimits: copySlurmLimits(ml.SlurmLimits)})
+	}
+	return c
+}
+
+func (m *MemoryStore) GetPirgLimits(ctx context.Context, pirgId int) (*PirgLimits, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.pirgs[pirgId]; !ok {
+		return nil, ErrNotFound
+	}
+	pl, ok := m.pirgLimits[pirgId]
+	if !ok {
+		return &PirgLimits{PirgId: pirgId}, nil
+	}
+	return copyPirgLimits(pl), nil
+}
+
+func (m *MemoryStore) SetPirgLimits(ctx context.Context, pirgId int, pl *PirgLimits) (*PirgLimits, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := validatePirgLimits(pirg, pl); err != nil {
+		return nil, err
+	}
+	stored := copyPirgLimits(pl)
+	stored.PirgId = pirgId
+	// members without limits aren't returned by postgres either
+	stored.Members = slices.DeleteFunc(stored.Members, func(ml MemberLimits) bool { return ml.empty() })
+	sort.Slice(stored.Members, func(i, j int) bool { return stored.Members[i].UserId < stored.Members[j].UserId })
+	m.pirgLimits[pirgId] = stored
+	// the limits are columns of the pirg, so updating them touches it
+	pirg.ModifiedAt = now()
+	return copyPirgLimits(stored), nil
+}
+
+//
+// Compute allocations and usage
+//
+
+func copyComputeAllocation(a *ComputeAllocation) *ComputeAllocation {
+	c := *a
+	return &c
+}
+
+func (m *MemoryStore) computeAllocations(match func(a *ComputeAllocation) bool) []*ComputeAllocation {
+	var allocations []*ComputeAllocation
+	for _, id := range sortedKeys(m.compute) {
+		if match(m.compute[id]) {
+			allocations = append(allocations, copyComputeAllocation(m.compute[id]))
+		}
+	}
+	return allocations
+}
+
+func (m *MemoryStore) GetAllComputeAllocations(ctx context.Context) ([]*ComputeAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.computeAllocations(func(a *ComputeAllocation) bool { return true }), nil
+}
+
+func (m *MemoryStore) GetPirgComputeAllocations(ctx context.Context, pirgId int) ([]*ComputeAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.computeAllocations(func(a *ComputeAllocation) bool { return a.PirgId == pirgId }), nil
+}
+
+func (m *MemoryStore) GetComputeAllocationById(ctx context.Context, id int) (*ComputeAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	allocation, ok := m.compute[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyComputeAllocation(allocation), nil
+}
+
+func (m *MemoryStore) CreateComputeAllocation(ctx context.Context, pirgId int, cr *ComputeAllocationRequest) (*ComputeAllocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := validateComputeAllocation(cr); err != nil {
+		return nil, err
+	}
+	if _, ok := m.pirgs[pirgId]; !ok {
+		return nil, fmt.Errorf("%w: pirg does not exist with id: %d", ErrConflict, pirgId)
+	}
+	ts := now()
+	allocation := &ComputeAllocation{
+		Id:         m.nextId("compute_allocations"),
+		PirgId:     pirgId,
+		Resource:   cr.Resource,
+		Hours:      cr.Hours,
+		StartDate:  cr.StartDate,
+		EndDate:    cr.EndDate,
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	m.compute[allocation.Id] = allocation
+	return copyComputeAllocation(allocation), nil
+}
+
+func (m *MemoryStore) UpdateComputeAllocation(ctx context.Context, id int, cr *ComputeAllocationRequest) (*ComputeAllocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	allocation, ok := m.compute[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := validateComputeAllocation(cr); err != nil {
+		return nil, err
+	}
+	allocation.Resource = cr.Resource
+	allocation.Hours = cr.Hours
+	allocation.StartDate = cr.StartDate
+	allocation.EndDate = cr.EndDate
+	allocation.ModifiedAt = now()
+	return copyComputeAllocation(allocation), nil
+}
+
+func (m *MemoryStore) DeleteComputeAllocation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.compute[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.compute, id)
+	return nil
+}
+
+func (m *MemoryStore) RecordJobUsage(ctx context.Context, jobs []*JobUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// check everything first so a bad job leaves nothing recorded, like the transaction does
+	for _, job := range jobs {
+		if _, ok := m.clusters[job.ClusterId]; !ok {
+			return fmt.Errorf("%w: cluster does not exist with id: %d", ErrConflict, job.ClusterId)
+		}
+		if _, ok := m.pirgs[job.PirgId]; !ok {
+			return fmt.Errorf("%w: pirg does not exist with id: %d", ErrConflict, job.PirgId)
+		}
+		if job.UserId != nil {
+			if _, ok := m.users[*job.UserId]; !ok {
+				return fmt.Errorf("%w: user does not exist with id: %d", ErrConflict, *job.UserId)
+			}
+		}
+	}
+	for _, job := range jobs {
+		stored := *job
+		stored.UserId = copyIntPtr(job.UserId)
+		// postgres keeps timestamps in UTC to the microsecond
+		stored.EndedAt = job.EndedAt.UTC().Truncate(time.Microsecond)
+		m.usage[jobKey{job.ClusterId, job.JobId}] = &stored
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetPirgUsage(ctx context.Context, pirgId int, from time.Time, to time.Time) ([]*UserUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type userKey struct {
+		userId   int
+		known    bool
+		username string
+	}
+	totals := make(map[userKey]*UserUsage)
+	for _, job := range m.usage {
+		if job.PirgId != pirgId || job.EndedAt.Before(from) || !job.EndedAt.Before(to) {
+			continue
+		}
+		key := userKey{username: job.Username}
+		if job.UserId != nil {
+			key.userId, key.known = *job.UserId, true
+		}
+		total, ok := totals[key]
+		if !ok {
+			total = &UserUsage{UserId: copyIntPtr(job.UserId), Username: job.Username}
+			totals[key] = total
+		}
+		total.Jobs++
+		total.CPUHours += job.CPUHours
+		total.GPUHours += job.GPUHours
+	}
+	var usage []*UserUsage
+	for _, total := range totals {
+		usage = append(usage, total)
+	}
+	// ordered by username then user id with unknown users last, like postgres
+	sort.Slice(usage, func(i, j int) bool {
+		a, b := usage[i], usage[j]
+		if a.Username != b.Username {
+			return a.Username < b.Username
+		}
+		if a.UserId == nil || b.UserId == nil {
+			return b.UserId == nil && a.UserId != nil
+		}
+		return *a.UserId < *b.UserId
+	})
+	return usage, nil
+}
+
+//
+// Access reviews
+//
+
+func copyAccessReview(ar *AccessReview) *AccessReview {
+	c := *ar
+	if ar.ClosedAt != nil {
+		closedAt := *ar.ClosedAt
+		c.ClosedAt = &closedAt
+	}
+	return &c
+}
+
+func copyAccessReviewItem(item *AccessReviewItem) *AccessReviewItem {
+	c := *item
+	c.DecidedBy = copyIntPtr(item.DecidedBy)
+	if item.DecidedAt != nil {
+		decidedAt := *item.DecidedAt
+		c.DecidedAt = &decidedAt
+	}
+	return &c
+}
+
+func (m *MemoryStore) GetAllAccessReviews(ctx context.Context) ([]*AccessReview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var reviews []*AccessReview
+	for _, id := range sortedKeys(m.reviews) {
+		reviews = append(reviews, copyAccessReview(m.reviews[id]))
+	}
+	return reviews, nil
+}
+
+func (m *MemoryStore) CountPendingAccessRequests(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for id, review := range m.reviews {
+		if review.ClosedAt != nil {
+			continue
+		}
+		for _, item := range m.reviewItems[id] {
+			if item.Decision == ReviewPending {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) GetAccessReviewById(ctx context.Context, id int) (*AccessReview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	review, ok := m.reviews[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyAccessReview(review), nil
+}
+
+func (m *MemoryStore) CreateAccessReview(ctx context.Context, rr *AccessReviewRequest) (*AccessReview, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := validateAccessReview(rr); err != nil {
+		return nil, err
+	}
+	pirgIds := uniqueIds(rr.PirgIds)
+	if len(pirgIds) == 0 {
+		pirgIds = sortedKeys(m.pirgs)
+	}
+	for _, pirgId := range pirgIds {
+		if _, ok := m.pirgs[pirgId]; !ok {
+			return nil, fmt.Errorf("%w: pirg does not exist with id: %d", ErrConflict, pirgId)
+		}
+	}
+	ts := now()
+	review := &AccessReview{
+		Id:                m.nextId("access_reviews"),
+		Name:              rr.Name,
+		Deadline:          rr.Deadline.UTC().Truncate(time.Microsecond),
+		RemoveUnconfirmed: rr.RemoveUnconfirmed,
+		CreatedAt:         ts,
+		ModifiedAt:        ts,
+	}
+	var items []*AccessReviewItem
+	for _, pirgId := range pirgIds {
+		pirg := m.pirgs[pirgId]
+		for _, userId := range pirg.UserIds {
+			if userId != pirg.OwnerId {
+				items = append(items, &AccessReviewItem{ReviewId: review.Id, PirgId: pirgId, UserId: userId, Decision: ReviewPending})
+			}
+		}
+	}
+	sortAccessReviewItems(items)
+	m.reviews[review.Id] = review
+	m.reviewItems[review.Id] = items
+	return copyAccessReview(review), nil
+}
+
+func (m *MemoryStore) GetAccessReviewItems(ctx context.Context, reviewId int) ([]*AccessReviewItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.reviews[reviewId]; !ok {
+		return nil, ErrNotFound
+	}
+	var items []*AccessReviewItem
+	for _, item := range m.reviewItems[reviewId] {
+		items = append(items, copyAccessReviewItem(item))
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) DecideAccessReview(ctx context.Context, reviewId int, pirgId int, decisions []AccessReviewDecision, decidedBy *int) ([]*AccessReviewItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := validateAccessReviewDecisions(decisions); err != nil {
+		return nil, err
+	}
+	review, ok := m.reviews[reviewId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if review.ClosedAt != nil {
+		return nil, fmt.Errorf("%w: access review %d is closed", ErrConflict, reviewId)
+	}
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// check everything first so nothing is decided on an error, like the transaction
+	pirgItems := make(map[int]*AccessReviewItem)
+	for _, item := range m.reviewItems[reviewId] {
+		if item.PirgId == pirgId {
+			pirgItems[item.UserId] = item
+		}
+	}
+	for _, d := range decisions {
+		if d.Decision == ReviewRemoved && d.UserId == pirg.OwnerId {
+			return nil, fmt.Errorf("%w: the owner of pirg %s can't be removed", ErrConflict, pirg.Name)
+		}
+		if _, ok := pirgItems[d.UserId]; !ok {
+			return nil, fmt.Errorf("user %d is not under review in pirg %s: %w", d.UserId, pirg.Name, ErrNotFound)
+		}
+	}
+	ts := now()
+	for _, d := range decisions {
+		item := pirgItems[d.UserId]
+		item.Decision = d.Decision
+		item.DecidedBy = copyIntPtr(decidedBy)
+		decidedAt := ts
+		item.DecidedAt = &decidedAt
+		if d.Decision == ReviewRemoved {
+			m.removePirgMember(pirgId, d.UserId)
+		}
+	}
+	var items []*AccessReviewItem
+	for _, item := range m.reviewItems[reviewId] {
+		if item.PirgId == pirgId {
+			items = append(items, copyAccessReviewItem(item))
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) CloseAccessReview(ctx context.Context, id int) (*AccessReview, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	review, ok := m.reviews[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if review.ClosedAt != nil {
+		return nil, fmt.Errorf("%w: access review %d is already closed", ErrConflict, id)
+	}
+	ts := now()
+	if review.RemoveUnconfirmed {
+		for _, item := range m.reviewItems[id] {
+			if item.Decision != ReviewPending {
+				continue
+			}
+			m.removePirgMember(item.PirgId, item.UserId)
+			item.Decision = ReviewRemoved
+			decidedAt := ts
+			item.DecidedAt = &decidedAt
+		}
+	}
+	review.ClosedAt = &ts
+	review.ModifiedAt = ts
+	return copyAccessReview(review), nil
+}
+
+//
+// Memberships and the audit log
+//
+
+// storedTime matches the zone and precision postgres keeps timestamps in
+func storedTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	stored := t.UTC().Truncate(time.Microsecond)
+	return &stored
+}
+
+func (m *MemoryStore) membership(pirgId int, userId int) *Membership {
+	membership := &Membership{PirgId: pirgId, UserId: userId}
+	if term, ok := m.terms[[2]int{pirgId, userId}]; ok {
+		membership.StartsAt = storedTime(term.StartsAt)
+		membership.ExpiresAt = storedTime(term.ExpiresAt)
+	}
+	return membership
+}
+
+func (m *MemoryStore) GetPirgMemberships(ctx context.Context, pirgId int) ([]*Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var memberships []*Membership
+	for _, userId := range pirg.UserIds {
+		memberships = append(memberships, m.membership(pirgId, userId))
+	}
+	return memberships, nil
+}
+
+func (m *MemoryStore) GetAllMemberships(ctx context.Context) ([]*Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var memberships []*Membership
+	for _, pirgId := range sortedKeys(m.pirgs) {
+		for _, userId := range m.pirgs[pirgId].UserIds {
+			memberships = append(memberships, m.membership(pirgId, userId))
+		}
+	}
+	return memberships, nil
+}
+
+func (m *MemoryStore) SetMembershipTerm(ctx context.Context, pirgId int, userId int, term *MembershipTerm) (*Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := validateMembershipTerm(pirg, userId, term); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(pirg.UserIds, userId) {
+		return nil, fmt.Errorf("user %d isn't a member of pirg %s: %w", userId, pirg.Name, ErrNotFound)
+	}
+	key := [2]int{pirgId, userId}
+	if term.StartsAt == nil && term.ExpiresAt == nil {
+		delete(m.terms, key)
+	} else {
+		m.terms[key] = &MembershipTerm{StartsAt: storedTime(term.StartsAt), ExpiresAt: storedTime(term.ExpiresAt)}
+	}
+	return m.membership(pirgId, userId), nil
+}
+
+// expiringMemberships mirrors the query in the postgres GetExpiringMemberships
+func (m *MemoryStore) expiringMemberships(by time.Time) []*Membership {
+	var expiring []*Membership
+	for key, term := range m.terms {
+		pirg, ok := m.pirgs[key[0]]
+		if !ok || key[1] == pirg.OwnerId || term.ExpiresAt == nil || term.ExpiresAt.After(by) {
+			continue
+		}
+		expiring = append(expiring, m.membership(key[0], key[1]))
+	}
+	sortExpiringMemberships(expiring)
+	return expiring
+}
+
+func (m *MemoryStore) GetExpiringMemberships(ctx context.Context, by time.Time) ([]*Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expiringMemberships(by), nil
+}
+
+func (m *MemoryStore) RemoveExpiredMemberships(ctx context.Context, asOf time.Time) ([]*Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expired := m.expiringMemberships(asOf)
+	for _, membership := range expired {
+		pirg, user := m.pirgs[membership.PirgId], m.users[membership.UserId]
+		m.audit = append(m.audit, &AuditEntry{
+			Id:        m.nextId("audit_log"),
+			Action:    AuditMembershipExpired,
+			Actor:     AuditActorSweeper,
+			PirgId:    copyIntPtr(&pirg.Id),
+			UserId:    copyIntPtr(&user.Id),
+			Detail:    expiredDetail(pirg, user, membership),
+			CreatedAt: now(),
+		})
+		m.removePirgMember(membership.PirgId, membership.UserId)
+	}
+	return expired, nil
+}
+
+func (m *MemoryStore) GetAuditLog(ctx context.Context, limit int) ([]*AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []*AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := *m.audit[i]
+		e.PirgId, e.UserId = copyIntPtr(e.PirgId), copyIntPtr(e.UserId)
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+//
+// Notifications
+//
+
+func copyNotification(n *Notification) *Notification {
+	c := *n
+	c.Params = make(map[string]string, len(n.Params))
+	for k, v := range n.Params {
+		c.Params[k] = v
+	}
+	c.SentAt, c.FailedAt = storedTime(n.SentAt), storedTime(n.FailedAt)
+	return &c
+}
+
+func (m *MemoryStore) notification(id int) (*Notification, bool) {
+	for _, n := range m.notifications {
+		if n.Id == id {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+func (m *MemoryStore) EnqueueNotification(ctx context.Context, nr *NotificationRequest) (*Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := validateNotification(nr); err != nil {
+		return nil, err
+	}
+	if nr.DedupeKey != "" {
+		for _, n := range m.notifications {
+			if n.DedupeKey == nr.DedupeKey {
+				return nil, fmt.Errorf("%w: notification %s is already queued", ErrConflict, nr.DedupeKey)
+			}
+		}
+	}
+	ts := now()
+	n := &Notification{
+		Id:            m.nextId("notifications"),
+		Event:         nr.Event,
+		Recipient:     nr.Recipient,
+		Params:        nr.Params,
+		DedupeKey:     nr.DedupeKey,
+		NextAttemptAt: ts,
+		CreatedAt:     ts,
+	}
+	n = copyNotification(n)
+	m.notifications = append(m.notifications, n)
+	return copyNotification(n), nil
+}
+
+func (m *MemoryStore) ClaimNotifications(ctx context.Context, asOf time.Time, lease time.Duration, limit int) ([]*Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []*Notification
+	for _, n := range m.notifications {
+		if n.SentAt == nil && n.FailedAt == nil && !n.NextAttemptAt.After(asOf) {
+			due = append(due, n)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	var claimed []*Notification
+	for _, n := range due {
+		n.Attempts++
+		n.NextAttemptAt = asOf.Add(lease).UTC().Truncate(time.Microsecond)
+		claimed = append(claimed, copyNotification(n))
+	}
+	sortNotifications(claimed)
+	return claimed, nil
+}
+
+func (m *MemoryStore) MarkNotificationSent(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notification(id)
+	if !ok {
+		return ErrNotFound
+	}
+	ts := now()
+	n.SentAt = &ts
+	n.LastError = ""
+	return nil
+}
+
+func (m *MemoryStore) MarkNotificationFailed(ctx context.Context, id int, reason string, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notification(id)
+	if !ok {
+		return ErrNotFound
+	}
+	n.LastError = reason
+	if retryAt != nil {
+		n.NextAttemptAt = *storedTime(retryAt)
+	} else {
+		ts := now()
+		n.FailedAt = &ts
+	}
+	return nil
+}
+
+//
+// Pirg groups
+//
+
+func copyPirgGroup(g *PirgGroup) *PirgGroup {
+	c := *g
+	c.Gid = copyIntPtr(g.Gid)
+	c.UserIds = slices.Clone(g.UserIds)
+	return &c
+}
+
+func (m *MemoryStore) pirgGroups(match func(*PirgGroup) bool) []*PirgGroup {
+	var groups []*PirgGroup
+	for _, id := range sortedKeys(m.groups) {
+		if match(m.groups[id]) {
+			groups = append(groups, copyPirgGroup(m.groups[id]))
+		}
+	}
+	return groups
+}
+
+func (m *MemoryStore) GetAllPirgGroups(ctx context.Context) ([]*PirgGroup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pirgGroups(func(*PirgGroup) bool { return true }), nil
+}
+
+func (m *MemoryStore) GetPirgGroups(ctx context.Context, pirgId int) ([]*PirgGroup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pirgGroups(func(g *PirgGroup) bool { return g.PirgId == pirgId }), nil
+}
+
+func (m *MemoryStore) GetPirgGroupById(ctx context.Context, id int) (*PirgGroup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	group, ok := m.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPirgGroup(group), nil
+}
+
+// checkPirgGroup validates the request and enforces the unique name within
+// the pirg and the unique gid, ignoring the group being updated
+func (m *MemoryStore) checkPirgGroup(id int, pirgId int, gr *PirgGroupRequest) error {
+	pirg, ok := m.pirgs[pirgId]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := validatePirgGroupRequest(pirg, gr); err != nil {
+		return err
+	}
+	for _, existing := range m.groups {
+		if existing.Id == id {
+			continue
+		}
+		if existing.PirgId == pirgId && existing.Name == gr.Name {
+			return fmt.Errorf("%w: pirg %s already has a group named %s", ErrConflict, pirg.Name, gr.Name)
+		}
+		if gr.Gid != nil && sameIntPtr(existing.Gid, gr.Gid) {
+			return fmt.Errorf("%w: group with gid %d already exists", ErrConflict, *gr.Gid)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreatePirgGroup(ctx context.Context, pirgId int, gr *PirgGroupRequest) (*PirgGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkPirgGroup(0, pirgId, gr); err != nil {
+		return nil, err
+	}
+	ts := now()
+	group := &PirgGroup{
+		Id:         m.nextId("pirgs_groups"),
+		PirgId:     pirgId,
+		Name:       gr.Name,
+		Gid:        copyIntPtr(gr.Gid),
+		UserIds:    sortedUniqueIds(gr.UserIds),
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	m.groups[group.Id] = group
+	return copyPirgGroup(group), nil
+}
+
+func (m *MemoryStore) UpdatePirgGroup(ctx context.Context, id int, gr *PirgGroupRequest) (*PirgGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	group, ok := m.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := m.checkPirgGroup(id, group.PirgId, gr); err != nil {
+		return nil, err
+	}
+	group.Name = gr.Name
+	group.Gid = copyIntPtr(gr.Gid)
+	group.UserIds = sortedUniqueIds(gr.UserIds)
+	group.ModifiedAt = now()
+	return copyPirgGroup(group), nil
+}
+
+func (m *MemoryStore) DeletePirgGroup(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.groups, id)
+	return nil
+}
+
+func (m *MemoryStore) AllocateUnixIds(ctx context.Context, uids IdRange, gids IdRange) (*UnixIdAllocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var usedUids, users []int
+	for _, id := range sortedKeys(m.users) {
+		if uid := m.users[id].Uid; uid != nil {
+			usedUids = append(usedUids, *uid)
+		} else {
+			users = append(users, id)
+		}
+	}
+	var usedGids, pirgs, groups []int
+	for _, id := range sortedKeys(m.pirgs) {
+		if gid := m.pirgs[id].Gid; gid != nil {
+			usedGids = append(usedGids, *gid)
+		} else {
+			pirgs = append(pirgs, id)
+		}
+	}
+	for _, id := range sortedKeys(m.groups) {
+		if gid := m.groups[id].Gid; gid != nil {
+			usedGids = append(usedGids, *gid)
+		} else {
+			groups = append(groups, id)
+		}
+	}
+	newUids, err := planUnixIds("uid", uids, usedUids, m.lastUnixIds["uid"], users)
+	if err != nil {
+		return nil, err
+	}
+	newGids, err := planUnixIds("gid", gids, usedGids, m.lastUnixIds["gid"], append(append([]int{}, pirgs...), groups...))
+	if err != nil {
+		return nil, err
+	}
+	m.lastUnixIds["uid"] = lastUnixId(m.lastUnixIds["uid"], newUids)
+	m.lastUnixIds["gid"] = lastUnixId(m.lastUnixIds["gid"], newGids)
+	ts := now()
+	for i, id := range users {
+		m.users[id].Uid = &newUids[i]
+		m.users[id].ModifiedAt = ts
+	}
+	for i, id := range pirgs {
+		m.pirgs[id].Gid = &newGids[i]
+		m.pirgs[id].ModifiedAt = ts
+	}
+	for i, id := range groups {
+		m.groups[id].Gid = &newGids[len(pirgs)+i]
+		m.groups[id].ModifiedAt = ts
+	}
+	return &UnixIdAllocation{Users: len(users), Pirgs: len(pirgs), Groups: len(groups)}, nil
+}
+
+//
+// Directory snapshots
+//
+
+func copyDirectorySnapshot(d *DirectorySnapshot) *DirectorySnapshot {
+	c := *d
+	c.Entries = append([]byte(nil), d.Entries...)
+	return &c
+}
+
+func (m *MemoryStore) SaveDirectorySnapshot(ctx context.Context, digest string, entries []byte) (*DirectorySnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n := len(m.snapshots); n > 0 && m.snapshots[n-1].Digest == digest {
+		return copyDirectorySnapshot(m.snapshots[n-1]), nil
+	}
+	snapshot := &DirectorySnapshot{
+		Id:        m.nextId("directory_snapshots"),
+		Digest:    digest,
+		Entries:   append([]byte(nil), entries...),
+		CreatedAt: now(),
+	}
+	m.snapshots = append(m.snapshots, snapshot)
+	return copyDirectorySnapshot(snapshot), nil
+}
+
+func (m *MemoryStore) GetDirectorySnapshot(ctx context.Context, at time.Time) (*DirectorySnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.snapshots) - 1; i >= 0; i-- {
+		if !m.snapshots[i].CreatedAt.After(at) {
+			return copyDirectorySnapshot(m.snapshots[i]), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) PruneDirectorySnapshots(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// the latest snapshot before the time is kept
+	keep := 0
+	for keep < len(m.snapshots)-1 && m.snapshots[keep+1].CreatedAt.Before(before) {
+		keep++
+	}
+	m.snapshots = m.snapshots[keep:]
+	return keep, nil
+}
+
+//
+// Transactions
+//
+
+// memoryTx is handed to the function run by Transaction so that
+// transactions started inside it join the outer one
+type memoryTx struct {
+	*MemoryStore
+}
+
+func (tx memoryTx) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	return fn(tx)
+}
+
+// Transaction restores a copy of the state taken before fn ran if it
+// fails. Only one transaction runs at a time, but writes made outside
+// of one while it runs are also lost on rollback.
+func (m *MemoryStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	m.mu.RLock()
+	saved := m.memoryState.clone()
+	m.mu.RUnlock()
+	if err := fn(memoryTx{m}); err != nil {
+		m.mu.Lock()
+		m.memoryState = saved
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// copyRow is used for rows that have no fields shared with other rows
+func copyRow[T any](v *T) *T {
+	c := *v
+	return &c
+}
+
+func cloneRows[K comparable, T any](rows map[K]*T, copyFn func(*T) *T) map[K]*T {
+	c := make(map[K]*T, len(rows))
+	for k, v := range rows {
+		c[k] = copyFn(v)
+	}
+	return c
+}
+
+func cloneRowList[T any](rows []*T, copyFn func(*T) *T) []*T {
+	c := make([]*T, len(rows))
+	for i, v := range rows {
+		c[i] = copyFn(v)
+	}
+	return c
+}
+
+func (s *memoryState) clone() memoryState {
+	reviewItems := make(map[int][]*AccessReviewItem, len(s.reviewItems))
+	for id, items := range s.reviewItems {
+		reviewItems[id] = cloneRowList(items, copyAccessReviewItem)
+	}
+	return memoryState{
+		seq:           maps.Clone(s.seq),
+		users:         cloneRows(s.users, copyUser),
+		pirgs:         cloneRows(s.pirgs, copyPirg),
+		apiKeys:       cloneRows(s.apiKeys, copyRow[APIKeyEntry]),
+		locations:     cloneRows(s.locations, copyLocation),
+		storage:       cloneRows(s.storage, copyStorageAllocation),
+		clusters:      cloneRows(s.clusters, copyCluster),
+		clusterPirgs:  cloneRows(s.clusterPirgs, copyClusterPirg),
+		qos:           cloneRows(s.qos, copyQOS),
+		partitions:    cloneRows(s.partitions, copyPartition),
+		pirgSlurm:     cloneRows(s.pirgSlurm, copyPirgSlurm),
+		pirgLimits:    cloneRows(s.pirgLimits, copyPirgLimits),
+		compute:       cloneRows(s.compute, copyComputeAllocation),
+		storageUsage:  cloneRowList(s.storageUsage, copyRow[StorageUsage]),
+		reviews:       cloneRows(s.reviews, copyAccessReview),
+		reviewItems:   reviewItems,
+		usage:         cloneRows(s.usage, copyRow[JobUsage]),
+		terms:         cloneRows(s.terms, copyRow[MembershipTerm]),
+		audit:         cloneRowList(s.audit, copyRow[AuditEntry]),
+		notifications: cloneRowList(s.notifications, copyNotification),
+		groups:        cloneRows(s.groups, copyPirgGroup),
+		lastUnixIds:   maps.Clone(s.lastUnixIds),
+		snapshots:     cloneRowList(s.snapshots, copyDirectorySnapshot),
+	}
+}
@@ -0,0 +1,11 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
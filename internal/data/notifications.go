@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Notification is an email in the outbox. It's rendered from the template
+// for Event with Params when it's sent. It's pending until SentAt or
+// FailedAt is set.
+type Notification struct {
+	Id            int
+	Event         string
+	Recipient     string
+	Params        map[string]string
+	DedupeKey     string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	SentAt        *time.Time
+	FailedAt      *time.Time
+	CreatedAt     time.Time
+}
+
+// NotificationRequest queues an email. Only one notification is ever
+// queued for a DedupeKey, it's ignored when empty.
+type NotificationRequest struct {
+	Event     string
+	Recipient string
+	Params    map[string]string
+	DedupeKey string
+}
+
+// validateNotification is shared by every store implementation
+func validateNotification(nr *NotificationRequest) error {
+	if nr.Event == "" || nr.Recipient == "" {
+		return fmt.Errorf("notification event and recipient are required")
+	}
+	return nil
+}
+
+const notificationColumns = "id, event, recipient, params, COALESCE(dedupe_key, ''), attempts, last_error, next_attempt_at, sent_at, failed_at, created_at"
+
+func scanNotification(row interface{ Scan(...any) error }) (*Notification, error) {
+	var n Notification
+	var params []byte
+	var sentAt, failedAt sql.NullTime
+	err := row.Scan(&n.Id, &n.Event, &n.Recipient, &params, &n.DedupeKey, &n.Attempts, &n.LastError, &n.NextAttemptAt, &sentAt, &failedAt, &n.CreatedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if err := json.Unmarshal(params, &n.Params); err != nil {
+		return nil, fmt.Errorf("failed to decode params of notification %d: %w", n.Id, err)
+	}
+	n.SentAt, n.FailedAt = nullTimePtr(sentAt), nullTimePtr(failedAt)
+	return &n, nil
+}
+
+// EnqueueNotification adds an email to the outbox. It returns ErrConflict if
+// one was already queued with the same dedupe key.
+func (s *PostgresStore) EnqueueNotification(ctx context.Context, nr *NotificationRequest) (*Notification, error) {
+	ctx, span := startSpan(ctx, "EnqueueNotification")
+	defer span.End()
+	slog.DebugContext(ctx, "queueing notification in database", "event", nr.Event, "package", "data", "method", "EnqueueNotification")
+	if err := validateNotification(nr); err != nil {
+		return nil, err
+	}
+	values := nr.Params
+	if values == nil {
+		values = map[string]string{}
+	}
+	params, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var dedupeKey *string
+	if nr.DedupeKey != "" {
+		dedupeKey = &nr.DedupeKey
+	}
+	// next_attempt_at is compared with times from the server, so it's not left to NOW()
+	return scanNotification(s.q.QueryRowContext(ctx, "INSERT INTO notifications (event, recipient, params, dedupe_key, next_attempt_at) VALUES ($1, $2, $3, $4, $5) RETURNING "+notificationColumns,
+		nr.Event, nr.Recipient, params, dedupeKey, time.Now().UTC()))
+}
+
+// ClaimNotifications returns up to limit pending notifications that are due
+// by now, oldest first, counting an attempt for each. They aren't handed out
+// again until the lease is up, so servers sharing the database don't send
+// the same email.
+func (s *PostgresStore) ClaimNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Notification, error) {
+	ctx, span := startSpan(ctx, "ClaimNotifications")
+	defer span.End()
+	slog.DebugContext(ctx, "claiming notifications in database", "limit", limit, "package", "data", "method", "ClaimNotifications")
+	rows, err := s.q.QueryContext(ctx, `UPDATE notifications SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		) RETURNING `+notificationColumns, now.UTC(), now.Add(lease).UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var notifications []*Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortNotifications(notifications)
+	return notifications, nil
+}
+
+// MarkNotificationSent records that the notification was sent
+func (s *PostgresStore) MarkNotificationSent(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "MarkNotificationSent")
+	defer span.End()
+	slog.DebugContext(ctx, "marking notification sent in database", "id", id, "package", "data", "method", "MarkNotificationSent")
+	res, err := s.q.ExecContext(ctx, "UPDATE notifications SET sent_at = NOW(), last_error = '' WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+// MarkNotificationFailed records why sending the notification failed. It's
+// tried again at retryAt, or given up on if retryAt is nil.
+func (s *PostgresStore) MarkNotificationFailed(ctx context.Context, id int, reason string, retryAt *time.Time) error {
+	ctx, span := startSpan(ctx, "MarkNotificationFailed")
+	defer span.End()
+	slog.DebugContext(ctx, "marking notification failed in database", "id", id, "package", "data", "method", "MarkNotificationFailed")
+	var res sql.Result
+	var err error
+	if retryAt != nil {
+		res, err = s.q.ExecContext(ctx, "UPDATE notifications SET last_error = $1, next_attempt_at = $2 WHERE id = $3", reason, retryAt.UTC(), id)
+	} else {
+		res, err = s.q.ExecContext(ctx, "UPDATE notifications SET last_error = $1, failed_at = NOW() WHERE id = $2", reason, id)
+	}
+	return checkAffectedRows(res, err)
+}
+
+// sortNotifications orders claimed notifications oldest first
+func sortNotifications(notifications []*Notification) {
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].Id < notifications[j].Id })
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -13,6 +14,7 @@ type Pirg struct {
 	Id         int       `json:"id"`
 	Name       string    `json:"name"`
 	OwnerId    int       `json:"owner_id"`
+	Gid        *int      `json:"gid"`
 	AdminIds   []int     `json:"admin_ids"`
 	UserIds    []int     `json:"user_ids"`
 	CreatedAt  time.Time `json:"created_at"`
@@ -22,24 +24,38 @@ type Pirg struct {
 type PirgRequest struct {
 	Name     string `json:"name"`
 	OwnerId  int    `json:"owner_id"`
+	Gid      *int   `json:"gid"`
 	AdminIds []int  `json:"admin_ids"`
 	UserIds  []int  `json:"user_ids"`
 }
 
-func GetAllPirgs(db *sql.DB) ([]*Pirg, error) {
-	var pirgs []*Pirg
-	rows, err := db.Query("SELECT id FROM pirgs")
+// CountPirgs returns the number of pirgs
+func (s *PostgresStore) CountPirgs(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "CountPirgs")
+	defer span.End()
+	slog.DebugContext(ctx, "counting pirgs in database", "package", "data", "method", "CountPirgs")
+	var count int
+	err := s.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM pirgs").Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) GetAllPirgs(ctx context.Context) ([]*Pirg, error) {
+	ctx, span := startSpan(ctx, "GetAllPirgs")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all pirgs from database", "package", "data", "method", "GetAllPirgs")
+	// collect the ids first, the connection can't be shared
+	// with the lookups below while the rows are open
+	ids, err := s.queryIds(ctx, "SELECT id FROM pirgs ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var id int
-		err := rows.Scan(&id)
-		if err != nil {
-			return nil, err
-		}
-		pirg, err := GetPirgById(db, id)
+	return s.getPirgsById(ctx, ids)
+}
+
+func (s *PostgresStore) getPirgsById(ctx context.Context, ids []int) ([]*Pirg, error) {
+	var pirgs []*Pirg
+	for _, id := range ids {
+		pirg, err := s.GetPirgById(ctx, id)
 		if err != nil {
 			return nil, err
 		}
@@ -48,251 +64,286 @@ func GetAllPirgs(db *sql.DB) ([]*Pirg, error) {
 	return pirgs, nil
 }
 
-func GetPirgById(db *sql.DB, id int) (*Pirg, error) {
-	slog.Debug("querying database for pirg", "id", id, "package", "data", "method", "GetPirgById")
-	var pirg Pirg
-	err := db.QueryRow("SELECT id, name, owner_id, created_at, modified_at FROM pirgs WHERE id = $1", id).Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt)
+func (s *PostgresStore) GetPirgById(ctx context.Context, id int) (*Pirg, error) {
+	ctx, span := startSpan(ctx, "GetPirgById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for pirg", "id", id, "package", "data", "method", "GetPirgById")
+	pirg, err := s.scanPirg(ctx, s.q.QueryRowContext(ctx, "SELECT "+pirgColumns+" FROM pirgs WHERE id = $1", id))
 	if err != nil {
-		slog.Error("failed to look up pirg from database", "package", "data", "method", "GetPirgById", "error", err)
+		slog.DebugContext(ctx, "failed to look up pirg from database", "package", "data", "method", "GetPirgById", "error", err)
 		return nil, err
 	}
-	adminIds, err := getPirgAdminIds(db, id)
+	return pirg, nil
+}
+
+func (s *PostgresStore) GetPirgByName(ctx context.Context, name string) (*Pirg, error) {
+	ctx, span := startSpan(ctx, "GetPirgByName")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for pirg", "name", name, "package", "data", "method", "GetPirgByName")
+	pirg, err := s.scanPirg(ctx, s.q.QueryRowContext(ctx, "SELECT "+pirgColumns+" FROM pirgs WHERE name = $1", name))
 	if err != nil {
+		slog.DebugContext(ctx, "failed to look up pirg from database", "package", "data", "method", "GetPirgByName", "error", err)
 		return nil, err
 	}
-	pirg.AdminIds = adminIds
-	userIds, err := getPirgUserIds(db, id)
+	return pirg, nil
+}
+
+func (s *PostgresStore) GetPirgByGid(ctx context.Context, gid int) (*Pirg, error) {
+	ctx, span := startSpan(ctx, "GetPirgByGid")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for pirg", "gid", gid, "package", "data", "method", "GetPirgByGid")
+	pirg, err := s.scanPirg(ctx, s.q.QueryRowContext(ctx, "SELECT "+pirgColumns+" FROM pirgs WHERE gid = $1", gid))
 	if err != nil {
+		slog.DebugContext(ctx, "failed to look up pirg from database", "package", "data", "method", "GetPirgByGid", "error", err)
 		return nil, err
 	}
-	pirg.UserIds = userIds
-	return &pirg, err
+	return pirg, nil
 }
 
-func GetPirgByName(db *sql.DB, name string) (*Pirg, error) {
-	slog.Debug("querying database for pirg", "name", name, "package", "data", "method", "GetPirgByName")
+const pirgColumns = "id, name, owner_id, gid, created_at, modified_at"
+
+// scanPirg reads a row of pirgColumns and loads the members of the pirg
+func (s *PostgresStore) scanPirg(ctx context.Context, row *sql.Row) (*Pirg, error) {
 	var pirg Pirg
-	err := db.QueryRow("SELECT id, name, owner_id, created_at, modified_at FROM pirgs WHERE name = $1", name).Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &pirg.CreatedAt, &pirg.ModifiedAt)
-	if err != nil {
-		slog.Error("failed to look up pirg from database", "package", "data", "method", "GetPirgByName", "error", err)
-		return nil, err
+	var gid sql.NullInt64
+	if err := row.Scan(&pirg.Id, &pirg.Name, &pirg.OwnerId, &gid, &pirg.CreatedAt, &pirg.ModifiedAt); err != nil {
+		return nil, mapError(err)
 	}
-	adminIds, err := getPirgAdminIds(db, pirg.Id)
+	pirg.Gid = nullIntPtr(gid)
+	return s.loadPirgMembers(ctx, &pirg)
+}
+
+func (s *PostgresStore) loadPirgMembers(ctx context.Context, pirg *Pirg) (*Pirg, error) {
+	adminIds, err := s.getPirgAdminIds(ctx, pirg.Id)
 	if err != nil {
 		return nil, err
 	}
 	pirg.AdminIds = adminIds
-	userIds, err := getPirgUserIds(db, pirg.Id)
+	userIds, err := s.getPirgUserIds(ctx, pirg.Id)
 	if err != nil {
 		return nil, err
 	}
 	pirg.UserIds = userIds
-	return &pirg, err
+	return pirg, nil
 }
 
-func getPirgAdminIds(db *sql.DB, id int) ([]int, error) {
-	slog.Debug("getting pirg admin ids from database", "package", "data", "method", "getPirgAdminIds")
-	var adminIds []int
-	rows, err := db.Query("SELECT user_id FROM pirgs_admins WHERE pirg_id = $1", id)
+func (s *PostgresStore) getPirgAdminIds(ctx context.Context, id int) ([]int, error) {
+	slog.DebugContext(ctx, "getting pirg admin ids from database", "package", "data", "method", "getPirgAdminIds")
+	adminIds, err := s.queryIds(ctx, "SELECT user_id FROM pirgs_admins WHERE pirg_id = $1 ORDER BY user_id", id)
 	if err != nil {
 		slog.Error("failed to look up pirg admins from database", "package", "data", "method", "getPirgAdminIds", "error", err)
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var adminId int
-		err := rows.Scan(&adminId)
-		if err != nil {
-			return nil, err
-		}
-		adminIds = append(adminIds, adminId)
-	}
-	return adminIds, err
+	return adminIds, nil
 }
 
-func getPirgUserIds(db *sql.DB, id int) ([]int, error) {
-	slog.Debug("getting pirg user ids from database", "package", "data", "method", "getPirgUserIds")
-	var userIds []int
-	rows, err := db.Query("SELECT user_id FROM pirgs_users WHERE pirg_id = $1", id)
+func (s *PostgresStore) getPirgUserIds(ctx context.Context, id int) ([]int, error) {
+	slog.DebugContext(ctx, "getting pirg user ids from database", "package", "data", "method", "getPirgUserIds")
+	userIds, err := s.queryIds(ctx, "SELECT user_id FROM pirgs_users WHERE pirg_id = $1 ORDER BY user_id", id)
 	if err != nil {
 		slog.Error("failed to look up pirg users from database", "package", "data", "method", "getPirgUserIds", "error", err)
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var userId int
-		err := rows.Scan(&userId)
-		if err != nil {
-			return nil, err
-		}
-		userIds = append(userIds, userId)
-	}
-	return userIds, err
+	return userIds, nil
 }
 
-func CreatePirg(db *sql.DB, pirg *PirgRequest) (*Pirg, error) {
-	slog.Debug("creating new pirg in database", "package", "data", "method", "CreatePirg")
-	var newId int
-
-	// verify that owner_id is a valid user
-	err := validateUserId(db, pirg.OwnerId)
-	if err != nil {
-		return nil, fmt.Errorf("validating owner_id failed: %v", err)
+func (s *PostgresStore) CreatePirg(ctx context.Context, pirg *PirgRequest) (*Pirg, error) {
+	ctx, span := startSpan(ctx, "CreatePirg")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new pirg in database", "package", "data", "method", "CreatePirg")
+	if err := validatePirgRequest(ctx, s, pirg); err != nil {
+		return nil, err
 	}
-	// verify that all the admin_ids are users
-	for _, adminId := range pirg.AdminIds {
-		err = validateUserId(db, adminId)
+	var newPirg *Pirg
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		var newId int
+		err := tx.q.QueryRowContext(ctx, "INSERT INTO pirgs (name, owner_id, gid) VALUES ($1, $2, $3) RETURNING id", pirg.Name, pirg.OwnerId, pirg.Gid).Scan(&newId)
 		if err != nil {
-			return nil, fmt.Errorf("validating admin_id failed: %v", err)
+			return mapError(err)
 		}
-	}
-	// verify that all the user_ids are users
-	for _, userId := range pirg.UserIds {
-		err = validateUserId(db, userId)
-		if err != nil {
-			return nil, fmt.Errorf("validating user_id failed: %v", err)
+		for _, adminId := range uniqueIds(pirg.AdminIds) {
+			if err = tx.addPirgAdmin(ctx, newId, adminId); err != nil {
+				return err
+			}
 		}
-	}
-	err = db.QueryRow("INSERT INTO pirgs (name, owner_id) VALUES ($1, $2) RETURNING id", pirg.Name, pirg.OwnerId).Scan(&newId)
-	if err != nil {
-		return nil, err
-	}
-	for adminId := range pirg.AdminIds {
-		db.QueryRow("INSERT INTO pirgs_admins (pirg_id, user_id) VALUES ($1, $2)", newId, adminId)
-	}
-	for userId := range pirg.UserIds {
-		db.QueryRow("INSERT INTO pirgs_users (pirg_id, user_id) VALUES ($1, $2)", newId, userId)
-	}
-	newPirg, err := GetPirgById(db, newId)
+		for _, userId := range uniqueIds(pirg.UserIds) {
+			if err = tx.addPirgUser(ctx, newId, userId); err != nil {
+				return err
+			}
+		}
+		newPirg, err = tx.GetPirgById(ctx, newId)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return newPirg, err
+	return newPirg, nil
 }
 
-func UpdatePirg(db *sql.DB, id int, pr *PirgRequest) (*Pirg, error) {
-	slog.Debug("updating pirg in database", "package", "data", "method", "UpdatePirg")
-	existingPirg, err := GetPirgById(db, id)
-	if err != nil {
+func (s *PostgresStore) UpdatePirg(ctx context.Context, id int, pr *PirgRequest) (*Pirg, error) {
+	ctx, span := startSpan(ctx, "UpdatePirg")
+	defer span.End()
+	slog.DebugContext(ctx, "updating pirg in database", "package", "data", "method", "UpdatePirg")
+	if err := validatePirgRequest(ctx, s, pr); err != nil {
 		return nil, err
 	}
-	// Updates name and owner_id if changed
-	if pr.Name != existingPirg.Name || pr.OwnerId != existingPirg.OwnerId {
-		slog.Debug("updating pirg name and owner_id", "name", pr.Name, "owner_id", pr.OwnerId, "package", "data", "method", "UpdatePirg")
-		res, err := db.Exec("UPDATE pirgs SET name = $1, owner_id = $2 WHERE id = $3", pr.Name, pr.OwnerId, id)
-		if err = checkAffectedRows(res, err); err != nil {
-			return nil, err
+	var newPirg *Pirg
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		existingPirg, err := tx.GetPirgById(ctx, id)
+		if err != nil {
+			return err
 		}
-	}
-	existingAdminIds, err := getPirgAdminIds(db, id)
-	if err != nil {
-		return nil, err
-	}
-	// Adds new admin ids
-	for _, adminId := range pr.AdminIds {
-		if !slices.Contains(existingAdminIds, adminId) {
-			if err = addPirgAdmin(db, id, adminId); err != nil {
-				return nil, err
+		// Updates name, owner_id and gid if changed
+		if pr.Name != existingPirg.Name || pr.OwnerId != existingPirg.OwnerId || !sameIntPtr(pr.Gid, existingPirg.Gid) {
+			slog.DebugContext(ctx, "updating pirg name, owner_id and gid", "name", pr.Name, "owner_id", pr.OwnerId, "package", "data", "method", "UpdatePirg")
+			res, err := tx.q.ExecContext(ctx, "UPDATE pirgs SET name = $1, owner_id = $2, gid = $3 WHERE id = $4", pr.Name, pr.OwnerId, pr.Gid, id)
+			if err = checkAffectedRows(res, err); err != nil {
+				return err
 			}
 		}
-	}
-	// Removes admin ids not present in request
-	for _, existingAdminId := range existingAdminIds {
-		if !slices.Contains(pr.AdminIds, existingAdminId) {
-			if err = deletePirgAdmin(db, id, existingAdminId); err != nil {
-				return nil, err
+		// Adds new admin ids
+		for _, adminId := range uniqueIds(pr.AdminIds) {
+			if !slices.Contains(existingPirg.AdminIds, adminId) {
+				if err = tx.addPirgAdmin(ctx, id, adminId); err != nil {
+					return err
+				}
 			}
 		}
-	}
-	existingUserIds, err := getPirgUserIds(db, id)
-	if err != nil {
-		return nil, err
-	}
-	// Adds new User ids
-	for _, UserId := range pr.UserIds {
-		if !slices.Contains(existingUserIds, UserId) {
-			if err = addPirgUser(db, id, UserId); err != nil {
-				return nil, err
+		// Removes admin ids not present in request
+		for _, existingAdminId := range existingPirg.AdminIds {
+			if !slices.Contains(pr.AdminIds, existingAdminId) {
+				if err = tx.deletePirgAdmin(ctx, id, existingAdminId); err != nil {
+					return err
+				}
 			}
 		}
-	}
-	// Removes User ids not present in request
-	for _, existingUserId := range existingUserIds {
-		if !slices.Contains(pr.UserIds, existingUserId) {
-			if err = deletePirgUser(db, id, existingUserId); err != nil {
-				return nil, err
+		// Adds new user ids
+		for _, userId := range uniqueIds(pr.UserIds) {
+			if !slices.Contains(existingPirg.UserIds, userId) {
+				if err = tx.addPirgUser(ctx, id, userId); err != nil {
+					return err
+				}
 			}
 		}
-	}
-	newPirg, err := GetPirgById(db, id)
+		// Removes user ids not present in request
+		for _, existingUserId := range existingPirg.UserIds {
+			if !slices.Contains(pr.UserIds, existingUserId) {
+				if err = tx.deletePirgUser(ctx, id, existingUserId); err != nil {
+					return err
+				}
+			}
+		}
+		newPirg, err = tx.GetPirgById(ctx, id)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return newPirg, err
+	return newPirg, nil
 }
 
-func DeletePirg(db *sql.DB, id int) error {
-	slog.Debug("deleting pirg from database", "package", "data", "method", "DeletePirg")
-	res, err := db.Exec("DELETE FROM pirgs WHERE id = $1", id)
-	if err != nil {
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil || count != 1 {
-		return err
-	}
-	return nil
-}
-
-func checkAffectedRows(res sql.Result, err error) error {
-	if err != nil {
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if count != 1 {
-		return fmt.Errorf("expected to update 1 row, updated %d rows", count)
-	}
-	return nil
+// DeletePirg removes a pirg along with its memberships, groups, group
+// memberships, cluster access, slurm grants, storage and compute allocations,
+// usage and access reviews in a single transaction
+func (s *PostgresStore) DeletePirg(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeletePirg")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting pirg from database", "package", "data", "method", "DeletePirg")
+	return s.withTx(ctx, func(tx *PostgresStore) error {
+		stmts := []string{
+			"DELETE FROM groups_users WHERE group_id IN (SELECT id FROM pirgs_groups WHERE pirg_id = $1)",
+			"DELETE FROM pirgs_groups WHERE pirg_id = $1",
+			"DELETE FROM clusters_pirgs_users WHERE pirg_id = $1",
+			"DELETE FROM clusters_pirgs WHERE pirg_id = $1",
+			"DELETE FROM pirgs_users_qos WHERE pirg_id = $1",
+			"DELETE FROM pirgs_users_partitions WHERE pirg_id = $1",
+			"DELETE FROM pirgs_qos WHERE pirg_id = $1",
+			"DELETE FROM pirgs_partitions WHERE pirg_id = $1",
+			"DELETE FROM pirgs_admins WHERE pirg_id = $1",
+			"DELETE FROM pirgs_users WHERE pirg_id = $1",
+			"DELETE FROM storage_allocations WHERE pirg_id = $1",
+			"DELETE FROM storage_usage WHERE pirg_id = $1",
+			"DELETE FROM compute_allocations WHERE pirg_id = $1",
+			// usage is kept for historical reports under its account name
+			"UPDATE compute_usage SET pirg_id = NULL WHERE pirg_id = $1",
+			"DELETE FROM access_review_items WHERE pirg_id = $1",
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.q.ExecContext(ctx, stmt, id); err != nil {
+				slog.Error("failed to delete pirg dependents", "package", "data", "method", "DeletePirg", "error", err)
+				return err
+			}
+		}
+		res, err := tx.q.ExecContext(ctx, "DELETE FROM pirgs WHERE id = $1", id)
+		return checkAffectedRows(res, err)
+	})
 }
 
-func addPirgAdmin(db *sql.DB, pirgId int, userId int) error {
-	slog.Debug("adding pirg admin to database", "package", "data", "method", "addPirgAdmin")
-	_, err := db.Exec("INSERT INTO pirgs_admins (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
-	return err
+func (s *PostgresStore) addPirgAdmin(ctx context.Context, pirgId int, userId int) error {
+	slog.DebugContext(ctx, "adding pirg admin to database", "package", "data", "method", "addPirgAdmin")
+	_, err := s.q.ExecContext(ctx, "INSERT INTO pirgs_admins (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
+	return mapError(err)
 }
 
-func deletePirgAdmin(db *sql.DB, pirgId int, userId int) error {
-	slog.Debug("deleting pirg admin from database", "package", "data", "method", "deletePirgAdmin")
-	_, err := db.Exec("DELETE FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
+func (s *PostgresStore) deletePirgAdmin(ctx context.Context, pirgId int, userId int) error {
+	slog.DebugContext(ctx, "deleting pirg admin from database", "package", "data", "method", "deletePirgAdmin")
+	_, err := s.q.ExecContext(ctx, "DELETE FROM pirgs_admins WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
 	return err
 }
 
-func addPirgUser(db *sql.DB, pirgId int, userId int) error {
-	slog.Debug("adding pirg user to database", "package", "data", "method", "addPirgUser")
-	_, err := db.Exec("INSERT INTO pirgs_users (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
-	return err
+func (s *PostgresStore) addPirgUser(ctx context.Context, pirgId int, userId int) error {
+	slog.DebugContext(ctx, "adding pirg user to database", "package", "data", "method", "addPirgUser")
+	_, err := s.q.ExecContext(ctx, "INSERT INTO pirgs_users (pirg_id, user_id) VALUES ($1, $2)", pirgId, userId)
+	return mapError(err)
 }
 
-func deletePirgUser(db *sql.DB, pirgId int, userId int) error {
-	slog.Debug("deleting pirg user from database", "package", "data", "method", "deletePirgUser")
-	_, err := db.Exec("DELETE FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
+func (s *PostgresStore) deletePirgUser(ctx context.Context, pirgId int, userId int) error {
+	slog.DebugContext(ctx, "deleting pirg user from database", "package", "data", "method", "deletePirgUser")
+	_, err := s.q.ExecContext(ctx, "DELETE FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2", pirgId, userId)
 	return err
 }
 
-func updateModifiedDatestamp(db *sql.DB, table string, id int) error {
-	slog.Debug("updating modified_at in database", "package", "data", "method", "updateModifiedDatestamp")
-	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET modified_at = NOW() WHERE id = $1", table), id)
-	return err
+// validatePirgRequest makes sure the owner, admins and users of the
+// request exist. It's shared by every store implementation.
+func validatePirgRequest(ctx context.Context, users UserStore, pirg *PirgRequest) error {
+	if pirg.Gid != nil && *pirg.Gid <= 0 {
+		return fmt.Errorf("gid must be positive")
+	}
+	// verify that owner_id is a valid user
+	if err := validateUserId(ctx, users, pirg.OwnerId); err != nil {
+		return fmt.Errorf("validating owner_id failed: %v", err)
+	}
+	// verify that all the admin_ids are users
+	for _, adminId := range pirg.AdminIds {
+		if err := validateUserId(ctx, users, adminId); err != nil {
+			return fmt.Errorf("validating admin_id failed: %v", err)
+		}
+	}
+	// verify that all the user_ids are users
+	for _, userId := range pirg.UserIds {
+		if err := validateUserId(ctx, users, userId); err != nil {
+			return fmt.Errorf("validating user_id failed: %v", err)
+		}
+	}
+	return nil
 }
 
-func validateUserId(db *sql.DB, userId int) error {
-	slog.Debug("validating user id", "id", userId, "package", "data", "method", "validateUserIds")
-	_, err := GetUserById(db, userId)
+func validateUserId(ctx context.Context, users UserStore, userId int) error {
+	slog.DebugContext(ctx, "validating user id", "id", userId, "package", "data", "method", "validateUserId")
+	_, err := users.GetUserById(ctx, userId)
 	if err != nil {
 		return fmt.Errorf("user does not exist with id: %d", userId)
 	}
 	return nil
 }
+
+// uniqueIds returns ids with duplicates removed, keeping the first occurrence
+func uniqueIds(ids []int) []int {
+	var unique []int
+	for _, id := range ids {
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
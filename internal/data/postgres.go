@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx so store methods
+// can run inside or outside of a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// PostgresStore implements Store on top of a postgres connection pool
+type PostgresStore struct {
+	// db is nil when the store is bound to a transaction
+	db *sql.DB
+	q  dbtx
+}
+
+var _ Store = (*PostgresStore)(nil)
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, q: tracedDB{db}}
+}
+
+// withTx runs fn inside a transaction, committing if it returns nil.
+// If the store is already bound to a transaction, fn joins it.
+func (s *PostgresStore) withTx(ctx context.Context, fn func(tx *PostgresStore) error) error {
+	if s.db == nil {
+		return fn(s)
+	}
+	ctx, span := tracer.Start(ctx, "db.transaction", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return endSpan(span, err)
+	}
+	defer tx.Rollback()
+	if err = fn(&PostgresStore{q: tracedDB{tx}}); err != nil {
+		return endSpan(span, err)
+	}
+	return endSpan(span, tx.Commit())
+}
+
+func (s *PostgresStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(ctx, func(tx *PostgresStore) error { return fn(tx) })
+}
+
+var tracer = otel.Tracer("github.com/lcrownover/hpcadmin-server/internal/data")
+
+// tracedDB records a span for every statement run through it
+type tracedDB struct {
+	q dbtx
+}
+
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBStatement(query),
+		))
+}
+
+// startSpan starts a span covering a whole store method
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "data."+method)
+}
+
+// endSpan records err, if any, on the span and passes it through
+func endSpan(span trace.Span, err error) error {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (t tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+	res, err := t.q.ExecContext(ctx, query, args...)
+	return res, endSpan(span, err)
+}
+
+func (t tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+	rows, err := t.q.QueryContext(ctx, query, args...)
+	return rows, endSpan(span, err)
+}
+
+func (t tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+	row := t.q.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+// mapError translates driver errors into the store's sentinel errors
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		// unique_violation, foreign_key_violation
+		case "23505", "23503":
+			return fmt.Errorf("%w: %s", ErrConflict, pqErr.Message)
+		}
+	}
+	return err
+}
+
+func checkAffectedRows(res sql.Result, err error) error {
+	if err != nil {
+		return mapError(err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	if count != 1 {
+		return fmt.Errorf("expected to update 1 row, updated %d rows", count)
+	}
+	return nil
+}
+
+// queryIds runs a query that returns a single integer column
+func (s *PostgresStore) queryIds(ctx context.Context, query string, args ...any) ([]int, error) {
+	var ids []int
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPostgresStore(t *testing.T) {
+	runStoreConformance(t, func(t *testing.T) Store {
+		return NewPostgresStore(NewTestDataHandler(t).DB)
+	})
+}
+
+// failingDB fails every statement
+type failingDB struct{}
+
+var errFailingDB = errors.New("connection refused")
+
+func (failingDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return nil, errFailingDB
+}
+
+func (failingDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, errFailingDB
+}
+
+func (failingDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return nil
+}
+
+func TestQuerySpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	s := &PostgresStore{q: tracedDB{failingDB{}}}
+	err := s.DeleteUser(context.Background(), 1)
+	if !errors.Is(err, errFailingDB) {
+		t.Fatalf("expected the database error, got %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected a method span and a query span, got %d spans", len(spans))
+	}
+	query, method := spans[0], spans[1]
+	if method.Name() != "data.DeleteUser" {
+		t.Errorf("expected method span data.DeleteUser, got %s", method.Name())
+	}
+	if query.Name() != "db.query" || query.Parent().SpanID() != method.SpanContext().SpanID() {
+		t.Errorf("expected db.query span under the method span, got %s", query.Name())
+	}
+	if query.Status().Code != codes.Error {
+		t.Errorf("expected query span to record the error, got %v", query.Status())
+	}
+	var statement string
+	for _, attr := range query.Attributes() {
+		if attr.Key == "db.statement" {
+			statement = attr.Value.AsString()
+		}
+	}
+	if statement != "DELETE FROM users WHERE id = $1" {
+		t.Errorf("unexpected db.statement %q", statement)
+	}
+}
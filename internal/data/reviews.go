@@ -0,0 +1,366 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Decisions an owner can make about a member during an access review
+const (
+	ReviewPending   = "pending"
+	ReviewConfirmed = "confirmed"
+	ReviewRemoved   = "removed"
+)
+
+// AccessReview is a campaign asking pirg owners to confirm or remove each
+// of their members before the deadline. It's open until ClosedAt is set.
+type AccessReview struct {
+	Id                int
+	Name              string
+	Deadline          time.Time
+	RemoveUnconfirmed bool
+	ClosedAt          *time.Time
+	CreatedAt         time.Time
+	ModifiedAt        time.Time
+}
+
+// AccessReviewRequest opens a review of the listed pirgs, or of every pirg
+// if PirgIds is empty
+type AccessReviewRequest struct {
+	Name              string
+	Deadline          time.Time
+	RemoveUnconfirmed bool
+	PirgIds           []int
+}
+
+// AccessReviewItem is a member of a pirg when the review was opened.
+// DecidedBy is nil for pending items and members removed when the review closed.
+type AccessReviewItem struct {
+	ReviewId  int
+	PirgId    int
+	UserId    int
+	Decision  string
+	DecidedBy *int
+	DecidedAt *time.Time
+}
+
+// AccessReviewDecision confirms or removes a member
+type AccessReviewDecision struct {
+	UserId   int
+	Decision string
+}
+
+// AccessReviewProgress counts the decisions made for a pirg
+type AccessReviewProgress struct {
+	PirgId    int
+	Pending   int
+	Confirmed int
+	Removed   int
+}
+
+// ReviewProgress counts the decisions for each pirg in the items, ordered by pirg id
+func ReviewProgress(items []*AccessReviewItem) []*AccessReviewProgress {
+	byPirg := make(map[int]*AccessReviewProgress)
+	for _, item := range items {
+		p, ok := byPirg[item.PirgId]
+		if !ok {
+			p = &AccessReviewProgress{PirgId: item.PirgId}
+			byPirg[item.PirgId] = p
+		}
+		switch item.Decision {
+		case ReviewConfirmed:
+			p.Confirmed++
+		case ReviewRemoved:
+			p.Removed++
+		default:
+			p.Pending++
+		}
+	}
+	var progress []*AccessReviewProgress
+	for _, id := range sortedKeys(byPirg) {
+		progress = append(progress, byPirg[id])
+	}
+	return progress
+}
+
+// validateAccessReview is shared by every store implementation
+func validateAccessReview(rr *AccessReviewRequest) error {
+	if rr.Name == "" {
+		return fmt.Errorf("access review name is required")
+	}
+	if rr.Deadline.IsZero() {
+		return fmt.Errorf("access review deadline is required")
+	}
+	return nil
+}
+
+// validateAccessReviewDecisions is shared by every store implementation
+func validateAccessReviewDecisions(decisions []AccessReviewDecision) error {
+	for _, d := range decisions {
+		if d.Decision != ReviewConfirmed && d.Decision != ReviewRemoved {
+			return fmt.Errorf("invalid decision %q for user %d, must be %s or %s", d.Decision, d.UserId, ReviewConfirmed, ReviewRemoved)
+		}
+	}
+	return nil
+}
+
+const accessReviewColumns = "id, name, deadline, remove_unconfirmed, closed_at, created_at, modified_at"
+
+func scanAccessReview(row interface{ Scan(...any) error }) (*AccessReview, error) {
+	var ar AccessReview
+	var closedAt sql.NullTime
+	err := row.Scan(&ar.Id, &ar.Name, &ar.Deadline, &ar.RemoveUnconfirmed, &closedAt, &ar.CreatedAt, &ar.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if closedAt.Valid {
+		ar.ClosedAt = &closedAt.Time
+	}
+	return &ar, nil
+}
+
+// CountPendingAccessRequests returns the number of members waiting on a
+// decision in open access reviews
+func (s *PostgresStore) CountPendingAccessRequests(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "CountPendingAccessRequests")
+	defer span.End()
+	slog.DebugContext(ctx, "counting pending access requests in database", "package", "data", "method", "CountPendingAccessRequests")
+	var count int
+	err := s.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM access_review_items i
+		JOIN access_reviews r ON r.id = i.review_id
+		WHERE r.closed_at IS NULL AND i.decision = $1`, ReviewPending).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) GetAllAccessReviews(ctx context.Context) ([]*AccessReview, error) {
+	ctx, span := startSpan(ctx, "GetAllAccessReviews")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all access reviews from database", "package", "data", "method", "GetAllAccessReviews")
+	rows, err := s.q.QueryContext(ctx, "SELECT "+accessReviewColumns+" FROM access_reviews ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reviews []*AccessReview
+	for rows.Next() {
+		ar, err := scanAccessReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, ar)
+	}
+	return reviews, rows.Err()
+}
+
+func (s *PostgresStore) GetAccessReviewById(ctx context.Context, id int) (*AccessReview, error) {
+	ctx, span := startSpan(ctx, "GetAccessReviewById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for access review", "id", id, "package", "data", "method", "GetAccessReviewById")
+	return scanAccessReview(s.q.QueryRowContext(ctx, "SELECT "+accessReviewColumns+" FROM access_reviews WHERE id = $1", id))
+}
+
+// CreateAccessReview opens a review with a pending item for every member of
+// the pirgs other than their owner
+func (s *PostgresStore) CreateAccessReview(ctx context.Context, rr *AccessReviewRequest) (*AccessReview, error) {
+	ctx, span := startSpan(ctx, "CreateAccessReview")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new access review in database", "name", rr.Name, "package", "data", "method", "CreateAccessReview")
+	if err := validateAccessReview(rr); err != nil {
+		return nil, err
+	}
+	var review *AccessReview
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		var err error
+		review, err = scanAccessReview(tx.q.QueryRowContext(ctx, "INSERT INTO access_reviews (name, deadline, remove_unconfirmed) VALUES ($1, $2, $3) RETURNING "+accessReviewColumns,
+			rr.Name, rr.Deadline.UTC(), rr.RemoveUnconfirmed))
+		if err != nil {
+			return err
+		}
+		pirgIds := uniqueIds(rr.PirgIds)
+		if len(pirgIds) == 0 {
+			if pirgIds, err = tx.queryIds(ctx, "SELECT id FROM pirgs ORDER BY id"); err != nil {
+				return err
+			}
+		}
+		for _, pirgId := range pirgIds {
+			res, err := tx.q.ExecContext(ctx, `INSERT INTO access_review_items (review_id, pirg_id, user_id)
+				SELECT $1, pu.pirg_id, pu.user_id FROM pirgs_users pu JOIN pirgs p ON p.id = pu.pirg_id
+				WHERE pu.pirg_id = $2 AND pu.user_id != p.owner_id`, review.Id, pirgId)
+			if err != nil {
+				return mapError(err)
+			}
+			// a pirg without members other than its owner adds nothing, so check it exists
+			if n, err := res.RowsAffected(); err == nil && n == 0 {
+				if _, err := tx.GetPirgById(ctx, pirgId); err != nil {
+					return fmt.Errorf("%w: pirg does not exist with id: %d", ErrConflict, pirgId)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+const accessReviewItemColumns = "review_id, pirg_id, user_id, decision, decided_by, decided_at"
+
+func scanAccessReviewItem(row interface{ Scan(...any) error }) (*AccessReviewItem, error) {
+	var item AccessReviewItem
+	var decidedBy sql.NullInt64
+	var decidedAt sql.NullTime
+	if err := row.Scan(&item.ReviewId, &item.PirgId, &item.UserId, &item.Decision, &decidedBy, &decidedAt); err != nil {
+		return nil, mapError(err)
+	}
+	item.DecidedBy = nullIntPtr(decidedBy)
+	if decidedAt.Valid {
+		item.DecidedAt = &decidedAt.Time
+	}
+	return &item, nil
+}
+
+// GetAccessReviewItems returns the items of the review ordered by pirg and user
+func (s *PostgresStore) GetAccessReviewItems(ctx context.Context, reviewId int) ([]*AccessReviewItem, error) {
+	ctx, span := startSpan(ctx, "GetAccessReviewItems")
+	defer span.End()
+	slog.DebugContext(ctx, "getting access review items from database", "review_id", reviewId, "package", "data", "method", "GetAccessReviewItems")
+	if _, err := s.GetAccessReviewById(ctx, reviewId); err != nil {
+		return nil, err
+	}
+	rows, err := s.q.QueryContext(ctx, "SELECT "+accessReviewItemColumns+" FROM access_review_items WHERE review_id = $1 ORDER BY pirg_id, user_id", reviewId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*AccessReviewItem
+	for rows.Next() {
+		item, err := scanAccessReviewItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DecideAccessReview records the decisions about members of the pirg in an
+// open review. Removed members are taken out of the pirg straight away.
+func (s *PostgresStore) DecideAccessReview(ctx context.Context, reviewId int, pirgId int, decisions []AccessReviewDecision, decidedBy *int) ([]*AccessReviewItem, error) {
+	ctx, span := startSpan(ctx, "DecideAccessReview")
+	defer span.End()
+	slog.DebugContext(ctx, "deciding access review items in database", "review_id", reviewId, "pirg_id", pirgId, "package", "data", "method", "DecideAccessReview")
+	if err := validateAccessReviewDecisions(decisions); err != nil {
+		return nil, err
+	}
+	var items []*AccessReviewItem
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		review, err := tx.GetAccessReviewById(ctx, reviewId)
+		if err != nil {
+			return err
+		}
+		if review.ClosedAt != nil {
+			return fmt.Errorf("%w: access review %d is closed", ErrConflict, reviewId)
+		}
+		pirg, err := tx.GetPirgById(ctx, pirgId)
+		if err != nil {
+			return err
+		}
+		for _, d := range decisions {
+			if d.Decision == ReviewRemoved && d.UserId == pirg.OwnerId {
+				return fmt.Errorf("%w: the owner of pirg %s can't be removed", ErrConflict, pirg.Name)
+			}
+			res, err := tx.q.ExecContext(ctx, "UPDATE access_review_items SET decision = $1, decided_by = $2, decided_at = NOW() WHERE review_id = $3 AND pirg_id = $4 AND user_id = $5",
+				d.Decision, decidedBy, reviewId, pirgId, d.UserId)
+			if err = checkAffectedRows(res, err); err != nil {
+				return fmt.Errorf("user %d is not under review in pirg %s: %w", d.UserId, pirg.Name, err)
+			}
+			if d.Decision == ReviewRemoved {
+				if err = tx.removePirgMember(ctx, pirgId, d.UserId); err != nil {
+					return err
+				}
+			}
+		}
+		all, err := tx.GetAccessReviewItems(ctx, reviewId)
+		if err != nil {
+			return err
+		}
+		for _, item := range all {
+			if item.PirgId == pirgId {
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// CloseAccessReview closes an open review. If the review removes
+// unconfirmed members, the members still pending are taken out of their pirgs.
+func (s *PostgresStore) CloseAccessReview(ctx context.Context, id int) (*AccessReview, error) {
+	ctx, span := startSpan(ctx, "CloseAccessReview")
+	defer span.End()
+	slog.DebugContext(ctx, "closing access review in database", "id", id, "package", "data", "method", "CloseAccessReview")
+	var review *AccessReview
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		existing, err := tx.GetAccessReviewById(ctx, id)
+		if err != nil {
+			return err
+		}
+		if existing.ClosedAt != nil {
+			return fmt.Errorf("%w: access review %d is already closed", ErrConflict, id)
+		}
+		if existing.RemoveUnconfirmed {
+			items, err := tx.GetAccessReviewItems(ctx, id)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				if item.Decision != ReviewPending {
+					continue
+				}
+				if err = tx.removePirgMember(ctx, item.PirgId, item.UserId); err != nil {
+					return err
+				}
+			}
+			_, err = tx.q.ExecContext(ctx, "UPDATE access_review_items SET decision = $1, decided_at = NOW() WHERE review_id = $2 AND decision = $3", ReviewRemoved, id, ReviewPending)
+			if err != nil {
+				return err
+			}
+		}
+		review, err = scanAccessReview(tx.q.QueryRowContext(ctx, "UPDATE access_reviews SET closed_at = NOW() WHERE id = $1 RETURNING "+accessReviewColumns, id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// removePirgMember takes a user out of a pirg. Their cluster access, grants
+// and limits in the pirg go with the membership. The owner is never removed.
+func (s *PostgresStore) removePirgMember(ctx context.Context, pirgId int, userId int) error {
+	slog.DebugContext(ctx, "removing pirg member from database", "pirg_id", pirgId, "user_id", userId, "package", "data", "method", "removePirgMember")
+	if err := s.deletePirgAdmin(ctx, pirgId, userId); err != nil {
+		return err
+	}
+	_, err := s.q.ExecContext(ctx, "DELETE FROM pirgs_users WHERE pirg_id = $1 AND user_id = $2 AND user_id != (SELECT owner_id FROM pirgs WHERE id = $1)", pirgId, userId)
+	return err
+}
+
+// sortAccessReviewItems orders items like postgres returns them
+func sortAccessReviewItems(items []*AccessReviewItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].PirgId != items[j].PirgId {
+			return items[i].PirgId < items[j].PirgId
+		}
+		return items[i].UserId < items[j].UserId
+	})
+}
@@ -0,0 +1,385 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+)
+
+// QOS is a slurm quality of service that can be granted to pirgs
+type QOS struct {
+	Id          int
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	ModifiedAt  time.Time
+}
+
+type QOSRequest struct {
+	Name        string
+	Description string
+}
+
+// Partition is a slurm partition on a cluster, such as a condo partition
+// bought by a pirg
+type Partition struct {
+	Id          int
+	ClusterId   int
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	ModifiedAt  time.Time
+}
+
+type PartitionRequest struct {
+	ClusterId   int
+	Name        string
+	Description string
+}
+
+// SlurmGrants are the QOS and partitions an association can use.
+// DefaultQOSId has to be one of QOSIds.
+type SlurmGrants struct {
+	QOSIds       []int
+	DefaultQOSId *int
+	PartitionIds []int
+}
+
+func (g *SlurmGrants) empty() bool {
+	return len(g.QOSIds) == 0 && len(g.PartitionIds) == 0
+}
+
+// MemberSlurm grants a single member of a pirg its own QOS or partitions,
+// which replace the pirg's for that member
+type MemberSlurm struct {
+	UserId int
+	SlurmGrants
+}
+
+// PirgSlurm is everything a pirg has been granted on its slurm associations
+type PirgSlurm struct {
+	PirgId int
+	SlurmGrants
+	Members []MemberSlurm
+}
+
+//
+// QOS
+//
+
+const qosColumns = "id, name, description, created_at, modified_at"
+
+func scanQOS(row interface{ Scan(...any) error }) (*QOS, error) {
+	var q QOS
+	err := row.Scan(&q.Id, &q.Name, &q.Description, &q.CreatedAt, &q.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &q, nil
+}
+
+func (s *PostgresStore) GetAllQOS(ctx context.Context) ([]*QOS, error) {
+	ctx, span := startSpan(ctx, "GetAllQOS")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all qos from database", "package", "data", "method", "GetAllQOS")
+	var qos []*QOS
+	rows, err := s.q.QueryContext(ctx, "SELECT "+qosColumns+" FROM qos ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		q, err := scanQOS(rows)
+		if err != nil {
+			return nil, err
+		}
+		qos = append(qos, q)
+	}
+	return qos, rows.Err()
+}
+
+func (s *PostgresStore) GetQOSById(ctx context.Context, id int) (*QOS, error) {
+	ctx, span := startSpan(ctx, "GetQOSById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for qos by id", "id", id, "package", "data", "method", "GetQOSById")
+	return scanQOS(s.q.QueryRowContext(ctx, "SELECT "+qosColumns+" FROM qos WHERE id = $1", id))
+}
+
+func (s *PostgresStore) CreateQOS(ctx context.Context, qr *QOSRequest) (*QOS, error) {
+	ctx, span := startSpan(ctx, "CreateQOS")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new qos in database", "package", "data", "method", "CreateQOS")
+	row := s.q.QueryRowContext(ctx, "INSERT INTO qos (name, description) VALUES ($1, $2) RETURNING "+qosColumns, qr.Name, qr.Description)
+	return scanQOS(row)
+}
+
+func (s *PostgresStore) UpdateQOS(ctx context.Context, id int, qr *QOSRequest) (*QOS, error) {
+	ctx, span := startSpan(ctx, "UpdateQOS")
+	defer span.End()
+	slog.DebugContext(ctx, "updating qos in database", "id", id, "package", "data", "method", "UpdateQOS")
+	row := s.q.QueryRowContext(ctx, "UPDATE qos SET name = $1, description = $2 WHERE id = $3 RETURNING "+qosColumns, qr.Name, qr.Description, id)
+	return scanQOS(row)
+}
+
+// DeleteQOS removes a QOS. QOS that are still granted can't be deleted.
+func (s *PostgresStore) DeleteQOS(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteQOS")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting qos from database", "id", id, "package", "data", "method", "DeleteQOS")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM qos WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+//
+// Partitions
+//
+
+const partitionColumns = "id, cluster_id, name, description, created_at, modified_at"
+
+func scanPartition(row interface{ Scan(...any) error }) (*Partition, error) {
+	var p Partition
+	err := row.Scan(&p.Id, &p.ClusterId, &p.Name, &p.Description, &p.CreatedAt, &p.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &p, nil
+}
+
+func (s *PostgresStore) GetAllPartitions(ctx context.Context) ([]*Partition, error) {
+	ctx, span := startSpan(ctx, "GetAllPartitions")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all partitions from database", "package", "data", "method", "GetAllPartitions")
+	var partitions []*Partition
+	rows, err := s.q.QueryContext(ctx, "SELECT "+partitionColumns+" FROM partitions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPartition(rows)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, rows.Err()
+}
+
+func (s *PostgresStore) GetPartitionById(ctx context.Context, id int) (*Partition, error) {
+	ctx, span := startSpan(ctx, "GetPartitionById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for partition by id", "id", id, "package", "data", "method", "GetPartitionById")
+	return scanPartition(s.q.QueryRowContext(ctx, "SELECT "+partitionColumns+" FROM partitions WHERE id = $1", id))
+}
+
+func (s *PostgresStore) CreatePartition(ctx context.Context, pr *PartitionRequest) (*Partition, error) {
+	ctx, span := startSpan(ctx, "CreatePartition")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new partition in database", "package", "data", "method", "CreatePartition")
+	row := s.q.QueryRowContext(ctx, "INSERT INTO partitions (cluster_id, name, description) VALUES ($1, $2, $3) RETURNING "+partitionColumns, pr.ClusterId, pr.Name, pr.Description)
+	return scanPartition(row)
+}
+
+func (s *PostgresStore) UpdatePartition(ctx context.Context, id int, pr *PartitionRequest) (*Partition, error) {
+	ctx, span := startSpan(ctx, "UpdatePartition")
+	defer span.End()
+	slog.DebugContext(ctx, "updating partition in database", "id", id, "package", "data", "method", "UpdatePartition")
+	row := s.q.QueryRowContext(ctx, "UPDATE partitions SET cluster_id = $1, name = $2, description = $3 WHERE id = $4 RETURNING "+partitionColumns, pr.ClusterId, pr.Name, pr.Description, id)
+	return scanPartition(row)
+}
+
+// DeletePartition removes a partition. Partitions that are still granted
+// can't be deleted.
+func (s *PostgresStore) DeletePartition(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeletePartition")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting partition from database", "id", id, "package", "data", "method", "DeletePartition")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM partitions WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+//
+// Grants
+//
+
+// GetPirgSlurm returns the QOS and partitions granted to the pirg and to
+// its members
+func (s *PostgresStore) GetPirgSlurm(ctx context.Context, pirgId int) (*PirgSlurm, error) {
+	ctx, span := startSpan(ctx, "GetPirgSlurm")
+	defer span.End()
+	slog.DebugContext(ctx, "getting slurm grants for pirg from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgSlurm")
+	if _, err := s.GetPirgById(ctx, pirgId); err != nil {
+		return nil, err
+	}
+	ps := &PirgSlurm{PirgId: pirgId}
+	pirgGrants := map[int]*SlurmGrants{}
+	if err := s.addQOSGrants(ctx, pirgGrants, "SELECT pirg_id, qos_id, is_default FROM pirgs_qos WHERE pirg_id = $1 ORDER BY qos_id", pirgId); err != nil {
+		return nil, err
+	}
+	if err := s.addPartitionGrants(ctx, pirgGrants, "SELECT pirg_id, partition_id FROM pirgs_partitions WHERE pirg_id = $1 ORDER BY partition_id", pirgId); err != nil {
+		return nil, err
+	}
+	if g, ok := pirgGrants[pirgId]; ok {
+		ps.SlurmGrants = *g
+	}
+	memberGrants := map[int]*SlurmGrants{}
+	if err := s.addQOSGrants(ctx, memberGrants, "SELECT user_id, qos_id, is_default FROM pirgs_users_qos WHERE pirg_id = $1 ORDER BY qos_id", pirgId); err != nil {
+		return nil, err
+	}
+	if err := s.addPartitionGrants(ctx, memberGrants, "SELECT user_id, partition_id FROM pirgs_users_partitions WHERE pirg_id = $1 ORDER BY partition_id", pirgId); err != nil {
+		return nil, err
+	}
+	ps.Members = sortedMembers(memberGrants)
+	return ps, nil
+}
+
+// addQOSGrants adds the rows of (holder id, qos id, is default) to grants
+func (s *PostgresStore) addQOSGrants(ctx context.Context, grants map[int]*SlurmGrants, query string, args ...any) error {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var holderId, qosId int
+		var isDefault bool
+		if err := rows.Scan(&holderId, &qosId, &isDefault); err != nil {
+			return err
+		}
+		g := grantsFor(grants, holderId)
+		g.QOSIds = append(g.QOSIds, qosId)
+		if isDefault {
+			g.DefaultQOSId = &qosId
+		}
+	}
+	return rows.Err()
+}
+
+// addPartitionGrants adds the rows of (holder id, partition id) to grants
+func (s *PostgresStore) addPartitionGrants(ctx context.Context, grants map[int]*SlurmGrants, query string, args ...any) error {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var holderId, partitionId int
+		if err := rows.Scan(&holderId, &partitionId); err != nil {
+			return err
+		}
+		g := grantsFor(grants, holderId)
+		g.PartitionIds = append(g.PartitionIds, partitionId)
+	}
+	return rows.Err()
+}
+
+func grantsFor(grants map[int]*SlurmGrants, holderId int) *SlurmGrants {
+	g, ok := grants[holderId]
+	if !ok {
+		g = &SlurmGrants{}
+		grants[holderId] = g
+	}
+	return g
+}
+
+// sortedMembers turns grants keyed by user id into members ordered by user id
+func sortedMembers(grants map[int]*SlurmGrants) []MemberSlurm {
+	var members []MemberSlurm
+	for userId, g := range grants {
+		members = append(members, MemberSlurm{UserId: userId, SlurmGrants: *g})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserId < members[j].UserId })
+	return members
+}
+
+// SetPirgSlurm replaces everything granted to the pirg and its members
+func (s *PostgresStore) SetPirgSlurm(ctx context.Context, pirgId int, ps *PirgSlurm) (*PirgSlurm, error) {
+	ctx, span := startSpan(ctx, "SetPirgSlurm")
+	defer span.End()
+	slog.DebugContext(ctx, "setting slurm grants for pirg in database", "pirg_id", pirgId, "package", "data", "method", "SetPirgSlurm")
+	pirg, err := s.GetPirgById(ctx, pirgId)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePirgSlurm(pirg, ps); err != nil {
+		return nil, err
+	}
+	err = s.withTx(ctx, func(tx *PostgresStore) error {
+		stmts := []string{
+			"DELETE FROM pirgs_users_qos WHERE pirg_id = $1",
+			"DELETE FROM pirgs_users_partitions WHERE pirg_id = $1",
+			"DELETE FROM pirgs_qos WHERE pirg_id = $1",
+			"DELETE FROM pirgs_partitions WHERE pirg_id = $1",
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.q.ExecContext(ctx, stmt, pirgId); err != nil {
+				return err
+			}
+		}
+		for _, qosId := range uniqueIds(ps.QOSIds) {
+			isDefault := ps.DefaultQOSId != nil && *ps.DefaultQOSId == qosId
+			if _, err := tx.q.ExecContext(ctx, "INSERT INTO pirgs_qos (pirg_id, qos_id, is_default) VALUES ($1, $2, $3)", pirgId, qosId, isDefault); err != nil {
+				return mapError(err)
+			}
+		}
+		for _, partitionId := range uniqueIds(ps.PartitionIds) {
+			if _, err := tx.q.ExecContext(ctx, "INSERT INTO pirgs_partitions (pirg_id, partition_id) VALUES ($1, $2)", pirgId, partitionId); err != nil {
+				return mapError(err)
+			}
+		}
+		for _, member := range ps.Members {
+			for _, qosId := range uniqueIds(member.QOSIds) {
+				isDefault := member.DefaultQOSId != nil && *member.DefaultQOSId == qosId
+				if _, err := tx.q.ExecContext(ctx, "INSERT INTO pirgs_users_qos (pirg_id, user_id, qos_id, is_default) VALUES ($1, $2, $3, $4)", pirgId, member.UserId, qosId, isDefault); err != nil {
+					return mapError(err)
+				}
+			}
+			for _, partitionId := range uniqueIds(member.PartitionIds) {
+				if _, err := tx.q.ExecContext(ctx, "INSERT INTO pirgs_users_partitions (pirg_id, user_id, partition_id) VALUES ($1, $2, $3)", pirgId, member.UserId, partitionId); err != nil {
+					return mapError(err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetPirgSlurm(ctx, pirgId)
+}
+
+// validatePirgSlurm makes sure default QOS are among the granted QOS and
+// that members are users of the pirg. It's shared by every store
+// implementation.
+func validatePirgSlurm(pirg *Pirg, ps *PirgSlurm) error {
+	if err := validateSlurmGrants(&ps.SlurmGrants); err != nil {
+		return fmt.Errorf("pirg %s: %w", pirg.Name, err)
+	}
+	var seen []int
+	for _, member := range ps.Members {
+		if !slices.Contains(pirg.UserIds, member.UserId) {
+			return fmt.Errorf("user %d is not a user of pirg %s", member.UserId, pirg.Name)
+		}
+		if slices.Contains(seen, member.UserId) {
+			return fmt.Errorf("user %d is listed more than once", member.UserId)
+		}
+		seen = append(seen, member.UserId)
+		if member.empty() {
+			return fmt.Errorf("user %d has no qos or partitions", member.UserId)
+		}
+		if err := validateSlurmGrants(&member.SlurmGrants); err != nil {
+			return fmt.Errorf("user %d: %w", member.UserId, err)
+		}
+	}
+	return nil
+}
+
+func validateSlurmGrants(g *SlurmGrants) error {
+	if g.DefaultQOSId != nil && !slices.Contains(g.QOSIds, *g.DefaultQOSId) {
+		return fmt.Errorf("default qos %d is not one of the granted qos", *g.DefaultQOSId)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// StorageAllocation is space given to a pirg on a filesystem location
+type StorageAllocation struct {
+	Id             int
+	PirgId         int
+	LocationId     int
+	Path           string
+	SoftLimitBytes int64
+	HardLimitBytes int64
+	CreatedAt      time.Time
+	ModifiedAt     time.Time
+}
+
+type StorageAllocationRequest struct {
+	LocationId     int
+	Path           string
+	SoftLimitBytes int64
+	HardLimitBytes int64
+}
+
+// StorageUsage is a sample of the space used by a pirg on a filesystem location
+type StorageUsage struct {
+	Id         int
+	PirgId     int
+	LocationId int
+	UsedBytes  int64
+	SampledAt  time.Time
+}
+
+// StorageTrendDays is how far back growth is measured
+const StorageTrendDays = 30
+
+// StorageStatus compares the latest usage of an allocation with its limits.
+// A limit of 0 isn't enforced.
+type StorageStatus struct {
+	Allocation *StorageAllocation
+	UsedBytes  int64
+	// SampledAt is nil when there's no usage for the allocation
+	SampledAt *time.Time
+	OverSoft  bool
+	OverHard  bool
+	// GrowthPerDay is the change in bytes per day from the first sample
+	GrowthPerDay float64
+	// ProjectedHardLimit is when the hard limit will be reached at the current growth
+	ProjectedHardLimit *time.Time
+}
+
+// NewStorageStatus works out the status of the allocation from its usage
+// samples, oldest first
+func NewStorageStatus(a *StorageAllocation, samples []*StorageUsage) *StorageStatus {
+	status := &StorageStatus{Allocation: a}
+	if len(samples) == 0 {
+		return status
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	sampledAt := last.SampledAt
+	status.SampledAt = &sampledAt
+	status.UsedBytes = last.UsedBytes
+	status.OverSoft = a.SoftLimitBytes > 0 && last.UsedBytes > a.SoftLimitBytes
+	status.OverHard = a.HardLimitBytes > 0 && last.UsedBytes > a.HardLimitBytes
+	if days := last.SampledAt.Sub(first.SampledAt).Hours() / 24; days > 0 {
+		status.GrowthPerDay = float64(last.UsedBytes-first.UsedBytes) / days
+	}
+	if status.GrowthPerDay > 0 && a.HardLimitBytes > 0 && last.UsedBytes < a.HardLimitBytes {
+		days := float64(a.HardLimitBytes-last.UsedBytes) / status.GrowthPerDay
+		projected := last.SampledAt.Add(time.Duration(days * 24 * float64(time.Hour)))
+		status.ProjectedHardLimit = &projected
+	}
+	return status
+}
+
+const storageAllocationColumns = "id, pirg_id, location_id, path, soft_limit_bytes, hard_limit_bytes, created_at, modified_at"
+
+func scanStorageAllocation(row interface{ Scan(...any) error }) (*StorageAllocation, error) {
+	var sa StorageAllocation
+	err := row.Scan(&sa.Id, &sa.PirgId, &sa.LocationId, &sa.Path, &sa.SoftLimitBytes, &sa.HardLimitBytes, &sa.CreatedAt, &sa.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return &sa, nil
+}
+
+func (s *PostgresStore) queryStorageAllocations(ctx context.Context, where string, args ...any) ([]*StorageAllocation, error) {
+	var allocations []*StorageAllocation
+	rows, err := s.q.QueryContext(ctx, "SELECT "+storageAllocationColumns+" FROM storage_allocations "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sa, err := scanStorageAllocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, sa)
+	}
+	return allocations, rows.Err()
+}
+
+func (s *PostgresStore) GetAllStorageAllocations(ctx context.Context) ([]*StorageAllocation, error) {
+	ctx, span := startSpan(ctx, "GetAllStorageAllocations")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all storage allocations from database", "package", "data", "method", "GetAllStorageAllocations")
+	return s.queryStorageAllocations(ctx, "")
+}
+
+func (s *PostgresStore) GetPirgStorageAllocations(ctx context.Context, pirgId int) ([]*StorageAllocation, error) {
+	ctx, span := startSpan(ctx, "GetPirgStorageAllocations")
+	defer span.End()
+	slog.DebugContext(ctx, "getting storage allocations for pirg from database", "pirg_id", pirgId, "package", "data", "method", "GetPirgStorageAllocations")
+	return s.queryStorageAllocations(ctx, "WHERE pirg_id = $1", pirgId)
+}
+
+func (s *PostgresStore) GetStorageAllocationById(ctx context.Context, id int) (*StorageAllocation, error) {
+	ctx, span := startSpan(ctx, "GetStorageAllocationById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for storage allocation", "id", id, "package", "data", "method", "GetStorageAllocationById")
+	return scanStorageAllocation(s.q.QueryRowContext(ctx, "SELECT "+storageAllocationColumns+" FROM storage_allocations WHERE id = $1", id))
+}
+
+func (s *PostgresStore) CreateStorageAllocation(ctx context.Context, pirgId int, sr *StorageAllocationRequest) (*StorageAllocation, error) {
+	ctx, span := startSpan(ctx, "CreateStorageAllocation")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new storage allocation in database", "pirg_id", pirgId, "package", "data", "method", "CreateStorageAllocation")
+	if err := s.validateStorageLocation(ctx, sr.LocationId); err != nil {
+		return nil, err
+	}
+	row := s.q.QueryRowContext(ctx, "INSERT INTO storage_allocations (pirg_id, location_id, path, soft_limit_bytes, hard_limit_bytes) VALUES ($1, $2, $3, $4, $5) RETURNING "+storageAllocationColumns, pirgId, sr.LocationId, sr.Path, sr.SoftLimitBytes, sr.HardLimitBytes)
+	return scanStorageAllocation(row)
+}
+
+func (s *PostgresStore) UpdateStorageAllocation(ctx context.Context, id int, sr *StorageAllocationRequest) (*StorageAllocation, error) {
+	ctx, span := startSpan(ctx, "UpdateStorageAllocation")
+	defer span.End()
+	slog.DebugContext(ctx, "updating storage allocation in database", "id", id, "package", "data", "method", "UpdateStorageAllocation")
+	if err := s.validateStorageLocation(ctx, sr.LocationId); err != nil {
+		return nil, err
+	}
+	res, err := s.q.ExecContext(ctx, "UPDATE storage_allocations SET location_id = $1, path = $2, soft_limit_bytes = $3, hard_limit_bytes = $4 WHERE id = $5", sr.LocationId, sr.Path, sr.SoftLimitBytes, sr.HardLimitBytes, id)
+	if err = checkAffectedRows(res, err); err != nil {
+		return nil, err
+	}
+	return s.GetStorageAllocationById(ctx, id)
+}
+
+func (s *PostgresStore) DeleteStorageAllocation(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteStorageAllocation")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting storage allocation from database", "id", id, "package", "data", "method", "DeleteStorageAllocation")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM storage_allocations WHERE id = $1", id)
+	return checkAffectedRows(res, err)
+}
+
+// RecordStorageUsage stores usage samples in a single transaction
+func (s *PostgresStore) RecordStorageUsage(ctx context.Context, samples []*StorageUsage) error {
+	ctx, span := startSpan(ctx, "RecordStorageUsage")
+	defer span.End()
+	slog.DebugContext(ctx, "recording storage usage in database", "samples", len(samples), "package", "data", "method", "RecordStorageUsage")
+	return s.withTx(ctx, func(tx *PostgresStore) error {
+		for _, sample := range samples {
+			err := tx.q.QueryRowContext(ctx, "INSERT INTO storage_usage (pirg_id, location_id, used_bytes, sampled_at) VALUES ($1, $2, $3, $4) RETURNING id",
+				sample.PirgId, sample.LocationId, sample.UsedBytes, sample.SampledAt.UTC()).Scan(&sample.Id)
+			if err != nil {
+				return mapError(err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetStorageUsage returns the samples for the pirg on the location taken
+// from from up to, but not including, to, oldest first
+func (s *PostgresStore) GetStorageUsage(ctx context.Context, pirgId int, locationId int, from time.Time, to time.Time) ([]*StorageUsage, error) {
+	ctx, span := startSpan(ctx, "GetStorageUsage")
+	defer span.End()
+	slog.DebugContext(ctx, "getting storage usage from database", "pirg_id", pirgId, "location_id", locationId, "package", "data", "method", "GetStorageUsage")
+	rows, err := s.q.QueryContext(ctx, `SELECT id, pirg_id, location_id, used_bytes, sampled_at FROM storage_usage
+		WHERE pirg_id = $1 AND location_id = $2 AND sampled_at >= $3 AND sampled_at < $4
+		ORDER BY sampled_at, id`, pirgId, locationId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var samples []*StorageUsage
+	for rows.Next() {
+		var su StorageUsage
+		if err := rows.Scan(&su.Id, &su.PirgId, &su.LocationId, &su.UsedBytes, &su.SampledAt); err != nil {
+			return nil, err
+		}
+		samples = append(samples, &su)
+	}
+	return samples, rows.Err()
+}
+
+// validateStorageLocation makes sure storage is only allocated on filesystems
+func (s *PostgresStore) validateStorageLocation(ctx context.Context, locationId int) error {
+	loc, err := s.GetLocationById(ctx, locationId)
+	if err != nil {
+		return fmt.Errorf("location does not exist with id: %d", locationId)
+	}
+	if loc.Kind != "filesystem" {
+		return fmt.Errorf("storage can only be allocated on filesystem locations, location %d is a %s", locationId, loc.Kind)
+	}
+	return nil
+}
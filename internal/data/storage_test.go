@@ -0,0 +1,43 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewStorageStatus(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	allocation := &StorageAllocation{SoftLimitBytes: 1000, HardLimitBytes: 2000}
+
+	status := NewStorageStatus(allocation, nil)
+	if status.SampledAt != nil || status.OverSoft || status.OverHard {
+		t.Fatalf("expected an empty status without samples, got %+v", status)
+	}
+
+	// 100 bytes a day from 1200 reaches 2000 in 8 days
+	status = NewStorageStatus(allocation, []*StorageUsage{
+		{UsedBytes: 200, SampledAt: start},
+		{UsedBytes: 1200, SampledAt: start.AddDate(0, 0, 10)},
+	})
+	if !status.OverSoft || status.OverHard || status.UsedBytes != 1200 || status.GrowthPerDay != 100 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.ProjectedHardLimit == nil || !status.ProjectedHardLimit.Equal(start.AddDate(0, 0, 18)) {
+		t.Fatalf("expected the hard limit to be reached on day 18, got %v", status.ProjectedHardLimit)
+	}
+
+	// shrinking and over the hard limit
+	status = NewStorageStatus(allocation, []*StorageUsage{
+		{UsedBytes: 3000, SampledAt: start},
+		{UsedBytes: 2500, SampledAt: start.AddDate(0, 0, 5)},
+	})
+	if !status.OverHard || status.GrowthPerDay != -100 || status.ProjectedHardLimit != nil {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// limits of 0 aren't enforced
+	status = NewStorageStatus(&StorageAllocation{}, []*StorageUsage{{UsedBytes: 1 << 40, SampledAt: start}})
+	if status.OverSoft || status.OverHard {
+		t.Fatalf("expected no limits, got %+v", status)
+	}
+}
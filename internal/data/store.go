@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when the requested record doesn't exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrConflict is returned when a change would violate a uniqueness or
+// reference rule, such as a duplicate username or deleting a pirg owner
+var ErrConflict = errors.New("conflicts with existing data")
+
+type UserStore interface {
+	GetAllUsers(ctx context.Context) ([]*User, error)
+	CountUsers(ctx context.Context) (int, error)
+	GetUserById(ctx context.Context, id int) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	CreateUser(ctx context.Context, user *UserRequest) (*User, error)
+	UpdateUser(ctx context.Context, userId int, user *UserRequest) error
+	DeleteUser(ctx context.Context, id int) error
+	DeactivateGuests(ctx context.Context, now time.Time) ([]*User, error)
+}
+
+type PirgStore interface {
+	GetAllPirgs(ctx context.Context) ([]*Pirg, error)
+	CountPirgs(ctx context.Context) (int, error)
+	GetPirgById(ctx context.Context, id int) (*Pirg, error)
+	GetPirgByName(ctx context.Context, name string) (*Pirg, error)
+	GetPirgByGid(ctx context.Context, gid int) (*Pirg, error)
+	CreatePirg(ctx context.Context, pirg *PirgRequest) (*Pirg, error)
+	UpdatePirg(ctx context.Context, id int, pirg *PirgRequest) (*Pirg, error)
+	DeletePirg(ctx context.Context, id int) error
+}
+
+type APIKeyStore interface {
+	GetAPIKeyEntry(ctx context.Context, key string) (*APIKeyEntry, error)
+	CreateAPIKey(ctx context.Context, key *APIKeyRequest) (*APIKeyEntry, error)
+	DeleteAPIKey(ctx context.Context, key string) error
+	// GetAdminUserIds returns the ids of the users holding an admin api key
+	GetAdminUserIds(ctx context.Context) ([]int, error)
+}
+
+type LocationStore interface {
+	GetAllLocations(ctx context.Context) ([]*Location, error)
+	GetLocationById(ctx context.Context, id int) (*Location, error)
+	CreateLocation(ctx context.Context, location *LocationRequest) (*Location, error)
+	UpdateLocation(ctx context.Context, id int, location *LocationRequest) (*Location, error)
+	DeleteLocation(ctx context.Context, id int) error
+	GetLocationPirgs(ctx context.Context, id int) ([]*Pirg, error)
+}
+
+type StorageStore interface {
+	GetPirgStorageAllocations(ctx context.Context, pirgId int) ([]*StorageAllocation, error)
+	GetStorageAllocationById(ctx context.Context, id int) (*StorageAllocation, error)
+	CreateStorageAllocation(ctx context.Context, pirgId int, allocation *StorageAllocationRequest) (*StorageAllocation, error)
+	UpdateStorageAllocation(ctx context.Context, id int, allocation *StorageAllocationRequest) (*StorageAllocation, error)
+	DeleteStorageAllocation(ctx context.Context, id int) error
+	GetAllStorageAllocations(ctx context.Context) ([]*StorageAllocation, error)
+	RecordStorageUsage(ctx context.Context, samples []*StorageUsage) error
+	GetStorageUsage(ctx context.Context, pirgId int, locationId int, from time.Time, to time.Time) ([]*StorageUsage, error)
+}
+
+type ClusterStore interface {
+	GetAllClusters(ctx context.Context) ([]*Cluster, error)
+	GetClusterById(ctx context.Context, id int) (*Cluster, error)
+	GetClusterByName(ctx context.Context, name string) (*Cluster, error)
+	CreateCluster(ctx context.Context, cluster *ClusterRequest) (*Cluster, error)
+	UpdateCluster(ctx context.Context, id int, cluster *ClusterRequest) (*Cluster, error)
+	DeleteCluster(ctx context.Context, id int) error
+	GetClusterPirgs(ctx context.Context, clusterId int) ([]*ClusterPirg, error)
+	GetPirgClusters(ctx context.Context, pirgId int) ([]*ClusterPirg, error)
+	GetClusterPirg(ctx context.Context, clusterId int, pirgId int) (*ClusterPirg, error)
+	SetClusterPirg(ctx context.Context, clusterId int, pirgId int, userIds []int) (*ClusterPirg, error)
+	DeleteClusterPirg(ctx context.Context, clusterId int, pirgId int) error
+}
+
+type SlurmStore interface {
+	GetAllQOS(ctx context.Context) ([]*QOS, error)
+	GetQOSById(ctx context.Context, id int) (*QOS, error)
+	CreateQOS(ctx context.Context, qos *QOSRequest) (*QOS, error)
+	UpdateQOS(ctx context.Context, id int, qos *QOSRequest) (*QOS, error)
+	DeleteQOS(ctx context.Context, id int) error
+	GetAllPartitions(ctx context.Context) ([]*Partition, error)
+	GetPartitionById(ctx context.Context, id int) (*Partition, error)
+	CreatePartition(ctx context.Context, partition *PartitionRequest) (*Partition, error)
+	UpdatePartition(ctx context.Context, id int, partition *PartitionRequest) (*Partition, error)
+	DeletePartition(ctx context.Context, id int) error
+	GetPirgSlurm(ctx context.Context, pirgId int) (*PirgSlurm, error)
+	SetPirgSlurm(ctx context.Context, pirgId int, slurm *PirgSlurm) (*PirgSlurm, error)
+	GetPirgLimits(ctx context.Context, pirgId int) (*PirgLimits, error)
+	SetPirgLimits(ctx context.Context, pirgId int, limits *PirgLimits) (*PirgLimits, error)
+}
+
+type ComputeStore interface {
+	GetAllComputeAllocations(ctx context.Context) ([]*ComputeAllocation, error)
+	GetPirgComputeAllocations(ctx context.Context, pirgId int) ([]*ComputeAllocation, error)
+	GetComputeAllocationById(ctx context.Context, id int) (*ComputeAllocation, error)
+	CreateComputeAllocation(ctx context.Context, pirgId int, allocation *ComputeAllocationRequest) (*ComputeAllocation, error)
+	UpdateComputeAllocation(ctx context.Context, id int, allocation *ComputeAllocationRequest) (*ComputeAllocation, error)
+	DeleteComputeAllocation(ctx context.Context, id int) error
+	RecordJobUsage(ctx context.Context, jobs []*JobUsage) error
+	GetPirgUsage(ctx context.Context, pirgId int, from time.Time, to time.Time) ([]*UserUsage, error)
+}
+
+type AccessReviewStore interface {
+	GetAllAccessReviews(ctx context.Context) ([]*AccessReview, error)
+	GetAccessReviewById(ctx context.Context, id int) (*AccessReview, error)
+	CreateAccessReview(ctx context.Context, review *AccessReviewRequest) (*AccessReview, error)
+	GetAccessReviewItems(ctx context.Context, reviewId int) ([]*AccessReviewItem, error)
+	DecideAccessReview(ctx context.Context, reviewId int, pirgId int, decisions []AccessReviewDecision, decidedBy *int) ([]*AccessReviewItem, error)
+	CloseAccessReview(ctx context.Context, id int) (*AccessReview, error)
+	// CountPendingAccessRequests counts the members still waiting on a
+	// decision in open access reviews
+	CountPendingAccessRequests(ctx context.Context) (int, error)
+}
+
+type MembershipStore interface {
+	GetPirgMemberships(ctx context.Context, pirgId int) ([]*Membership, error)
+	GetAllMemberships(ctx context.Context) ([]*Membership, error)
+	SetMembershipTerm(ctx context.Context, pirgId int, userId int, term *MembershipTerm) (*Membership, error)
+	GetExpiringMemberships(ctx context.Context, by time.Time) ([]*Membership, error)
+	RemoveExpiredMemberships(ctx context.Context, now time.Time) ([]*Membership, error)
+}
+
+type AuditStore interface {
+	GetAuditLog(ctx context.Context, limit int) ([]*AuditEntry, error)
+}
+
+type NotificationStore interface {
+	EnqueueNotification(ctx context.Context, notification *NotificationRequest) (*Notification, error)
+	ClaimNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Notification, error)
+	MarkNotificationSent(ctx context.Context, id int) error
+	MarkNotificationFailed(ctx context.Context, id int, reason string, retryAt *time.Time) error
+}
+
+type PirgGroupStore interface {
+	GetAllPirgGroups(ctx context.Context) ([]*PirgGroup, error)
+	GetPirgGroups(ctx context.Context, pirgId int) ([]*PirgGroup, error)
+	GetPirgGroupById(ctx context.Context, id int) (*PirgGroup, error)
+	CreatePirgGroup(ctx context.Context, pirgId int, group *PirgGroupRequest) (*PirgGroup, error)
+	UpdatePirgGroup(ctx context.Context, id int, group *PirgGroupRequest) (*PirgGroup, error)
+	DeletePirgGroup(ctx context.Context, id int) error
+	AllocateUnixIds(ctx context.Context, uids IdRange, gids IdRange) (*UnixIdAllocation, error)
+}
+
+type DirectoryStore interface {
+	SaveDirectorySnapshot(ctx context.Context, digest string, entries []byte) (*DirectorySnapshot, error)
+	GetDirectorySnapshot(ctx context.Context, at time.Time) (*DirectorySnapshot, error)
+	PruneDirectorySnapshots(ctx context.Context, before time.Time) (int, error)
+}
+
+// Store is everything the server needs from a backend.
+// PostgresStore is used in production, MemoryStore in tests.
+type Store interface {
+	UserStore
+	PirgStore
+	APIKeyStore
+	LocationStore
+	StorageStore
+	ClusterStore
+	SlurmStore
+	ComputeStore
+	AccessReviewStore
+	MembershipStore
+	AuditStore
+	NotificationStore
+	PirgGroupStore
+	DirectoryStore
+
+	// Transaction runs fn against a store whose changes are only kept
+	// if fn returns nil. Transactions started inside fn join the outer one.
+	Transaction(ctx context.Context, fn func(tx Store) error) error
+}
@@ -0,0 +1,1792 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The conformance suite below runs against every Store implementation
+// so that the in-memory backend keeps the semantics of postgres.
+// Names get a per-run suffix because the postgres test database
+// isn't wiped between runs.
+
+var testSuffix = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+func uniqueName(name string) string {
+	return name + testSuffix
+}
+
+func runStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("Users", func(t *testing.T) { testStoreUsers(t, newStore(t)) })
+	t.Run("Pirgs", func(t *testing.T) { testStorePirgs(t, newStore(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testStoreAPIKeys(t, newStore(t)) })
+	t.Run("Locations", func(t *testing.T) { testStoreLocations(t, newStore(t)) })
+	t.Run("StorageAllocations", func(t *testing.T) { testStoreStorageAllocations(t, newStore(t)) })
+	t.Run("Clusters", func(t *testing.T) { testStoreClusters(t, newStore(t)) })
+	t.Run("Slurm", func(t *testing.T) { testStoreSlurm(t, newStore(t)) })
+	t.Run("SlurmLimits", func(t *testing.T) { testStoreSlurmLimits(t, newStore(t)) })
+	t.Run("Compute", func(t *testing.T) { testStoreCompute(t, newStore(t)) })
+	t.Run("StorageUsage", func(t *testing.T) { testStoreStorageUsage(t, newStore(t)) })
+	t.Run("AccessReviews", func(t *testing.T) { testStoreAccessReviews(t, newStore(t)) })
+	t.Run("Memberships", func(t *testing.T) { testStoreMemberships(t, newStore(t)) })
+	t.Run("Notifications", func(t *testing.T) { testStoreNotifications(t, newStore(t)) })
+	t.Run("PirgGroups", func(t *testing.T) { testStorePirgGroups(t, newStore(t)) })
+	t.Run("UnixIds", func(t *testing.T) { testStoreUnixIds(t, newStore(t)) })
+	t.Run("DirectorySnapshots", func(t *testing.T) { testStoreDirectorySnapshots(t, newStore(t)) })
+	t.Run("Transactions", func(t *testing.T) { testStoreTransactions(t, newStore(t)) })
+	t.Run("Guests", func(t *testing.T) { testStoreGuests(t, newStore(t)) })
+}
+
+func mustCreateUser(t *testing.T, s Store, username string) *User {
+	t.Helper()
+	username = uniqueName(username)
+	user, err := s.CreateUser(context.Background(), &UserRequest{
+		Username:  username,
+		Email:     username + "@localhost",
+		FirstName: "Test",
+		LastName:  "User",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func mustCreatePirg(t *testing.T, s Store, name string, owner *User, members ...*User) *Pirg {
+	t.Helper()
+	pr := PirgRequest{
+		Name:     uniqueName(name),
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id},
+		UserIds:  []int{owner.Id},
+	}
+	for _, member := range members {
+		pr.UserIds = append(pr.UserIds, member.Id)
+	}
+	pirg, err := s.CreatePirg(context.Background(), &pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pirg
+}
+
+func expectErr(t *testing.T, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("expected error %v, got %v", want, err)
+	}
+}
+
+func testStoreUsers(t *testing.T, s Store) {
+	ctx := context.Background()
+	ur := UserRequest{
+		Username:  uniqueName("teststoreuser"),
+		Email:     uniqueName("teststoreuser") + "@localhost",
+		FirstName: "TestStore",
+		LastName:  "User",
+	}
+	user, err := s.CreateUser(ctx, &ur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id < 1 {
+		t.Fatal("expected id to be greater than 0")
+	}
+	if user.Username != ur.Username || user.Email != ur.Email || user.FirstName != ur.FirstName || user.LastName != ur.LastName {
+		t.Fatalf("expected user to match request %+v, got %+v", ur, user)
+	}
+	if user.CreatedAt.IsZero() || user.ModifiedAt.IsZero() {
+		t.Fatal("expected timestamps to be set")
+	}
+
+	got, err := s.GetUserById(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, user) {
+		t.Fatalf("expected %+v got %+v", user, got)
+	}
+	got, err = s.GetUserByUsername(ctx, ur.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != user.Id {
+		t.Fatalf("expected user id %d got %d", user.Id, got.Id)
+	}
+
+	t.Run("DuplicateUsername", func(t *testing.T) {
+		dup := ur
+		dup.Email = uniqueName("otheremail") + "@localhost"
+		_, err := s.CreateUser(ctx, &dup)
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("DuplicateEmail", func(t *testing.T) {
+		dup := ur
+		dup.Username = uniqueName("otherusername")
+		_, err := s.CreateUser(ctx, &dup)
+		expectErr(t, err, ErrConflict)
+	})
+
+	users, err := s.GetAllUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(users, func(u *User) bool { return u.Id == user.Id }) {
+		t.Fatalf("expected user %d in all users", user.Id)
+	}
+	if count, err := s.CountUsers(ctx); err != nil || count != len(users) {
+		t.Fatalf("expected %d users to be counted, got %d: %v", len(users), count, err)
+	}
+
+	updated := UserRequest{
+		Username:  uniqueName("teststoreuser2"),
+		Email:     uniqueName("teststoreuser2") + "@localhost",
+		FirstName: "TestStore2",
+		LastName:  "User2",
+	}
+	if err = s.UpdateUser(ctx, user.Id, &updated); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetUserById(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != updated.Username || got.Email != updated.Email || got.FirstName != updated.FirstName || got.LastName != updated.LastName {
+		t.Fatalf("expected user to match update %+v, got %+v", updated, got)
+	}
+	expectErr(t, s.UpdateUser(ctx, -1, &updated), ErrNotFound)
+
+	if err = s.DeleteUser(ctx, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetUserById(ctx, user.Id)
+	expectErr(t, err, ErrNotFound)
+	_, err = s.GetUserByUsername(ctx, updated.Username)
+	expectErr(t, err, ErrNotFound)
+	expectErr(t, s.DeleteUser(ctx, user.Id), ErrNotFound)
+}
+
+func testStorePirgs(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststorepirgowner")
+	member := mustCreateUser(t, s, "teststorepirgmember")
+	other := mustCreateUser(t, s, "teststorepirgother")
+
+	pr := PirgRequest{
+		Name:     uniqueName("teststorepirg"),
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id, owner.Id},
+		UserIds:  []int{member.Id, owner.Id},
+	}
+	pirg, err := s.CreatePirg(ctx, &pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pirg.Name != pr.Name || pirg.OwnerId != owner.Id {
+		t.Fatalf("expected pirg to match request %+v, got %+v", pr, pirg)
+	}
+	// duplicates are collapsed and ids come back sorted
+	if !reflect.DeepEqual(pirg.AdminIds, []int{owner.Id}) {
+		t.Fatalf("expected admin ids %v got %v", []int{owner.Id}, pirg.AdminIds)
+	}
+	if !reflect.DeepEqual(pirg.UserIds, []int{owner.Id, member.Id}) {
+		t.Fatalf("expected user ids %v got %v", []int{owner.Id, member.Id}, pirg.UserIds)
+	}
+
+	got, err := s.GetPirgByName(ctx, pr.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, pirg) {
+		t.Fatalf("expected %+v got %+v", pirg, got)
+	}
+	pirgs, err := s.GetAllPirgs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(pirgs, func(p *Pirg) bool { return p.Id == pirg.Id }) {
+		t.Fatalf("expected pirg %d in all pirgs", pirg.Id)
+	}
+	if count, err := s.CountPirgs(ctx); err != nil || count != len(pirgs) {
+		t.Fatalf("expected %d pirgs to be counted, got %d: %v", len(pirgs), count, err)
+	}
+
+	t.Run("MissingOwner", func(t *testing.T) {
+		_, err := s.CreatePirg(ctx, &PirgRequest{Name: uniqueName("teststorepirgnoowner"), OwnerId: -1})
+		if err == nil {
+			t.Fatal("expected error creating pirg with missing owner")
+		}
+	})
+	t.Run("DuplicateName", func(t *testing.T) {
+		_, err := s.CreatePirg(ctx, &PirgRequest{Name: pr.Name, OwnerId: owner.Id})
+		expectErr(t, err, ErrConflict)
+	})
+
+	update := PirgRequest{
+		Name:     uniqueName("teststorepirgrenamed"),
+		OwnerId:  owner.Id,
+		AdminIds: []int{owner.Id, other.Id},
+		UserIds:  []int{owner.Id, other.Id},
+	}
+	updated, err := s.UpdatePirg(ctx, pirg.Id, &update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != update.Name {
+		t.Fatalf("expected name %v got %v", update.Name, updated.Name)
+	}
+	if !reflect.DeepEqual(updated.AdminIds, []int{owner.Id, other.Id}) {
+		t.Fatalf("expected admin ids %v got %v", []int{owner.Id, other.Id}, updated.AdminIds)
+	}
+	if !reflect.DeepEqual(updated.UserIds, []int{owner.Id, other.Id}) {
+		t.Fatalf("expected user ids %v got %v", []int{owner.Id, other.Id}, updated.UserIds)
+	}
+	_, err = s.UpdatePirg(ctx, -1, &update)
+	expectErr(t, err, ErrNotFound)
+
+	t.Run("DeleteOwner", func(t *testing.T) {
+		expectErr(t, s.DeleteUser(ctx, owner.Id), ErrConflict)
+	})
+	t.Run("DeleteMember", func(t *testing.T) {
+		if err := s.DeleteUser(ctx, other.Id); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetPirgById(ctx, pirg.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(got.UserIds, other.Id) || slices.Contains(got.AdminIds, other.Id) {
+			t.Fatalf("expected deleted user %d to be removed from pirg, got %+v", other.Id, got)
+		}
+	})
+
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetPirgById(ctx, pirg.Id)
+	expectErr(t, err, ErrNotFound)
+	expectErr(t, s.DeletePirg(ctx, pirg.Id), ErrNotFound)
+	// with the pirg gone its owner can be deleted
+	if err = s.DeleteUser(ctx, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreAPIKeys(t *testing.T, s Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s, "teststoreapikeyuser")
+	key := uniqueName("teststoreapikey")
+	entry, err := s.CreateAPIKey(ctx, &APIKeyRequest{Key: key, Role: "admin", UserId: user.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Key != key || entry.Role != "admin" || entry.UserId != user.Id {
+		t.Fatalf("unexpected api key entry %+v", entry)
+	}
+	got, err := s.GetAPIKeyEntry(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entry) {
+		t.Fatalf("expected %+v got %+v", entry, got)
+	}
+	_, err = s.GetAPIKeyEntry(ctx, uniqueName("teststoremissingkey"))
+	expectErr(t, err, ErrNotFound)
+	_, err = s.CreateAPIKey(ctx, &APIKeyRequest{Key: key, Role: "user", UserId: user.Id})
+	expectErr(t, err, ErrConflict)
+	_, err = s.CreateAPIKey(ctx, &APIKeyRequest{Key: uniqueName("teststorenouserkey"), Role: "user", UserId: -1})
+	expectErr(t, err, ErrConflict)
+	admins, err := s.GetAdminUserIds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(admins, user.Id) {
+		t.Fatalf("expected %d to hold an admin key, got %v", user.Id, admins)
+	}
+
+	if err = s.DeleteAPIKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetAPIKeyEntry(ctx, key)
+	expectErr(t, err, ErrNotFound)
+	expectErr(t, s.DeleteAPIKey(ctx, key), ErrNotFound)
+
+	// keys go away with their user
+	key2 := uniqueName("teststoreapikey2")
+	if _, err = s.CreateAPIKey(ctx, &APIKeyRequest{Key: key2, Role: "user", UserId: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteUser(ctx, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetAPIKeyEntry(ctx, key2)
+	expectErr(t, err, ErrNotFound)
+}
+
+func testStoreLocations(t *testing.T, s Store) {
+	ctx := context.Background()
+	site, err := s.CreateLocation(ctx, &LocationRequest{Name: uniqueName("teststoresite"), Kind: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.ParentId != nil {
+		t.Fatalf("expected top level location, got parent %d", *site.ParentId)
+	}
+	fs, err := s.CreateLocation(ctx, &LocationRequest{Name: "/projects", Kind: "filesystem", ParentId: &site.Id, Description: "project space"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.ParentId == nil || *fs.ParentId != site.Id {
+		t.Fatalf("expected parent %d, got %v", site.Id, fs.ParentId)
+	}
+	got, err := s.GetLocationById(ctx, fs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fs) {
+		t.Fatalf("expected %+v got %+v", fs, got)
+	}
+	locations, err := s.GetAllLocations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(locations, func(l *Location) bool { return l.Id == fs.Id }) {
+		t.Fatalf("expected location %d in all locations", fs.Id)
+	}
+
+	t.Run("DuplicateSibling", func(t *testing.T) {
+		_, err := s.CreateLocation(ctx, &LocationRequest{Name: "/projects", Kind: "filesystem", ParentId: &site.Id})
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("MissingParent", func(t *testing.T) {
+		missing := -1
+		_, err := s.CreateLocation(ctx, &LocationRequest{Name: "orphan", Kind: "cluster", ParentId: &missing})
+		if err == nil {
+			t.Fatal("expected error creating location with missing parent")
+		}
+	})
+	t.Run("OwnAncestor", func(t *testing.T) {
+		_, err := s.UpdateLocation(ctx, site.Id, &LocationRequest{Name: site.Name, Kind: site.Kind, ParentId: &fs.Id})
+		if err == nil {
+			t.Fatal("expected error making a location its own ancestor")
+		}
+	})
+
+	owner := mustCreateUser(t, s, "teststorelocationowner")
+	pirg := mustCreatePirg(t, s, "teststorelocationpirg", owner)
+	if _, err = s.CreateStorageAllocation(ctx, pirg.Id, &StorageAllocationRequest{LocationId: fs.Id, Path: "/projects/" + pirg.Name}); err != nil {
+		t.Fatal(err)
+	}
+	// the pirg is found from the filesystem and from the site above it
+	for _, loc := range []*Location{fs, site} {
+		pirgs, err := s.GetLocationPirgs(ctx, loc.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pirgs) != 1 || pirgs[0].Id != pirg.Id {
+			t.Fatalf("expected pirg %d at location %s, got %+v", pirg.Id, loc.Name, pirgs)
+		}
+	}
+
+	expectErr(t, s.DeleteLocation(ctx, site.Id), ErrConflict)
+	expectErr(t, s.DeleteLocation(ctx, fs.Id), ErrConflict)
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteLocation(ctx, fs.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteLocation(ctx, site.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectErr(t, s.DeleteLocation(ctx, site.Id), ErrNotFound)
+}
+
+func testStoreStorageAllocations(t *testing.T, s Store) {
+	ctx := context.Background()
+	site, err := s.CreateLocation(ctx, &LocationRequest{Name: uniqueName("teststorestoragesite"), Kind: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := s.CreateLocation(ctx, &LocationRequest{Name: "/home", Kind: "filesystem", ParentId: &site.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := mustCreateUser(t, s, "teststorestorageowner")
+	pirg := mustCreatePirg(t, s, "teststorestoragepirg", owner)
+	path := fmt.Sprintf("/home/%s", pirg.Name)
+
+	_, err = s.CreateStorageAllocation(ctx, pirg.Id, &StorageAllocationRequest{LocationId: site.Id, Path: path})
+	if err == nil {
+		t.Fatal("expected error allocating storage on a site")
+	}
+	_, err = s.CreateStorageAllocation(ctx, -1, &StorageAllocationRequest{LocationId: fs.Id, Path: path})
+	expectErr(t, err, ErrConflict)
+
+	sr := StorageAllocationRequest{LocationId: fs.Id, Path: path, SoftLimitBytes: 1 << 30, HardLimitBytes: 2 << 30}
+	allocation, err := s.CreateStorageAllocation(ctx, pirg.Id, &sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocation.PirgId != pirg.Id || allocation.Path != path || allocation.SoftLimitBytes != sr.SoftLimitBytes || allocation.HardLimitBytes != sr.HardLimitBytes {
+		t.Fatalf("expected allocation to match request %+v, got %+v", sr, allocation)
+	}
+	_, err = s.CreateStorageAllocation(ctx, pirg.Id, &sr)
+	expectErr(t, err, ErrConflict)
+
+	allocations, err := s.GetPirgStorageAllocations(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || !reflect.DeepEqual(allocations[0], allocation) {
+		t.Fatalf("expected [%+v] got %+v", allocation, allocations)
+	}
+
+	sr.HardLimitBytes = 4 << 30
+	updated, err := s.UpdateStorageAllocation(ctx, allocation.Id, &sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.HardLimitBytes != sr.HardLimitBytes {
+		t.Fatalf("expected hard limit %d got %d", sr.HardLimitBytes, updated.HardLimitBytes)
+	}
+	_, err = s.UpdateStorageAllocation(ctx, -1, &sr)
+	expectErr(t, err, ErrNotFound)
+
+	if err = s.DeleteStorageAllocation(ctx, allocation.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetStorageAllocationById(ctx, allocation.Id)
+	expectErr(t, err, ErrNotFound)
+	expectErr(t, s.DeleteStorageAllocation(ctx, allocation.Id), ErrNotFound)
+
+	// allocations go away with their pirg
+	allocation, err = s.CreateStorageAllocation(ctx, pirg.Id, &sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetStorageAllocationById(ctx, allocation.Id)
+	expectErr(t, err, ErrNotFound)
+}
+
+func testStoreClusters(t *testing.T, s Store) {
+	ctx := context.Background()
+	cluster, err := s.CreateCluster(ctx, &ClusterRequest{Name: uniqueName("teststorecluster"), Description: "test cluster"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetClusterByName(ctx, cluster.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cluster) {
+		t.Fatalf("expected %+v got %+v", cluster, got)
+	}
+	other, err := s.CreateCluster(ctx, &ClusterRequest{Name: uniqueName("teststoreothercluster")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := s.GetAllClusters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(clusters, func(c *Cluster) bool { return c.Id == other.Id }) {
+		t.Fatalf("expected cluster %d in all clusters", other.Id)
+	}
+
+	t.Run("DuplicateName", func(t *testing.T) {
+		_, err := s.CreateCluster(ctx, &ClusterRequest{Name: cluster.Name})
+		expectErr(t, err, ErrConflict)
+		_, err = s.UpdateCluster(ctx, other.Id, &ClusterRequest{Name: cluster.Name})
+		expectErr(t, err, ErrConflict)
+	})
+
+	updated, err := s.UpdateCluster(ctx, other.Id, &ClusterRequest{Name: other.Name, Description: "renamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Description != "renamed" {
+		t.Fatalf("expected description renamed got %s", updated.Description)
+	}
+	_, err = s.UpdateCluster(ctx, -1, &ClusterRequest{Name: uniqueName("teststoremissingcluster")})
+	expectErr(t, err, ErrNotFound)
+
+	owner := mustCreateUser(t, s, "teststoreclusterowner")
+	member := mustCreateUser(t, s, "teststoreclustermember")
+	outsider := mustCreateUser(t, s, "teststoreclusteroutsider")
+	pirg := mustCreatePirg(t, s, "teststoreclusterpirg", owner, member)
+
+	t.Run("UserNotInPirg", func(t *testing.T) {
+		if _, err := s.SetClusterPirg(ctx, cluster.Id, pirg.Id, []int{outsider.Id}); err == nil {
+			t.Fatal("expected error enabling a user outside the pirg")
+		}
+	})
+	t.Run("MissingCluster", func(t *testing.T) {
+		_, err := s.SetClusterPirg(ctx, -1, pirg.Id, nil)
+		expectErr(t, err, ErrNotFound)
+	})
+
+	cp, err := s.SetClusterPirg(ctx, cluster.Id, pirg.Id, []int{member.Id, owner.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.ClusterId != cluster.Id || cp.PirgId != pirg.Id || !reflect.DeepEqual(cp.UserIds, []int{owner.Id, member.Id}) {
+		t.Fatalf("unexpected cluster pirg %+v", cp)
+	}
+	// setting again replaces the enabled users
+	cp, err = s.SetClusterPirg(ctx, cluster.Id, pirg.Id, []int{member.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp.UserIds, []int{member.Id}) {
+		t.Fatalf("expected only user %d enabled, got %v", member.Id, cp.UserIds)
+	}
+	if _, err = s.SetClusterPirg(ctx, other.Id, pirg.Id, []int{owner.Id, member.Id}); err != nil {
+		t.Fatal(err)
+	}
+	cps, err := s.GetPirgClusters(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 2 || cps[0].ClusterId != cluster.Id || cps[1].ClusterId != other.Id {
+		t.Fatalf("expected pirg on clusters %d and %d, got %+v", cluster.Id, other.Id, cps)
+	}
+
+	// users taken out of the pirg lose their cluster access
+	_, err = s.UpdatePirg(ctx, pirg.Id, &PirgRequest{Name: pirg.Name, OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err = s.GetClusterPirg(ctx, other.Id, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp.UserIds, []int{owner.Id}) {
+		t.Fatalf("expected only user %d enabled, got %v", owner.Id, cp.UserIds)
+	}
+
+	if err = s.DeleteClusterPirg(ctx, cluster.Id, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetClusterPirg(ctx, cluster.Id, pirg.Id)
+	expectErr(t, err, ErrNotFound)
+	expectErr(t, s.DeleteClusterPirg(ctx, cluster.Id, pirg.Id), ErrNotFound)
+
+	// access goes away with the cluster
+	if err = s.DeleteCluster(ctx, other.Id); err != nil {
+		t.Fatal(err)
+	}
+	cps, err = s.GetPirgClusters(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 0 {
+		t.Fatalf("expected no clusters for pirg, got %+v", cps)
+	}
+	expectErr(t, s.DeleteCluster(ctx, other.Id), ErrNotFound)
+
+	// and with the pirg
+	if _, err = s.SetClusterPirg(ctx, cluster.Id, pirg.Id, []int{owner.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	cps, err = s.GetClusterPirgs(ctx, cluster.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 0 {
+		t.Fatalf("expected no pirgs on cluster, got %+v", cps)
+	}
+	if err = s.DeleteCluster(ctx, cluster.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreSlurm(t *testing.T, s Store) {
+	ctx := context.Background()
+	normal, err := s.CreateQOS(ctx, &QOSRequest{Name: uniqueName("teststorenormal")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := s.CreateQOS(ctx, &QOSRequest{Name: uniqueName("teststorehigh"), Description: "high priority"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetQOSById(ctx, high.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, high) {
+		t.Fatalf("expected %+v got %+v", high, got)
+	}
+	_, err = s.CreateQOS(ctx, &QOSRequest{Name: normal.Name})
+	expectErr(t, err, ErrConflict)
+
+	cluster, err := s.CreateCluster(ctx, &ClusterRequest{Name: uniqueName("teststoreslurmcluster")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpu, err := s.CreatePartition(ctx, &PartitionRequest{ClusterId: cluster.Id, Name: "gpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	condo, err := s.CreatePartition(ctx, &PartitionRequest{ClusterId: cluster.Id, Name: "condo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CreatePartition(ctx, &PartitionRequest{ClusterId: cluster.Id, Name: "gpu"})
+	expectErr(t, err, ErrConflict)
+	_, err = s.CreatePartition(ctx, &PartitionRequest{ClusterId: -1, Name: "gpu"})
+	expectErr(t, err, ErrConflict)
+	updated, err := s.UpdatePartition(ctx, condo.Id, &PartitionRequest{ClusterId: cluster.Id, Name: "condo", Description: "bought by a pirg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Description != "bought by a pirg" {
+		t.Fatalf("expected description to be updated, got %+v", updated)
+	}
+
+	owner := mustCreateUser(t, s, "teststoreslurmowner")
+	member := mustCreateUser(t, s, "teststoreslurmmember")
+	outsider := mustCreateUser(t, s, "teststoreslurmoutsider")
+	pirg := mustCreatePirg(t, s, "teststoreslurmpirg", owner, member)
+
+	ps, err := s.GetPirgSlurm(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ps, &PirgSlurm{PirgId: pirg.Id}) {
+		t.Fatalf("expected no grants, got %+v", ps)
+	}
+	_, err = s.GetPirgSlurm(ctx, -1)
+	expectErr(t, err, ErrNotFound)
+
+	t.Run("DefaultNotGranted", func(t *testing.T) {
+		_, err := s.SetPirgSlurm(ctx, pirg.Id, &PirgSlurm{SlurmGrants: SlurmGrants{QOSIds: []int{normal.Id}, DefaultQOSId: &high.Id}})
+		if err == nil {
+			t.Fatal("expected error for a default qos that isn't granted")
+		}
+	})
+	t.Run("MemberNotInPirg", func(t *testing.T) {
+		_, err := s.SetPirgSlurm(ctx, pirg.Id, &PirgSlurm{Members: []MemberSlurm{{UserId: outsider.Id, SlurmGrants: SlurmGrants{QOSIds: []int{high.Id}}}}})
+		if err == nil {
+			t.Fatal("expected error granting to a user outside the pirg")
+		}
+	})
+	t.Run("MissingQOS", func(t *testing.T) {
+		_, err := s.SetPirgSlurm(ctx, pirg.Id, &PirgSlurm{SlurmGrants: SlurmGrants{QOSIds: []int{-1}}})
+		expectErr(t, err, ErrConflict)
+	})
+
+	want := &PirgSlurm{
+		PirgId:      pirg.Id,
+		SlurmGrants: SlurmGrants{QOSIds: []int{normal.Id, high.Id}, DefaultQOSId: &normal.Id, PartitionIds: []int{gpu.Id}},
+		Members: []MemberSlurm{
+			{UserId: member.Id, SlurmGrants: SlurmGrants{QOSIds: []int{high.Id}, DefaultQOSId: &high.Id, PartitionIds: []int{gpu.Id, condo.Id}}},
+		},
+	}
+	ps, err = s.SetPirgSlurm(ctx, pirg.Id, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ps, want) {
+		t.Fatalf("expected %+v got %+v", want, ps)
+	}
+
+	// granted qos and partitions can't be deleted, nor can the cluster they're on
+	expectErr(t, s.DeleteQOS(ctx, high.Id), ErrConflict)
+	expectErr(t, s.DeletePartition(ctx, condo.Id), ErrConflict)
+	expectErr(t, s.DeleteCluster(ctx, cluster.Id), ErrConflict)
+
+	// member grants go away when the member leaves the pirg
+	_, err = s.UpdatePirg(ctx, pirg.Id, &PirgRequest{Name: pirg.Name, OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err = s.GetPirgSlurm(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Members) != 0 {
+		t.Fatalf("expected no member grants, got %+v", ps.Members)
+	}
+	if err = s.DeletePartition(ctx, condo.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// and all grants go away with the pirg
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []*QOS{normal, high} {
+		if err = s.DeleteQOS(ctx, q.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = s.GetQOSById(ctx, normal.Id)
+	expectErr(t, err, ErrNotFound)
+	if err = s.DeletePartition(ctx, gpu.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteCluster(ctx, cluster.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreSlurmLimits(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststorelimitsowner")
+	member := mustCreateUser(t, s, "teststorelimitsmember")
+	outsider := mustCreateUser(t, s, "teststorelimitsoutsider")
+	pirg := mustCreatePirg(t, s, "teststorelimitspirg", owner, member)
+
+	pl, err := s.GetPirgLimits(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pl, &PirgLimits{PirgId: pirg.Id}) {
+		t.Fatalf("expected no limits, got %+v", pl)
+	}
+	_, err = s.GetPirgLimits(ctx, -1)
+	expectErr(t, err, ErrNotFound)
+
+	t.Run("BadTRES", func(t *testing.T) {
+		_, err := s.SetPirgLimits(ctx, pirg.Id, &PirgLimits{SlurmLimits: SlurmLimits{GrpTRES: "cpus=100"}})
+		if err == nil {
+			t.Fatal("expected error for an invalid tres")
+		}
+	})
+	t.Run("MemberNotInPirg", func(t *testing.T) {
+		_, err := s.SetPirgLimits(ctx, pirg.Id, &PirgLimits{Members: []MemberLimits{{UserId: outsider.Id, SlurmLimits: SlurmLimits{MaxWall: "60"}}}})
+		if err == nil {
+			t.Fatal("expected error setting limits for a user outside the pirg")
+		}
+	})
+
+	fairshare, maxJobs := 10, 0
+	want := &PirgLimits{
+		PirgId:      pirg.Id,
+		SlurmLimits: SlurmLimits{Fairshare: &fairshare, GrpTRES: "cpu=100,mem=500G,gres/gpu=4", MaxWall: "2-00:00:00"},
+		Members: []MemberLimits{
+			{UserId: member.Id, SlurmLimits: SlurmLimits{MaxJobs: &maxJobs}},
+		},
+	}
+	pl, err = s.SetPirgLimits(ctx, pirg.Id, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pl, want) {
+		t.Fatalf("expected %+v got %+v", want, pl)
+	}
+	got, err := s.GetPirgLimits(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v got %+v", want, got)
+	}
+
+	// member limits go away when the member leaves the pirg
+	_, err = s.UpdatePirg(ctx, pirg.Id, &PirgRequest{Name: pirg.Name, OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetPirgLimits(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Members) != 0 || got.GrpTRES != want.GrpTRES {
+		t.Fatalf("expected only the pirg limits to be left, got %+v", got)
+	}
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreCompute(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststorecomputeowner")
+	member := mustCreateUser(t, s, "teststorecomputemember")
+	pirg := mustCreatePirg(t, s, "teststorecomputepirg", owner, member)
+	cluster, err := s.CreateCluster(ctx, &ClusterRequest{Name: uniqueName("teststorecomputecluster")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the time of day is dropped from the dates
+	allocation, err := s.CreateComputeAllocation(ctx, pirg.Id, &ComputeAllocationRequest{Resource: "cpu", Hours: 1000, StartDate: start.Add(5 * time.Hour), EndDate: start.AddDate(0, 1, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allocation.StartDate.Equal(start) {
+		t.Fatalf("expected start date %v got %v", start, allocation.StartDate)
+	}
+	got, err := s.GetComputeAllocationById(ctx, allocation.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, allocation) {
+		t.Fatalf("expected %+v got %+v", allocation, got)
+	}
+
+	t.Run("BadResource", func(t *testing.T) {
+		_, err := s.CreateComputeAllocation(ctx, pirg.Id, &ComputeAllocationRequest{Resource: "tpu", Hours: 1, StartDate: start, EndDate: start})
+		if err == nil {
+			t.Fatal("expected error for an unknown resource")
+		}
+	})
+	t.Run("EndBeforeStart", func(t *testing.T) {
+		_, err := s.CreateComputeAllocation(ctx, pirg.Id, &ComputeAllocationRequest{Resource: "gpu", Hours: 1, StartDate: start, EndDate: start.AddDate(0, 0, -1)})
+		if err == nil {
+			t.Fatal("expected error for an end date before the start date")
+		}
+	})
+	t.Run("MissingPirg", func(t *testing.T) {
+		_, err := s.CreateComputeAllocation(ctx, -1, &ComputeAllocationRequest{Resource: "gpu", Hours: 1, StartDate: start, EndDate: start})
+		expectErr(t, err, ErrConflict)
+	})
+
+	updated, err := s.UpdateComputeAllocation(ctx, allocation.Id, &ComputeAllocationRequest{Resource: "gpu", Hours: 200, StartDate: start, EndDate: start.AddDate(0, 1, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Resource != "gpu" || updated.Hours != 200 {
+		t.Fatalf("expected updated allocation, got %+v", updated)
+	}
+	_, err = s.UpdateComputeAllocation(ctx, -1, &ComputeAllocationRequest{Resource: "gpu", Hours: 1, StartDate: start, EndDate: start})
+	expectErr(t, err, ErrNotFound)
+	allocations, err := s.GetPirgComputeAllocations(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].Id != allocation.Id {
+		t.Fatalf("expected only allocation %d, got %+v", allocation.Id, allocations)
+	}
+
+	jobs := []*JobUsage{
+		{ClusterId: cluster.Id, JobId: "1", PirgId: pirg.Id, Account: pirg.Name, UserId: &member.Id, Username: member.Username, CPUHours: 10, GPUHours: 1, EndedAt: start.Add(time.Hour)},
+		{ClusterId: cluster.Id, JobId: "2", PirgId: pirg.Id, Account: pirg.Name, UserId: &member.Id, Username: member.Username, CPUHours: 5, EndedAt: start.Add(2 * time.Hour)},
+		{ClusterId: cluster.Id, JobId: "3", PirgId: pirg.Id, Account: pirg.Name, Username: "gone", CPUHours: 2, EndedAt: start.Add(3 * time.Hour)},
+		// outside of the allocation
+		{ClusterId: cluster.Id, JobId: "4", PirgId: pirg.Id, Account: pirg.Name, UserId: &owner.Id, Username: owner.Username, CPUHours: 100, EndedAt: start.AddDate(0, 2, 0)},
+	}
+	if err = s.RecordJobUsage(ctx, jobs); err != nil {
+		t.Fatal(err)
+	}
+	// recording a job again replaces it
+	jobs[1].CPUHours = 6
+	if err = s.RecordJobUsage(ctx, jobs[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	err = s.RecordJobUsage(ctx, []*JobUsage{{ClusterId: -1, JobId: "1", PirgId: pirg.Id, EndedAt: start}})
+	expectErr(t, err, ErrConflict)
+
+	from, to := allocation.Window()
+	usage, err := s.GetPirgUsage(ctx, pirg.Id, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*UserUsage{
+		{Username: "gone", Jobs: 1, CPUHours: 2},
+		{UserId: &member.Id, Username: member.Username, Jobs: 2, CPUHours: 16, GPUHours: 1},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Fatalf("expected %+v got %+v", want, usage)
+	}
+
+	t.Run("DeleteClusterWithUsage", func(t *testing.T) {
+		expectErr(t, s.DeleteCluster(ctx, cluster.Id), ErrConflict)
+	})
+
+	// usage is kept when the user goes away
+	if err = s.DeleteUser(ctx, member.Id); err != nil {
+		t.Fatal(err)
+	}
+	usage, err = s.GetPirgUsage(ctx, pirg.Id, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[1].UserId != nil || usage[1].CPUHours != 16 {
+		t.Fatalf("expected usage without a user id, got %+v", usage)
+	}
+
+	if err = s.DeleteComputeAllocation(ctx, allocation.Id); err != nil {
+		t.Fatal(err)
+	}
+	expectErr(t, s.DeleteComputeAllocation(ctx, allocation.Id), ErrNotFound)
+
+	// allocations go with the pirg, usage is kept for historical reports
+	if _, err = s.CreateComputeAllocation(ctx, pirg.Id, &ComputeAllocationRequest{Resource: "cpu", Hours: 1, StartDate: start, EndDate: start}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	allocations, err = s.GetPirgComputeAllocations(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 0 {
+		t.Fatalf("expected allocations to be deleted with the pirg, got %+v", allocations)
+	}
+	expectErr(t, s.DeleteCluster(ctx, cluster.Id), ErrConflict)
+}
+
+func testStoreStorageUsage(t *testing.T, s Store) {
+	ctx := context.Background()
+	fs, err := s.CreateLocation(ctx, &LocationRequest{Name: uniqueName("teststoreusagefs"), Kind: "filesystem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := mustCreateUser(t, s, "teststoreusageowner")
+	pirg := mustCreatePirg(t, s, "teststoreusagepirg", owner)
+	other := mustCreatePirg(t, s, "teststoreusageother", owner)
+
+	// gids are unique across runs against the same database
+	gid := int(time.Now().UnixNano()%1000000000) + 1
+	pr := &PirgRequest{Name: pirg.Name, OwnerId: owner.Id, Gid: &gid, AdminIds: pirg.AdminIds, UserIds: pirg.UserIds}
+	if _, err = s.UpdatePirg(ctx, pirg.Id, pr); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetPirgByGid(ctx, gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != pirg.Id || got.Gid == nil || *got.Gid != gid {
+		t.Fatalf("expected pirg %d with gid %d, got %+v", pirg.Id, gid, got)
+	}
+	_, err = s.GetPirgByGid(ctx, -1)
+	expectErr(t, err, ErrNotFound)
+	t.Run("DuplicateGid", func(t *testing.T) {
+		_, err := s.UpdatePirg(ctx, other.Id, &PirgRequest{Name: other.Name, OwnerId: owner.Id, Gid: &gid, AdminIds: other.AdminIds, UserIds: other.UserIds})
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("BadGid", func(t *testing.T) {
+		zero := 0
+		_, err := s.UpdatePirg(ctx, other.Id, &PirgRequest{Name: other.Name, OwnerId: owner.Id, Gid: &zero, AdminIds: other.AdminIds, UserIds: other.UserIds})
+		if err == nil {
+			t.Fatal("expected error for a gid of 0")
+		}
+	})
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	samples := []*StorageUsage{
+		{PirgId: pirg.Id, LocationId: fs.Id, UsedBytes: 300, SampledAt: start.AddDate(0, 0, 2)},
+		{PirgId: pirg.Id, LocationId: fs.Id, UsedBytes: 100, SampledAt: start},
+		{PirgId: other.Id, LocationId: fs.Id, UsedBytes: 5, SampledAt: start},
+	}
+	if err = s.RecordStorageUsage(ctx, samples); err != nil {
+		t.Fatal(err)
+	}
+	if samples[0].Id == 0 {
+		t.Fatal("expected recorded samples to get an id")
+	}
+	err = s.RecordStorageUsage(ctx, []*StorageUsage{{PirgId: pirg.Id, LocationId: -1, SampledAt: start}})
+	expectErr(t, err, ErrConflict)
+
+	usage, err := s.GetStorageUsage(ctx, pirg.Id, fs.Id, start, start.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Id != samples[1].Id || usage[0].UsedBytes != 100 || !usage[0].SampledAt.Equal(start) {
+		t.Fatalf("expected only %+v, got %+v", samples[1], usage)
+	}
+	usage, err = s.GetStorageUsage(ctx, pirg.Id, fs.Id, start, start.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[0].UsedBytes != 100 || usage[1].UsedBytes != 300 {
+		t.Fatalf("expected usage oldest first, got %+v", usage)
+	}
+
+	// samples go away with their pirg and location
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	usage, err = s.GetStorageUsage(ctx, pirg.Id, fs.Id, start, start.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 0 {
+		t.Fatalf("expected usage to be deleted with the pirg, got %+v", usage)
+	}
+	if err = s.DeleteLocation(ctx, fs.Id); err != nil {
+		t.Fatal(err)
+	}
+	usage, err = s.GetStorageUsage(ctx, other.Id, fs.Id, start, start.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 0 {
+		t.Fatalf("expected usage to be deleted with the location, got %+v", usage)
+	}
+}
+
+func testStoreAccessReviews(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststorereviewowner")
+	kept := mustCreateUser(t, s, "teststorereviewkept")
+	dropped := mustCreateUser(t, s, "teststorereviewdropped")
+	ignored := mustCreateUser(t, s, "teststorereviewignored")
+	pirg := mustCreatePirg(t, s, "teststorereviewpirg", owner, kept, dropped, ignored)
+	pendingBefore, err := s.CountPendingAccessRequests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPending := func(want int) {
+		t.Helper()
+		if count, err := s.CountPendingAccessRequests(ctx); err != nil || count != pendingBefore+want {
+			t.Fatalf("expected %d more pending access requests, got %d: %v", want, count-pendingBefore, err)
+		}
+	}
+
+	_, err = s.CreateAccessReview(ctx, &AccessReviewRequest{Name: uniqueName("teststorereview"), Deadline: time.Now().Add(time.Hour), PirgIds: []int{-1}})
+	expectErr(t, err, ErrConflict)
+	if _, err = s.CreateAccessReview(ctx, &AccessReviewRequest{Deadline: time.Now().Add(time.Hour)}); err == nil {
+		t.Fatal("expected error for a review without a name")
+	}
+
+	review, err := s.CreateAccessReview(ctx, &AccessReviewRequest{
+		Name:              uniqueName("teststorereview"),
+		Deadline:          time.Now().Add(time.Hour),
+		RemoveUnconfirmed: true,
+		PirgIds:           []int{pirg.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.Id == 0 || review.ClosedAt != nil {
+		t.Fatalf("expected an open review, got %+v", review)
+	}
+	expectPending(3)
+
+	// the owner isn't reviewed
+	items, err := s.GetAccessReviewItems(ctx, review.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reviewed []int
+	for _, item := range items {
+		if item.Decision != ReviewPending || item.DecidedBy != nil {
+			t.Fatalf("expected a pending item, got %+v", item)
+		}
+		reviewed = append(reviewed, item.UserId)
+	}
+	if !reflect.DeepEqual(reviewed, []int{kept.Id, dropped.Id, ignored.Id}) {
+		t.Fatalf("expected members %v under review, got %v", []int{kept.Id, dropped.Id, ignored.Id}, reviewed)
+	}
+	_, err = s.GetAccessReviewItems(ctx, -1)
+	expectErr(t, err, ErrNotFound)
+
+	decisions := []AccessReviewDecision{{UserId: kept.Id, Decision: ReviewConfirmed}, {UserId: dropped.Id, Decision: ReviewRemoved}}
+	items, err = s.DecideAccessReview(ctx, review.Id, pirg.Id, decisions, &owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := ReviewProgress(items)
+	if len(progress) != 1 || *progress[0] != (AccessReviewProgress{PirgId: pirg.Id, Pending: 1, Confirmed: 1, Removed: 1}) {
+		t.Fatalf("expected one of each decision, got %+v", progress)
+	}
+	expectPending(1)
+	for _, item := range items {
+		if item.Decision != ReviewPending && (item.DecidedBy == nil || *item.DecidedBy != owner.Id || item.DecidedAt == nil) {
+			t.Fatalf("expected the owner to have decided %+v", item)
+		}
+	}
+	p, err := s.GetPirgById(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(p.UserIds, dropped.Id) {
+		t.Fatalf("expected user %d to be removed from the pirg, got %v", dropped.Id, p.UserIds)
+	}
+	t.Run("RemoveOwner", func(t *testing.T) {
+		_, err := s.DecideAccessReview(ctx, review.Id, pirg.Id, []AccessReviewDecision{{UserId: owner.Id, Decision: ReviewRemoved}}, nil)
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("NotUnderReview", func(t *testing.T) {
+		other := mustCreateUser(t, s, "teststorereviewother")
+		_, err := s.DecideAccessReview(ctx, review.Id, pirg.Id, []AccessReviewDecision{{UserId: other.Id, Decision: ReviewConfirmed}}, nil)
+		expectErr(t, err, ErrNotFound)
+	})
+	t.Run("BadDecision", func(t *testing.T) {
+		_, err := s.DecideAccessReview(ctx, review.Id, pirg.Id, []AccessReviewDecision{{UserId: kept.Id, Decision: ReviewPending}}, nil)
+		if err == nil {
+			t.Fatal("expected error for a pending decision")
+		}
+	})
+
+	// closing removes the members nobody decided on
+	closed, err := s.CloseAccessReview(ctx, review.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.ClosedAt == nil {
+		t.Fatal("expected the review to be closed")
+	}
+	expectPending(0)
+	p, err = s.GetPirgById(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.UserIds, []int{owner.Id, kept.Id}) {
+		t.Fatalf("expected only the owner and confirmed member to be left, got %v", p.UserIds)
+	}
+	items, err = s.GetAccessReviewItems(ctx, review.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := items[len(items)-1]; last.UserId != ignored.Id || last.Decision != ReviewRemoved || last.DecidedBy != nil {
+		t.Fatalf("expected user %d to be removed by the close, got %+v", ignored.Id, last)
+	}
+	_, err = s.CloseAccessReview(ctx, review.Id)
+	expectErr(t, err, ErrConflict)
+	_, err = s.DecideAccessReview(ctx, review.Id, pirg.Id, []AccessReviewDecision{{UserId: kept.Id, Decision: ReviewConfirmed}}, nil)
+	expectErr(t, err, ErrConflict)
+
+	reviews, err := s.GetAllAccessReviews(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(reviews, func(r *AccessReview) bool { return r.Id == review.Id }) {
+		t.Fatalf("expected review %d in %+v", review.Id, reviews)
+	}
+
+	// items go away with their pirg
+	if err = s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	items, err = s.GetAccessReviewItems(ctx, review.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected no items after deleting the pirg, got %+v", items)
+	}
+}
+
+func testStoreMemberships(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststoretermowner")
+	student := mustCreateUser(t, s, "teststoretermstudent")
+	visitor := mustCreateUser(t, s, "teststoretermvisitor")
+	pirg := mustCreatePirg(t, s, "teststoretermpirg", owner, student, visitor)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	startsAt, expiresAt := now.AddDate(0, -1, 0), now.AddDate(0, 0, 7)
+	m, err := s.SetMembershipTerm(ctx, pirg.Id, student.Id, &MembershipTerm{StartsAt: &startsAt, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.StartsAt == nil || !m.StartsAt.Equal(startsAt) || m.ExpiresAt == nil || !m.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected a term from %v to %v, got %+v", startsAt, expiresAt, m)
+	}
+	if !m.Active(now) || m.Active(expiresAt) || m.Active(startsAt.Add(-time.Second)) {
+		t.Fatalf("expected the membership to be active only during its term, got %+v", m)
+	}
+	t.Run("Owner", func(t *testing.T) {
+		_, err := s.SetMembershipTerm(ctx, pirg.Id, owner.Id, &MembershipTerm{ExpiresAt: &expiresAt})
+		if err == nil {
+			t.Fatal("expected error for a term on the owner's membership")
+		}
+	})
+	t.Run("ExpiresBeforeStart", func(t *testing.T) {
+		_, err := s.SetMembershipTerm(ctx, pirg.Id, student.Id, &MembershipTerm{StartsAt: &expiresAt, ExpiresAt: &startsAt})
+		if err == nil {
+			t.Fatal("expected error for a membership that expires before it starts")
+		}
+	})
+	t.Run("NotAMember", func(t *testing.T) {
+		other := mustCreateUser(t, s, "teststoretermother")
+		_, err := s.SetMembershipTerm(ctx, pirg.Id, other.Id, &MembershipTerm{ExpiresAt: &expiresAt})
+		expectErr(t, err, ErrNotFound)
+		_, err = s.SetMembershipTerm(ctx, -1, other.Id, &MembershipTerm{})
+		expectErr(t, err, ErrNotFound)
+	})
+
+	memberships, err := s.GetPirgMemberships(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberships) != 3 || memberships[1].UserId != student.Id || memberships[1].ExpiresAt == nil || memberships[2].ExpiresAt != nil {
+		t.Fatalf("expected only the student's membership to have a term, got %+v", memberships)
+	}
+	all, err := s.GetAllMemberships(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inAll []*Membership
+	for _, m := range all {
+		if m.PirgId == pirg.Id {
+			inAll = append(inAll, m)
+		}
+	}
+	if !reflect.DeepEqual(inAll, memberships) {
+		t.Fatalf("expected all memberships to include the pirg's %+v, got %+v", memberships, inAll)
+	}
+
+	// the visitor's membership already expired
+	expiredAt := now.Add(-time.Hour)
+	if _, err = s.SetMembershipTerm(ctx, pirg.Id, visitor.Id, &MembershipTerm{ExpiresAt: &expiredAt}); err != nil {
+		t.Fatal(err)
+	}
+	inPirg := func(memberships []*Membership) []int {
+		var ids []int
+		for _, m := range memberships {
+			if m.PirgId == pirg.Id {
+				ids = append(ids, m.UserId)
+			}
+		}
+		return ids
+	}
+	expiring, err := s.GetExpiringMemberships(ctx, now.AddDate(0, 0, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := inPirg(expiring); !reflect.DeepEqual(ids, []int{visitor.Id, student.Id}) {
+		t.Fatalf("expected the visitor then the student to be expiring, got %v", ids)
+	}
+	expiring, err = s.GetExpiringMemberships(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := inPirg(expiring); !reflect.DeepEqual(ids, []int{visitor.Id}) {
+		t.Fatalf("expected only the visitor to have expired, got %v", ids)
+	}
+
+	removed, err := s.RemoveExpiredMemberships(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := inPirg(removed); !reflect.DeepEqual(ids, []int{visitor.Id}) {
+		t.Fatalf("expected only the visitor to be removed, got %v", ids)
+	}
+	p, err := s.GetPirgById(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.UserIds, []int{owner.Id, student.Id}) {
+		t.Fatalf("expected the visitor to be out of the pirg, got %v", p.UserIds)
+	}
+	entries, err := s.GetAuditLog(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(entries, func(e *AuditEntry) bool {
+		return e.Action == AuditMembershipExpired && e.UserId != nil && *e.UserId == visitor.Id && e.PirgId != nil && *e.PirgId == pirg.Id
+	}) {
+		t.Fatalf("expected an audit entry for the visitor, got %+v", entries)
+	}
+
+	// rejoining starts without a term
+	pr := &PirgRequest{Name: p.Name, OwnerId: owner.Id, AdminIds: p.AdminIds, UserIds: append(p.UserIds, visitor.Id)}
+	if _, err = s.UpdatePirg(ctx, pirg.Id, pr); err != nil {
+		t.Fatal(err)
+	}
+	memberships, err = s.GetPirgMemberships(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := memberships[len(memberships)-1]; last.UserId != visitor.Id || last.ExpiresAt != nil {
+		t.Fatalf("expected the visitor to rejoin without a term, got %+v", last)
+	}
+}
+
+func testStoreNotifications(t *testing.T, s Store) {
+	ctx := context.Background()
+	recipient := uniqueName("teststorenotify") + "@example.org"
+	dedupeKey := uniqueName("teststorenotify")
+	expiring, err := s.EnqueueNotification(ctx, &NotificationRequest{Event: "membership_expiring", Recipient: recipient, Params: map[string]string{"pirg": "racs"}, DedupeKey: dedupeKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := s.EnqueueNotification(ctx, &NotificationRequest{Event: "pirg_member_added", Recipient: recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiring.Attempts != 0 || expiring.SentAt != nil || expiring.FailedAt != nil || expiring.Params["pirg"] != "racs" {
+		t.Fatalf("expected a pending notification, got %+v", expiring)
+	}
+	t.Run("Duplicate", func(t *testing.T) {
+		_, err := s.EnqueueNotification(ctx, &NotificationRequest{Event: "membership_expiring", Recipient: recipient, DedupeKey: dedupeKey})
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("MissingRecipient", func(t *testing.T) {
+		if _, err := s.EnqueueNotification(ctx, &NotificationRequest{Event: "pirg_member_added"}); err == nil {
+			t.Fatal("expected error for a notification without a recipient")
+		}
+	})
+	t.Run("Missing", func(t *testing.T) {
+		expectErr(t, s.MarkNotificationSent(ctx, -1), ErrNotFound)
+		expectErr(t, s.MarkNotificationFailed(ctx, -1, "nope", nil), ErrNotFound)
+	})
+
+	// rows left by earlier runs against postgres are ignored
+	claim := func(asOf time.Time) map[int]*Notification {
+		t.Helper()
+		notifications, err := s.ClaimNotifications(ctx, asOf, 5*time.Minute, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ours := make(map[int]*Notification)
+		for _, n := range notifications {
+			if n.Recipient == recipient {
+				ours[n.Id] = n
+			}
+		}
+		return ours
+	}
+	start := time.Now().Add(time.Second)
+	claimed := claim(start)
+	if len(claimed) != 2 || claimed[expiring.Id] == nil || claimed[added.Id] == nil {
+		t.Fatalf("expected both notifications to be claimed, got %v", claimed)
+	}
+	if claimed[expiring.Id].Attempts != 1 || claimed[added.Id].Params == nil {
+		t.Fatalf("expected the claim to count an attempt, got %+v", claimed[expiring.Id])
+	}
+	if claimed := claim(start); len(claimed) != 0 {
+		t.Fatalf("expected leased notifications not to be claimed again, got %v", claimed)
+	}
+
+	if err := s.MarkNotificationSent(ctx, expiring.Id); err != nil {
+		t.Fatal(err)
+	}
+	retryAt := start.Add(time.Hour)
+	if err := s.MarkNotificationFailed(ctx, added.Id, "connection refused", &retryAt); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(retryAt.Add(-time.Minute)); len(claimed) != 0 {
+		t.Fatalf("expected nothing to be due before the retry, got %v", claimed)
+	}
+	claimed = claim(retryAt)
+	if len(claimed) != 1 || claimed[added.Id] == nil {
+		t.Fatalf("expected only the failed notification to be retried, got %v", claimed)
+	}
+	if n := claimed[added.Id]; n.Attempts != 2 || n.LastError != "connection refused" {
+		t.Fatalf("expected a second attempt after the failure, got %+v", n)
+	}
+	if err := s.MarkNotificationFailed(ctx, added.Id, "mailbox unavailable", nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(retryAt.Add(24 * time.Hour)); len(claimed) != 0 {
+		t.Fatalf("expected sent and failed notifications not to be claimed, got %v", claimed)
+	}
+}
+
+func testStorePirgGroups(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststoregroupowner")
+	student := mustCreateUser(t, s, "teststoregroupstudent")
+	outsider := mustCreateUser(t, s, "teststoregroupoutsider")
+	pirg := mustCreatePirg(t, s, "teststoregrouppirg", owner, student)
+	other := mustCreatePirg(t, s, "teststoregroupother", owner)
+
+	// gids are unique across runs against the same database
+	gid := int(time.Now().UnixNano()%1000000000) + 1
+	group, err := s.CreatePirgGroup(ctx, pirg.Id, &PirgGroupRequest{Name: "students", Gid: &gid, UserIds: []int{student.Id, student.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.PirgId != pirg.Id || group.Name != "students" || group.Gid == nil || *group.Gid != gid || !reflect.DeepEqual(group.UserIds, []int{student.Id}) {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	t.Run("DuplicateName", func(t *testing.T) {
+		_, err := s.CreatePirgGroup(ctx, pirg.Id, &PirgGroupRequest{Name: "students"})
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("DuplicateGid", func(t *testing.T) {
+		_, err := s.CreatePirgGroup(ctx, other.Id, &PirgGroupRequest{Name: "staff", Gid: &gid})
+		expectErr(t, err, ErrConflict)
+	})
+	t.Run("BadName", func(t *testing.T) {
+		if _, err := s.CreatePirgGroup(ctx, pirg.Id, &PirgGroupRequest{Name: "a:b"}); err == nil {
+			t.Fatal("expected error for a group name with a colon")
+		}
+	})
+	t.Run("NotAMember", func(t *testing.T) {
+		if _, err := s.CreatePirgGroup(ctx, pirg.Id, &PirgGroupRequest{Name: "outsiders", UserIds: []int{outsider.Id}}); err == nil {
+			t.Fatal("expected error for a group member outside the pirg")
+		}
+	})
+	t.Run("MissingPirg", func(t *testing.T) {
+		_, err := s.CreatePirgGroup(ctx, -1, &PirgGroupRequest{Name: "students"})
+		expectErr(t, err, ErrNotFound)
+	})
+
+	// the same name can be used in another pirg
+	staff, err := s.CreatePirgGroup(ctx, other.Id, &PirgGroupRequest{Name: "students"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staff.Gid != nil || len(staff.UserIds) != 0 {
+		t.Fatalf("expected a group without a gid or members, got %+v", staff)
+	}
+	groups, err := s.GetPirgGroups(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Id != group.Id {
+		t.Fatalf("expected only the students group, got %+v", groups)
+	}
+
+	updated, err := s.UpdatePirgGroup(ctx, group.Id, &PirgGroupRequest{Name: "lab", UserIds: []int{owner.Id, student.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "lab" || updated.Gid != nil || !reflect.DeepEqual(updated.UserIds, []int{owner.Id, student.Id}) {
+		t.Fatalf("unexpected updated group %+v", updated)
+	}
+
+	// leaving the pirg leaves its groups
+	pr := &PirgRequest{Name: pirg.Name, OwnerId: owner.Id, AdminIds: pirg.AdminIds, UserIds: []int{owner.Id}}
+	if _, err = s.UpdatePirg(ctx, pirg.Id, pr); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetPirgGroupById(ctx, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.UserIds, []int{owner.Id}) {
+		t.Fatalf("expected the student to leave the group with the pirg, got %v", got.UserIds)
+	}
+
+	if err := s.DeletePirgGroup(ctx, group.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetPirgGroupById(ctx, group.Id)
+	expectErr(t, err, ErrNotFound)
+	expectErr(t, s.DeletePirgGroup(ctx, group.Id), ErrNotFound)
+
+	// deleting the pirg deletes its groups
+	if err := s.DeletePirg(ctx, other.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetPirgGroupById(ctx, staff.Id)
+	expectErr(t, err, ErrNotFound)
+}
+
+func testStoreUnixIds(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "teststoreunixowner")
+	member := mustCreateUser(t, s, "teststoreunixmember")
+	pirg := mustCreatePirg(t, s, "teststoreunixpirg", owner, member)
+	group, err := s.CreatePirgGroup(ctx, pirg.Id, &PirgGroupRequest{Name: "students", UserIds: []int{member.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a uid that was set is kept, even outside the range
+	uid := int(time.Now().UnixNano()%1000000000) + 1
+	ur := &UserRequest{Username: owner.Username, Email: owner.Email, FirstName: owner.FirstName, LastName: owner.LastName, Uid: &uid}
+	if err := s.UpdateUser(ctx, owner.Id, ur); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("DuplicateUid", func(t *testing.T) {
+		ur := &UserRequest{Username: member.Username, Email: member.Email, FirstName: member.FirstName, LastName: member.LastName, Uid: &uid}
+		err := s.UpdateUser(ctx, member.Id, ur)
+		expectErr(t, err, ErrConflict)
+	})
+
+	uids, gids := IdRange{Min: 1500000000, Max: 1599999999}, IdRange{Min: 1600000000, Max: 1699999999}
+	if _, err := s.AllocateUnixIds(ctx, uids, gids); err != nil {
+		t.Fatal(err)
+	}
+	gotOwner, err := s.GetUserById(ctx, owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotMember, err := s.GetUserById(ctx, member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotOwner.Uid == nil || *gotOwner.Uid != uid {
+		t.Fatalf("expected the owner to keep uid %d, got %v", uid, gotOwner.Uid)
+	}
+	if gotMember.Uid == nil || *gotMember.Uid < uids.Min || *gotMember.Uid > uids.Max {
+		t.Fatalf("expected the member to get a uid in %v, got %v", uids, gotMember.Uid)
+	}
+	gotPirg, err := s.GetPirgById(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotGroup, err := s.GetPirgGroupById(ctx, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPirg.Gid == nil || gotGroup.Gid == nil || *gotPirg.Gid == *gotGroup.Gid || *gotGroup.Gid < gids.Min || *gotGroup.Gid > gids.Max {
+		t.Fatalf("expected the pirg and group to get different gids in %v, got %v and %v", gids, gotPirg.Gid, gotGroup.Gid)
+	}
+
+	// allocating again changes nothing
+	allocation, err := s.AllocateUnixIds(ctx, uids, gids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocation.Users != 0 || allocation.Pirgs != 0 || allocation.Groups != 0 {
+		t.Fatalf("expected nothing to be allocated, got %+v", allocation)
+	}
+	again, err := s.GetUserById(ctx, member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIntPtr(again.Uid, gotMember.Uid) {
+		t.Fatalf("expected the member to keep uid %d, got %v", *gotMember.Uid, again.Uid)
+	}
+
+	t.Run("NotReused", func(t *testing.T) {
+		// the id of a deleted user isn't handed out again
+		gone := mustCreateUser(t, s, "teststoreunixgone")
+		if _, err := s.AllocateUnixIds(ctx, uids, gids); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetUserById(ctx, gone.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteUser(ctx, gone.Id); err != nil {
+			t.Fatal(err)
+		}
+		next := mustCreateUser(t, s, "teststoreunixnext")
+		if _, err := s.AllocateUnixIds(ctx, uids, gids); err != nil {
+			t.Fatal(err)
+		}
+		gotNext, err := s.GetUserById(ctx, next.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotNext.Uid == nil || *gotNext.Uid <= *got.Uid {
+			t.Fatalf("expected a uid above %d, got %v", *got.Uid, gotNext.Uid)
+		}
+	})
+	t.Run("Exhausted", func(t *testing.T) {
+		late := mustCreateUser(t, s, "teststoreunixlate")
+		full := IdRange{Min: *gotMember.Uid, Max: *gotMember.Uid}
+		if _, err := s.AllocateUnixIds(ctx, full, gids); err == nil {
+			t.Fatal("expected error when the uid range is full")
+		}
+		got, err := s.GetUserById(ctx, late.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Uid != nil {
+			t.Fatalf("expected no uid after a failed allocation, got %d", *got.Uid)
+		}
+	})
+}
+
+func testStoreDirectorySnapshots(t *testing.T, s Store) {
+	ctx := context.Background()
+	first, err := s.SaveDirectorySnapshot(ctx, uniqueName("first"), []byte(`[{"dn": "ou=people"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.SaveDirectorySnapshot(ctx, first.Digest, []byte(`[{"dn": "ou=people"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != first.Id {
+		t.Fatalf("expected an unchanged snapshot not to be saved again, got %d and %d", first.Id, again.Id)
+	}
+	// snapshots are told apart by the time they were saved
+	time.Sleep(10 * time.Millisecond)
+	second, err := s.SaveDirectorySnapshot(ctx, uniqueName("second"), []byte(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Id == first.Id || !second.CreatedAt.After(first.CreatedAt) {
+		t.Fatalf("expected a new snapshot after the first, got %+v", second)
+	}
+
+	at, err := s.GetDirectorySnapshot(ctx, first.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.Id != first.Id || string(at.Entries) == "" {
+		t.Fatalf("expected the first snapshot, got %+v", at)
+	}
+	at, err = s.GetDirectorySnapshot(ctx, second.CreatedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.Id != second.Id || at.Digest != second.Digest {
+		t.Fatalf("expected the second snapshot, got %+v", at)
+	}
+	_, err = s.GetDirectorySnapshot(ctx, first.CreatedAt.AddDate(-10, 0, 0))
+	expectErr(t, err, ErrNotFound)
+
+	// the snapshot in effect at the time is kept
+	if _, err := s.PruneDirectorySnapshots(ctx, second.CreatedAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDirectorySnapshot(ctx, first.CreatedAt); err != nil {
+		t.Fatalf("expected the first snapshot to be kept, got %v", err)
+	}
+	if _, err := s.PruneDirectorySnapshots(ctx, second.CreatedAt.Add(time.Microsecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetDirectorySnapshot(ctx, first.CreatedAt)
+	expectErr(t, err, ErrNotFound)
+	if _, err := s.GetDirectorySnapshot(ctx, second.CreatedAt); err != nil {
+		t.Fatalf("expected the second snapshot to be kept, got %v", err)
+	}
+}
+
+func testStoreTransactions(t *testing.T, s Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s, "txowner")
+	failed := errors.New("failed")
+
+	var created *User
+	err := s.Transaction(ctx, func(tx Store) error {
+		created = mustCreateUser(t, tx, "txrolledback")
+		mustCreatePirg(t, tx, "txrolledback", owner, created)
+		// nested transactions join the outer one
+		if err := tx.Transaction(ctx, func(tx Store) error {
+			return tx.UpdateUser(ctx, owner.Id, &UserRequest{Username: owner.Username, Email: "changed@localhost"})
+		}); err != nil {
+			return err
+		}
+		return failed
+	})
+	expectErr(t, err, failed)
+	_, err = s.GetUserById(ctx, created.Id)
+	expectErr(t, err, ErrNotFound)
+	_, err = s.GetPirgByName(ctx, uniqueName("txrolledback"))
+	expectErr(t, err, ErrNotFound)
+	got, err := s.GetUserById(ctx, owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != owner.Email {
+		t.Fatalf("expected the update to be rolled back, got %q", got.Email)
+	}
+
+	err = s.Transaction(ctx, func(tx Store) error {
+		created = mustCreateUser(t, tx, "txcommitted")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserById(ctx, created.Id); err != nil {
+		t.Fatalf("expected the user to be committed, got %v", err)
+	}
+}
+
+func testStoreGuests(t *testing.T, s Store) {
+	ctx := context.Background()
+	sponsor := mustCreateUser(t, s, "guestsponsor")
+	pirg := mustCreatePirg(t, s, "guestpirg", sponsor)
+	notOwner := mustCreateUser(t, s, "guestnotowner")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+	guestRequest := func(name string, sponsorId int) *UserRequest {
+		username := uniqueName(name)
+		return &UserRequest{Username: username, Email: username + "@localhost", FirstName: "Test", LastName: "Guest", Type: UserGuest, SponsorId: &sponsorId, ExpiresAt: &expiresAt}
+	}
+
+	guest, err := s.CreateUser(ctx, guestRequest("guest", sponsor.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Type != UserGuest || *guest.SponsorId != sponsor.Id || !guest.ExpiresAt.Equal(expiresAt) || !guest.Active() {
+		t.Fatalf("unexpected guest %+v", guest)
+	}
+	if sponsor.Type != UserInstitutional {
+		t.Fatalf("expected users to be institutional by default, got %q", sponsor.Type)
+	}
+	key, err := s.CreateAPIKey(ctx, &APIKeyRequest{Key: uniqueName("guestkey"), Role: "user", UserId: guest.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		noSponsor := guestRequest("guestnosponsor", 0)
+		noSponsor.SponsorId = nil
+		notAnOwner := guestRequest("guestnotanowner", notOwner.Id)
+		sponsored := guestRequest("sponsoredservice", sponsor.Id)
+		sponsored.Type = UserService
+		badType := guestRequest("badtype", sponsor.Id)
+		badType.Type = "contractor"
+		for _, req := range []*UserRequest{noSponsor, notAnOwner, sponsored, badType} {
+			if _, err := s.CreateUser(ctx, req); err == nil {
+				t.Errorf("expected an error creating %+v", req)
+			}
+		}
+	})
+
+	deactivatedIds := func(at time.Time) []int {
+		t.Helper()
+		users, err := s.DeactivateGuests(ctx, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, u := range users {
+			if u.Id == guest.Id {
+				ids = append(ids, u.Id)
+			}
+		}
+		return ids
+	}
+	if ids := deactivatedIds(time.Now()); len(ids) != 0 {
+		t.Fatal("expected the guest to stay active before they expire")
+	}
+	if ids := deactivatedIds(expiresAt); len(ids) != 1 {
+		t.Fatal("expected the guest to be deactivated once they expire")
+	}
+	got, err := s.GetUserById(ctx, guest.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Active() {
+		t.Fatal("expected the guest to be deactivated")
+	}
+	_, err = s.GetAPIKeyEntry(ctx, key.Key)
+	expectErr(t, err, ErrNotFound)
+	entries, err := s.GetAuditLog(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[0].Action != AuditGuestDeactivated || *entries[0].UserId != guest.Id {
+		t.Fatalf("expected an audit entry for the guest, got %+v", entries)
+	}
+
+	// renewing reactivates them
+	renewed := guestRequest("guest", sponsor.Id)
+	later := expiresAt.Add(24 * time.Hour)
+	renewed.ExpiresAt = &later
+	if err := s.UpdateUser(ctx, guest.Id, renewed); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.GetUserById(ctx, guest.Id); err != nil || !got.Active() || !got.ExpiresAt.Equal(later) {
+		t.Fatalf("expected the guest to be renewed, got %+v %v", got, err)
+	}
+	if _, err := s.GetAPIKeyEntry(ctx, key.Key); err != nil {
+		t.Fatalf("expected the key to work again, got %v", err)
+	}
+
+	// the sponsor leaves once they no longer own a pirg
+	if err := s.DeletePirg(ctx, pirg.Id); err != nil {
+		t.Fatal(err)
+	}
+	if ids := deactivatedIds(time.Now()); len(ids) != 1 {
+		t.Fatal("expected the guest to be deactivated without a sponsoring pirg owner")
+	}
+	if err := s.DeleteUser(ctx, sponsor.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.GetUserById(ctx, guest.Id); err != nil || got.SponsorId != nil {
+		t.Fatalf("expected the guest to be left without a sponsor, got %+v %v", got, err)
+	}
+}
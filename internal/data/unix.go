@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// IdRange is an inclusive range of unix ids
+type IdRange struct {
+	Min int
+	Max int
+}
+
+// UnixIdAllocation counts the ids handed out by AllocateUnixIds
+type UnixIdAllocation struct {
+	Users  int
+	Pirgs  int
+	Groups int
+}
+
+// planUnixIds picks an id in the range for each of need, in order. Ids
+// count up from the highest one used or handed out before, last, so the id
+// of someone who was deleted isn't given out again while there's room
+// above it. Once the top is reached the gaps below are filled. It's shared
+// by every store implementation.
+func planUnixIds(kind string, r IdRange, used []int, last int, need []int) ([]int, error) {
+	if len(need) == 0 {
+		return nil, nil
+	}
+	if r.Min <= 0 || r.Max < r.Min {
+		return nil, fmt.Errorf("invalid %s range %d-%d", kind, r.Min, r.Max)
+	}
+	taken := make(map[int]bool, len(used))
+	next := r.Min
+	if last >= r.Min && last <= r.Max {
+		next = last + 1
+	}
+	for _, id := range used {
+		taken[id] = true
+		if id >= next && id <= r.Max {
+			next = id + 1
+		}
+	}
+	var ids []int
+	for id := next; id <= r.Max && len(ids) < len(need); id++ {
+		ids = append(ids, id)
+	}
+	for id := r.Min; id < next && len(ids) < len(need); id++ {
+		if !taken[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < len(need) {
+		return nil, fmt.Errorf("only %d of the %d %ss needed are free in %d-%d", len(ids), len(need), kind, r.Min, r.Max)
+	}
+	return ids, nil
+}
+
+// lastUnixId is the highest of the ids, or last if it's higher
+func lastUnixId(last int, ids []int) int {
+	for _, id := range ids {
+		last = max(last, id)
+	}
+	return last
+}
+
+// AllocateUnixIds gives every user without a uid, and every pirg and pirg
+// group without a gid, the next free id in its range, in a single
+// transaction. Pirgs and pirg groups share the gid range. Ids that were
+// already set are kept, even outside the range. The tables are only locked
+// when something is missing an id, so exports polling it don't block writes.
+func (s *PostgresStore) AllocateUnixIds(ctx context.Context, uids IdRange, gids IdRange) (*UnixIdAllocation, error) {
+	ctx, span := startSpan(ctx, "AllocateUnixIds")
+	defer span.End()
+	slog.DebugContext(ctx, "allocating unix ids in database", "package", "data", "method", "AllocateUnixIds")
+	allocation := &UnixIdAllocation{}
+	var missing bool
+	err := s.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE uid IS NULL)
+		OR EXISTS (SELECT 1 FROM pirgs WHERE gid IS NULL)
+		OR EXISTS (SELECT 1 FROM pirgs_groups WHERE gid IS NULL)`).Scan(&missing)
+	if err != nil {
+		return nil, err
+	}
+	if !missing {
+		return allocation, nil
+	}
+	err = s.withTx(ctx, func(tx *PostgresStore) error {
+		// servers allocating at the same time would pick the same ids
+		if _, err := tx.q.ExecContext(ctx, "LOCK TABLE users, pirgs, pirgs_groups, unix_id_marks IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		usedUids, err := tx.queryIds(ctx, "SELECT uid FROM users WHERE uid IS NOT NULL")
+		if err != nil {
+			return err
+		}
+		users, err := tx.queryIds(ctx, "SELECT id FROM users WHERE uid IS NULL ORDER BY id")
+		if err != nil {
+			return err
+		}
+		lastUid, err := tx.lastUnixId(ctx, "uid")
+		if err != nil {
+			return err
+		}
+		newUids, err := planUnixIds("uid", uids, usedUids, lastUid, users)
+		if err != nil {
+			return err
+		}
+		if err := tx.setLastUnixId(ctx, "uid", lastUnixId(lastUid, newUids)); err != nil {
+			return err
+		}
+		for i, id := range users {
+			if _, err := tx.q.ExecContext(ctx, "UPDATE users SET uid = $1 WHERE id = $2", newUids[i], id); err != nil {
+				return mapError(err)
+			}
+		}
+
+		usedGids, err := tx.queryIds(ctx, "SELECT gid FROM pirgs WHERE gid IS NOT NULL UNION SELECT gid FROM pirgs_groups WHERE gid IS NOT NULL")
+		if err != nil {
+			return err
+		}
+		pirgs, err := tx.queryIds(ctx, "SELECT id FROM pirgs WHERE gid IS NULL ORDER BY id")
+		if err != nil {
+			return err
+		}
+		groups, err := tx.queryIds(ctx, "SELECT id FROM pirgs_groups WHERE gid IS NULL ORDER BY id")
+		if err != nil {
+			return err
+		}
+		lastGid, err := tx.lastUnixId(ctx, "gid")
+		if err != nil {
+			return err
+		}
+		newGids, err := planUnixIds("gid", gids, usedGids, lastGid, append(append([]int{}, pirgs...), groups...))
+		if err != nil {
+			return err
+		}
+		if err := tx.setLastUnixId(ctx, "gid", lastUnixId(lastGid, newGids)); err != nil {
+			return err
+		}
+		for i, id := range pirgs {
+			if _, err := tx.q.ExecContext(ctx, "UPDATE pirgs SET gid = $1 WHERE id = $2", newGids[i], id); err != nil {
+				return mapError(err)
+			}
+		}
+		for i, id := range groups {
+			if _, err := tx.q.ExecContext(ctx, "UPDATE pirgs_groups SET gid = $1 WHERE id = $2", newGids[len(pirgs)+i], id); err != nil {
+				return mapError(err)
+			}
+		}
+		allocation.Users, allocation.Pirgs, allocation.Groups = len(users), len(pirgs), len(groups)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allocation, nil
+}
+
+// lastUnixId returns the highest id of the kind handed out, or 0
+func (s *PostgresStore) lastUnixId(ctx context.Context, kind string) (int, error) {
+	var last int
+	err := s.q.QueryRowContext(ctx, "SELECT last_id FROM unix_id_marks WHERE kind = $1", kind).Scan(&last)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return last, err
+}
+
+func (s *PostgresStore) setLastUnixId(ctx context.Context, kind string, last int) error {
+	if last == 0 {
+		return nil
+	}
+	_, err := s.q.ExecContext(ctx, "INSERT INTO unix_id_marks (kind, last_id) VALUES ($1, $2) ON CONFLICT (kind) DO UPDATE SET last_id = EXCLUDED.last_id", kind, last)
+	return err
+}
@@ -1,22 +1,40 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
 type User struct {
-	Id         int
-	Username   string
-	Email      string
-	FirstName  string
-	LastName   string
-	CreatedAt  time.Time
-	ModifiedAt time.Time
+	Id        int
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	// Uid is the user's unix uid, nil until it's allocated
+	Uid *int
+	// Type is one of UserTypes. Only guests have a SponsorId and ExpiresAt,
+	// SponsorId is nil once the sponsor is deleted.
+	Type      string
+	SponsorId *int
+	ExpiresAt *time.Time
+	// DeactivatedAt is set when a guest expires or their sponsor leaves.
+	// Deactivated users are left out of the exports and can't use the api.
+	DeactivatedAt *time.Time
+	CreatedAt     time.Time
+	ModifiedAt    time.Time
+}
+
+// Active reports whether the user hasn't been deactivated
+func (u *User) Active() bool {
+	return u.DeactivatedAt == nil
 }
 
 type UserRequest struct {
@@ -24,78 +42,266 @@ type UserRequest struct {
 	Email     string
 	FirstName string
 	LastName  string
+	Uid       *int
+	Type      string
+	SponsorId *int
+	ExpiresAt *time.Time
+}
+
+// User types
+const (
+	UserInstitutional = "institutional"
+	UserGuest         = "guest"
+	UserService       = "service"
+)
+
+// UserTypes are the types a user can have
+var UserTypes = []string{UserInstitutional, UserGuest, UserService}
+
+// AuditGuestDeactivated is logged when the sweeper deactivates a guest
+const AuditGuestDeactivated = "guest_deactivated"
+
+const userColumns = "id, username, email, firstname, lastname, uid, type, sponsor_id, expires_at, deactivated_at, created_at, modified_at"
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var user User
+	var uid, sponsorId sql.NullInt64
+	var expiresAt, deactivatedAt sql.NullTime
+	err := row.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &uid, &user.Type, &sponsorId, &expiresAt, &deactivatedAt, &user.CreatedAt, &user.ModifiedAt)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	user.Uid, user.SponsorId = nullIntPtr(uid), nullIntPtr(sponsorId)
+	user.ExpiresAt, user.DeactivatedAt = nullTimePtr(expiresAt), nullTimePtr(deactivatedAt)
+	return &user, nil
+}
+
+// validateUserRequest is shared by every store implementation. userId is
+// 0 for a new user. An empty type is taken as institutional.
+func validateUserRequest(userId int, user *UserRequest) error {
+	if user.Uid != nil && *user.Uid <= 0 {
+		return fmt.Errorf("uid must be positive")
+	}
+	if user.Type == "" {
+		user.Type = UserInstitutional
+	}
+	if !slices.Contains(UserTypes, user.Type) {
+		return fmt.Errorf("invalid user type %q, must be one of %v", user.Type, UserTypes)
+	}
+	if user.Type != UserGuest {
+		if user.SponsorId != nil || user.ExpiresAt != nil {
+			return fmt.Errorf("only guests have a sponsor and an expiry")
+		}
+		return nil
+	}
+	if user.SponsorId == nil || user.ExpiresAt == nil {
+		return fmt.Errorf("guests need a sponsor_id and an expires_at")
+	}
+	if *user.SponsorId == userId {
+		return fmt.Errorf("guests can't sponsor themselves")
+	}
+	return nil
+}
+
+// validateSponsor is shared by every store implementation. sponsor is nil
+// if the user doesn't exist.
+func validateSponsor(sponsorId int, sponsor *User, ownsPirg bool) error {
+	switch {
+	case sponsor == nil:
+		return fmt.Errorf("sponsor does not exist with id: %d", sponsorId)
+	case !sponsor.Active():
+		return fmt.Errorf("sponsor %s is deactivated", sponsor.Username)
+	case !ownsPirg:
+		return fmt.Errorf("sponsor %s doesn't own a pirg", sponsor.Username)
+	}
+	return nil
+}
+
+// reactivates reports whether saving the user clears their deactivation,
+// which is how an expired guest is renewed
+func (user *UserRequest) reactivates(now time.Time) bool {
+	return user.ExpiresAt == nil || user.ExpiresAt.After(now)
+}
+
+// guestDeactivation is shared by every store implementation. It returns
+// why an active guest has to be deactivated, or "" if they don't. sponsor
+// is nil if they have none.
+func guestDeactivation(guest *User, sponsor *User, sponsorOwnsPirg bool, now time.Time) string {
+	switch {
+	case guest.ExpiresAt != nil && !guest.ExpiresAt.After(now):
+		return "account expired " + guest.ExpiresAt.Format(time.RFC3339)
+	case sponsor == nil:
+		return "sponsor was deleted"
+	case !sponsor.Active():
+		return fmt.Sprintf("sponsor %s was deactivated", sponsor.Username)
+	case !sponsorOwnsPirg:
+		return fmt.Sprintf("sponsor %s no longer owns a pirg", sponsor.Username)
+	}
+	return ""
+}
+
+func deactivatedDetail(guest *User, reason string) string {
+	return fmt.Sprintf("deactivated guest %s, %s", guest.Username, reason)
+}
+
+// sponsor looks up a user and whether they own a pirg. The user is nil
+// if they don't exist.
+func (s *PostgresStore) sponsor(ctx context.Context, id int) (*User, bool, error) {
+	user, err := s.GetUserById(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var owns bool
+	err = s.q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pirgs WHERE owner_id = $1)", id).Scan(&owns)
+	return user, owns, err
+}
+
+func (s *PostgresStore) validateUser(ctx context.Context, userId int, user *UserRequest) error {
+	if err := validateUserRequest(userId, user); err != nil {
+		return err
+	}
+	if user.SponsorId == nil {
+		return nil
+	}
+	sponsor, owns, err := s.sponsor(ctx, *user.SponsorId)
+	if err != nil {
+		return err
+	}
+	return validateSponsor(*user.SponsorId, sponsor, owns)
 }
 
-func GetAllUsers(db *sql.DB) ([]*User, error) {
-	slog.Debug("getting all users from database", "package", "data", "method", "GetAllUsers")
+func (s *PostgresStore) queryUsers(ctx context.Context, query string, args ...any) ([]*User, error) {
 	var users []*User
-	rows, err := db.Query("SELECT id, username, email, firstname, lastname, created_at, modified_at FROM users")
+	rows, err := s.q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
-	return users, nil
+	return users, rows.Err()
 }
 
-func GetUserById(db *sql.DB, id int) (*User, error) {
-	slog.Debug("querying database for user by id", "package", "data", "method", "GetUserById")
-	var user User
-	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at FROM users WHERE id = $1", id).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt)
-	return &user, err
+func (s *PostgresStore) GetAllUsers(ctx context.Context) ([]*User, error) {
+	ctx, span := startSpan(ctx, "GetAllUsers")
+	defer span.End()
+	slog.DebugContext(ctx, "getting all users from database", "package", "data", "method", "GetAllUsers")
+	return s.queryUsers(ctx, "SELECT "+userColumns+" FROM users ORDER BY id")
 }
 
-func GetUserByUsername(db *sql.DB, username string) (*User, error) {
-	slog.Debug("querying database for user by username", "package", "data", "method", "GetUserByUsername")
-	var user User
-	err := db.QueryRow("SELECT id, username, email, firstname, lastname, created_at, modified_at FROM users WHERE username = $1", username).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.CreatedAt, &user.ModifiedAt)
-	return &user, err
+// CountUsers returns the number of users
+func (s *PostgresStore) CountUsers(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "CountUsers")
+	defer span.End()
+	slog.DebugContext(ctx, "counting users in database", "package", "data", "method", "CountUsers")
+	var count int
+	err := s.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) GetUserById(ctx context.Context, id int) (*User, error) {
+	ctx, span := startSpan(ctx, "GetUserById")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for user by id", "package", "data", "method", "GetUserById")
+	return scanUser(s.q.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
 }
 
-func CreateUser(db *sql.DB, user *UserRequest) (*User, error) {
-	slog.Debug("creating new user in database", "package", "data", "method", "CreateUser")
-	var newUser User
-	_, err := GetUserByUsername(db, user.Username)
+func (s *PostgresStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, span := startSpan(ctx, "GetUserByUsername")
+	defer span.End()
+	slog.DebugContext(ctx, "querying database for user by username", "package", "data", "method", "GetUserByUsername")
+	return scanUser(s.q.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username))
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, user *UserRequest) (*User, error) {
+	ctx, span := startSpan(ctx, "CreateUser")
+	defer span.End()
+	slog.DebugContext(ctx, "creating new user in database", "package", "data", "method", "CreateUser")
+	if err := s.validateUser(ctx, 0, user); err != nil {
+		return nil, err
+	}
+	_, err := s.GetUserByUsername(ctx, user.Username)
 	if err == nil {
-		return nil, fmt.Errorf("user with username %s already exists", user.Username)
+		return nil, fmt.Errorf("%w: user with username %s already exists", ErrConflict, user.Username)
 	}
-	err = db.QueryRow("INSERT INTO users (username, email, firstname, lastname) VALUES ($1, $2, $3, $4) RETURNING id, username, email, firstname, lastname, created_at, modified_at", user.Username, user.Email, user.FirstName, user.LastName).Scan(&newUser.Id, &newUser.Username, &newUser.Email, &newUser.FirstName, &newUser.LastName, &newUser.CreatedAt, &newUser.ModifiedAt)
-	return &newUser, err
+	row := s.q.QueryRowContext(ctx, "INSERT INTO users (username, email, firstname, lastname, uid, type, sponsor_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING "+userColumns,
+		user.Username, user.Email, user.FirstName, user.LastName, user.Uid, user.Type, user.SponsorId, utcTime(user.ExpiresAt))
+	return scanUser(row)
 }
 
-func UpdateUser(db *sql.DB, userId int, user *UserRequest) error {
-	slog.Debug("updating user in database", "package", "data", "method", "UpdateUser")
-	res, err := db.Exec("UPDATE users SET username = $1, email = $2, firstname = $3, lastname = $4 WHERE id = $5 RETURNING id, username, email, firstname, lastname, created_at, modified_at", user.Username, user.Email, user.FirstName, user.LastName, userId)
-	if err != nil {
+func (s *PostgresStore) UpdateUser(ctx context.Context, userId int, user *UserRequest) error {
+	ctx, span := startSpan(ctx, "UpdateUser")
+	defer span.End()
+	slog.DebugContext(ctx, "updating user in database", "package", "data", "method", "UpdateUser")
+	if err := s.validateUser(ctx, userId, user); err != nil {
 		return err
 	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if count != 1 {
-		return fmt.Errorf("expected to update 1 row, updated %d rows", count)
-	}
+	res, err := s.q.ExecContext(ctx, `UPDATE users SET username = $1, email = $2, firstname = $3, lastname = $4, uid = $5, type = $6, sponsor_id = $7, expires_at = $8,
+		deactivated_at = CASE WHEN $9 THEN NULL ELSE deactivated_at END WHERE id = $10`,
+		user.Username, user.Email, user.FirstName, user.LastName, user.Uid, user.Type, user.SponsorId, utcTime(user.ExpiresAt), user.reactivates(time.Now()), userId)
+	return checkAffectedRows(res, err)
+}
 
-	return err
+// DeleteUser removes a user along with their memberships and api keys.
+// Users that still own a pirg can't be deleted. Guests they sponsored are
+// left without a sponsor.
+func (s *PostgresStore) DeleteUser(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteUser")
+	defer span.End()
+	slog.DebugContext(ctx, "deleting user from database", "package", "data", "method", "DeleteUser")
+	res, err := s.q.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	return checkAffectedRows(res, err)
 }
 
-func DeleteUser(db *sql.DB, id int) error {
-	slog.Debug("deleting user from database", "package", "data", "method", "DeleteUser")
-	res, err := db.Exec("DELETE FROM users WHERE id = $1", id)
+// DeactivateGuests deactivates the guests whose account expired by now or
+// whose sponsor left, and writes an audit entry for each, in a single
+// transaction. It returns the guests that were deactivated.
+func (s *PostgresStore) DeactivateGuests(ctx context.Context, now time.Time) ([]*User, error) {
+	ctx, span := startSpan(ctx, "DeactivateGuests")
+	defer span.End()
+	slog.DebugContext(ctx, "deactivating guests in database", "now", now, "package", "data", "method", "DeactivateGuests")
+	var deactivated []*User
+	err := s.withTx(ctx, func(tx *PostgresStore) error {
+		guests, err := tx.queryUsers(ctx, "SELECT "+userColumns+" FROM users WHERE type = $1 AND deactivated_at IS NULL ORDER BY id", UserGuest)
+		if err != nil {
+			return err
+		}
+		for _, guest := range guests {
+			var sponsor *User
+			var owns bool
+			if guest.SponsorId != nil {
+				if sponsor, owns, err = tx.sponsor(ctx, *guest.SponsorId); err != nil {
+					return err
+				}
+			}
+			reason := guestDeactivation(guest, sponsor, owns, now)
+			if reason == "" {
+				continue
+			}
+			guest.DeactivatedAt = utcTime(&now)
+			res, err := tx.q.ExecContext(ctx, "UPDATE users SET deactivated_at = $1 WHERE id = $2", guest.DeactivatedAt, guest.Id)
+			if err = checkAffectedRows(res, err); err != nil {
+				return err
+			}
+			_, err = tx.q.ExecContext(ctx, "INSERT INTO audit_log (action, actor, user_id, detail) VALUES ($1, $2, $3, $4)",
+				AuditGuestDeactivated, AuditActorSweeper, guest.Id, deactivatedDetail(guest, reason))
+			if err != nil {
+				return err
+			}
+			deactivated = append(deactivated, guest)
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil || count != 1 {
-		return err
+		return nil, err
 	}
-	return nil
+	return deactivated, nil
 }
@@ -0,0 +1,412 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// The ldap settings used when the configuration leaves them unset
+const (
+	DefaultPeopleOU          = "ou=people"
+	DefaultGroupsOU          = "ou=groups"
+	DefaultSnapshotRetention = 30 * 24 * time.Hour
+)
+
+// ErrLDIFDisabled is returned when no base dn is configured
+var ErrLDIFDisabled = errors.New("the ldif export needs an ldap base_dn")
+
+// ErrSnapshotExpired is returned when the changes since a time are asked
+// for, but the snapshot at that time has already been pruned
+var ErrSnapshotExpired = errors.New("no snapshot is kept from that far back, a full export is needed")
+
+// ldifLineLength is where long lines are folded
+const ldifLineLength = 76
+
+// LDIFOptions are the settings of the LDIF export
+type LDIFOptions struct {
+	// Unix gives the ids, shell and home directory of each entry
+	Unix     *UnixOptions
+	PeopleDN string
+	GroupsDN string
+	// Attributes maps the default name of an attribute to the one written
+	Attributes        map[string]string
+	SnapshotRetention time.Duration
+}
+
+// NewLDIFOptions fills in the defaults for whatever the configuration leaves
+// unset. It returns ErrLDIFDisabled if there's no base dn.
+func NewLDIFOptions(cfg config.LDAPConfig, unix *UnixOptions) (*LDIFOptions, error) {
+	if !cfg.Enabled() {
+		return nil, ErrLDIFDisabled
+	}
+	opts := &LDIFOptions{
+		Unix:              unix,
+		PeopleDN:          cfg.PeopleOU,
+		GroupsDN:          cfg.GroupsOU,
+		Attributes:        cfg.Attributes,
+		SnapshotRetention: cfg.SnapshotRetention,
+	}
+	if opts.PeopleDN == "" {
+		opts.PeopleDN = DefaultPeopleOU
+	}
+	if opts.GroupsDN == "" {
+		opts.GroupsDN = DefaultGroupsOU
+	}
+	opts.PeopleDN += "," + cfg.BaseDN
+	opts.GroupsDN += "," + cfg.BaseDN
+	if opts.SnapshotRetention == 0 {
+		opts.SnapshotRetention = DefaultSnapshotRetention
+	}
+	return opts, nil
+}
+
+// attr returns the name written for an attribute
+func (o *LDIFOptions) attr(name string) string {
+	if renamed, ok := o.Attributes[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// LDIFAttribute is an attribute of an entry with its values in order
+type LDIFAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// LDIFEntry is an entry of the directory
+type LDIFEntry struct {
+	DN         string          `json:"dn"`
+	Attributes []LDIFAttribute `json:"attributes"`
+}
+
+// entryBuilder collects the attributes of an entry, leaving out empty values
+type entryBuilder struct {
+	opts  *LDIFOptions
+	entry LDIFEntry
+}
+
+func (b *entryBuilder) add(name string, values ...string) {
+	values = slices.DeleteFunc(values, func(v string) bool { return v == "" })
+	if len(values) > 0 {
+		b.entry.Attributes = append(b.entry.Attributes, LDIFAttribute{Name: b.opts.attr(name), Values: values})
+	}
+}
+
+// LDIFEntries returns the organizational units, a posixAccount for every
+// user ordered by uid, then a posixGroup for every pirg and pirg group
+// ordered by name. The accounts and groups are the ones of the unix
+// exports, so it returns *UnixProblems for the same collisions.
+func LDIFEntries(ctx context.Context, store data.Store, opts *LDIFOptions) ([]LDIFEntry, error) {
+	users, err := allocatedUsers(ctx, store, opts.Unix)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := unixPasswd(users, opts.Unix)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := unixGroups(ctx, store, users, opts.Unix)
+	if err != nil {
+		return nil, err
+	}
+	byUsername := make(map[string]*data.User, len(users))
+	for _, u := range users {
+		byUsername[u.Username] = u
+	}
+
+	var entries []LDIFEntry
+	for _, dn := range []string{opts.PeopleDN, opts.GroupsDN} {
+		name, value, _ := strings.Cut(strings.SplitN(dn, ",", 2)[0], "=")
+		entries = append(entries, LDIFEntry{DN: dn, Attributes: []LDIFAttribute{
+			{Name: "objectClass", Values: []string{"top", "organizationalUnit"}},
+			{Name: name, Values: []string{value}},
+		}})
+	}
+	userDN := func(username string) string {
+		return opts.attr("uid") + "=" + escapeDNValue(username) + "," + opts.PeopleDN
+	}
+	for _, a := range accounts {
+		u := byUsername[a.Username]
+		fullName := strings.TrimSpace(u.FirstName + " " + u.LastName)
+		b := &entryBuilder{opts: opts, entry: LDIFEntry{DN: userDN(a.Username)}}
+		b.entry.Attributes = append(b.entry.Attributes, LDIFAttribute{Name: "objectClass", Values: []string{"top", "person", "organizationalPerson", "inetOrgPerson", "posixAccount"}})
+		b.add("uid", a.Username)
+		// cn and sn are required, so they fall back to the username
+		b.add("cn", firstNonEmpty(fullName, a.Username))
+		b.add("sn", firstNonEmpty(u.LastName, a.Username))
+		b.add("givenName", u.FirstName)
+		b.add("displayName", fullName)
+		b.add("mail", u.Email)
+		b.add("uidNumber", strconv.Itoa(a.Uid))
+		b.add("gidNumber", strconv.Itoa(a.Gid))
+		b.add("homeDirectory", a.Home)
+		b.add("loginShell", a.Shell)
+		entries = append(entries, b.entry)
+	}
+	for _, g := range groups {
+		b := &entryBuilder{opts: opts, entry: LDIFEntry{DN: opts.attr("cn") + "=" + escapeDNValue(g.Name) + "," + opts.GroupsDN}}
+		// groupOfNames needs a member, so empty groups are only a posixGroup
+		objectClasses := []string{"top", "posixGroup"}
+		if len(g.Members) > 0 {
+			objectClasses = []string{"top", "groupOfNames", "posixGroup"}
+		}
+		b.entry.Attributes = append(b.entry.Attributes, LDIFAttribute{Name: "objectClass", Values: objectClasses})
+		b.add("cn", g.Name)
+		b.add("gidNumber", strconv.Itoa(g.Gid))
+		b.add("memberUid", g.Members...)
+		var members []string
+		for _, username := range g.Members {
+			members = append(members, userDN(username))
+		}
+		b.add("member", members...)
+		entries = append(entries, b.entry)
+	}
+	return entries, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// escapeDNValue escapes the characters that are special in a dn (RFC 4514)
+func escapeDNValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`"+,;<>\=`, r),
+			i == 0 && (r == '#' || r == ' '),
+			i == len(value)-1 && r == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// safeValue reports whether a value can be written as is rather than in
+// base64 (RFC 2849)
+func safeValue(value string) bool {
+	if value == "" {
+		return true
+	}
+	if strings.ContainsAny(value[:1], " :<") || strings.HasSuffix(value, " ") {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// ldifWriter writes folded LDIF lines, keeping the first error
+type ldifWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (l *ldifWriter) line(s string) {
+	if l.err != nil {
+		return
+	}
+	width := ldifLineLength
+	for len(s) > width {
+		if _, l.err = l.w.WriteString(s[:width] + "\n "); l.err != nil {
+			return
+		}
+		s = s[width:]
+		// continuation lines start with a space, so they hold one less
+		width = ldifLineLength - 1
+	}
+	_, l.err = l.w.WriteString(s + "\n")
+}
+
+func (l *ldifWriter) value(name string, value string) {
+	if safeValue(value) {
+		l.line(name + ": " + value)
+	} else {
+		l.line(name + ":: " + base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+}
+
+func (l *ldifWriter) attributes(attrs []LDIFAttribute) {
+	for _, a := range attrs {
+		for _, v := range a.Values {
+			l.value(a.Name, v)
+		}
+	}
+}
+
+func (l *ldifWriter) header(at time.Time) {
+	l.line("version: 1")
+	// the time is passed back as since to get the changes after this export
+	l.line("# hpcadmin-server snapshot " + at.UTC().Format(time.RFC3339Nano))
+	l.line("")
+}
+
+// WriteLDIF writes the entries as a full LDIF dump. at is the time of the
+// snapshot they were saved in, to ask for the changes since.
+func WriteLDIF(w io.Writer, entries []LDIFEntry, at time.Time) error {
+	l := &ldifWriter{w: bufio.NewWriter(w)}
+	l.header(at)
+	for _, e := range entries {
+		l.value("dn", e.DN)
+		l.attributes(e.Attributes)
+		l.line("")
+	}
+	if l.err != nil {
+		return l.err
+	}
+	return l.w.Flush()
+}
+
+// WriteLDIFChanges writes the change records that turn the from entries
+// into the to entries. Changed entries get a modify record replacing the
+// attributes that changed, new entries an add and removed entries a
+// delete. An entry whose dn changed is deleted and added again.
+func WriteLDIFChanges(w io.Writer, from []LDIFEntry, to []LDIFEntry, at time.Time) error {
+	l := &ldifWriter{w: bufio.NewWriter(w)}
+	l.header(at)
+	previous := make(map[string]LDIFEntry, len(from))
+	for _, e := range from {
+		previous[e.DN] = e
+	}
+	current := make(map[string]bool, len(to))
+	for _, e := range to {
+		current[e.DN] = true
+		old, ok := previous[e.DN]
+		if !ok {
+			l.value("dn", e.DN)
+			l.line("changetype: add")
+			l.attributes(e.Attributes)
+			l.line("")
+			continue
+		}
+		mods := modifications(old.Attributes, e.Attributes)
+		if len(mods) == 0 {
+			continue
+		}
+		l.value("dn", e.DN)
+		l.line("changetype: modify")
+		for _, mod := range mods {
+			l.line(mod.op + ": " + mod.attr.Name)
+			for _, v := range mod.attr.Values {
+				l.value(mod.attr.Name, v)
+			}
+			l.line("-")
+		}
+		l.line("")
+	}
+	// groups come after the users in them, so they're deleted first
+	for i := len(from) - 1; i >= 0; i-- {
+		if !current[from[i].DN] {
+			l.value("dn", from[i].DN)
+			l.line("changetype: delete")
+			l.line("")
+		}
+	}
+	if l.err != nil {
+		return l.err
+	}
+	return l.w.Flush()
+}
+
+type modification struct {
+	op   string
+	attr LDIFAttribute
+}
+
+// modifications lists a replace for every attribute whose values changed,
+// and a delete for every attribute that's gone
+func modifications(from []LDIFAttribute, to []LDIFAttribute) []modification {
+	var mods []modification
+	for _, a := range to {
+		i := slices.IndexFunc(from, func(b LDIFAttribute) bool { return b.Name == a.Name })
+		if i < 0 || !slices.Equal(from[i].Values, a.Values) {
+			mods = append(mods, modification{op: "replace", attr: a})
+		}
+	}
+	for _, a := range from {
+		if !slices.ContainsFunc(to, func(b LDIFAttribute) bool { return b.Name == a.Name }) {
+			mods = append(mods, modification{op: "delete", attr: LDIFAttribute{Name: a.Name}})
+		}
+	}
+	return mods
+}
+
+// snapshot saves the entries so that later exports can list the changes
+// since, then prunes the snapshots that are past the retention
+func snapshot(ctx context.Context, store data.Store, opts *LDIFOptions, entries []LDIFEntry) (*data.DirectorySnapshot, error) {
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.SaveDirectorySnapshot(ctx, fmt.Sprintf("%x", sha256.Sum256(encoded)), encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save directory snapshot: %w", err)
+	}
+	if _, err := store.PruneDirectorySnapshots(ctx, time.Now().Add(-opts.SnapshotRetention)); err != nil {
+		return nil, fmt.Errorf("failed to prune directory snapshots: %w", err)
+	}
+	return s, nil
+}
+
+// LDIF writes every user, pirg and pirg group as a full LDIF dump
+func LDIF(ctx context.Context, store data.Store, opts *LDIFOptions, w io.Writer) error {
+	entries, err := LDIFEntries(ctx, store, opts)
+	if err != nil {
+		return err
+	}
+	s, err := snapshot(ctx, store, opts, entries)
+	if err != nil {
+		return err
+	}
+	return WriteLDIF(w, entries, s.CreatedAt)
+}
+
+// LDIFChanges writes the change records since an earlier export, given the
+// time in its header. It returns ErrSnapshotExpired if that export is past
+// the snapshot retention.
+func LDIFChanges(ctx context.Context, store data.Store, opts *LDIFOptions, since time.Time, w io.Writer) error {
+	entries, err := LDIFEntries(ctx, store, opts)
+	if err != nil {
+		return err
+	}
+	// the earlier snapshot is loaded before saving can prune it
+	previous, err := store.GetDirectorySnapshot(ctx, since)
+	if errors.Is(err, data.ErrNotFound) {
+		return ErrSnapshotExpired
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get directory snapshot: %w", err)
+	}
+	var from []LDIFEntry
+	if err := json.Unmarshal(previous.Entries, &from); err != nil {
+		return fmt.Errorf("failed to decode directory snapshot %d: %w", previous.Id, err)
+	}
+	s, err := snapshot(ctx, store, opts, entries)
+	if err != nil {
+		return err
+	}
+	return WriteLDIFChanges(w, from, entries, s.CreatedAt)
+}
@@ -0,0 +1,254 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// snapshotLine is the header line with the time of the export's snapshot
+func snapshotLine(t *testing.T, ldif string) (string, time.Time) {
+	t.Helper()
+	lines := strings.SplitN(ldif, "\n", 3)
+	at, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(lines[1], "# hpcadmin-server snapshot "))
+	if err != nil {
+		t.Fatalf("expected a snapshot time in %q: %v", lines[1], err)
+	}
+	return lines[1], at
+}
+
+func TestLDIF(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	user := func(username string, first string, last string) *data.User {
+		u, err := store.CreateUser(ctx, &data.UserRequest{Username: username, Email: username + "@example.org", FirstName: first, LastName: last})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	marka, student := user("marka", "Mark", "Allen"), user("student", "Zoë", "")
+	racs, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "racs", OwnerId: marka.Id, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id, student.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreatePirgGroup(ctx, racs.Id, &data.PirgGroupRequest{Name: "racs-admins"}); err != nil {
+		t.Fatal(err)
+	}
+
+	unix, err := NewUnixOptions(config.UnixConfig{UIDMin: 5000, UIDMax: 5999, GIDMin: 7000, GIDMax: 7999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLDIFOptions(config.LDAPConfig{}, unix); !errors.Is(err, ErrLDIFDisabled) {
+		t.Fatalf("expected the export to be off without a base dn, got %v", err)
+	}
+	opts, err := NewLDIFOptions(config.LDAPConfig{BaseDN: "dc=example,dc=edu", Attributes: map[string]string{"mail": "campusMail"}}, unix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := LDIF(ctx, store, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	header, first := snapshotLine(t, buf.String())
+	want := `version: 1
+` + header + `
+
+dn: ou=people,dc=example,dc=edu
+objectClass: top
+objectClass: organizationalUnit
+ou: people
+
+dn: ou=groups,dc=example,dc=edu
+objectClass: top
+objectClass: organizationalUnit
+ou: groups
+
+dn: uid=marka,ou=people,dc=example,dc=edu
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+objectClass: posixAccount
+uid: marka
+cn: Mark Allen
+sn: Allen
+givenName: Mark
+displayName: Mark Allen
+campusMail: marka@example.org
+uidNumber: 5000
+gidNumber: 100
+homeDirectory: /home/marka
+loginShell: /bin/bash
+
+dn: uid=student,ou=people,dc=example,dc=edu
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+objectClass: posixAccount
+uid: student
+cn:: Wm/Dqw==
+sn: student
+givenName:: Wm/Dqw==
+displayName:: Wm/Dqw==
+campusMail: student@example.org
+uidNumber: 5001
+gidNumber: 100
+homeDirectory: /home/student
+loginShell: /bin/bash
+
+dn: cn=racs,ou=groups,dc=example,dc=edu
+objectClass: top
+objectClass: groupOfNames
+objectClass: posixGroup
+cn: racs
+gidNumber: 7000
+memberUid: marka
+memberUid: student
+member: uid=marka,ou=people,dc=example,dc=edu
+member: uid=student,ou=people,dc=example,dc=edu
+
+dn: cn=racs-admins,ou=groups,dc=example,dc=edu
+objectClass: top
+objectClass: posixGroup
+cn: racs-admins
+gidNumber: 7001
+
+`
+	if buf.String() != want {
+		t.Errorf("unexpected ldif:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := LDIFChanges(ctx, store, opts, first, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if header, at := snapshotLine(t, buf.String()); !at.Equal(first) || buf.String() != "version: 1\n"+header+"\n\n" {
+			t.Errorf("expected no changes in the same snapshot, got:\n%s", buf.String())
+		}
+	})
+
+	// the student leaves, marka joins racs-admins and a new user arrives
+	pr := &data.PirgRequest{Name: "racs", OwnerId: marka.Id, Gid: racs.Gid, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id}}
+	if racs, err = store.GetPirgById(ctx, racs.Id); err != nil {
+		t.Fatal(err)
+	}
+	pr.Gid = racs.Gid
+	if _, err := store.UpdatePirg(ctx, racs.Id, pr); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := store.GetPirgGroups(ctx, racs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdatePirgGroup(ctx, groups[0].Id, &data.PirgGroupRequest{Name: "racs-admins", Gid: groups[0].Gid, UserIds: []int{marka.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteUser(ctx, student.Id); err != nil {
+		t.Fatal(err)
+	}
+	user("newcomer", "New", "Comer")
+
+	buf.Reset()
+	if err := LDIFChanges(ctx, store, opts, first, &buf); err != nil {
+		t.Fatal(err)
+	}
+	header, second := snapshotLine(t, buf.String())
+	if !second.After(first) {
+		t.Errorf("expected a new snapshot after %v, got %v", first, second)
+	}
+	want = `version: 1
+` + header + `
+
+dn: uid=newcomer,ou=people,dc=example,dc=edu
+changetype: add
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+objectClass: posixAccount
+uid: newcomer
+cn: New Comer
+sn: Comer
+givenName: New
+displayName: New Comer
+campusMail: newcomer@example.org
+uidNumber: 5002
+gidNumber: 100
+homeDirectory: /home/newcomer
+loginShell: /bin/bash
+
+dn: cn=racs,ou=groups,dc=example,dc=edu
+changetype: modify
+replace: memberUid
+memberUid: marka
+-
+replace: member
+member: uid=marka,ou=people,dc=example,dc=edu
+-
+
+dn: cn=racs-admins,ou=groups,dc=example,dc=edu
+changetype: modify
+replace: objectClass
+objectClass: top
+objectClass: groupOfNames
+objectClass: posixGroup
+-
+replace: memberUid
+memberUid: marka
+-
+replace: member
+member: uid=marka,ou=people,dc=example,dc=edu
+-
+
+dn: uid=student,ou=people,dc=example,dc=edu
+changetype: delete
+
+`
+	if buf.String() != want {
+		t.Errorf("unexpected changes:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := LDIFChanges(ctx, store, opts, first.AddDate(0, 0, -1), &buf)
+		if !errors.Is(err, ErrSnapshotExpired) {
+			t.Fatalf("expected changes from before the first snapshot to be refused, got %v", err)
+		}
+	})
+}
+
+func TestLDIFFolding(t *testing.T) {
+	var buf bytes.Buffer
+	long := strings.Repeat("a", 200)
+	entries := []LDIFEntry{{DN: "cn=long,dc=example,dc=edu", Attributes: []LDIFAttribute{{Name: "description", Values: []string{long, " leading space", "trailing space "}}}}}
+	if err := WriteLDIF(&buf, entries, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	var unfolded string
+	for _, line := range lines[4:7] {
+		if len(line) > ldifLineLength {
+			t.Errorf("line longer than %d: %q", ldifLineLength, line)
+		}
+		unfolded += strings.TrimPrefix(line, " ")
+	}
+	if unfolded != "description: "+long {
+		t.Errorf("expected the folded lines to join back up, got %q", unfolded)
+	}
+	if lines[7] != "description:: IGxlYWRpbmcgc3BhY2U=" || lines[8] != "description:: dHJhaWxpbmcgc3BhY2Ug" {
+		t.Errorf("expected values with leading or trailing spaces in base64, got %q and %q", lines[7], lines[8])
+	}
+	if got := escapeDNValue(" Smith, J+r#"); got != `\ Smith\, J\+r#` {
+		t.Errorf("unexpected escaped dn value %q", got)
+	}
+}
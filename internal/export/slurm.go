@@ -0,0 +1,297 @@
+// Package export renders hpcadmin data in the formats other systems load
+package export
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// SlurmAccount is a pirg enabled on the cluster, the QOS granted to it,
+// the limits on it and its members that can use the cluster
+type SlurmAccount struct {
+	Name       string
+	QOS        []string
+	DefaultQOS string
+	data.SlurmLimits
+	Users []SlurmUser
+}
+
+// SlurmUser is a member of an account. QOS is only set when the member has
+// been granted their own, otherwise they get the account's. A user with
+// partitions gets one association per partition.
+type SlurmUser struct {
+	Username   string
+	QOS        []string
+	DefaultQOS string
+	Partitions []string
+	data.SlurmLimits
+}
+
+// Slurm writes the pirgs enabled on the cluster, along with their enabled
+// users and the QOS and partitions granted to them, as a sacctmgr dump file
+// ready for `sacctmgr load file=<path>`. Users outside the term of their
+// membership, and deactivated users, are left out.
+func Slurm(ctx context.Context, store data.Store, clusterName string, w io.Writer) error {
+	cluster, err := store.GetClusterByName(ctx, clusterName)
+	if errors.Is(err, data.ErrNotFound) {
+		return fmt.Errorf("cluster %s not found", clusterName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get cluster: %w", err)
+	}
+	cps, err := store.GetClusterPirgs(ctx, cluster.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get pirgs for cluster: %w", err)
+	}
+	users, err := store.GetAllUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get users: %w", err)
+	}
+	usernames := make(map[int]string, len(users))
+	deactivated := make(map[int]bool)
+	for _, u := range users {
+		usernames[u.Id] = u.Username
+		deactivated[u.Id] = !u.Active()
+	}
+	qos, err := store.GetAllQOS(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get qos: %w", err)
+	}
+	qosNames := make(map[int]string, len(qos))
+	for _, q := range qos {
+		qosNames[q.Id] = q.Name
+	}
+	partitions, err := store.GetAllPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get partitions: %w", err)
+	}
+	// partitions on other clusters are left out
+	partitionNames := make(map[int]string)
+	for _, p := range partitions {
+		if p.ClusterId == cluster.Id {
+			partitionNames[p.Id] = p.Name
+		}
+	}
+
+	now := time.Now()
+	var accounts []SlurmAccount
+	for _, cp := range cps {
+		pirg, err := store.GetPirgById(ctx, cp.PirgId)
+		if err != nil {
+			return fmt.Errorf("failed to get pirg %d: %w", cp.PirgId, err)
+		}
+		ps, err := store.GetPirgSlurm(ctx, cp.PirgId)
+		if err != nil {
+			return fmt.Errorf("failed to get slurm grants for pirg %s: %w", pirg.Name, err)
+		}
+		pl, err := store.GetPirgLimits(ctx, cp.PirgId)
+		if err != nil {
+			return fmt.Errorf("failed to get slurm limits for pirg %s: %w", pirg.Name, err)
+		}
+		account := SlurmAccount{Name: pirg.Name, SlurmLimits: pl.SlurmLimits}
+		account.QOS, account.DefaultQOS = grantedQOS(ps.SlurmGrants, qosNames)
+		members := make(map[int]data.SlurmGrants, len(ps.Members))
+		for _, member := range ps.Members {
+			members[member.UserId] = member.SlurmGrants
+		}
+		memberLimits := make(map[int]data.SlurmLimits, len(pl.Members))
+		for _, member := range pl.Members {
+			memberLimits[member.UserId] = member.SlurmLimits
+		}
+		memberships, err := store.GetPirgMemberships(ctx, cp.PirgId)
+		if err != nil {
+			return fmt.Errorf("failed to get memberships for pirg %s: %w", pirg.Name, err)
+		}
+		// members whose term hasn't started, or has expired but not been swept yet, are left out
+		inactive := make(map[int]bool)
+		for _, m := range memberships {
+			if !m.Active(now) {
+				inactive[m.UserId] = true
+			}
+		}
+		pirgPartitions := grantedPartitions(ps.SlurmGrants, partitionNames)
+		for _, id := range cp.UserIds {
+			if inactive[id] || deactivated[id] {
+				continue
+			}
+			username, ok := usernames[id]
+			if !ok {
+				return fmt.Errorf("pirg %s references unknown user id %d", pirg.Name, id)
+			}
+			user := SlurmUser{Username: username, Partitions: pirgPartitions, SlurmLimits: memberLimits[id]}
+			if g, ok := members[id]; ok {
+				user.QOS, user.DefaultQOS = grantedQOS(g, qosNames)
+				// the member's own partitions replace the pirg's if any are on this cluster
+				if p := grantedPartitions(g, partitionNames); len(p) > 0 {
+					user.Partitions = p
+				}
+			}
+			account.Users = append(account.Users, user)
+		}
+		accounts = append(accounts, account)
+	}
+	return WriteSlurm(w, cluster.Name, accounts)
+}
+
+// grantedQOS returns the sorted names of the granted QOS and the name of the default
+func grantedQOS(g data.SlurmGrants, names map[int]string) ([]string, string) {
+	var qos []string
+	for _, id := range g.QOSIds {
+		qos = append(qos, names[id])
+	}
+	sort.Strings(qos)
+	defaultQOS := ""
+	if g.DefaultQOSId != nil {
+		defaultQOS = names[*g.DefaultQOSId]
+	}
+	return qos, defaultQOS
+}
+
+// grantedPartitions returns the sorted names of the granted partitions found in names
+func grantedPartitions(g data.SlurmGrants, names map[int]string) []string {
+	var partitions []string
+	for _, id := range g.PartitionIds {
+		if name, ok := names[id]; ok {
+			partitions = append(partitions, name)
+		}
+	}
+	sort.Strings(partitions)
+	return partitions
+}
+
+// WriteSlurm writes the associations in the sacctmgr flat file format.
+// Each account goes under root with its users below it. A user's default
+// account is the first of their accounts by name.
+func WriteSlurm(w io.Writer, cluster string, accounts []SlurmAccount) error {
+	if err := checkSlurmName(cluster); err != nil {
+		return err
+	}
+	sorted := make([]SlurmAccount, len(accounts))
+	copy(sorted, accounts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	members := make(map[string][]SlurmUser, len(sorted))
+	defaultAccount := make(map[string]string)
+	for _, a := range sorted {
+		if err := checkSlurmNames(append([]string{a.Name}, a.QOS...)...); err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, u := range a.Users {
+			if err := checkSlurmNames(append(append([]string{u.Username}, u.QOS...), u.Partitions...)...); err != nil {
+				return err
+			}
+			if seen[u.Username] {
+				continue
+			}
+			seen[u.Username] = true
+			members[a.Name] = append(members[a.Name], u)
+			// accounts are sorted, so the first one seen is the default
+			if _, ok := defaultAccount[u.Username]; !ok {
+				defaultAccount[u.Username] = a.Name
+			}
+		}
+		sort.Slice(members[a.Name], func(i, j int) bool { return members[a.Name][i].Username < members[a.Name][j].Username })
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# sacctmgr dump generated by hpcadmin-server")
+	fmt.Fprintf(bw, "Cluster - '%s'\n", cluster)
+	fmt.Fprintln(bw, "Parent - 'root'")
+	fmt.Fprintln(bw, "User - 'root':DefaultAccount='root':AdminLevel='Administrator':Fairshare=1")
+	for _, a := range sorted {
+		limits, err := limitFields(a.SlurmLimits)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", a.Name, err)
+		}
+		fmt.Fprintf(bw, "Account - '%s':Description='%s':Organization='%s'%s%s\n", a.Name, a.Name, a.Name, limits, qosFields(a.QOS, a.DefaultQOS))
+	}
+	for _, a := range sorted {
+		if len(members[a.Name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "Parent - '%s'\n", a.Name)
+		for _, u := range members[a.Name] {
+			limits, err := limitFields(u.SlurmLimits)
+			if err != nil {
+				return fmt.Errorf("user %s in account %s: %w", u.Username, a.Name, err)
+			}
+			fields := fmt.Sprintf("DefaultAccount='%s'%s%s", defaultAccount[u.Username], limits, qosFields(u.QOS, u.DefaultQOS))
+			if len(u.Partitions) == 0 {
+				fmt.Fprintf(bw, "User - '%s':%s\n", u.Username, fields)
+				continue
+			}
+			for _, partition := range u.Partitions {
+				fmt.Fprintf(bw, "User - '%s':Partition='%s':%s\n", u.Username, partition, fields)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// limitFields formats the limits of an association. Fairshare defaults to 1.
+func limitFields(l data.SlurmLimits) (string, error) {
+	fairshare := 1
+	if l.Fairshare != nil {
+		fairshare = *l.Fairshare
+	}
+	fields := fmt.Sprintf(":Fairshare=%d", fairshare)
+	if l.GrpTRES != "" {
+		if err := data.ValidateTRES(l.GrpTRES); err != nil {
+			return "", err
+		}
+		fields += fmt.Sprintf(":GrpTRES='%s'", l.GrpTRES)
+	}
+	if l.MaxJobs != nil {
+		fields += fmt.Sprintf(":MaxJobs=%d", *l.MaxJobs)
+	}
+	if l.MaxWall != "" {
+		minutes, err := data.WallMinutes(l.MaxWall)
+		if err != nil {
+			return "", err
+		}
+		fields += fmt.Sprintf(":MaxWallDurationPerJob=%d", minutes)
+	}
+	return fields, nil
+}
+
+// qosFields formats the QOS of an association, or nothing if there are none
+func qosFields(qos []string, defaultQOS string) string {
+	if len(qos) == 0 {
+		return ""
+	}
+	fields := fmt.Sprintf(":QOS='%s'", strings.Join(qos, ","))
+	if defaultQOS != "" {
+		fields += fmt.Sprintf(":DefaultQOS='%s'", defaultQOS)
+	}
+	return fields
+}
+
+// checkSlurmName rejects names that would break the quoting of the dump file
+func checkSlurmName(name string) error {
+	if name == "" || strings.ContainsAny(name, "':\n") {
+		return fmt.Errorf("%q can't be used as a slurm name", name)
+	}
+	return nil
+}
+
+// checkSlurmNames checks names that may end up in a comma separated list
+func checkSlurmNames(names ...string) error {
+	for _, name := range names {
+		if err := checkSlurmName(name); err != nil {
+			return err
+		}
+		if strings.Contains(name, ",") {
+			return fmt.Errorf("%q can't be used as a slurm name", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestSlurm(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	user := func(username string) *data.User {
+		u, err := store.CreateUser(ctx, &data.UserRequest{Username: username, Email: username + "@example.org", FirstName: username, LastName: username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	lcrown, marka, craigs, mollman := user("lcrown"), user("marka"), user("craigs"), user("mollman")
+	user("nopirg")
+	pirg := func(name string, owner *data.User, admins []int, users []int) *data.Pirg {
+		p, err := store.CreatePirg(ctx, &data.PirgRequest{Name: name, OwnerId: owner.Id, AdminIds: admins, UserIds: users})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	systems := pirg("systems", craigs, []int{craigs.Id}, []int{craigs.Id, mollman.Id, lcrown.Id})
+	racs := pirg("racs", marka, []int{lcrown.Id}, []int{lcrown.Id, marka.Id})
+	pirg("notenabled", marka, []int{marka.Id}, []int{marka.Id})
+	cluster := func(name string) *data.Cluster {
+		c, err := store.CreateCluster(ctx, &data.ClusterRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	talapas, other := cluster("talapas"), cluster("other")
+	enable := func(c *data.Cluster, p *data.Pirg, users ...int) {
+		if _, err := store.SetClusterPirg(ctx, c.Id, p.Id, users); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enable(talapas, systems, craigs.Id, mollman.Id, lcrown.Id)
+	enable(talapas, racs, lcrown.Id, marka.Id)
+	// only the enabled users of the pirgs enabled on the cluster are exported
+	enable(other, systems, craigs.Id)
+
+	var buf bytes.Buffer
+	if err := Slurm(ctx, store, "talapas", &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# sacctmgr dump generated by hpcadmin-server
+Cluster - 'talapas'
+Parent - 'root'
+User - 'root':DefaultAccount='root':AdminLevel='Administrator':Fairshare=1
+Account - 'racs':Description='racs':Organization='racs':Fairshare=1
+Account - 'systems':Description='systems':Organization='systems':Fairshare=1
+Parent - 'racs'
+User - 'lcrown':DefaultAccount='racs':Fairshare=1
+User - 'marka':DefaultAccount='racs':Fairshare=1
+Parent - 'systems'
+User - 'craigs':DefaultAccount='systems':Fairshare=1
+User - 'lcrown':DefaultAccount='racs':Fairshare=1
+User - 'mollman':DefaultAccount='systems':Fairshare=1
+`
+	if buf.String() != want {
+		t.Errorf("unexpected dump:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := Slurm(ctx, store, "other", &buf); err != nil {
+		t.Fatal(err)
+	}
+	want = `# sacctmgr dump generated by hpcadmin-server
+Cluster - 'other'
+Parent - 'root'
+User - 'root':DefaultAccount='root':AdminLevel='Administrator':Fairshare=1
+Account - 'systems':Description='systems':Organization='systems':Fairshare=1
+Parent - 'systems'
+User - 'craigs':DefaultAccount='systems':Fairshare=1
+`
+	if buf.String() != want {
+		t.Errorf("unexpected dump:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	// members are left out until their membership starts
+	startsAt := time.Now().Add(24 * time.Hour)
+	if _, err := store.SetMembershipTerm(ctx, systems.Id, mollman.Id, &data.MembershipTerm{StartsAt: &startsAt}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := Slurm(ctx, store, "talapas", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "mollman") {
+		t.Errorf("expected mollman to be left out before their membership starts:\n%s", buf.String())
+	}
+
+	if err := Slurm(ctx, store, "missing", &buf); err == nil {
+		t.Error("expected error exporting a missing cluster")
+	}
+}
+
+func TestSlurmGrants(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	owner, err := store.CreateUser(ctx, &data.UserRequest{Username: "owner", Email: "owner@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buyer, err := store.CreateUser(ctx, &data.UserRequest{Username: "buyer", Email: "buyer@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "condo", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id, buyer.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	talapas, err := store.CreateCluster(ctx, &data.ClusterRequest{Name: "talapas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.CreateCluster(ctx, &data.ClusterRequest{Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetClusterPirg(ctx, talapas.Id, pirg.Id, []int{owner.Id, buyer.Id}); err != nil {
+		t.Fatal(err)
+	}
+	normal, err := store.CreateQOS(ctx, &data.QOSRequest{Name: "normal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := store.CreateQOS(ctx, &data.QOSRequest{Name: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition := func(c *data.Cluster, name string) *data.Partition {
+		p, err := store.CreatePartition(ctx, &data.PartitionRequest{ClusterId: c.Id, Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	compute, gpu, elsewhere := partition(talapas, "compute"), partition(talapas, "gpu"), partition(other, "elsewhere")
+	_, err = store.SetPirgSlurm(ctx, pirg.Id, &data.PirgSlurm{
+		SlurmGrants: data.SlurmGrants{QOSIds: []int{normal.Id}, DefaultQOSId: &normal.Id, PartitionIds: []int{compute.Id, elsewhere.Id}},
+		Members: []data.MemberSlurm{
+			{UserId: buyer.Id, SlurmGrants: data.SlurmGrants{QOSIds: []int{normal.Id, high.Id}, DefaultQOSId: &high.Id, PartitionIds: []int{gpu.Id, compute.Id}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fairshare, maxJobs := 20, 5
+	_, err = store.SetPirgLimits(ctx, pirg.Id, &data.PirgLimits{
+		SlurmLimits: data.SlurmLimits{Fairshare: &fairshare, GrpTRES: "cpu=100,gres/gpu=4,gres/gpu:a100=2"},
+		Members: []data.MemberLimits{
+			{UserId: owner.Id, SlurmLimits: data.SlurmLimits{MaxJobs: &maxJobs, MaxWall: "1-00:00:00"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Slurm(ctx, store, "talapas", &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# sacctmgr dump generated by hpcadmin-server
+Cluster - 'talapas'
+Parent - 'root'
+User - 'root':DefaultAccount='root':AdminLevel='Administrator':Fairshare=1
+Account - 'condo':Description='condo':Organization='condo':Fairshare=20:GrpTRES='cpu=100,gres/gpu=4,gres/gpu:a100=2':QOS='normal':DefaultQOS='normal'
+Parent - 'condo'
+User - 'buyer':Partition='compute':DefaultAccount='condo':Fairshare=1:QOS='high,normal':DefaultQOS='high'
+User - 'buyer':Partition='gpu':DefaultAccount='condo':Fairshare=1:QOS='high,normal':DefaultQOS='high'
+User - 'owner':Partition='compute':DefaultAccount='condo':Fairshare=1:MaxJobs=5:MaxWallDurationPerJob=1440
+`
+	if buf.String() != want {
+		t.Errorf("unexpected dump:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestSlurmRejectsBadNames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSlurm(&buf, "bad'cluster", nil); err == nil {
+		t.Error("expected error for a cluster name with a quote")
+	}
+	if err := WriteSlurm(&buf, "", nil); err == nil {
+		t.Error("expected error for an empty cluster name")
+	}
+	if err := WriteSlurm(&buf, "talapas", []SlurmAccount{{Name: "racs", QOS: []string{"bad,qos"}}}); err == nil {
+		t.Error("expected error for a qos name with a comma")
+	}
+	if err := WriteSlurm(&buf, "talapas", []SlurmAccount{{Name: "racs", SlurmLimits: data.SlurmLimits{GrpTRES: "cpu=1:mem=2"}}}); err == nil {
+		t.Error("expected error for an invalid tres")
+	}
+}
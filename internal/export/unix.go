@@ -0,0 +1,358 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// The unix settings used when the configuration leaves them unset
+const (
+	DefaultUIDMin       = 100000
+	DefaultUIDMax       = 199999
+	DefaultGIDMin       = 200000
+	DefaultGIDMax       = 299999
+	DefaultPrimaryGID   = 100
+	DefaultShell        = "/bin/bash"
+	DefaultHomeTemplate = "/home/{{.Username}}"
+)
+
+// unixName is what useradd and groupadd accept by default
+var unixName = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,31}$`)
+
+// UnixOptions are the settings of the passwd and group exports
+type UnixOptions struct {
+	UIDs       data.IdRange
+	GIDs       data.IdRange
+	PrimaryGID int
+	Shell      string
+	// Home is executed with the *data.User to get their home directory
+	Home *template.Template
+}
+
+// NewUnixOptions fills in the defaults for whatever the configuration leaves unset
+func NewUnixOptions(cfg config.UnixConfig) (*UnixOptions, error) {
+	opts := &UnixOptions{
+		UIDs:       data.IdRange{Min: cfg.UIDMin, Max: cfg.UIDMax},
+		GIDs:       data.IdRange{Min: cfg.GIDMin, Max: cfg.GIDMax},
+		PrimaryGID: cfg.PrimaryGID,
+		Shell:      cfg.Shell,
+	}
+	if opts.UIDs.Min == 0 {
+		opts.UIDs.Min = DefaultUIDMin
+	}
+	if opts.UIDs.Max == 0 {
+		opts.UIDs.Max = DefaultUIDMax
+	}
+	if opts.GIDs.Min == 0 {
+		opts.GIDs.Min = DefaultGIDMin
+	}
+	if opts.GIDs.Max == 0 {
+		opts.GIDs.Max = DefaultGIDMax
+	}
+	if opts.PrimaryGID == 0 {
+		opts.PrimaryGID = DefaultPrimaryGID
+	}
+	if opts.Shell == "" {
+		opts.Shell = DefaultShell
+	}
+	home := cfg.HomeTemplate
+	if home == "" {
+		home = DefaultHomeTemplate
+	}
+	var err error
+	opts.Home, err = template.New("home").Option("missingkey=error").Parse(home)
+	if err != nil {
+		return nil, fmt.Errorf("invalid home template: %v", err)
+	}
+	return opts, nil
+}
+
+// GroupEntry is a line of /etc/group
+type GroupEntry struct {
+	Name    string
+	Gid     int
+	Members []string
+}
+
+// PasswdEntry is a line of /etc/passwd
+type PasswdEntry struct {
+	Username string
+	Uid      int
+	Gid      int
+	Gecos    string
+	Home     string
+	Shell    string
+}
+
+// Collision is a name or id shared by more than one entry
+type Collision struct {
+	// Kind is name, uid or gid
+	Kind    string   `json:"kind"`
+	Value   string   `json:"value"`
+	Entries []string `json:"entries"`
+}
+
+// UnixProblems is returned instead of a file when writing it would give
+// two entries the same name or id, or an entry a name unix won't accept
+type UnixProblems struct {
+	Collisions []Collision `json:"collisions"`
+	Invalid    []string    `json:"invalid"`
+}
+
+func (p *UnixProblems) Error() string {
+	var parts []string
+	for _, c := range p.Collisions {
+		parts = append(parts, fmt.Sprintf("%s %s is used by %s", c.Kind, c.Value, strings.Join(c.Entries, " and ")))
+	}
+	parts = append(parts, p.Invalid...)
+	return strings.Join(parts, "; ")
+}
+
+// uniqueness collects the entries using each name or id so that every
+// collision can be reported at once
+type uniqueness struct {
+	kind  string
+	users map[string][]string
+	order []string
+}
+
+func newUniqueness(kind string) *uniqueness {
+	return &uniqueness{kind: kind, users: make(map[string][]string)}
+}
+
+func (u *uniqueness) add(value string, entry string) {
+	if _, ok := u.users[value]; !ok {
+		u.order = append(u.order, value)
+	}
+	u.users[value] = append(u.users[value], entry)
+}
+
+func (u *uniqueness) collisions() []Collision {
+	var collisions []Collision
+	for _, value := range u.order {
+		if entries := u.users[value]; len(entries) > 1 {
+			collisions = append(collisions, Collision{Kind: u.kind, Value: value, Entries: entries})
+		}
+	}
+	return collisions
+}
+
+// activeMembers maps each pirg to the usernames of its members that are
+// within the term of their membership. usernames only has active users.
+func activeMembers(ctx context.Context, store data.Store, usernames map[int]string) (map[int]map[int]string, error) {
+	memberships, err := store.GetAllMemberships(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memberships: %w", err)
+	}
+	now := time.Now()
+	members := make(map[int]map[int]string)
+	for _, m := range memberships {
+		if members[m.PirgId] == nil {
+			members[m.PirgId] = make(map[int]string)
+		}
+		if username, ok := usernames[m.UserId]; ok && m.Active(now) {
+			members[m.PirgId][m.UserId] = username
+		}
+	}
+	return members, nil
+}
+
+// allocatedUsers gives anything without a unix id one, then returns every
+// user. The tables are only locked when something needs an id.
+func allocatedUsers(ctx context.Context, store data.Store, opts *UnixOptions) ([]*data.User, error) {
+	if _, err := store.AllocateUnixIds(ctx, opts.UIDs, opts.GIDs); err != nil {
+		return nil, fmt.Errorf("failed to allocate unix ids: %w", err)
+	}
+	users, err := store.GetAllUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return users, nil
+}
+
+// UnixGroups returns a group for every pirg and pirg group with its active
+// members, ordered by name. Deactivated users aren't members of any group.
+// Pirgs and groups without a gid are given one first. It returns
+// *UnixProblems if any names or gids collide.
+func UnixGroups(ctx context.Context, store data.Store, opts *UnixOptions) ([]GroupEntry, error) {
+	users, err := allocatedUsers(ctx, store, opts)
+	if err != nil {
+		return nil, err
+	}
+	return unixGroups(ctx, store, users, opts)
+}
+
+// unixGroups is UnixGroups once the ids are allocated
+func unixGroups(ctx context.Context, store data.Store, users []*data.User, opts *UnixOptions) ([]GroupEntry, error) {
+	usernames := make(map[int]string, len(users))
+	for _, u := range users {
+		if u.Active() {
+			usernames[u.Id] = u.Username
+		}
+	}
+	pirgs, err := store.GetAllPirgs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pirgs: %w", err)
+	}
+	groups, err := store.GetAllPirgGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pirg groups: %w", err)
+	}
+	members, err := activeMembers(ctx, store, usernames)
+	if err != nil {
+		return nil, err
+	}
+	pirgNames := make(map[int]string, len(pirgs))
+	for _, pirg := range pirgs {
+		pirgNames[pirg.Id] = pirg.Name
+	}
+
+	names, gids := newUniqueness("name"), newUniqueness("gid")
+	gids.add(strconv.Itoa(opts.PrimaryGID), "the primary group")
+	problems := &UnixProblems{}
+	var entries []GroupEntry
+	add := func(label string, name string, gid int, userIds []int, active map[int]string) {
+		names.add(name, label)
+		gids.add(strconv.Itoa(gid), label)
+		if !unixName.MatchString(name) {
+			problems.Invalid = append(problems.Invalid, fmt.Sprintf("%s isn't a valid group name", label))
+		}
+		entry := GroupEntry{Name: name, Gid: gid}
+		for _, id := range userIds {
+			if username, ok := active[id]; ok {
+				entry.Members = append(entry.Members, username)
+			}
+		}
+		sort.Strings(entry.Members)
+		entries = append(entries, entry)
+	}
+	// anything created since the ids were allocated is left for the next export
+	for _, pirg := range pirgs {
+		if pirg.Gid == nil {
+			continue
+		}
+		add("pirg "+pirg.Name, pirg.Name, *pirg.Gid, pirg.UserIds, members[pirg.Id])
+	}
+	for _, group := range groups {
+		if group.Gid == nil {
+			continue
+		}
+		add(fmt.Sprintf("group %s/%s", pirgNames[group.PirgId], group.Name), group.Name, *group.Gid, group.UserIds, members[group.PirgId])
+	}
+	problems.Collisions = append(names.collisions(), gids.collisions()...)
+	if len(problems.Collisions) > 0 || len(problems.Invalid) > 0 {
+		return nil, problems
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// UnixPasswd returns an account for every active user, ordered by uid.
+// Users without a uid are given one first. It returns *UnixProblems if any
+// names or uids collide, or a name or home directory can't be written.
+func UnixPasswd(ctx context.Context, store data.Store, opts *UnixOptions) ([]PasswdEntry, error) {
+	users, err := allocatedUsers(ctx, store, opts)
+	if err != nil {
+		return nil, err
+	}
+	return unixPasswd(users, opts)
+}
+
+// unixPasswd is UnixPasswd once the ids are allocated
+func unixPasswd(users []*data.User, opts *UnixOptions) ([]PasswdEntry, error) {
+	names, uids := newUniqueness("name"), newUniqueness("uid")
+	problems := &UnixProblems{}
+	var entries []PasswdEntry
+	for _, u := range users {
+		// users created since the ids were allocated are left for the next export
+		if u.Uid == nil || !u.Active() {
+			continue
+		}
+		label := "user " + u.Username
+		names.add(u.Username, label)
+		uids.add(strconv.Itoa(*u.Uid), label)
+		if !unixName.MatchString(u.Username) {
+			problems.Invalid = append(problems.Invalid, fmt.Sprintf("%s isn't a valid username", label))
+		}
+		var home strings.Builder
+		if err := opts.Home.Execute(&home, u); err != nil {
+			return nil, fmt.Errorf("failed to render home directory of %s: %v", u.Username, err)
+		}
+		if !strings.HasPrefix(home.String(), "/") || strings.ContainsAny(home.String(), ":\n") {
+			problems.Invalid = append(problems.Invalid, fmt.Sprintf("%s has an invalid home directory %q", label, home.String()))
+		}
+		entries = append(entries, PasswdEntry{
+			Username: u.Username,
+			Uid:      *u.Uid,
+			Gid:      opts.PrimaryGID,
+			Gecos:    gecos(u),
+			Home:     home.String(),
+			Shell:    opts.Shell,
+		})
+	}
+	problems.Collisions = append(names.collisions(), uids.collisions()...)
+	if len(problems.Collisions) > 0 || len(problems.Invalid) > 0 {
+		return nil, problems
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Uid < entries[j].Uid })
+	return entries, nil
+}
+
+// gecos is the user's full name without the characters that would break
+// the passwd line
+func gecos(u *data.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	return strings.Map(func(r rune) rune {
+		if r == ':' || r == ',' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, name)
+}
+
+// WriteGroup writes the entries in /etc/group format
+func WriteGroup(w io.Writer, entries []GroupEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "%s:x:%d:%s\n", e.Name, e.Gid, strings.Join(e.Members, ","))
+	}
+	return bw.Flush()
+}
+
+// WritePasswd writes the entries in /etc/passwd format
+func WritePasswd(w io.Writer, entries []PasswdEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "%s:x:%d:%d:%s:%s:%s\n", e.Username, e.Uid, e.Gid, e.Gecos, e.Home, e.Shell)
+	}
+	return bw.Flush()
+}
+
+// Group writes every pirg and pirg group in /etc/group format
+func Group(ctx context.Context, store data.Store, opts *UnixOptions, w io.Writer) error {
+	entries, err := UnixGroups(ctx, store, opts)
+	if err != nil {
+		return err
+	}
+	return WriteGroup(w, entries)
+}
+
+// Passwd writes every user in /etc/passwd format
+func Passwd(ctx context.Context, store data.Store, opts *UnixOptions, w io.Writer) error {
+	entries, err := UnixPasswd(ctx, store, opts)
+	if err != nil {
+		return err
+	}
+	return WritePasswd(w, entries)
+}
@@ -0,0 +1,163 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestUnix(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	user := func(username string, first string, last string) *data.User {
+		u, err := store.CreateUser(ctx, &data.UserRequest{Username: username, Email: username + "@example.org", FirstName: first, LastName: last})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	marka, lcrown, student := user("marka", "Mark", "Allen"), user("lcrown", "Lucas", "Crownover, Jr."), user("student", "Some", "Student")
+	racs, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "racs", OwnerId: marka.Id, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id, lcrown.Id, student.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreatePirgGroup(ctx, racs.Id, &data.PirgGroupRequest{Name: "racs-students", UserIds: []int{student.Id, lcrown.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	// members outside of their term are left out
+	expired := time.Now().Add(-time.Hour)
+	if _, err := store.SetMembershipTerm(ctx, racs.Id, lcrown.Id, &data.MembershipTerm{ExpiresAt: &expired}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := NewUnixOptions(config.UnixConfig{UIDMin: 5000, UIDMax: 5999, GIDMin: 7000, GIDMax: 7999, Shell: "/bin/zsh", HomeTemplate: "/home/{{.Username}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Group(ctx, store, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `racs:x:7000:marka,student
+racs-students:x:7001:student
+`
+	if buf.String() != want {
+		t.Errorf("unexpected group file:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := Passwd(ctx, store, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want = `marka:x:5000:100:Mark Allen:/home/marka:/bin/zsh
+lcrown:x:5001:100:Lucas Crownover  Jr.:/home/lcrown:/bin/zsh
+student:x:5002:100:Some Student:/home/student:/bin/zsh
+`
+	if buf.String() != want {
+		t.Errorf("unexpected passwd file:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	t.Run("Stable", func(t *testing.T) {
+		// ids that were handed out are kept once more are allocated
+		newcomer := user("newcomer", "New", "Comer")
+		var buf bytes.Buffer
+		if err := Passwd(ctx, store, opts, &buf); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.GetUserById(ctx, newcomer.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Uid == nil || *got.Uid != 5003 {
+			t.Fatalf("expected the newcomer to get uid 5003, got %v", got.Uid)
+		}
+	})
+
+	t.Run("Deactivated", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		guest, err := store.CreateUser(ctx, &data.UserRequest{Username: "guest", Email: "guest@example.org", FirstName: "Some", LastName: "Guest", Type: data.UserGuest, SponsorId: &marka.Id, ExpiresAt: &expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		current, err := store.GetPirgById(ctx, racs.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pr := &data.PirgRequest{Name: "racs", OwnerId: marka.Id, Gid: current.Gid, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id, lcrown.Id, student.Id, guest.Id}}
+		if _, err := store.UpdatePirg(ctx, racs.Id, pr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.DeactivateGuests(ctx, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Group(ctx, store, opts, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := Passwd(ctx, store, opts, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), "guest") {
+			t.Errorf("expected the deactivated guest to be left out, got:\n%s", buf.String())
+		}
+	})
+
+	t.Run("Collisions", func(t *testing.T) {
+		// a pirg group named after a pirg, and a pirg given the primary gid
+		primary := DefaultPrimaryGID
+		if _, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "users", OwnerId: marka.Id, Gid: &primary, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.CreatePirgGroup(ctx, racs.Id, &data.PirgGroupRequest{Name: "racs"}); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err := Group(ctx, store, opts, &buf)
+		var problems *UnixProblems
+		if !errors.As(err, &problems) {
+			t.Fatalf("expected collisions to be reported, got %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected nothing to be written, got:\n%s", buf.String())
+		}
+		if len(problems.Collisions) != 2 {
+			t.Fatalf("expected 2 collisions, got %+v", problems.Collisions)
+		}
+		name, gid := problems.Collisions[0], problems.Collisions[1]
+		if name.Kind != "name" || name.Value != "racs" || len(name.Entries) != 2 || name.Entries[1] != "group racs/racs" {
+			t.Errorf("unexpected name collision %+v", name)
+		}
+		if gid.Kind != "gid" || gid.Value != "100" || gid.Entries[0] != "the primary group" || gid.Entries[1] != "pirg users" {
+			t.Errorf("unexpected gid collision %+v", gid)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		user("Bad.Name", "Bad", "Name")
+		var buf bytes.Buffer
+		err := Passwd(ctx, store, opts, &buf)
+		var problems *UnixProblems
+		if !errors.As(err, &problems) || len(problems.Invalid) != 1 || len(problems.Collisions) != 0 {
+			t.Fatalf("expected the invalid username to be reported, got %v", err)
+		}
+	})
+}
+
+func TestNewUnixOptions(t *testing.T) {
+	opts, err := NewUnixOptions(config.UnixConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.UIDs.Min != DefaultUIDMin || opts.GIDs.Max != DefaultGIDMax || opts.PrimaryGID != DefaultPrimaryGID || opts.Shell != DefaultShell {
+		t.Errorf("expected the defaults, got %+v", opts)
+	}
+	if _, err := NewUnixOptions(config.UnixConfig{HomeTemplate: "/home/{{.Username"}); err == nil {
+		t.Error("expected error for an invalid home template")
+	}
+}
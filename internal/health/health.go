@@ -0,0 +1,151 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// DefaultCheckTimeout bounds how long a single readiness check can take
+const DefaultCheckTimeout = 5 * time.Second
+
+// CheckFunc reports whether a dependency is usable
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Checker serves the liveness and readiness probes
+type Checker struct {
+	checks  []check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check
+func (c *Checker) Add(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type ReadyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Healthz reports that the process is up and serving requests
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, map[string]string{"status": "ok"})
+}
+
+// Readyz runs every check concurrently and responds with 503 if any fail
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	resp := c.Run(r.Context())
+	if resp.Status != "ok" {
+		slog.Warn("readiness check failed", "checks", resp.Checks, "package", "health", "method", "Readyz")
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, resp)
+}
+
+// Run runs the readiness checks and collects their results
+func (c *Checker) Run(ctx context.Context) ReadyResponse {
+	resp := ReadyResponse{Status: "ok", Checks: make(map[string]CheckResult, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ch := range c.checks {
+		wg.Add(1)
+		go func(ch check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			start := time.Now()
+			err := ch.fn(checkCtx)
+			result := CheckResult{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[ch.name] = result
+			if err != nil {
+				resp.Status = "unavailable"
+			}
+		}(ch)
+	}
+	wg.Wait()
+	return resp
+}
+
+// DatabaseCheck pings the database, failing if it doesn't answer within maxLatency
+func DatabaseCheck(db *sql.DB, maxLatency time.Duration) CheckFunc {
+	return func(ctx context.Context) error {
+		start := time.Now()
+		if err := db.PingContext(ctx); err != nil {
+			return err
+		}
+		if latency := time.Since(start); maxLatency > 0 && latency > maxLatency {
+			return fmt.Errorf("ping took %v, more than %v", latency, maxLatency)
+		}
+		return nil
+	}
+}
+
+// SchemaCheck makes sure the database has been migrated to the version
+// this build expects
+func SchemaCheck(db *sql.DB, expected uint) CheckFunc {
+	return func(ctx context.Context) error {
+		version, dirty, err := data.GetSchemaVersion(ctx, db)
+		if err != nil {
+			return fmt.Errorf("reading schema version: %w", err)
+		}
+		if dirty {
+			return fmt.Errorf("schema version %d is dirty, a migration failed partway through", version)
+		}
+		if version != expected {
+			return fmt.Errorf("schema version is %d, expected %d", version, expected)
+		}
+		return nil
+	}
+}
+
+// URLCheck makes sure url answers a GET with 200, such as the oidc issuer metadata
+func URLCheck(client *http.Client, url string) CheckFunc {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func readyz(t *testing.T, c *Checker) (int, ReadyResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var resp ReadyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, resp
+}
+
+func TestReadyzAllPassing(t *testing.T) {
+	c := NewChecker(time.Second)
+	c.Add("first", func(ctx context.Context) error { return nil })
+	c.Add("second", func(ctx context.Context) error { return nil })
+
+	code, resp := readyz(t, c)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.Status != "ok" || len(resp.Checks) != 2 {
+		t.Fatalf("expected 2 passing checks, got %+v", resp)
+	}
+}
+
+func TestReadyzFailingCheck(t *testing.T) {
+	c := NewChecker(50 * time.Millisecond)
+	c.Add("good", func(ctx context.Context) error { return nil })
+	c.Add("bad", func(ctx context.Context) error { return errors.New("broken") })
+	c.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, resp := readyz(t, c)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", code)
+	}
+	if resp.Status != "unavailable" {
+		t.Fatalf("expected unavailable, got %s", resp.Status)
+	}
+	if resp.Checks["good"].Status != "ok" {
+		t.Errorf("expected good check to pass, got %+v", resp.Checks["good"])
+	}
+	if bad := resp.Checks["bad"]; bad.Status != "fail" || bad.Error != "broken" {
+		t.Errorf("expected bad check to fail with its error, got %+v", bad)
+	}
+	if slow := resp.Checks["slow"]; slow.Status != "fail" {
+		t.Errorf("expected slow check to time out, got %+v", slow)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	c := NewChecker(0)
+	c.Add("bad", func(ctx context.Context) error { return errors.New("broken") })
+	rec := httptest.NewRecorder()
+	c.Healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+	// liveness doesn't depend on the readiness checks
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestURLCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	if err := URLCheck(srv.Client(), srv.URL+"/.well-known/openid-configuration")(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := URLCheck(srv.Client(), srv.URL+"/missing")(ctx); err == nil {
+		t.Error("expected error for a 404")
+	}
+}
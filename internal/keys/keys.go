@@ -11,3 +11,18 @@ const ConfigKey key = "config"
 const RoleKey key = "role"
 const JWTTokenKey key = "token"
 const APIKey key = "APIKey"
+const LocationKey key = "LocationKey"
+const StorageAllocationKey key = "StorageAllocationKey"
+const StoreKey key = "store"
+const ClusterKey key = "ClusterKey"
+const QOSKey key = "QOSKey"
+const PartitionKey key = "PartitionKey"
+const ComputeAllocationKey key = "ComputeAllocationKey"
+const AccessReviewKey key = "AccessReviewKey"
+const PirgGroupKey key = "PirgGroupKey"
+
+// CallerIdKey holds the id of the user making the request, if it's known
+const CallerIdKey key = "CallerIdKey"
+
+// NotifierKey holds the *notifications.Notifier, which is nil when email is disabled
+const NotifierKey key = "NotifierKey"
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// level is shared by every handler built by Configure so it can be
+// changed while the server is running
+var level = new(slog.LevelVar)
+
+// Configure installs the default slog logger writing to w in the given
+// format ("text" or "json") at the given level
+func Configure(w io.Writer, format string, lvl slog.Level) {
+	level.Set(lvl)
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+	var h slog.Handler
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(NewContextHandler(h)))
+}
+
+// Level returns the current log level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the log level of the default logger
+func SetLevel(lvl slog.Level) {
+	level.Set(lvl)
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(s)); err != nil {
+		return lvl, fmt.Errorf("invalid log level %q, must be one of debug, info, warn or error", s)
+	}
+	return lvl, nil
+}
+
+// sensitiveKeys are attribute keys whose values are never written
+var sensitiveKeys = []string{"authorization", "x-api-key", "api_key", "apikey", "password", "client_secret", "token", "cookie", "set-cookie"}
+
+const redacted = "REDACTED"
+
+// redactAttr blanks out the values of sensitive attributes, wherever
+// they show up, as a last line of defense against leaking credentials
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// IsSensitive reports whether a header or attribute name carries credentials
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if key == s {
+			return true
+		}
+	}
+	return false
+}
+
+// requestInfo is shared by every context derived from a request, so
+// values set deep in the middleware chain show up in logs written
+// further out, like the access log
+type requestInfo struct {
+	mu     sync.Mutex
+	caller string
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo attaches a requestInfo to the context
+func withRequestInfo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, &requestInfo{})
+}
+
+// SetCaller records who made the request, such as "user:12" for an api key
+func SetCaller(ctx context.Context, caller string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.caller = caller
+		info.mu.Unlock()
+	}
+}
+
+// Caller returns the identity recorded by SetCaller
+func Caller(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.caller
+	}
+	return ""
+}
+
+// ContextHandler adds the request id, caller, route pattern and trace
+// ids to every record logged with a request context
+type ContextHandler struct {
+	slog.Handler
+}
+
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := middleware.GetReqID(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if caller := Caller(ctx); caller != "" {
+			r.AddAttrs(slog.String("caller", caller))
+		}
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			r.AddAttrs(slog.String("route", rctx.RoutePattern()))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
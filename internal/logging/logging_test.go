@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// logLines configures json logging into a buffer and returns a func
+// that parses everything logged so far
+func logLines(t *testing.T, lvl slog.Level) (*bytes.Buffer, func() []map[string]any) {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	var buf bytes.Buffer
+	Configure(&buf, "json", lvl)
+	return &buf, func() []map[string]any {
+		t.Helper()
+		var lines []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				t.Fatalf("invalid json log line %q: %v", line, err)
+			}
+			lines = append(lines, m)
+		}
+		return lines
+	}
+}
+
+func testRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(AccessLog)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetCaller(r.Context(), "user:7")
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "handling thing")
+	})
+	return r
+}
+
+func TestRequestLogsCarryContext(t *testing.T) {
+	_, lines := logLines(t, slog.LevelInfo)
+	testRouter().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/things/12", nil))
+
+	logged := lines()
+	if len(logged) != 2 {
+		t.Fatalf("expected a handler line and an access line, got %v", logged)
+	}
+	for _, line := range logged {
+		if line["request_id"] == nil || line["request_id"] == "" {
+			t.Errorf("expected request_id in %v", line)
+		}
+		if line["caller"] != "user:7" {
+			t.Errorf("expected caller user:7 in %v", line)
+		}
+		if line["route"] != "/things/{thingID}" {
+			t.Errorf("expected route /things/{thingID} in %v", line)
+		}
+	}
+	access := logged[1]
+	if access["msg"] != "request completed" || access["status"] != float64(200) || access["path"] != "/things/12" {
+		t.Errorf("unexpected access log line %v", access)
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	buf, _ := logLines(t, slog.LevelDebug)
+	req := httptest.NewRequest("GET", "/things/12", nil)
+	req.Header.Set("X-API-Key", "supersecretapikey")
+	req.Header.Set("Authorization", "Bearer supersecrettoken")
+	testRouter().ServeHTTP(httptest.NewRecorder(), req)
+	slog.Info("connecting", "password", "supersecretpassword", "client_secret", "supersecretclient")
+
+	out := buf.String()
+	for _, secret := range []string{"supersecretapikey", "supersecrettoken", "supersecretpassword", "supersecretclient"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("secret %q was logged: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, `"X-Api-Key":"REDACTED"`) {
+		t.Errorf("expected redacted api key header in %s", out)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf, _ := logLines(t, slog.LevelInfo)
+	slog.Debug("hidden")
+	SetLevel(slog.LevelDebug)
+	slog.Debug("shown")
+	if Level() != slog.LevelDebug {
+		t.Fatalf("expected debug level, got %v", Level())
+	}
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Fatalf("level change wasn't applied: %s", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if lvl, err := ParseLevel("WARN"); err != nil || lvl != slog.LevelWarn {
+		t.Errorf("expected warn, got %v %v", lvl, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
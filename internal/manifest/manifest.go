@@ -0,0 +1,584 @@
+// Package manifest compares a declared set of users and pirgs with the
+// store, and applies the differences in one transaction
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid is returned for a manifest that can't be planned, such as one
+// naming a user twice or referencing a user that doesn't exist
+var ErrInvalid = errors.New("invalid manifest")
+
+// Manifest is the declared state of the users and pirgs it lists. Users and
+// pirgs that aren't listed are left alone unless the plan prunes them.
+type Manifest struct {
+	Users []User `yaml:"users"`
+	Pirgs []Pirg `yaml:"pirgs"`
+}
+
+// User is a user by username. A nil Uid keeps the uid the user already has.
+// Users are created as institutional, and the type, sponsor and expiry of
+// existing users are kept.
+type User struct {
+	Username  string `yaml:"username"`
+	Email     string `yaml:"email"`
+	FirstName string `yaml:"first_name"`
+	LastName  string `yaml:"last_name"`
+	Uid       *int   `yaml:"uid"`
+}
+
+// Pirg is a pirg by name. The owner is always an admin and the admins are
+// always members, so they don't need to be repeated. A nil Gid keeps the
+// gid the pirg already has.
+type Pirg struct {
+	Name    string   `yaml:"name"`
+	Owner   string   `yaml:"owner"`
+	Gid     *int     `yaml:"gid"`
+	Admins  []string `yaml:"admins"`
+	Members []string `yaml:"members"`
+	Groups  []Group  `yaml:"groups"`
+}
+
+// Group is a subgroup of a pirg, its members must be members of the pirg
+type Group struct {
+	Name    string   `yaml:"name"`
+	Gid     *int     `yaml:"gid"`
+	Members []string `yaml:"members"`
+}
+
+// Parse reads a manifest in YAML, or in JSON since it's also YAML.
+// Unknown fields are refused so that typos don't go unnoticed.
+func Parse(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks the rules that don't need the store
+func (m *Manifest) Validate() error {
+	users := make(map[string]bool)
+	for _, u := range m.Users {
+		if u.Username == "" {
+			return fmt.Errorf("%w: user without a username", ErrInvalid)
+		}
+		if users[u.Username] {
+			return fmt.Errorf("%w: user %s is listed twice", ErrInvalid, u.Username)
+		}
+		users[u.Username] = true
+	}
+	pirgs := make(map[string]bool)
+	for _, p := range m.Pirgs {
+		if p.Name == "" {
+			return fmt.Errorf("%w: pirg without a name", ErrInvalid)
+		}
+		if pirgs[p.Name] {
+			return fmt.Errorf("%w: pirg %s is listed twice", ErrInvalid, p.Name)
+		}
+		pirgs[p.Name] = true
+		if p.Owner == "" {
+			return fmt.Errorf("%w: pirg %s has no owner", ErrInvalid, p.Name)
+		}
+		members := p.members()
+		groups := make(map[string]bool)
+		for _, g := range p.Groups {
+			if g.Name == "" {
+				return fmt.Errorf("%w: group without a name in pirg %s", ErrInvalid, p.Name)
+			}
+			if groups[g.Name] {
+				return fmt.Errorf("%w: group %s is listed twice in pirg %s", ErrInvalid, g.Name, p.Name)
+			}
+			groups[g.Name] = true
+			for _, username := range g.Members {
+				if !slices.Contains(members, username) {
+					return fmt.Errorf("%w: %s is in group %s but isn't a member of pirg %s", ErrInvalid, username, g.Name, p.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// admins is the owner and the listed admins, sorted
+func (p *Pirg) admins() []string {
+	return sortedNames(append([]string{p.Owner}, p.Admins...))
+}
+
+// members is the admins and the listed members, sorted
+func (p *Pirg) members() []string {
+	return sortedNames(append(p.admins(), p.Members...))
+}
+
+func sortedNames(names []string) []string {
+	names = append([]string{}, names...)
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// Actions of a Change
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Kinds of a Change
+const (
+	KindUser  = "user"
+	KindPirg  = "pirg"
+	KindGroup = "group"
+)
+
+// FieldChange is a field of a record being created or updated. From is
+// left out for creates.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from,omitempty"`
+	To    any    `json:"to"`
+}
+
+// Change is a record to create, update or delete. Groups are named
+// pirg/group.
+type Change struct {
+	Action string        `json:"action"`
+	Kind   string        `json:"kind"`
+	Name   string        `json:"name"`
+	Fields []FieldChange `json:"fields,omitempty"`
+
+	apply func(ctx context.Context, tx data.Store) error
+}
+
+// Summary counts the changes in a plan by action
+type Summary struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+// PlanOptions are the choices a plan makes beyond the manifest
+type PlanOptions struct {
+	// Prune deletes the groups of listed pirgs and the users the manifest
+	// manages but doesn't list
+	Prune bool
+	// PrunePirgs deletes every pirg the manifest doesn't list, with its
+	// allocations. It's separate from Prune so a manifest of a few pirgs
+	// can't delete all the others by mistake.
+	PrunePirgs bool
+	// CallerId is the user asking for the plan, who is never pruned. It's
+	// 0 when there isn't one, such as from the command line.
+	CallerId int
+}
+
+// Plan is the list of changes that bring the store in line with a
+// manifest, in the order they're applied
+type Plan struct {
+	Prune      bool     `json:"prune"`
+	PrunePirgs bool     `json:"prune_pirgs"`
+	Applied    bool     `json:"applied"`
+	Summary    Summary  `json:"summary"`
+	Changes    []Change `json:"changes"`
+}
+
+func (p *Plan) add(c Change) {
+	switch c.Action {
+	case ActionCreate:
+		p.Summary.Create++
+	case ActionUpdate:
+		p.Summary.Update++
+	case ActionDelete:
+		p.Summary.Delete++
+	}
+	p.Changes = append(p.Changes, c)
+}
+
+// Apply plans the manifest and makes the changes in a single transaction,
+// so either all of them are made or none are
+func Apply(ctx context.Context, store data.Store, m *Manifest, opts PlanOptions) (*Plan, error) {
+	var plan *Plan
+	err := store.Transaction(ctx, func(tx data.Store) error {
+		var err error
+		if plan, err = NewPlan(ctx, tx, m, opts); err != nil {
+			return err
+		}
+		for _, c := range plan.Changes {
+			if err := c.apply(ctx, tx); err != nil {
+				return fmt.Errorf("%s %s %s: %w", c.Action, c.Kind, c.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.Applied = true
+	return plan, nil
+}
+
+// state is what the store has now, looked up by name
+type state struct {
+	users     map[string]*data.User
+	usernames map[int]string
+	pirgs     map[string]*data.Pirg
+	// groups is keyed by pirg id
+	groups map[int][]*data.PirgGroup
+}
+
+func loadState(ctx context.Context, store data.Store) (*state, error) {
+	s := &state{
+		users:     make(map[string]*data.User),
+		usernames: make(map[int]string),
+		pirgs:     make(map[string]*data.Pirg),
+		groups:    make(map[int][]*data.PirgGroup),
+	}
+	users, err := store.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		s.users[u.Username] = u
+		s.usernames[u.Id] = u.Username
+	}
+	pirgs, err := store.GetAllPirgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pirgs {
+		s.pirgs[p.Name] = p
+	}
+	groups, err := store.GetAllPirgGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		s.groups[g.PirgId] = append(s.groups[g.PirgId], g)
+	}
+	return s, nil
+}
+
+func (s *state) names(ids []int) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, s.usernames[id])
+	}
+	return sortedNames(names)
+}
+
+// NewPlan compares the manifest with the store. When pruning, groups of
+// listed pirgs that aren't in the manifest are deleted, so every user a
+// pirg references must then be listed too. Only the users the manifest
+// manages are pruned: institutional users in a listed pirg. The caller and
+// holders of admin api keys are never pruned, the plan is refused if it
+// would delete them. Pirgs that aren't listed are only deleted with
+// PrunePirgs.
+func NewPlan(ctx context.Context, store data.Store, m *Manifest, opts PlanOptions) (*Plan, error) {
+	prune := opts.Prune
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	s, err := loadState(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool)
+	for _, u := range m.Users {
+		listed[u.Username] = true
+	}
+	for _, p := range m.Pirgs {
+		for _, username := range p.members() {
+			switch {
+			case prune && !listed[username]:
+				return nil, fmt.Errorf("%w: %s is in pirg %s but isn't listed, so it would be pruned", ErrInvalid, username, p.Name)
+			case !listed[username] && s.users[username] == nil:
+				return nil, fmt.Errorf("%w: %s is in pirg %s but doesn't exist", ErrInvalid, username, p.Name)
+			}
+		}
+	}
+
+	plan := &Plan{Prune: prune, PrunePirgs: opts.PrunePirgs, Changes: []Change{}}
+	for _, u := range m.Users {
+		planUser(plan, s, u)
+	}
+	for _, p := range m.Pirgs {
+		planPirg(plan, s, p)
+	}
+	for _, p := range m.Pirgs {
+		planGroups(plan, s, p, prune)
+	}
+	if opts.PrunePirgs {
+		pirgs := make(map[string]bool)
+		for _, p := range m.Pirgs {
+			pirgs[p.Name] = true
+		}
+		for _, name := range sortedKeys(s.pirgs) {
+			if pirgs[name] {
+				continue
+			}
+			id := s.pirgs[name].Id
+			plan.add(Change{Action: ActionDelete, Kind: KindPirg, Name: name, apply: func(ctx context.Context, tx data.Store) error {
+				return tx.DeletePirg(ctx, id)
+			}})
+		}
+	}
+	if !prune {
+		return plan, nil
+	}
+
+	admins, err := store.GetAdminUserIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[int]bool)
+	for _, p := range m.Pirgs {
+		if cur := s.pirgs[p.Name]; cur != nil {
+			for _, id := range cur.UserIds {
+				managed[id] = true
+			}
+		}
+	}
+	for _, username := range sortedKeys(s.users) {
+		u := s.users[username]
+		if listed[username] || u.Type != data.UserInstitutional || !managed[u.Id] {
+			continue
+		}
+		switch {
+		case u.Id == opts.CallerId:
+			return nil, fmt.Errorf("%w: %s is making the request but isn't listed, so it would be pruned", ErrInvalid, username)
+		case slices.Contains(admins, u.Id):
+			return nil, fmt.Errorf("%w: %s holds an admin api key but isn't listed, so it would be pruned", ErrInvalid, username)
+		}
+		id := u.Id
+		plan.add(Change{Action: ActionDelete, Kind: KindUser, Name: username, apply: func(ctx context.Context, tx data.Store) error {
+			return tx.DeleteUser(ctx, id)
+		}})
+	}
+	return plan, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diff collects the fields of a record that change
+type diff []FieldChange
+
+func (d *diff) add(field string, from any, to any) {
+	*d = append(*d, FieldChange{Field: field, From: from, To: to})
+}
+
+func (d *diff) set(field string, to any) {
+	*d = append(*d, FieldChange{Field: field, To: to})
+}
+
+// intValue turns a nil pointer into a nil interface so it's left out
+func intValue(i *int) any {
+	if i == nil {
+		return nil
+	}
+	return *i
+}
+
+func sameInt(a *int, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func planUser(plan *Plan, s *state, u User) {
+	req := &data.UserRequest{Username: u.Username, Email: u.Email, FirstName: u.FirstName, LastName: u.LastName, Uid: u.Uid}
+	cur := s.users[u.Username]
+	var fields diff
+	if cur == nil {
+		fields.set("email", u.Email)
+		fields.set("first_name", u.FirstName)
+		fields.set("last_name", u.LastName)
+		if u.Uid != nil {
+			fields.set("uid", *u.Uid)
+		}
+		plan.add(Change{Action: ActionCreate, Kind: KindUser, Name: u.Username, Fields: fields, apply: func(ctx context.Context, tx data.Store) error {
+			_, err := tx.CreateUser(ctx, req)
+			return err
+		}})
+		return
+	}
+	if cur.Email != u.Email {
+		fields.add("email", cur.Email, u.Email)
+	}
+	if cur.FirstName != u.FirstName {
+		fields.add("first_name", cur.FirstName, u.FirstName)
+	}
+	if cur.LastName != u.LastName {
+		fields.add("last_name", cur.LastName, u.LastName)
+	}
+	// guests are managed through the api, so their sponsor and expiry are kept
+	req.Type, req.SponsorId, req.ExpiresAt = cur.Type, cur.SponsorId, cur.ExpiresAt
+	if u.Uid == nil {
+		req.Uid = cur.Uid
+	} else if !sameInt(cur.Uid, u.Uid) {
+		fields.add("uid", intValue(cur.Uid), *u.Uid)
+	}
+	if len(fields) == 0 {
+		return
+	}
+	id := cur.Id
+	plan.add(Change{Action: ActionUpdate, Kind: KindUser, Name: u.Username, Fields: fields, apply: func(ctx context.Context, tx data.Store) error {
+		return tx.UpdateUser(ctx, id, req)
+	}})
+}
+
+// userIds looks up users by name when the change is applied, since they
+// may have been created earlier in the same plan
+func userIds(ctx context.Context, tx data.Store, usernames []string) ([]int, error) {
+	ids := make([]int, 0, len(usernames))
+	for _, username := range usernames {
+		u, err := tx.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", username, err)
+		}
+		ids = append(ids, u.Id)
+	}
+	return ids, nil
+}
+
+func planPirg(plan *Plan, s *state, p Pirg) {
+	admins, members := p.admins(), p.members()
+	cur := s.pirgs[p.Name]
+	gid := p.Gid
+	var fields diff
+	action, id := ActionCreate, 0
+	if cur == nil {
+		fields.set("owner", p.Owner)
+		if p.Gid != nil {
+			fields.set("gid", *p.Gid)
+		}
+		fields.set("admins", admins)
+		fields.set("members", members)
+	} else {
+		action, id = ActionUpdate, cur.Id
+		if owner := s.usernames[cur.OwnerId]; owner != p.Owner {
+			fields.add("owner", owner, p.Owner)
+		}
+		if gid == nil {
+			gid = cur.Gid
+		} else if !sameInt(cur.Gid, gid) {
+			fields.add("gid", intValue(cur.Gid), *gid)
+		}
+		if curAdmins := s.names(cur.AdminIds); !slices.Equal(curAdmins, admins) {
+			fields.add("admins", curAdmins, admins)
+		}
+		if curMembers := s.names(cur.UserIds); !slices.Equal(curMembers, members) {
+			fields.add("members", curMembers, members)
+		}
+		if len(fields) == 0 {
+			return
+		}
+	}
+	plan.add(Change{Action: action, Kind: KindPirg, Name: p.Name, Fields: fields, apply: func(ctx context.Context, tx data.Store) error {
+		req := &data.PirgRequest{Name: p.Name, Gid: gid}
+		owner, err := userIds(ctx, tx, []string{p.Owner})
+		if err != nil {
+			return err
+		}
+		req.OwnerId = owner[0]
+		if req.AdminIds, err = userIds(ctx, tx, admins); err != nil {
+			return err
+		}
+		if req.UserIds, err = userIds(ctx, tx, members); err != nil {
+			return err
+		}
+		if action == ActionCreate {
+			_, err = tx.CreatePirg(ctx, req)
+		} else {
+			_, err = tx.UpdatePirg(ctx, id, req)
+		}
+		return err
+	}})
+}
+
+func planGroups(plan *Plan, s *state, p Pirg, prune bool) {
+	var current []*data.PirgGroup
+	if cur := s.pirgs[p.Name]; cur != nil {
+		current = s.groups[cur.Id]
+	}
+	listed := make(map[string]bool)
+	for _, g := range p.Groups {
+		listed[g.Name] = true
+		var cur *data.PirgGroup
+		if idx := slices.IndexFunc(current, func(cur *data.PirgGroup) bool { return cur.Name == g.Name }); idx >= 0 {
+			cur = current[idx]
+		}
+		planGroup(plan, s, p.Name, g, cur)
+	}
+	if !prune {
+		return
+	}
+	for _, cur := range current {
+		if listed[cur.Name] {
+			continue
+		}
+		id := cur.Id
+		plan.add(Change{Action: ActionDelete, Kind: KindGroup, Name: p.Name + "/" + cur.Name, apply: func(ctx context.Context, tx data.Store) error {
+			return tx.DeletePirgGroup(ctx, id)
+		}})
+	}
+}
+
+func planGroup(plan *Plan, s *state, pirgName string, g Group, cur *data.PirgGroup) {
+	members := sortedNames(g.Members)
+	gid := g.Gid
+	var fields diff
+	action, id := ActionCreate, 0
+	if cur == nil {
+		if g.Gid != nil {
+			fields.set("gid", *g.Gid)
+		}
+		fields.set("members", members)
+	} else {
+		action, id = ActionUpdate, cur.Id
+		if gid == nil {
+			gid = cur.Gid
+		} else if !sameInt(cur.Gid, gid) {
+			fields.add("gid", intValue(cur.Gid), *gid)
+		}
+		if curMembers := s.names(cur.UserIds); !slices.Equal(curMembers, members) {
+			fields.add("members", curMembers, members)
+		}
+		if len(fields) == 0 {
+			return
+		}
+	}
+	plan.add(Change{Action: action, Kind: KindGroup, Name: pirgName + "/" + g.Name, Fields: fields, apply: func(ctx context.Context, tx data.Store) error {
+		req := &data.PirgGroupRequest{Name: g.Name, Gid: gid}
+		var err error
+		if req.UserIds, err = userIds(ctx, tx, members); err != nil {
+			return err
+		}
+		if action == ActionUpdate {
+			_, err = tx.UpdatePirgGroup(ctx, id, req)
+			return err
+		}
+		pirg, err := tx.GetPirgByName(ctx, pirgName)
+		if err != nil {
+			return fmt.Errorf("pirg %s: %w", pirgName, err)
+		}
+		_, err = tx.CreatePirgGroup(ctx, pirg.Id, req)
+		return err
+	}})
+}
@@ -0,0 +1,314 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+const testManifest = `
+users:
+  - username: marka
+    email: marka@example.org
+    first_name: Mark
+    last_name: Allen
+  - username: student
+    email: student@example.org
+    first_name: Some
+    last_name: Student
+    uid: 5100
+pirgs:
+  - name: racs
+    owner: marka
+    gid: 7100
+    members: [student]
+    groups:
+      - name: racs-students
+        members: [student]
+`
+
+func mustParse(t *testing.T, manifest string) *Manifest {
+	t.Helper()
+	m, err := Parse(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// summarize lists the changes as "action kind name" for comparing
+func summarize(plan *Plan) []string {
+	var changes []string
+	for _, c := range plan.Changes {
+		changes = append(changes, c.Action+" "+c.Kind+" "+c.Name)
+	}
+	return changes
+}
+
+func TestPlanAndApply(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	marka, err := store.CreateUser(ctx, &data.UserRequest{Username: "marka", Email: "old@example.org", FirstName: "Mark", LastName: "Allen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaving, err := store.CreateUser(ctx, &data.UserRequest{Username: "leaving", Email: "leaving@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "old", OwnerId: marka.Id, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id, leaving.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mustParse(t, testManifest)
+
+	plan, err := NewPlan(ctx, store, m, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"update user marka", "create user student", "create pirg racs", "create group racs/racs-students"}
+	if got := summarize(plan); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected plan %v, want %v", got, want)
+	}
+	if plan.Summary != (Summary{Create: 3, Update: 1}) || plan.Applied {
+		t.Errorf("unexpected summary %+v", plan.Summary)
+	}
+	if fields := plan.Changes[0].Fields; len(fields) != 1 || fields[0] != (FieldChange{Field: "email", From: "old@example.org", To: "marka@example.org"}) {
+		t.Errorf("expected only the email to change, got %+v", fields)
+	}
+	if fields := plan.Changes[2].Fields; !reflect.DeepEqual(fields[3], FieldChange{Field: "members", To: []string{"marka", "student"}}) {
+		t.Errorf("expected the owner to be a member, got %+v", fields)
+	}
+	// planning doesn't change anything
+	if _, err := store.GetPirgByName(ctx, "racs"); !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("expected racs not to exist yet, got %v", err)
+	}
+
+	plan, err = Apply(ctx, store, m, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Applied || len(plan.Changes) != 4 {
+		t.Errorf("expected the plan to be applied, got %+v", plan)
+	}
+	racs, err := store.GetPirgByName(ctx, "racs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *racs.Gid != 7100 || racs.OwnerId != marka.Id || len(racs.AdminIds) != 1 || len(racs.UserIds) != 2 {
+		t.Errorf("unexpected pirg %+v", racs)
+	}
+	groups, err := store.GetPirgGroups(ctx, racs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "racs-students" || len(groups[0].UserIds) != 1 {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		plan, err := NewPlan(ctx, store, m, PlanOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Changes) != 0 {
+			t.Errorf("expected nothing to change, got %v", summarize(plan))
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		if _, err := store.CreatePirgGroup(ctx, racs.Id, &data.PirgGroupRequest{Name: "racs-extra"}); err != nil {
+			t.Fatal(err)
+		}
+		// leaving is in a listed pirg, so the manifest manages them
+		addMember(t, store, "racs", leaving.Id)
+		// users outside of the listed pirgs are left alone
+		outside, err := store.CreateUser(ctx, &data.UserRequest{Username: "outside", Email: "outside@example.org"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := Apply(ctx, store, m, PlanOptions{Prune: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"update pirg racs", "delete group racs/racs-extra", "delete user leaving"}
+		if got := summarize(plan); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected plan %v, want %v", got, want)
+		}
+		if _, err := store.GetUserById(ctx, leaving.Id); !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("expected leaving to be deleted, got %v", err)
+		}
+		if _, err := store.GetUserById(ctx, outside.Id); err != nil {
+			t.Errorf("expected outside to be kept, got %v", err)
+		}
+		// pirgs that aren't listed are only deleted with PrunePirgs
+		if _, err := store.GetPirgById(ctx, old.Id); err != nil {
+			t.Errorf("expected old to be kept, got %v", err)
+		}
+	})
+
+	t.Run("PrunePirgs", func(t *testing.T) {
+		plan, err := Apply(ctx, store, m, PlanOptions{PrunePirgs: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"delete pirg old"}
+		if got := summarize(plan); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected plan %v, want %v", got, want)
+		}
+		if _, err := store.GetPirgById(ctx, old.Id); !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("expected old to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("RolledBack", func(t *testing.T) {
+		// the second pirg can't take the gid of the first, so nothing is kept
+		m := mustParse(t, testManifest+`
+  - name: clash
+    owner: marka
+    gid: 7100
+`)
+		m.Users[0].Email = "rolledback@example.org"
+		if _, err := Apply(ctx, store, m, PlanOptions{}); !errors.Is(err, data.ErrConflict) {
+			t.Fatalf("expected a conflict, got %v", err)
+		}
+		got, err := store.GetUserById(ctx, marka.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Email != "marka@example.org" {
+			t.Errorf("expected the email change to be rolled back, got %q", got.Email)
+		}
+		if _, err := store.GetPirgByName(ctx, "clash"); !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("expected clash not to be created, got %v", err)
+		}
+	})
+}
+
+func TestPruneProtectedUsers(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	user := func(req *data.UserRequest) *data.User {
+		req.Email = req.Username + "@example.org"
+		u, err := store.CreateUser(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	marka, operator, caller := user(&data.UserRequest{Username: "marka"}), user(&data.UserRequest{Username: "operator"}), user(&data.UserRequest{Username: "caller"})
+	service := user(&data.UserRequest{Username: "backups", Type: data.UserService})
+	if _, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "racs", OwnerId: marka.Id, AdminIds: []int{marka.Id}, UserIds: []int{marka.Id, operator.Id, caller.Id, service.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	guest := user(&data.UserRequest{Username: "guest", Type: data.UserGuest, SponsorId: &marka.Id, ExpiresAt: &expiresAt})
+	addMember(t, store, "racs", guest.Id)
+	if _, err := store.CreateAPIKey(ctx, &data.APIKeyRequest{Key: "adminkey", Role: "admin", UserId: operator.Id}); err != nil {
+		t.Fatal(err)
+	}
+	m := mustParse(t, `
+users:
+  - username: marka
+    email: marka@example.org
+pirgs:
+  - name: racs
+    owner: marka
+`)
+	if _, err := Apply(ctx, store, m, PlanOptions{Prune: true}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected pruning a holder of an admin key to be refused, got %v", err)
+	}
+	if err := store.DeleteAPIKey(ctx, "adminkey"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(ctx, store, m, PlanOptions{Prune: true, CallerId: caller.Id}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected pruning the caller to be refused, got %v", err)
+	}
+
+	plan, err := Apply(ctx, store, m, PlanOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"update pirg racs", "delete user caller", "delete user operator"}
+	if got := summarize(plan); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected plan %v, want %v", got, want)
+	}
+	for _, u := range []*data.User{service, guest} {
+		if _, err := store.GetUserById(ctx, u.Id); err != nil {
+			t.Errorf("expected %s to be kept, got %v", u.Username, err)
+		}
+	}
+}
+
+// addMember adds the user to the pirg in the store
+func addMember(t *testing.T, store data.Store, pirg string, userId int) {
+	t.Helper()
+	ctx := context.Background()
+	p, err := store.GetPirgByName(ctx, pirg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := &data.PirgRequest{Name: p.Name, OwnerId: p.OwnerId, Gid: p.Gid, AdminIds: p.AdminIds, UserIds: append(p.UserIds, userId)}
+	if _, err := store.UpdatePirg(ctx, p.Id, pr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanUnknownUsers(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	if _, err := store.CreateUser(ctx, &data.UserRequest{Username: "unlisted", Email: "unlisted@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	m := mustParse(t, `
+pirgs:
+  - name: racs
+    owner: unlisted
+`)
+	if _, err := NewPlan(ctx, store, m, PlanOptions{}); err != nil {
+		t.Errorf("expected users in the store to be usable without pruning, got %v", err)
+	}
+	if _, err := NewPlan(ctx, store, m, PlanOptions{Prune: true}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected unlisted users to be refused when pruning, got %v", err)
+	}
+	m.Pirgs[0].Owner = "nobody"
+	if _, err := NewPlan(ctx, store, m, PlanOptions{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a missing user to be refused, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse(strings.NewReader(`{"users": [{"username": "marka", "email": "marka@example.org"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Users) != 1 || m.Users[0].Email != "marka@example.org" {
+		t.Errorf("unexpected manifest from json %+v", m)
+	}
+	if m, err := Parse(strings.NewReader("")); err != nil || len(m.Users)+len(m.Pirgs) != 0 {
+		t.Errorf("expected an empty manifest, got %+v, %v", m, err)
+	}
+	invalid := map[string]string{
+		"UnknownField":     "users:\n  - username: marka\n    mail: marka@example.org\n",
+		"DuplicateUser":    "users:\n  - username: marka\n  - username: marka\n",
+		"NoOwner":          "pirgs:\n  - name: racs\n",
+		"DuplicateGroup":   "pirgs:\n  - name: racs\n    owner: marka\n    groups: [{name: a}, {name: a}]\n",
+		"GroupNonMember":   "pirgs:\n  - name: racs\n    owner: marka\n    groups: [{name: a, members: [student]}]\n",
+		"DuplicatePirg":    "pirgs:\n  - {name: racs, owner: marka}\n  - {name: racs, owner: marka}\n",
+		"UserWithoutName":  "users:\n  - email: marka@example.org\n",
+		"NotAManifest":     "- marka\n",
+		"GroupWithoutName": "pirgs:\n  - name: racs\n    owner: marka\n    groups: [{members: [marka]}]\n",
+	}
+	for name, manifest := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(manifest)); !errors.Is(err, ErrInvalid) {
+				t.Errorf("expected an invalid manifest, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+const namespace = "hpcadmin"
+
+// DefaultRefreshInterval is how often the domain gauges are recalculated
+const DefaultRefreshInterval = time.Minute
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method, route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// AuthCacheLookups counts auth cache lookups by cache ("api_key" or "jwt")
+	// and result ("hit" or "miss")
+	AuthCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_cache_lookups_total",
+		Help:      "Auth cache lookups, by cache and result.",
+	}, []string{"cache", "result"})
+
+	// RateLimited counts requests turned away with 429, by reason
+	// ("rate_limit" or "lockout")
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by rate limiting or failed-auth lockout, by reason.",
+	}, []string{"reason"})
+
+	Users = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "users",
+		Help:      "Number of users.",
+	})
+
+	Pirgs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pirgs",
+		Help:      "Number of pirgs.",
+	})
+
+	PendingAccessRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_access_requests",
+		Help:      "Number of members waiting on a decision in open access reviews.",
+	})
+)
+
+// NewRegistry returns a registry with the server's collectors along with
+// go runtime, process and database pool stats
+func NewRegistry(db *sql.DB) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		AuthCacheLookups,
+		RateLimited,
+		Users,
+		Pirgs,
+		PendingAccessRequests,
+	)
+	if db != nil {
+		reg.MustRegister(collectors.NewDBStatsCollector(db, "hpcadmin"))
+	}
+	return reg
+}
+
+// Handler serves the registry in the prometheus text format
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+// Middleware records request counts and latency. Requests are labelled
+// with the chi route pattern rather than the path so ids in the url
+// don't create a series per record.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			// nothing was written, net/http sends a 200
+			status = http.StatusOK
+		}
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		HTTPRequests.With(labels).Inc()
+		HTTPRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RefreshDomainGauges recalculates the domain gauges every interval until
+// ctx is done. It's meant to be run as a background worker.
+func RefreshDomainGauges(ctx context.Context, store data.Store, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshDomainGauges(ctx, store)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refreshDomainGauges(ctx context.Context, store data.Store) {
+	gauges := []struct {
+		name  string
+		gauge prometheus.Gauge
+		count func(context.Context) (int, error)
+	}{
+		{"users", Users, store.CountUsers},
+		{"pirgs", Pirgs, store.CountPirgs},
+		{"pending access requests", PendingAccessRequests, store.CountPendingAccessRequests},
+	}
+	for _, g := range gauges {
+		count, err := g.count(ctx)
+		if err != nil {
+			slog.Warn("failed to count "+g.name+" for metrics", "error", err, "package", "metrics", "method", "refreshDomainGauges")
+			continue
+		}
+		g.gauge.Set(float64(count))
+	}
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestMiddlewareLabelsByRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Route("/things", func(r chi.Router) {
+		r.Get("/{thingID}", func(w http.ResponseWriter, r *http.Request) {})
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})
+	})
+
+	for _, path := range []string{"/things/1", "/things/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/things/", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nothing", nil))
+
+	if got := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/things/{thingID}", "200")); got != 2 {
+		t.Errorf("expected 2 requests for /things/{thingID}, got %v", got)
+	}
+	if got := testutil.ToFloat64(HTTPRequests.WithLabelValues("POST", "/things", "201")); got != 1 {
+		t.Errorf("expected 1 created request for /things, got %v", got)
+	}
+	if got := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "unmatched", "404")); got != 1 {
+		t.Errorf("expected 1 unmatched request, got %v", got)
+	}
+}
+
+func TestHandlerServesRegistry(t *testing.T) {
+	reg := NewRegistry(nil)
+	AuthCacheLookups.WithLabelValues("api_key", "hit").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"hpcadmin_auth_cache_lookups_total", "hpcadmin_users", "go_goroutines"} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("expected %s in metrics output", name)
+		}
+	}
+}
+
+func TestRefreshDomainGauges(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	owner, err := store.CreateUser(ctx, &data.UserRequest{Username: "owner", Email: "owner@localhost", FirstName: "Pirg", LastName: "Owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := store.CreateUser(ctx, &data.UserRequest{Username: "member", Email: "member@localhost", FirstName: "Pirg", LastName: "Member"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "pirg", OwnerId: owner.Id, UserIds: []int{owner.Id, member.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateAccessReview(ctx, &data.AccessReviewRequest{Name: "review", Deadline: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		RefreshDomainGauges(ctx, store, time.Hour)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(Users) != 2 || testutil.ToFloat64(Pirgs) != 1 || testutil.ToFloat64(PendingAccessRequests) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("gauges weren't refreshed, users=%v pirgs=%v pending=%v", testutil.ToFloat64(Users), testutil.ToFloat64(Pirgs), testutil.ToFloat64(PendingAccessRequests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,166 @@
+// Package notifications emails users when something happens to their
+// account. Messages are queued in the database outbox by a Notifier and
+// sent by a Service running in the background, so a slow or broken mail
+// server never holds up a request.
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// The events that send email. Each has a template of the same name.
+const (
+	EventPirgMemberAdded      = "pirg_member_added"
+	EventAccessRequestPending = "access_request_pending"
+	EventAccessRequestDecided = "access_request_decided"
+	EventMembershipExpiring   = "membership_expiring"
+	EventQuotaExceeded        = "quota_exceeded"
+)
+
+// Events lists every event, in the order they're documented
+var Events = []string{
+	EventPirgMemberAdded,
+	EventAccessRequestPending,
+	EventAccessRequestDecided,
+	EventMembershipExpiring,
+	EventQuotaExceeded,
+}
+
+// Notifier queues notifications in the outbox. A nil Notifier queues
+// nothing, so callers don't need to check whether email is enabled.
+type Notifier struct {
+	store data.NotificationStore
+}
+
+func NewNotifier(store data.NotificationStore) *Notifier {
+	return &Notifier{store: store}
+}
+
+// enqueue adds the notification to the outbox. One that was already queued
+// with the same dedupe key isn't an error.
+func (n *Notifier) enqueue(ctx context.Context, nr *data.NotificationRequest) error {
+	if n == nil {
+		return nil
+	}
+	if _, err := n.store.EnqueueNotification(ctx, nr); err != nil && !(nr.DedupeKey != "" && errors.Is(err, data.ErrConflict)) {
+		return fmt.Errorf("failed to queue %s notification for %s: %w", nr.Event, nr.Recipient, err)
+	}
+	return nil
+}
+
+func displayName(u *data.User) string {
+	if u.FirstName != "" {
+		return u.FirstName
+	}
+	return u.Username
+}
+
+// PirgMemberAdded tells the user they were added to the pirg
+func (n *Notifier) PirgMemberAdded(ctx context.Context, pirg *data.Pirg, owner *data.User, user *data.User) error {
+	return n.enqueue(ctx, &data.NotificationRequest{
+		Event:     EventPirgMemberAdded,
+		Recipient: user.Email,
+		Params: map[string]string{
+			"name":     displayName(user),
+			"username": user.Username,
+			"pirg":     pirg.Name,
+			"owner":    owner.Username,
+		},
+	})
+}
+
+// AccessRequestPending asks the pirg's owner to confirm or remove the
+// pending members of the pirg before the review's deadline. It's sent once
+// for each review.
+func (n *Notifier) AccessRequestPending(ctx context.Context, review *data.AccessReview, pirg *data.Pirg, owner *data.User, pending int) error {
+	return n.enqueue(ctx, &data.NotificationRequest{
+		Event:     EventAccessRequestPending,
+		Recipient: owner.Email,
+		Params: map[string]string{
+			"name":     displayName(owner),
+			"pirg":     pirg.Name,
+			"review":   review.Name,
+			"pending":  strconv.Itoa(pending),
+			"deadline": review.Deadline.UTC().Format(time.DateOnly),
+		},
+		DedupeKey: fmt.Sprintf("%s:%d:%d", EventAccessRequestPending, review.Id, pirg.Id),
+	})
+}
+
+// AccessRequestDecided tells the member whether their access to the pirg
+// was confirmed or removed during the review. It's sent once for each
+// decision.
+func (n *Notifier) AccessRequestDecided(ctx context.Context, review *data.AccessReview, pirg *data.Pirg, user *data.User, decision string) error {
+	return n.enqueue(ctx, &data.NotificationRequest{
+		Event:     EventAccessRequestDecided,
+		Recipient: user.Email,
+		Params: map[string]string{
+			"name":     displayName(user),
+			"pirg":     pirg.Name,
+			"review":   review.Name,
+			"decision": decision,
+		},
+		DedupeKey: fmt.Sprintf("%s:%d:%d:%d:%s", EventAccessRequestDecided, review.Id, pirg.Id, user.Id, decision),
+	})
+}
+
+// MembershipExpiring warns the member that their membership of the pirg
+// expires soon. It's sent once for each expiry date.
+func (n *Notifier) MembershipExpiring(ctx context.Context, pirg *data.Pirg, user *data.User, m *data.Membership) error {
+	return n.enqueue(ctx, &data.NotificationRequest{
+		Event:     EventMembershipExpiring,
+		Recipient: user.Email,
+		Params: map[string]string{
+			"name":       displayName(user),
+			"pirg":       pirg.Name,
+			"expires_at": m.ExpiresAt.UTC().Format(time.DateOnly),
+		},
+		DedupeKey: fmt.Sprintf("%s:%d:%d:%d", EventMembershipExpiring, m.PirgId, m.UserId, m.ExpiresAt.Unix()),
+	})
+}
+
+// QuotaExceeded tells the pirg's owner that a storage allocation is over
+// its soft or hard limit. It's sent at most once a day for each limit.
+func (n *Notifier) QuotaExceeded(ctx context.Context, pirg *data.Pirg, owner *data.User, status *data.StorageStatus) error {
+	level, limit := "soft", status.Allocation.SoftLimitBytes
+	if status.OverHard {
+		level, limit = "hard", status.Allocation.HardLimitBytes
+	}
+	day := time.Now()
+	if status.SampledAt != nil {
+		day = *status.SampledAt
+	}
+	return n.enqueue(ctx, &data.NotificationRequest{
+		Event:     EventQuotaExceeded,
+		Recipient: owner.Email,
+		Params: map[string]string{
+			"name":  displayName(owner),
+			"pirg":  pirg.Name,
+			"path":  status.Allocation.Path,
+			"level": level,
+			"used":  formatBytes(status.UsedBytes),
+			"limit": formatBytes(limit),
+		},
+		DedupeKey: fmt.Sprintf("%s:%d:%s:%s", EventQuotaExceeded, status.Allocation.Id, level, day.UTC().Format(time.DateOnly)),
+	})
+}
+
+// formatBytes writes a size in binary units, such as 1.5 TiB
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,223 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+const (
+	// DefaultPollInterval is how often the outbox is checked when it isn't configured
+	DefaultPollInterval = 30 * time.Second
+	// DefaultMaxAttempts is how many times a message is tried when it isn't configured
+	DefaultMaxAttempts = 8
+	// DefaultExpiringDays is how early members are warned when it isn't configured
+	DefaultExpiringDays = 14
+	// CheckInterval is how often expiring memberships and quotas are checked
+	CheckInterval = time.Hour
+
+	// claimLease is how long a claimed message is held before another
+	// server may try it
+	claimLease = 5 * time.Minute
+	// batchSize is the most messages sent each poll
+	batchSize = 50
+	// sendTimeout bounds a single smtp conversation
+	sendTimeout = time.Minute
+	// retryBase is the wait after the first failure, doubling after each
+	// one up to retryMax
+	retryBase = time.Minute
+	retryMax  = 6 * time.Hour
+)
+
+// Service sends the outbox and queues the notifications that come from
+// deadlines rather than requests
+type Service struct {
+	store        data.Store
+	sender       Sender
+	templates    *Templates
+	notifier     *Notifier
+	baseURL      string
+	pollInterval time.Duration
+	maxAttempts  int
+	expiringDays int
+}
+
+func NewService(store data.Store, sender Sender, templates *Templates, cfg config.NotificationsConfig, baseURL string) *Service {
+	s := &Service{
+		store:        store,
+		sender:       sender,
+		templates:    templates,
+		notifier:     NewNotifier(store),
+		baseURL:      baseURL,
+		pollInterval: cfg.PollInterval,
+		maxAttempts:  cfg.MaxAttempts,
+		expiringDays: cfg.ExpiringDays,
+	}
+	if s.pollInterval == 0 {
+		s.pollInterval = DefaultPollInterval
+	}
+	if s.maxAttempts == 0 {
+		s.maxAttempts = DefaultMaxAttempts
+	}
+	if s.expiringDays == 0 {
+		s.expiringDays = DefaultExpiringDays
+	}
+	return s
+}
+
+// Run sends the outbox every poll interval and checks for expiring
+// memberships and quotas every CheckInterval until ctx is done. It's meant
+// to be run as a background worker.
+func (s *Service) Run(ctx context.Context) {
+	poll := time.NewTicker(s.pollInterval)
+	defer poll.Stop()
+	check := time.NewTicker(CheckInterval)
+	defer check.Stop()
+	s.runCheck(ctx)
+	for {
+		s.runDeliver(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-check.C:
+			s.runCheck(ctx)
+		}
+	}
+}
+
+func (s *Service) runDeliver(ctx context.Context) {
+	if _, err := s.Deliver(ctx, time.Now()); err != nil {
+		slog.Warn("failed to send notifications", "error", err, "package", "notifications", "method", "Run")
+	}
+}
+
+func (s *Service) runCheck(ctx context.Context) {
+	if err := s.Check(ctx, time.Now()); err != nil {
+		slog.Warn("failed to check for notifications", "error", err, "package", "notifications", "method", "Run")
+	}
+}
+
+// Deliver sends the messages in the outbox that are due by now and returns
+// how many were sent. Failed messages are retried with a growing wait
+// until they've been tried maxAttempts times.
+func (s *Service) Deliver(ctx context.Context, now time.Time) (int, error) {
+	notifications, err := s.store.ClaimNotifications(ctx, now, claimLease, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, n := range notifications {
+		if err := s.send(ctx, n); err != nil {
+			if err := s.fail(ctx, n, now, err); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		if err := s.store.MarkNotificationSent(ctx, n.Id); err != nil {
+			return sent, err
+		}
+		slog.Info("sent notification", "id", n.Id, "event", n.Event, "recipient", n.Recipient, "package", "notifications", "method", "Deliver")
+		sent++
+	}
+	return sent, nil
+}
+
+func (s *Service) send(ctx context.Context, n *data.Notification) error {
+	params := map[string]string{"base_url": s.baseURL}
+	for k, v := range n.Params {
+		params[k] = v
+	}
+	subject, body, err := s.templates.Render(n.Event, params)
+	if err != nil {
+		// a broken template won't fix itself
+		return &permanentError{err}
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	return s.sender.Send(ctx, &Message{To: n.Recipient, Subject: subject, Body: body})
+}
+
+// fail records why the notification wasn't sent and when to try it again
+func (s *Service) fail(ctx context.Context, n *data.Notification, now time.Time, sendErr error) error {
+	var retryAt *time.Time
+	if !isPermanent(sendErr) && n.Attempts < s.maxAttempts {
+		wait := retryBase << (n.Attempts - 1)
+		if wait <= 0 || wait > retryMax {
+			wait = retryMax
+		}
+		t := now.Add(wait)
+		retryAt = &t
+	}
+	slog.Warn("failed to send notification", "id", n.Id, "event", n.Event, "recipient", n.Recipient, "attempts", n.Attempts, "retry_at", retryAt, "error", sendErr, "package", "notifications", "method", "Deliver")
+	return s.store.MarkNotificationFailed(ctx, n.Id, sendErr.Error(), retryAt)
+}
+
+// Check queues warnings for memberships expiring in the next expiringDays
+// and for storage allocations over their limits. Each is only queued once,
+// so it's safe to check as often as needed.
+func (s *Service) Check(ctx context.Context, now time.Time) error {
+	if err := s.checkExpiring(ctx, now); err != nil {
+		return err
+	}
+	return s.checkQuotas(ctx, now)
+}
+
+func (s *Service) checkExpiring(ctx context.Context, now time.Time) error {
+	memberships, err := s.store.GetExpiringMemberships(ctx, now.AddDate(0, 0, s.expiringDays))
+	if err != nil {
+		return err
+	}
+	for _, m := range memberships {
+		// the sweeper removes ones that have already expired
+		if !m.ExpiresAt.After(now) {
+			continue
+		}
+		pirg, err := s.store.GetPirgById(ctx, m.PirgId)
+		if err != nil {
+			return err
+		}
+		user, err := s.store.GetUserById(ctx, m.UserId)
+		if err != nil {
+			return err
+		}
+		if err := s.notifier.MembershipExpiring(ctx, pirg, user, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) checkQuotas(ctx context.Context, now time.Time) error {
+	allocations, err := s.store.GetAllStorageAllocations(ctx)
+	if err != nil {
+		return err
+	}
+	from := now.AddDate(0, 0, -data.StorageTrendDays)
+	for _, allocation := range allocations {
+		samples, err := s.store.GetStorageUsage(ctx, allocation.PirgId, allocation.LocationId, from, now)
+		if err != nil {
+			return err
+		}
+		status := data.NewStorageStatus(allocation, samples)
+		if !status.OverSoft && !status.OverHard {
+			continue
+		}
+		pirg, err := s.store.GetPirgById(ctx, allocation.PirgId)
+		if err != nil {
+			return err
+		}
+		owner, err := s.store.GetUserById(ctx, pirg.OwnerId)
+		if err != nil {
+			return fmt.Errorf("failed to get owner of pirg %s: %w", pirg.Name, err)
+		}
+		if err := s.notifier.QuotaExceeded(ctx, pirg, owner, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
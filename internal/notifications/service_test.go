@@ -0,0 +1,177 @@
+package notifications
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func newTestService(t *testing.T, store data.Store, standIn *smtpStandIn, maxAttempts int) *Service {
+	t.Helper()
+	templates, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := NewSMTPSender("hpc@example.org", standIn.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NotificationsConfig{From: "hpc@example.org", SMTP: standIn.config(), MaxAttempts: maxAttempts}
+	return NewService(store, sender, templates, cfg, "https://hpcadmin.example.org")
+}
+
+func mustCreateUser(t *testing.T, store data.Store, username string) *data.User {
+	t.Helper()
+	u, err := store.CreateUser(context.Background(), &data.UserRequest{Username: username, Email: username + "@example.org", FirstName: strings.ToUpper(username[:1]) + username[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	standIn := newSMTPStandIn(t)
+	s := newTestService(t, store, standIn, 3)
+	owner, member := mustCreateUser(t, store, "marka"), mustCreateUser(t, store, "lcrown")
+	pirg, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "racs", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id, member.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewNotifier(store).PirgMemberAdded(ctx, pirg, owner, member); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sent, err := s.Deliver(ctx, now)
+	if err != nil || sent != 1 {
+		t.Fatalf("expected one notification to be sent, got %d: %v", sent, err)
+	}
+	messages, bodies := standIn.received()
+	if got := messages[0].Header.Get("Subject"); got != "You've been added to racs" {
+		t.Errorf("unexpected subject %q", got)
+	}
+	if !strings.HasPrefix(bodies[0], "Hello Lcrown,") || !strings.Contains(bodies[0], "marka added you (lcrown) to the racs PIRG") || !strings.Contains(bodies[0], "https://hpcadmin.example.org") {
+		t.Errorf("unexpected body:\n%s", bodies[0])
+	}
+	if sent, _ := s.Deliver(ctx, now.Add(time.Hour)); sent != 0 {
+		t.Errorf("expected a sent notification not to be sent again, sent %d", sent)
+	}
+
+	t.Run("Retry", func(t *testing.T) {
+		standIn.refuse("451 try again later")
+		if err := NewNotifier(store).PirgMemberAdded(ctx, pirg, owner, member); err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now()
+		if sent, err := s.Deliver(ctx, at); err != nil || sent != 0 {
+			t.Fatalf("expected the refused notification not to be sent, got %d: %v", sent, err)
+		}
+		if sent, _ := s.Deliver(ctx, at.Add(30*time.Second)); sent != 0 {
+			t.Fatalf("expected the notification to wait before it's retried, sent %d", sent)
+		}
+		if sent, err := s.Deliver(ctx, at.Add(retryBase)); err != nil || sent != 1 {
+			t.Fatalf("expected the notification to be sent on retry, got %d: %v", sent, err)
+		}
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		standIn.refuse("451 try again later", "451 try again later", "451 try again later", "451 try again later")
+		if err := NewNotifier(store).PirgMemberAdded(ctx, pirg, owner, member); err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now()
+		for i := 0; i < 5; i++ {
+			if sent, err := s.Deliver(ctx, at); err != nil || sent != 0 {
+				t.Fatalf("expected the refused notification not to be sent, got %d: %v", sent, err)
+			}
+			at = at.Add(retryMax)
+		}
+		// three attempts were refused, after which it was given up on
+		standIn.mu.Lock()
+		left := len(standIn.replies)
+		standIn.replies = nil
+		standIn.mu.Unlock()
+		if left != 1 {
+			t.Errorf("expected 3 attempts before giving up, %d replies are left", left)
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		standIn.refuse("550 no such user")
+		if err := NewNotifier(store).PirgMemberAdded(ctx, pirg, owner, member); err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now()
+		if sent, _ := s.Deliver(ctx, at); sent != 0 {
+			t.Fatalf("expected the rejected notification not to be sent, sent %d", sent)
+		}
+		if sent, _ := s.Deliver(ctx, at.Add(retryMax)); sent != 0 {
+			t.Fatalf("expected the rejected notification not to be retried, sent %d", sent)
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	s := newTestService(t, store, newSMTPStandIn(t), 0)
+	owner, student, later := mustCreateUser(t, store, "marka"), mustCreateUser(t, store, "student"), mustCreateUser(t, store, "later")
+	pirg, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "racs", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id, student.Id, later.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	soon, notSoon := now.AddDate(0, 0, 3), now.AddDate(0, 0, DefaultExpiringDays+1)
+	if _, err := store.SetMembershipTerm(ctx, pirg.Id, student.Id, &data.MembershipTerm{ExpiresAt: &soon}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetMembershipTerm(ctx, pirg.Id, later.Id, &data.MembershipTerm{ExpiresAt: &notSoon}); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := store.CreateLocation(ctx, &data.LocationRequest{Name: "/projects", Kind: "filesystem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocation, err := store.CreateStorageAllocation(ctx, pirg.Id, &data.StorageAllocationRequest{LocationId: fs.Id, Path: "/projects/racs", SoftLimitBytes: 1 << 40, HardLimitBytes: 2 << 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.RecordStorageUsage(ctx, []*data.StorageUsage{{PirgId: pirg.Id, LocationId: allocation.LocationId, UsedBytes: 3 << 39, SampledAt: now.Add(-time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checking again doesn't queue anything twice
+	for i := 0; i < 2; i++ {
+		if err := s.Check(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queued, err := store.ClaimNotifications(ctx, now.Add(time.Minute), time.Minute, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(queued))
+	}
+	expiring, quota := queued[0], queued[1]
+	if expiring.Event != EventMembershipExpiring || expiring.Recipient != student.Email || expiring.Params["expires_at"] != soon.UTC().Format(time.DateOnly) {
+		t.Errorf("unexpected expiring notification %+v", expiring)
+	}
+	if quota.Event != EventQuotaExceeded || quota.Recipient != owner.Email || quota.Params["level"] != "soft" || quota.Params["used"] != "1.5 TiB" || quota.Params["limit"] != "1.0 TiB" {
+		t.Errorf("unexpected quota notification %+v", quota)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	if err := n.PirgMemberAdded(context.Background(), &data.Pirg{Name: "racs"}, &data.User{Username: "marka"}, &data.User{Email: "lcrown@example.org"}); err != nil {
+		t.Errorf("expected a nil notifier to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// smtpStandIn is a local smtp server that records the messages it's sent.
+// RCPT is answered with the queued replies first, so tests can make the
+// server refuse mail.
+type smtpStandIn struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	replies  []string
+	messages []*mail.Message
+	bodies   []string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config points an smtp sender at the stand in
+func (s *smtpStandIn) config() config.SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return config.SMTPConfig{Host: host, Port: p}
+}
+
+// refuse queues replies to the next RCPT commands, such as "451 try again later"
+func (s *smtpStandIn) refuse(replies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = append(s.replies, replies...)
+}
+
+func (s *smtpStandIn) received() ([]*mail.Message, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*mail.Message(nil), s.messages...), append([]string(nil), s.bodies...)
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RSET"), strings.HasPrefix(cmd, "NOOP"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT"):
+			s.mu.Lock()
+			answer := "250 ok"
+			if len(s.replies) > 0 {
+				answer, s.replies = s.replies[0], s.replies[1:]
+			}
+			s.mu.Unlock()
+			reply(answer)
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(data.String()))
+			if err != nil {
+				reply("554 bad message")
+				continue
+			}
+			var body strings.Builder
+			bufio.NewReader(msg.Body).WriteTo(&body)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.bodies = append(s.bodies, body.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	ctx := context.Background()
+	standIn := newSMTPStandIn(t)
+	sender, err := NewSMTPSender("HPC Admin <hpc@example.org>", standIn.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sender.Send(ctx, &Message{To: "lcrown@example.org", Subject: "Your membership of racs expires soon", Body: "Hello,\n\n.leading dot\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, bodies := standIn.received()
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	h := messages[0].Header
+	if h.Get("From") != `"HPC Admin" <hpc@example.org>` || h.Get("To") != "<lcrown@example.org>" || h.Get("Subject") != "Your membership of racs expires soon" {
+		t.Errorf("unexpected headers %v", h)
+	}
+	if bodies[0] != "Hello,\r\n\r\n.leading dot\r\n" {
+		t.Errorf("unexpected body %q", bodies[0])
+	}
+
+	standIn.refuse("451 try again later", "550 no such user")
+	if err := sender.Send(ctx, &Message{To: "lcrown@example.org", Subject: "s", Body: "b"}); err == nil || isPermanent(err) {
+		t.Errorf("expected a temporary failure, got %v", err)
+	}
+	if err := sender.Send(ctx, &Message{To: "lcrown@example.org", Subject: "s", Body: "b"}); !isPermanent(err) {
+		t.Errorf("expected a permanent failure, got %v", err)
+	}
+	if err := sender.Send(ctx, &Message{To: "not an address", Subject: "s", Body: "b"}); !isPermanent(err) {
+		t.Errorf("expected a permanent failure for a bad recipient, got %v", err)
+	}
+	if _, err := NewSMTPSender("", standIn.config()); err == nil {
+		t.Error("expected error for an empty from address")
+	}
+}
@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Templates renders the subject and body of each event's email. Every
+// template defines a "subject" and a "body", and is given the
+// notification's params along with base_url.
+type Templates struct {
+	byEvent map[string]*template.Template
+}
+
+// LoadTemplates parses the built in templates, replacing any that have a
+// file of the same name in dir. An empty dir uses only the built in ones.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{byEvent: make(map[string]*template.Template)}
+	for _, event := range Events {
+		name := event + ".tmpl"
+		text, err := defaultTemplates.ReadFile("templates/" + name)
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			override, err := os.ReadFile(filepath.Join(dir, name))
+			if err == nil {
+				text = override
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to read template %s: %v", name, err)
+			}
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %v", name, err)
+		}
+		for _, part := range []string{"subject", "body"} {
+			if tmpl.Lookup(part) == nil {
+				return nil, fmt.Errorf("template %s doesn't define %q", name, part)
+			}
+		}
+		t.byEvent[event] = tmpl
+	}
+	return t, nil
+}
+
+// Render returns the subject and body of the email for the event
+func (t *Templates) Render(event string, params map[string]string) (string, string, error) {
+	tmpl, ok := t.byEvent[event]
+	if !ok {
+		return "", "", fmt.Errorf("no template for event %s", event)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", params); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", params); err != nil {
+		return "", "", err
+	}
+	// subjects can't span lines
+	return strings.Join(strings.Fields(subject.String()), " "), strings.TrimLeft(body.String(), "\n"), nil
+}
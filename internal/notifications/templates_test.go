@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	templates, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{
+		"name": "Lcrown", "username": "lcrown", "owner": "marka", "pirg": "racs", "review": "2024 spring",
+		"pending": "3", "deadline": "2024-05-01", "decision": "removed", "expires_at": "2024-12-14",
+		"path": "/projects/racs", "level": "hard", "used": "2.5 TiB", "limit": "2.0 TiB",
+		"base_url": "https://hpcadmin.example.org",
+	}
+	for _, event := range Events {
+		subject, body, err := templates.Render(event, params)
+		if err != nil {
+			t.Errorf("failed to render %s: %v", event, err)
+			continue
+		}
+		if subject == "" || strings.Contains(subject, "\n") || !strings.HasPrefix(body, "Hello Lcrown,") {
+			t.Errorf("unexpected %s email %q:\n%s", event, subject, body)
+		}
+	}
+	params["decision"] = "confirmed"
+	if subject, body, err := templates.Render(EventAccessRequestDecided, params); err != nil || subject != "Your access to racs was confirmed" || strings.Contains(body, "no longer") {
+		t.Errorf("expected a confirmation without the removal note, got %q: %v\n%s", subject, err, body)
+	}
+	if _, _, err := templates.Render(EventPirgMemberAdded, map[string]string{"name": "Lcrown"}); err == nil {
+		t.Error("expected error rendering without every param")
+	}
+	if _, _, err := templates.Render("missing", params); err == nil {
+		t.Error("expected error rendering a missing event")
+	}
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	override := `{{define "subject"}}Welcome to {{.pirg}}{{end}}{{define "body"}}See {{.base_url}}/docs{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, EventPirgMemberAdded+".tmpl"), []byte(override), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, body, err := templates.Render(EventPirgMemberAdded, map[string]string{"pirg": "racs", "base_url": "https://hpc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Welcome to racs" || body != "See https://hpc/docs" {
+		t.Errorf("expected the override to be used, got %q %q", subject, body)
+	}
+	// events without an override keep the built in template
+	subject, _, err = templates.Render(EventMembershipExpiring, map[string]string{"name": "Lcrown", "pirg": "racs", "expires_at": "2024-12-14", "base_url": "https://hpc"})
+	if err != nil || subject != "Your membership of racs expires on 2024-12-14" {
+		t.Errorf("expected the built in template, got %q: %v", subject, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, EventQuotaExceeded+".tmpl"), []byte(`{{define "subject"}}no body{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("expected error for a template without a body")
+	}
+}
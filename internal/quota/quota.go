@@ -0,0 +1,219 @@
+// Package quota reads the group usage reported by filesystem quota tools
+package quota
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Formats are the report formats Parse understands
+var Formats = []string{"xfs", "lfs", "csv"}
+
+// Row is the space used by a group. Gid is nil when the report only has
+// the name, and Group is empty when it only has the gid.
+type Row struct {
+	Group     string
+	Gid       *int
+	UsedBytes int64
+}
+
+// Parse reads a report in one of Formats:
+//
+//   - xfs: `xfs_quota -x -c 'report -g'`, with or without -h or -n
+//   - lfs: one or more `lfs quota -g <group> <mount>`, with or without -h
+//   - csv: a header with group and/or gid and used_bytes columns
+func Parse(format string, r io.Reader) ([]Row, error) {
+	switch format {
+	case "xfs":
+		return parseXFS(r)
+	case "lfs":
+		return parseLFS(r)
+	case "csv":
+		return parseCSV(r)
+	}
+	return nil, fmt.Errorf("unknown quota report format %q, must be one of %v", format, Formats)
+}
+
+// parseSize reads a size printed by a quota tool. Plain numbers are in
+// units of unit bytes, numbers with a suffix like 1.5G are binary.
+func parseSize(s string, unit int64) (int64, error) {
+	s = strings.TrimSuffix(s, "*")
+	if s == "" {
+		return 0, fmt.Errorf("missing size")
+	}
+	multiplier := float64(unit)
+	switch suffix := strings.ToUpper(s[len(s)-1:]); suffix {
+	case "K", "M", "G", "T", "P", "E":
+		multiplier = float64(int64(1) << (10 * (strings.Index("KMGTPE", suffix) + 1)))
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+func parseXFS(r io.Reader) ([]Row, error) {
+	var rows []Row
+	scanner := bufio.NewScanner(r)
+	found, inTable := false, false
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(text, "User quota") || strings.HasPrefix(text, "Project quota"):
+			return nil, fmt.Errorf("line %d: expected a group quota report", line)
+		case strings.HasPrefix(text, "Group quota"):
+			inTable = false
+			continue
+		case strings.HasPrefix(text, "---"):
+			found, inTable = true, true
+			continue
+		case text == "":
+			inTable = false
+			continue
+		case !inTable:
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a group and its usage", line)
+		}
+		// blocks are 1k unless -h was given
+		used, err := parseSize(fields[1], 1024)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		row := Row{Group: fields[0], UsedBytes: used}
+		// groups without a name are shown by gid
+		if gid, ok := strings.CutPrefix(fields[0], "#"); ok {
+			n, err := strconv.Atoi(gid)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid gid %q", line, fields[0])
+			}
+			row = Row{Gid: &n, UsedBytes: used}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("no xfs_quota report found")
+	}
+	return rows, nil
+}
+
+var lfsHeader = regexp.MustCompile(`^Disk quotas for (grp|group|usr|user|prj|project) (\S+) \((?:gid|uid|id) (\d+)\):?$`)
+
+func parseLFS(r io.Reader) ([]Row, error) {
+	var rows []Row
+	scanner := bufio.NewScanner(r)
+	var current *Row
+	var pending []string
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if m := lfsHeader.FindStringSubmatch(text); m != nil {
+			if current != nil {
+				return nil, fmt.Errorf("line %d: no usage found for group %s", line, current.Group)
+			}
+			if m[1] != "grp" && m[1] != "group" {
+				return nil, fmt.Errorf("line %d: expected a group quota report", line)
+			}
+			gid, _ := strconv.Atoi(m[3])
+			current = &Row{Group: m[2], Gid: &gid}
+			continue
+		}
+		if current == nil || text == "" || strings.HasPrefix(text, "Filesystem") {
+			continue
+		}
+		// long filesystem names put the numbers on the next line
+		pending = append(pending, strings.Fields(text)...)
+		if len(pending) < 2 {
+			continue
+		}
+		used, err := parseSize(pending[1], 1024)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		current.UsedBytes = used
+		rows = append(rows, *current)
+		current, pending = nil, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("no usage found for group %s", current.Group)
+	}
+	if rows == nil {
+		return nil, errors.New("no lfs quota report found")
+	}
+	return rows, nil
+}
+
+func parseCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing csv header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	groupCol, hasGroup := index["group"]
+	gidCol, hasGid := index["gid"]
+	usedCol, hasUsed := index["used_bytes"]
+	if !hasGroup && !hasGid {
+		return nil, errors.New("csv needs a group or gid column")
+	}
+	if !hasUsed {
+		return nil, errors.New("csv needs a used_bytes column")
+	}
+	var rows []Row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		var row Row
+		if hasGroup {
+			row.Group = strings.TrimSpace(record[groupCol])
+		}
+		if hasGid && strings.TrimSpace(record[gidCol]) != "" {
+			gid, err := strconv.Atoi(strings.TrimSpace(record[gidCol]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid gid %q", line, record[gidCol])
+			}
+			row.Gid = &gid
+		}
+		if row.Group == "" && row.Gid == nil {
+			return nil, fmt.Errorf("line %d: missing group and gid", line)
+		}
+		row.UsedBytes, err = parseSize(strings.TrimSpace(record[usedCol]), 1)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
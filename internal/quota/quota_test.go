@@ -0,0 +1,98 @@
+package quota
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func gid(n int) *int {
+	return &n
+}
+
+func TestParseXFS(t *testing.T) {
+	report := `Group quota on /data (/dev/sdb1)
+                               Blocks
+Group ID         Used       Soft       Hard    Warn/Grace
+---------- --------------------------------------------------
+root                0          0          0     00 [--------]
+hpcrcf         102400    1048576    2097152     00 [--------]
+#5001            2.5G         0          0     00 [--------]
+
+`
+	rows, err := Parse("xfs", strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Row{
+		{Group: "root"},
+		{Group: "hpcrcf", UsedBytes: 100 << 20},
+		{Gid: gid(5001), UsedBytes: 5 << 29},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("expected %+v got %+v", want, rows)
+	}
+	if _, err := Parse("xfs", strings.NewReader("User quota on /data (/dev/sdb1)\n")); err == nil {
+		t.Error("expected error for a user quota report")
+	}
+	if _, err := Parse("xfs", strings.NewReader("group,used_bytes\nhpcrcf,1\n")); err == nil {
+		t.Error("expected error for something that isn't an xfs_quota report")
+	}
+}
+
+func TestParseLFS(t *testing.T) {
+	report := `Disk quotas for grp hpcrcf (gid 5000):
+     Filesystem  kbytes   quota   limit   grace   files   quota   limit   grace
+        /lustre 2048000* 1000000 2000000       -    1000       0       0       -
+Disk quotas for group otherlab (gid 5002):
+     Filesystem    used   quota   limit   grace   files   quota   limit   grace
+/lustre/a/very/long/mount/point
+                     1T      2T      3T       -      10       0       0       -
+`
+	rows, err := Parse("lfs", strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Row{
+		{Group: "hpcrcf", Gid: gid(5000), UsedBytes: 2048000 << 10},
+		{Group: "otherlab", Gid: gid(5002), UsedBytes: 1 << 40},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("expected %+v got %+v", want, rows)
+	}
+	if _, err := Parse("lfs", strings.NewReader("Disk quotas for usr alice (uid 1000):\n")); err == nil {
+		t.Error("expected error for a user quota report")
+	}
+	if _, err := Parse("lfs", strings.NewReader("Disk quotas for grp hpcrcf (gid 5000):\n")); err == nil {
+		t.Error("expected error for a group without usage")
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	rows, err := Parse("csv", strings.NewReader("gid,group,used_bytes\n5000,hpcrcf,1024\n,otherlab,2K\n5003,,0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Row{
+		{Group: "hpcrcf", Gid: gid(5000), UsedBytes: 1024},
+		{Group: "otherlab", UsedBytes: 2048},
+		{Gid: gid(5003)},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("expected %+v got %+v", want, rows)
+	}
+	for name, bad := range map[string]string{
+		"no group":     "used_bytes\n1\n",
+		"no used":      "group\nhpcrcf\n",
+		"bad gid":      "gid,used_bytes\nabc,1\n",
+		"bad size":     "group,used_bytes\nhpcrcf,-1\n",
+		"empty row id": "group,gid,used_bytes\n,,1\n",
+	} {
+		if _, err := Parse("csv", strings.NewReader(bad)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := Parse("gpfs", strings.NewReader("")); err == nil {
+		t.Error("expected error for an unknown format")
+	}
+}
@@ -0,0 +1,276 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/time/rate"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+	"github.com/lcrownover/hpcadmin-server/internal/logging"
+	"github.com/lcrownover/hpcadmin-server/internal/metrics"
+)
+
+// DefaultPruneInterval is how often idle buckets and expired lockouts are dropped
+const DefaultPruneInterval = time.Minute
+
+// ClientIP returns the ip the request came from. When proxy headers are
+// trusted, middleware.RealIP has already rewritten RemoteAddr.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IPKey keys requests by the client ip
+func IPKey(r *http.Request) string {
+	return "ip:" + ClientIP(r)
+}
+
+// CallerKey keys requests by the authenticated caller, such as "user:12"
+// for an api key, falling back to the client ip for anonymous requests
+func CallerKey(r *http.Request) string {
+	if caller := logging.Caller(r.Context()); caller != "" {
+		return caller
+	}
+	return IPKey(r)
+}
+
+// tooManyRequests rejects the request, telling the client when to come back
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter keeps a token bucket for every key it has seen
+type Limiter struct {
+	mu      sync.Mutex
+	rate    rate.Limit
+	burst   int
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewLimiter(cfg config.RateConfig) *Limiter {
+	return &Limiter{
+		rate:    rate.Limit(cfg.Rate),
+		burst:   cfg.Burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the key's bucket. When the bucket is empty it
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	res := b.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Prune drops buckets that have been idle long enough to refill, they
+// behave the same as a new bucket
+func (l *Limiter) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := time.Duration(float64(l.burst) / float64(l.rate) * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware rejects requests with 429 once the bucket for the key
+// returned by keyFunc is empty
+func (l *Limiter) Middleware(keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if ok, retryAfter := l.Allow(key); !ok {
+				slog.DebugContext(r.Context(), "rate limit exceeded", "package", "ratelimit", "method", "Middleware", "key", key)
+				metrics.RateLimited.WithLabelValues("rate_limit").Inc()
+				tooManyRequests(w, retryAfter)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Limiters holds the limiter for each configured route group
+type Limiters map[string]*Limiter
+
+// NewLimiters builds a limiter for every group with a rate set
+func NewLimiters(groups map[string]config.RateConfig) Limiters {
+	limiters := make(Limiters)
+	for group, cfg := range groups {
+		if cfg.Rate > 0 {
+			limiters[group] = NewLimiter(cfg)
+		}
+	}
+	return limiters
+}
+
+// Middleware limits the group by keyFunc, or does nothing if the group
+// has no limit configured
+func (ls Limiters) Middleware(group string, keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	l, ok := ls[group]
+	if !ok {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return l.Middleware(keyFunc)
+}
+
+func (ls Limiters) Prune(now time.Time) {
+	for _, l := range ls {
+		l.Prune(now)
+	}
+}
+
+type failures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// Lockout counts failed authentications by client ip and turns the ip
+// away for a while once it has failed too many times
+type Lockout struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	clients     map[string]*failures
+	now         func() time.Time
+}
+
+func NewLockout(cfg config.LockoutConfig) *Lockout {
+	return &Lockout{
+		maxFailures: cfg.MaxFailures,
+		window:      cfg.Window,
+		duration:    cfg.Duration,
+		clients:     make(map[string]*failures),
+		now:         time.Now,
+	}
+}
+
+// Locked reports whether the ip is locked out and for how much longer
+func (l *Lockout) Locked(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.clients[ip]
+	if !ok {
+		return false, 0
+	}
+	if remaining := f.lockedUntil.Sub(l.now()); remaining > 0 {
+		return true, remaining
+	}
+	return false, 0
+}
+
+// Fail records a failed authentication from the ip, returning true if
+// that locked it out
+func (l *Lockout) Fail(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	f, ok := l.clients[ip]
+	if !ok || now.Sub(f.windowStart) > l.window {
+		f = &failures{windowStart: now}
+		l.clients[ip] = f
+	}
+	f.count++
+	if f.count >= l.maxFailures {
+		f.lockedUntil = now.Add(l.duration)
+		f.count = 0
+		f.windowStart = now
+		return true
+	}
+	return false
+}
+
+// Prune drops ips whose failures and lockout have both expired
+func (l *Lockout) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, f := range l.clients {
+		if now.Sub(f.windowStart) > l.window && now.After(f.lockedUntil) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// Middleware turns away locked out ips with 429 and counts every 401
+// returned further down the chain as a failed authentication. It has to
+// come before the auth middlewares.
+func (l *Lockout) Middleware(next http.Handler) http.Handler {
+	if l.maxFailures == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if locked, retryAfter := l.Locked(ip); locked {
+			slog.DebugContext(r.Context(), "client is locked out", "package", "ratelimit", "method", "Middleware", "ip", ip)
+			metrics.RateLimited.WithLabelValues("lockout").Inc()
+			tooManyRequests(w, retryAfter)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if ww.Status() == http.StatusUnauthorized && l.Fail(ip) {
+			slog.WarnContext(r.Context(), "locking out client after repeated authentication failures", "package", "ratelimit", "method", "Middleware", "ip", ip, "duration", l.duration)
+		}
+	})
+}
+
+// Pruner is a limiter that holds per-client state
+type Pruner interface {
+	Prune(now time.Time)
+}
+
+// RunPruner prunes the given limiters every interval until ctx is done
+func RunPruner(ctx context.Context, interval time.Duration, pruners ...Pruner) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, p := range pruners {
+				p.Prune(now)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// clock is a fake time source that only moves when told to
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newClock() *clock {
+	return &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	c := newClock()
+	l := NewLimiter(config.RateConfig{Rate: 1, Burst: 2})
+	l.now = c.now
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("user:1"); !ok {
+			t.Fatalf("expected request %d to fit in the burst", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("user:1")
+	if ok {
+		t.Fatal("expected request over the burst to be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("expected retry after up to a second, got %v", retryAfter)
+	}
+	// other keys have their own bucket
+	if ok, _ := l.Allow("user:2"); !ok {
+		t.Error("expected a different key to be allowed")
+	}
+	c.advance(time.Second)
+	if ok, _ := l.Allow("user:1"); !ok {
+		t.Error("expected a token after the bucket refilled")
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	c := newClock()
+	l := NewLimiter(config.RateConfig{Rate: 1, Burst: 5})
+	l.now = c.now
+	l.Allow("user:1")
+
+	l.Prune(c.now().Add(time.Second))
+	if len(l.buckets) != 1 {
+		t.Fatal("expected a bucket that hasn't refilled to be kept")
+	}
+	l.Prune(c.now().Add(10 * time.Second))
+	if len(l.buckets) != 0 {
+		t.Fatal("expected an idle bucket to be dropped")
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := NewLimiter(config.RateConfig{Rate: 0.5, Burst: 1})
+	h := l.Middleware(CallerKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/api/v1/users", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After 2, got %q", got)
+	}
+}
+
+func TestLimitersUnconfiguredGroup(t *testing.T) {
+	ls := NewLimiters(map[string]config.RateConfig{"api": {Rate: 1, Burst: 1}})
+	called := 0
+	h := ls.Middleware("public", CallerKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+	for i := 0; i < 5; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if called != 5 {
+		t.Errorf("expected an unconfigured group not to be limited, handled %d of 5", called)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	c := newClock()
+	l := NewLockout(config.LockoutConfig{MaxFailures: 3, Window: time.Minute, Duration: 5 * time.Minute})
+	l.now = c.now
+	status := http.StatusUnauthorized
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/users", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := serve("192.0.2.1:1234"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected failure %d to reach the handler, got %d", i+1, rec.Code)
+		}
+	}
+	rec := serve("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected locked out ip to get 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "300" {
+		t.Errorf("expected Retry-After 300, got %q", got)
+	}
+	if rec := serve("192.0.2.2:1234"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected other ips not to be locked out, got %d", rec.Code)
+	}
+
+	c.advance(5*time.Minute + time.Second)
+	status = http.StatusOK
+	if rec := serve("192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected lockout to expire, got %d", rec.Code)
+	}
+}
+
+func TestLockoutWindow(t *testing.T) {
+	c := newClock()
+	l := NewLockout(config.LockoutConfig{MaxFailures: 2, Window: time.Minute, Duration: time.Minute})
+	l.now = c.now
+
+	l.Fail("192.0.2.1")
+	c.advance(2 * time.Minute)
+	if l.Fail("192.0.2.1") {
+		t.Fatal("expected failures outside the window not to add up")
+	}
+	if !l.Fail("192.0.2.1") {
+		t.Fatal("expected failures inside the window to lock out")
+	}
+	c.advance(5 * time.Minute)
+	l.Prune(c.now())
+	if len(l.clients) != 0 {
+		t.Error("expected expired lockout to be pruned")
+	}
+}
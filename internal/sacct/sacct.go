@@ -0,0 +1,141 @@
+// Package sacct reads the accounting records slurm prints with
+// `sacct --parsable2`
+package sacct
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeLayout is how sacct prints times with the default SLURM_TIME_FORMAT
+const TimeLayout = "2006-01-02T15:04:05"
+
+// Columns are the fields Parse needs. AllocTRES can be swapped for AllocCPUS
+// if gpus aren't tracked.
+var Columns = []string{"JobID", "Account", "User", "End", "ElapsedRaw", "AllocTRES"}
+
+// Job is a finished job and the hours it used
+type Job struct {
+	JobId    string
+	Account  string
+	User     string
+	End      time.Time
+	CPUHours float64
+	GPUHours float64
+}
+
+// Parse reads `sacct --parsable2 --format=JobID,Account,User,End,ElapsedRaw,AllocTRES`
+// output. Columns are found by the header so they can be in any order and
+// extra ones are ignored. Job steps and jobs that haven't ended are
+// skipped. Times are read in loc, which should be the timezone sacct ran in.
+func Parse(r io.Reader, loc *time.Location) ([]Job, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("missing sacct header")
+	}
+	header := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(name)] = i
+	}
+	for _, name := range []string{"jobid", "account", "user", "end", "elapsedraw"} {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("sacct output is missing the %s column", name)
+		}
+	}
+	_, hasTRES := index["alloctres"]
+	_, hasCPUs := index["alloccpus"]
+	if !hasTRES && !hasCPUs {
+		return nil, fmt.Errorf("sacct output is missing the alloctres or alloccpus column")
+	}
+
+	var jobs []Job
+	line := 1
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "|")
+		if len(fields) != len(header) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", line, len(header), len(fields))
+		}
+		field := func(name string) string { return strings.TrimSpace(fields[index[name]]) }
+
+		jobId := field("jobid")
+		end := field("end")
+		// steps are already counted by their job
+		if strings.Contains(jobId, ".") || end == "" || end == "Unknown" || end == "None" {
+			continue
+		}
+		job := Job{JobId: jobId, Account: field("account"), User: field("user")}
+		var err error
+		job.End, err = time.ParseInLocation(TimeLayout, end, loc)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid end time %q", line, end)
+		}
+		elapsed, err := strconv.ParseInt(field("elapsedraw"), 10, 64)
+		if err != nil || elapsed < 0 {
+			return nil, fmt.Errorf("line %d: invalid elapsed seconds %q", line, field("elapsedraw"))
+		}
+		var cpus, gpus float64
+		if hasTRES {
+			cpus, gpus, err = parseTRES(field("alloctres"))
+		} else {
+			cpus, err = strconv.ParseFloat(field("alloccpus"), 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		hours := float64(elapsed) / 3600
+		job.CPUHours = cpus * hours
+		job.GPUHours = gpus * hours
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+// parseTRES pulls the cpus and gpus out of a tres list like
+// cpu=4,mem=16G,node=1,gres/gpu=1. Slurm lists typed gpus as well as the
+// total, so the typed ones are only summed when there's no total.
+func parseTRES(tres string) (cpus float64, gpus float64, err error) {
+	var typedGPUs float64
+	hasTotal := false
+	for _, item := range strings.Split(tres, ",") {
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid tres %q", item)
+		}
+		if name != "cpu" && name != "gres/gpu" && !strings.HasPrefix(name, "gres/gpu:") {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid tres %q", item)
+		}
+		switch {
+		case name == "cpu":
+			cpus = n
+		case name == "gres/gpu":
+			gpus, hasTotal = n, true
+		default:
+			typedGPUs += n
+		}
+	}
+	if !hasTotal {
+		gpus = typedGPUs
+	}
+	return cpus, gpus, nil
+}
@@ -0,0 +1,51 @@
+package sacct
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	input := `JobID|Account|User|End|ElapsedRaw|AllocTRES|State
+100|hpcrcf|alice|2024-01-02T10:00:00|7200|billing=4,cpu=4,gres/gpu=2,gres/gpu:a100=2,mem=16G,node=1|COMPLETED
+100.batch|hpcrcf||2024-01-02T10:00:00|7200|cpu=4,mem=16G,node=1|COMPLETED
+101_3|hpcrcf|bob|2024-01-03T00:30:00|1800|cpu=2,gres/gpu:v100=1,gres/gpu:a100=1|FAILED
+102|hpcrcf|bob|Unknown|60|cpu=1|RUNNING
+
+`
+	jobs, err := Parse(strings.NewReader(input), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Job{
+		{JobId: "100", Account: "hpcrcf", User: "alice", End: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), CPUHours: 8, GPUHours: 4},
+		{JobId: "101_3", Account: "hpcrcf", User: "bob", End: time.Date(2024, 1, 3, 0, 30, 0, 0, time.UTC), CPUHours: 1, GPUHours: 1},
+	}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Fatalf("expected %+v got %+v", want, jobs)
+	}
+
+	// AllocCPUS works without gpus, columns in any order
+	jobs, err = Parse(strings.NewReader("User|JobID|AllocCPUS|ElapsedRaw|End|Account\ncarol|5|8|450|2024-02-01T00:00:00|lab\n"), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].CPUHours != 1 || jobs[0].GPUHours != 0 || jobs[0].User != "carol" {
+		t.Fatalf("unexpected jobs %+v", jobs)
+	}
+
+	for name, bad := range map[string]string{
+		"empty":          "",
+		"missing column": "JobID|Account|User|End|ElapsedRaw\n1|a|b|2024-01-01T00:00:00|1\n",
+		"short line":     "JobID|Account|User|End|ElapsedRaw|AllocCPUS\n1|a|b\n",
+		"bad end":        "JobID|Account|User|End|ElapsedRaw|AllocCPUS\n1|a|b|yesterday|1|1\n",
+		"bad elapsed":    "JobID|Account|User|End|ElapsedRaw|AllocCPUS\n1|a|b|2024-01-01T00:00:00|x|1\n",
+		"bad tres":       "JobID|Account|User|End|ElapsedRaw|AllocTRES\n1|a|b|2024-01-01T00:00:00|1|cpu=many\n",
+	} {
+		if _, err := Parse(strings.NewReader(bad), time.UTC); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// Defaults used for any http limits left unset in the configuration
+const (
+	DefaultReadTimeout       = 15 * time.Second
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultShutdownTimeout   = 30 * time.Second
+	DefaultMaxHeaderBytes    = 1 << 20
+)
+
+// Server runs the http server along with any background workers,
+// and on shutdown drains in-flight requests, stops the workers and
+// releases shared resources, in that order.
+type Server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+
+	workers []worker
+	closers []func() error
+	wg      sync.WaitGroup
+}
+
+type worker struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+func New(addr string, handler http.Handler, cfg config.HTTPConfig) *Server {
+	cfg = withDefaults(cfg)
+	return &Server{
+		http: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		},
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+}
+
+func withDefaults(cfg config.HTTPConfig) config.HTTPConfig {
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = DefaultReadTimeout
+	}
+	if cfg.ReadHeaderTimeout == 0 {
+		cfg.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if cfg.MaxHeaderBytes == 0 {
+		cfg.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	return cfg
+}
+
+// Go registers a background worker that is started with the server.
+// The context passed to fn is cancelled once in-flight requests have
+// drained, and shutdown waits for fn to return.
+func (s *Server) Go(name string, fn func(ctx context.Context)) {
+	s.workers = append(s.workers, worker{name: name, fn: fn})
+}
+
+// OnShutdown registers a cleanup function that runs after the http
+// server and workers have stopped, such as closing the database pool
+func (s *Server) OnShutdown(fn func() error) {
+	s.closers = append(s.closers, fn)
+}
+
+// ListenAndServe listens on the configured address and serves until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is done, then shuts down
+// gracefully. It returns nil if everything drained within the shutdown
+// timeout.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	for _, w := range s.workers {
+		s.wg.Add(1)
+		go func(w worker) {
+			defer s.wg.Done()
+			slog.Debug("starting background worker", "worker", w.name, "package", "server", "method", "Serve")
+			w.fn(workerCtx)
+			slog.Debug("background worker stopped", "worker", w.name, "package", "server", "method", "Serve")
+		}(w)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.http.TLSConfig != nil {
+			// certificates come from TLSConfig.GetCertificate
+			serveErr <- s.http.ServeTLS(ln, "", "")
+			return
+		}
+		serveErr <- s.http.Serve(ln)
+	}()
+
+	var errs []error
+	select {
+	case err := <-serveErr:
+		// the server failed on its own, still clean everything up
+		errs = append(errs, err)
+	case <-ctx.Done():
+		slog.Info("shutting down, draining in-flight requests", "timeout", s.shutdownTimeout, "package", "server", "method", "Serve")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil {
+		// drain deadline passed, drop whatever is still connected
+		s.http.Close()
+		errs = append(errs, fmt.Errorf("draining requests: %w", err))
+	}
+
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		errs = append(errs, fmt.Errorf("stopping background workers: %w", shutdownCtx.Err()))
+	}
+
+	for _, closer := range s.closers {
+		if err := closer(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
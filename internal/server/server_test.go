@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// startServer serves handler on a random local port until the returned
+// cancel func is called. The error from Serve is sent on the channel.
+func startServer(t *testing.T, s *Server) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, ln)
+	}()
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+// blockingHandler signals on started when a request arrives and doesn't
+// respond until release is closed
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("done"))
+	})
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s := New("", blockingHandler(started, release), config.HTTPConfig{ShutdownTimeout: 5 * time.Second})
+
+	var workerStopped, closed atomic.Bool
+	s.Go("test", func(ctx context.Context) {
+		<-ctx.Done()
+		workerStopped.Store(true)
+	})
+	s.OnShutdown(func() error {
+		if !workerStopped.Load() {
+			t.Error("expected workers to stop before closers run")
+		}
+		closed.Store(true)
+		return nil
+	})
+
+	url, cancel, done := startServer(t, s)
+
+	type result struct {
+		body string
+		err  error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			respCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		respCh <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if workerStopped.Load() {
+		t.Fatal("expected workers to keep running while requests drain")
+	}
+
+	close(release)
+	res := <-respCh
+	if res.err != nil {
+		t.Fatalf("in-flight request failed: %v", res.err)
+	}
+	if res.body != "done" {
+		t.Fatalf("expected body %q got %q", "done", res.body)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if !workerStopped.Load() || !closed.Load() {
+		t.Fatal("expected workers to stop and closers to run")
+	}
+}
+
+func TestServeShutdownDeadline(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	s := New("", blockingHandler(started, release), config.HTTPConfig{ShutdownTimeout: 50 * time.Millisecond})
+
+	var closed atomic.Bool
+	s.OnShutdown(func() error {
+		closed.Store(true)
+		return nil
+	})
+
+	url, cancel, done := startServer(t, s)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't give up after the shutdown timeout")
+	}
+	if !closed.Load() {
+		t.Fatal("expected closers to run even when the drain times out")
+	}
+}
+
+func TestServeRefusesNewConnections(t *testing.T) {
+	s := New("", http.NotFoundHandler(), config.HTTPConfig{})
+	url, cancel, done := startServer(t, s)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Fatal("expected connection to be refused after shutdown")
+	}
+}
+
+func TestNewAppliesDefaults(t *testing.T) {
+	s := New(":0", http.NotFoundHandler(), config.HTTPConfig{WriteTimeout: time.Minute})
+	if s.http.WriteTimeout != time.Minute {
+		t.Errorf("expected configured write timeout, got %v", s.http.WriteTimeout)
+	}
+	if s.http.ReadHeaderTimeout != DefaultReadHeaderTimeout {
+		t.Errorf("expected default read header timeout, got %v", s.http.ReadHeaderTimeout)
+	}
+	if s.http.MaxHeaderBytes != DefaultMaxHeaderBytes {
+		t.Errorf("expected default max header bytes, got %v", s.http.MaxHeaderBytes)
+	}
+	if s.shutdownTimeout != DefaultShutdownTimeout {
+		t.Errorf("expected default shutdown timeout, got %v", s.shutdownTimeout)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// DefaultCertReloadInterval is how often certificate files are checked
+// for changes when tls.reload_interval isn't set
+const DefaultCertReloadInterval = time.Minute
+
+// CertReloader serves a certificate that can be swapped out while the
+// server is running. Only new handshakes see the new certificate, so
+// existing connections are left alone.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader loads the certificate and key, failing if they can't be read
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the certificate and key from disk. If they can't be
+// loaded, the current certificate stays in place.
+func (c *CertReloader) Reload() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading tls certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Watch reloads the certificate on SIGHUP, or when either file changes,
+// until ctx is done. It's meant to be run as a background worker.
+func (c *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultCertReloadInterval
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("reloading tls certificate on SIGHUP", "package", "server", "method", "Watch")
+			c.reload()
+		case <-ticker.C:
+			if c.changed() {
+				slog.Info("tls certificate files changed, reloading", "package", "server", "method", "Watch")
+				c.reload()
+			}
+		}
+	}
+}
+
+func (c *CertReloader) reload() {
+	if err := c.Reload(); err != nil {
+		slog.Error("failed to reload tls certificate, keeping the current one", "error", err, "package", "server", "method", "Watch")
+	}
+}
+
+func (c *CertReloader) changed() bool {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		slog.Warn("failed to check tls certificate files", "error", err, "package", "server", "method", "Watch")
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !modTime.Equal(c.modTime)
+}
+
+// filesModTime returns the latest modification time of the cert and key
+func (c *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewTLSConfig builds the server tls.Config, taking certificates from the reloader
+func NewTLSConfig(cfg config.TLSConfig, certs *CertReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if cfg.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading tls client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls client ca file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// UseTLS makes the server serve TLS with the given config
+func (s *Server) UseTLS(tlsConfig *tls.Config) {
+	s.http.TLSConfig = tlsConfig
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+// writeTestCert writes a self-signed certificate for commonName to
+// cert.pem and key.pem in dir, with the given modification time
+func writeTestCert(t *testing.T, dir string, commonName string, modTime time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func servedCommonName(t *testing.T, c *CertReloader) string {
+	t.Helper()
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, dir, "first", now)
+	c, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := servedCommonName(t, c); cn != "first" {
+		t.Fatalf("expected first certificate, got %s", cn)
+	}
+
+	writeTestCert(t, dir, "second", now.Add(time.Second))
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := servedCommonName(t, c); cn != "second" {
+		t.Fatalf("expected second certificate, got %s", cn)
+	}
+
+	// a broken certificate is reported and the current one is kept
+	if err := os.WriteFile(certFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Fatal("expected error reloading a broken certificate")
+	}
+	if cn := servedCommonName(t, c); cn != "second" {
+		t.Fatalf("expected second certificate to be kept, got %s", cn)
+	}
+}
+
+func TestCertReloaderWatchDetectsChanges(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, dir, "first", now)
+	c, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	writeTestCert(t, dir, "second", now.Add(time.Second))
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCommonName(t, c) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate change wasn't picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeTLSReloadsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, dir, "first", now)
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := NewTLSConfig(config.TLSConfig{MinVersion: "1.3"}, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("", http.NotFoundHandler(), config.HTTPConfig{})
+	s.UseTLS(tlsConfig)
+	url, cancel, done := startServer(t, s)
+	url = "https" + url[len("http"):]
+
+	peerName := func() string {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.TLS.Version != tls.VersionTLS13 {
+			t.Errorf("expected tls 1.3, got %x", resp.TLS.Version)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if cn := peerName(); cn != "first" {
+		t.Fatalf("expected first certificate, got %s", cn)
+	}
+	writeTestCert(t, dir, "second", now.Add(time.Second))
+	if err := certs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := peerName(); cn != "second" {
+		t.Fatalf("expected second certificate, got %s", cn)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+}
+
+func TestNewTLSConfigClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server", time.Now())
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := NewTLSConfig(config.TLSConfig{ClientCAFile: certFile}, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Fatal("expected client certificates to be required")
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected default minimum version tls 1.2, got %x", tlsConfig.MinVersion)
+	}
+
+	if _, err := NewTLSConfig(config.TLSConfig{ClientCAFile: keyFile}, certs); err == nil {
+		t.Fatal("expected error for a client ca file without certificates")
+	}
+}
@@ -0,0 +1,92 @@
+// Package sweep runs the periodic jobs that act on deadlines stored in the
+// database
+package sweep
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+// DefaultInterval is how often the sweeps run
+const DefaultInterval = 5 * time.Minute
+
+// Run sweeps every interval until ctx is done. It's meant to be run as a
+// background worker.
+func Run(ctx context.Context, store data.Store, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		Sweep(ctx, store, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep runs every sweep once. Failures are logged so one sweep can't stop the others.
+func Sweep(ctx context.Context, store data.Store, now time.Time) {
+	if _, err := CloseOverdueReviews(ctx, store, now); err != nil {
+		slog.Warn("failed to close overdue access reviews", "error", err, "package", "sweep", "method", "Sweep")
+	}
+	if _, err := RemoveExpiredMemberships(ctx, store, now); err != nil {
+		slog.Warn("failed to remove expired memberships", "error", err, "package", "sweep", "method", "Sweep")
+	}
+	if _, err := DeactivateGuests(ctx, store, now); err != nil {
+		slog.Warn("failed to deactivate guests", "error", err, "package", "sweep", "method", "Sweep")
+	}
+}
+
+// CloseOverdueReviews closes the open access reviews whose deadline has
+// passed and returns how many were closed
+func CloseOverdueReviews(ctx context.Context, store data.AccessReviewStore, now time.Time) (int, error) {
+	reviews, err := store.GetAllAccessReviews(ctx)
+	if err != nil {
+		return 0, err
+	}
+	closed := 0
+	for _, review := range reviews {
+		if review.ClosedAt != nil || review.Deadline.After(now) {
+			continue
+		}
+		if _, err := store.CloseAccessReview(ctx, review.Id); err != nil {
+			return closed, err
+		}
+		slog.Info("closed overdue access review", "id", review.Id, "name", review.Name, "package", "sweep", "method", "CloseOverdueReviews")
+		closed++
+	}
+	return closed, nil
+}
+
+// RemoveExpiredMemberships takes users out of the pirgs their membership
+// has expired in and returns how many were removed
+func RemoveExpiredMemberships(ctx context.Context, store data.MembershipStore, now time.Time) (int, error) {
+	expired, err := store.RemoveExpiredMemberships(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range expired {
+		slog.Info("removed expired membership", "pirg_id", m.PirgId, "user_id", m.UserId, "expires_at", m.ExpiresAt, "package", "sweep", "method", "RemoveExpiredMemberships")
+	}
+	return len(expired), nil
+}
+
+// DeactivateGuests deactivates the guests that expired or whose sponsor no
+// longer owns a pirg, and returns how many were deactivated
+func DeactivateGuests(ctx context.Context, store data.UserStore, now time.Time) (int, error) {
+	deactivated, err := store.DeactivateGuests(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range deactivated {
+		slog.Info("deactivated guest", "user_id", u.Id, "username", u.Username, "expires_at", u.ExpiresAt, "package", "sweep", "method", "DeactivateGuests")
+	}
+	return len(deactivated), nil
+}
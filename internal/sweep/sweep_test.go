@@ -0,0 +1,165 @@
+package sweep
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/hpcadmin-server/internal/data"
+)
+
+func TestCloseOverdueReviews(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	owner, err := store.CreateUser(ctx, &data.UserRequest{Username: "owner", Email: "owner@localhost", FirstName: "Test", LastName: "Owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := store.CreateUser(ctx, &data.UserRequest{Username: "member", Email: "member@localhost", FirstName: "Test", LastName: "Member"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "sweeppirg", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id, member.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	overdue, err := store.CreateAccessReview(ctx, &data.AccessReviewRequest{Name: "overdue", Deadline: now.Add(-time.Hour), RemoveUnconfirmed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upcoming, err := store.CreateAccessReview(ctx, &data.AccessReviewRequest{Name: "upcoming", Deadline: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed, err := CloseOverdueReviews(ctx, store, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != 1 {
+		t.Fatalf("expected 1 review to be closed, got %d", closed)
+	}
+	review, err := store.GetAccessReviewById(ctx, overdue.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.ClosedAt == nil {
+		t.Fatal("expected the overdue review to be closed")
+	}
+	review, err = store.GetAccessReviewById(ctx, upcoming.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.ClosedAt != nil {
+		t.Fatal("expected the upcoming review to stay open")
+	}
+	// the unconfirmed member was removed when the review closed
+	p, err := store.GetPirgById(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.UserIds) != 1 || p.UserIds[0] != owner.Id {
+		t.Fatalf("expected only the owner to be left, got %v", p.UserIds)
+	}
+
+	// closed reviews are left alone
+	if closed, err = CloseOverdueReviews(ctx, store, now); err != nil || closed != 0 {
+		t.Fatalf("expected nothing to close, got %d %v", closed, err)
+	}
+}
+
+func TestRemoveExpiredMemberships(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	owner, err := store.CreateUser(ctx, &data.UserRequest{Username: "owner", Email: "owner@localhost", FirstName: "Test", LastName: "Owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	student, err := store.CreateUser(ctx, &data.UserRequest{Username: "student", Email: "student@localhost", FirstName: "Test", LastName: "Student"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pirg, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "sweeppirg", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id, student.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	if _, err = store.SetMembershipTerm(ctx, pirg.Id, student.Id, &data.MembershipTerm{ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := RemoveExpiredMemberships(ctx, store, now); err != nil || removed != 0 {
+		t.Fatalf("expected nothing to be removed before the membership expires, got %d %v", removed, err)
+	}
+	removed, err := RemoveExpiredMemberships(ctx, store, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 membership to be removed, got %d", removed)
+	}
+	p, err := store.GetPirgById(ctx, pirg.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.UserIds) != 1 || p.UserIds[0] != owner.Id {
+		t.Fatalf("expected only the owner to be left, got %v", p.UserIds)
+	}
+	entries, err := store.GetAuditLog(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != data.AuditMembershipExpired || entries[0].Actor != data.AuditActorSweeper {
+		t.Fatalf("expected an audit entry for the removal, got %+v", entries)
+	}
+}
+
+func TestDeactivateGuests(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	owner, err := store.CreateUser(ctx, &data.UserRequest{Username: "owner", Email: "owner@localhost", FirstName: "Test", LastName: "Owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreatePirg(ctx, &data.PirgRequest{Name: "sweeppirg", OwnerId: owner.Id, AdminIds: []int{owner.Id}, UserIds: []int{owner.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	guest, err := store.CreateUser(ctx, &data.UserRequest{Username: "guest", Email: "guest@localhost", FirstName: "Test", LastName: "Guest", Type: data.UserGuest, SponsorId: &owner.Id, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deactivated, err := DeactivateGuests(ctx, store, now); err != nil || deactivated != 0 {
+		t.Fatalf("expected nothing to be deactivated before the guest expires, got %d %v", deactivated, err)
+	}
+	deactivated, err := DeactivateGuests(ctx, store, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deactivated != 1 {
+		t.Fatalf("expected 1 guest to be deactivated, got %d", deactivated)
+	}
+	u, err := store.GetUserById(ctx, guest.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Active() {
+		t.Fatal("expected the guest to be deactivated")
+	}
+	entries, err := store.GetAuditLog(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != data.AuditGuestDeactivated || entries[0].Actor != data.AuditActorSweeper {
+		t.Fatalf("expected an audit entry for the deactivation, got %+v", entries)
+	}
+
+	// deactivated guests are left alone
+	if deactivated, err = DeactivateGuests(ctx, store, expiresAt); err != nil || deactivated != 0 {
+		t.Fatalf("expected nothing to deactivate, got %d %v", deactivated, err)
+	}
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lcrownover/hpcadmin-server/internal/config"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	if _, err := Setup(context.Background(), config.TracingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	return recorder
+}
+
+func spanByName(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("no span named %q", name)
+	return nil
+}
+
+func TestMiddlewareSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	passThrough := func(next http.Handler) http.Handler { return next }
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", http.StatusForbidden)
+		})
+	}
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Use(Stage("loader", passThrough))
+	r.Get("/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := otel.Tracer("test").Start(r.Context(), "handler")
+		span.End()
+	})
+	r.With(Stage("reject", reject)).Get("/private", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("rejected request reached the handler")
+	})
+
+	// continue a trace started by the caller
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/things/12", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	request := spanByName(t, spans, "GET /things/{thingID}")
+	if request.SpanContext().TraceID().String() != traceID {
+		t.Errorf("expected trace id %s, got %s", traceID, request.SpanContext().TraceID())
+	}
+	if request.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected request span to continue the caller's span, got parent %s", request.Parent().SpanID())
+	}
+	stage := spanByName(t, spans, "middleware loader")
+	if stage.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Error("expected stage span to be a child of the request span")
+	}
+	handler := spanByName(t, spans, "handler")
+	if handler.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Error("expected handler span to be a child of the request span, not the stage")
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/private", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+	spanByName(t, recorder.Ended(), "middleware reject")
+}
+
+func TestSetupPropagatesTraceContext(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	carrier := propagation.HeaderCarrier(http.Header{})
+	otel.GetTextMapPropagator().Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	if carrier.Get("traceparent") == "" {
+		t.Fatal("expected traceparent header to be injected")
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "carrier-pigeon"}); err == nil {
+		t.Fatal("expected error for unknown exporter")
+	}
+}